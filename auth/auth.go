@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
-	
+
+	"ims-go/config"
 	"ims-go/models"
+	"ims-go/roles"
 )
 
 func HashPassword(password string) (string, error) {
@@ -21,30 +23,49 @@ func CheckPasswordHash(password, hash string) bool {
 }
 
 type AppState struct {
-	db   interface {
+	db interface {
 		GetDB() *sql.DB
-		ResetDatabase() error
+		ResetDatabaseAs(userID int) error
 	}
-	user *models.User
+	user   *models.User
+	config *config.Config
 }
 
+// NewAppState creates the application state. A nil config falls back to the
+// built-in defaults.
 func NewAppState(db interface {
 	GetDB() *sql.DB
-	ResetDatabase() error
-}) *AppState {
-	return &AppState{db: db}
+	ResetDatabaseAs(userID int) error
+}, cfg *config.Config) *AppState {
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	return &AppState{db: db, config: cfg}
 }
 
 func (a *AppState) Authenticate(username, password string) (*models.User, error) {
+	user, err := a.VerifyCredentials(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	a.user = user
+	return user, nil
+}
+
+// VerifyCredentials checks a username and password without changing the
+// logged-in user. It is used when a second user has to approve an action.
+// Archived users are refused.
+func (a *AppState) VerifyCredentials(username, password string) (*models.User, error) {
 	var id int
 	var usernameDB, passwordHash string
-	var isRootAdmin, canRead, canTransaction, canRevenue int
+	var isRootAdmin int
 	var createdAt time.Time
 
 	err := a.db.GetDB().QueryRow(
-		"SELECT id, username, password_hash, is_root_admin, can_read, can_transaction, can_revenue, created_at FROM users WHERE username = ?",
+		"SELECT id, username, password_hash, is_root_admin, created_at FROM users WHERE username = ? AND archived_at IS NULL",
 		username,
-	).Scan(&id, &usernameDB, &passwordHash, &isRootAdmin, &canRead, &canTransaction, &canRevenue, &createdAt)
+	).Scan(&id, &usernameDB, &passwordHash, &isRootAdmin, &createdAt)
 
 	if err == sql.ErrNoRows {
 		return nil, errors.New("invalid credentials")
@@ -57,18 +78,18 @@ func (a *AppState) Authenticate(username, password string) (*models.User, error)
 		return nil, errors.New("invalid credentials")
 	}
 
-	user := &models.User{
-		ID:             id,
-		Username:       usernameDB,
-		IsRootAdmin:    isRootAdmin == 1,
-		CanRead:        canRead == 1,
-		CanTransaction: canTransaction == 1,
-		CanRevenue:     canRevenue == 1,
-		CreatedAt:       createdAt,
+	userRoles, err := roles.GetUserRoles(a.db, id)
+	if err != nil {
+		return nil, err
 	}
 
-	a.user = user
-	return user, nil
+	return &models.User{
+		ID:          id,
+		Username:    usernameDB,
+		IsRootAdmin: isRootAdmin == 1,
+		Roles:       userRoles,
+		CreatedAt:   createdAt,
+	}, nil
 }
 
 func (a *AppState) GetCurrentUser() *models.User {
@@ -81,8 +102,11 @@ func (a *AppState) SetUser(user *models.User) {
 
 func (a *AppState) GetDB() interface {
 	GetDB() *sql.DB
-	ResetDatabase() error
+	ResetDatabaseAs(userID int) error
 } {
 	return a.db
 }
 
+func (a *AppState) GetConfig() *config.Config {
+	return a.config
+}
@@ -1,17 +1,65 @@
 package barcode
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"os"
 
 	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/datamatrix"
 	"github.com/makiuchi-d/gozxing/oned"
 	"github.com/makiuchi-d/gozxing/qrcode"
 )
 
+// Result is one code found in an image
+type Result struct {
+	Text   string
+	Format Format
+	// Raw is the symbol's codewords as read, when the reader reports them
+	Raw []byte
+	// Bounds encloses the points the code was found by, in image pixels. For
+	// a linear code it's a line across the bars.
+	Bounds image.Rectangle
+}
+
+// DecodeOptions choose how hard to look and for what
+type DecodeOptions struct {
+	// Formats limits the symbologies looked for; empty means DecodeFormats
+	Formats []Format
+	// TryHarder looks more thoroughly, including at the image turned on its
+	// side, at the cost of speed
+	TryHarder bool
+	// Multiple finds every different code in the image instead of stopping
+	// at the first. A DataMatrix is only found when it covers the middle of
+	// the part of the image being searched.
+	Multiple bool
+}
+
+// DecodeFormats are the symbologies that can be read, in the order they're
+// tried. PDF417 isn't among them as gozxing has no reader for it.
+var DecodeFormats = []Format{
+	FormatQR, FormatDataMatrix,
+	FormatEAN13, FormatEAN8, FormatUPCA, FormatUPCE,
+	FormatCode128, FormatCode39, FormatITF,
+}
+
+var zxFormats = map[Format]gozxing.BarcodeFormat{
+	FormatQR:         gozxing.BarcodeFormat_QR_CODE,
+	FormatDataMatrix: gozxing.BarcodeFormat_DATA_MATRIX,
+	FormatEAN13:      gozxing.BarcodeFormat_EAN_13,
+	FormatEAN8:       gozxing.BarcodeFormat_EAN_8,
+	FormatUPCA:       gozxing.BarcodeFormat_UPC_A,
+	FormatUPCE:       gozxing.BarcodeFormat_UPC_E,
+	FormatCode128:    gozxing.BarcodeFormat_CODE_128,
+	FormatCode39:     gozxing.BarcodeFormat_CODE_39,
+	FormatITF:        gozxing.BarcodeFormat_ITF,
+}
+
+// DecodeBarcodeFromImage reads the first code in an image file, looking hard
 func DecodeBarcodeFromImage(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -19,52 +67,192 @@ func DecodeBarcodeFromImage(filePath string) (string, error) {
 	}
 	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	results, err := DecodeReader(file, DecodeOptions{TryHarder: true})
 	if err != nil {
 		return "", err
 	}
+	return results[0].Text, nil
+}
+
+// DecodeReader reads codes from a PNG or JPEG
+func DecodeReader(r io.Reader, opts DecodeOptions) ([]Result, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeImage(img, opts)
+}
+
+// DecodeImage reads the codes in img: the first one found, or every
+// different one with opts.Multiple. It fails if there are none.
+func DecodeImage(img image.Image, opts DecodeOptions) ([]Result, error) {
+	reader, hints, err := newMultiFormatReader(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// Convert image to binary bitmap
 	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
 	if err != nil {
-		return "", fmt.Errorf("failed to create binary bitmap: %v", err)
+		return nil, fmt.Errorf("failed to create binary bitmap: %v", err)
 	}
 
-	// Try QR code first
-	qrReader := qrcode.NewQRCodeReader()
-	result, err := qrReader.Decode(bmp, nil)
-	if err == nil && result != nil {
-		return result.String(), nil
+	var results []Result
+	if opts.Multiple {
+		decodeMultiple(reader, bmp, hints, image.Point{}, 0, &results)
+	} else if found, err := reader.decode(bmp, hints); err == nil {
+		results = append(results, toResult(found, image.Point{}))
+	}
+	if len(results) == 0 {
+		return nil, errors.New("could not decode barcode or QR code from image")
 	}
+	return results, nil
+}
+
+// multiFormatReader tries a reader per symbology in turn
+type multiFormatReader []gozxing.Reader
 
-	// Try Code128
-	reader := oned.NewCode128Reader()
-	result, err = reader.Decode(bmp, nil)
-	if err == nil && result != nil {
-		return result.String(), nil
+func (readers multiFormatReader) decode(bmp *gozxing.BinaryBitmap, hints map[gozxing.DecodeHintType]interface{}) (*gozxing.Result, error) {
+	for _, reader := range readers {
+		if result, err := reader.Decode(bmp, hints); err == nil && result != nil {
+			return result, nil
+		}
 	}
+	return nil, errors.New("no barcode found")
+}
 
-	// Try EAN13
-	eanReader := oned.NewEAN13Reader()
-	result, err = eanReader.Decode(bmp, nil)
-	if err == nil && result != nil {
-		return result.String(), nil
+// newMultiFormatReader builds the readers for opts.Formats and the hints to
+// pass them. GS1-128 keeps its ]C1 identifier and turns each later FNC1 into
+// a group separator, as ParseGS1 expects.
+func newMultiFormatReader(opts DecodeOptions) (multiFormatReader, map[gozxing.DecodeHintType]interface{}, error) {
+	formats := opts.Formats
+	if len(formats) == 0 {
+		formats = DecodeFormats
 	}
 
-	// Try EAN8
-	ean8Reader := oned.NewEAN8Reader()
-	result, err = ean8Reader.Decode(bmp, nil)
-	if err == nil && result != nil {
-		return result.String(), nil
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_ASSUME_GS1: true}
+	if opts.TryHarder {
+		hints[gozxing.DecodeHintType_TRY_HARDER] = true
 	}
+	var possible []gozxing.BarcodeFormat
+	for _, format := range formats {
+		zx, ok := zxFormats[format]
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot decode %q barcodes", format)
+		}
+		possible = append(possible, zx)
+	}
+	hints[gozxing.DecodeHintType_POSSIBLE_FORMATS] = possible
 
-	// Try UPC-A
-	upcReader := oned.NewUPCAReader()
-	result, err = upcReader.Decode(bmp, nil)
-	if err == nil && result != nil {
-		return result.String(), nil
+	var readers multiFormatReader
+	upcean := false
+	for _, format := range formats {
+		switch format {
+		case FormatQR:
+			readers = append(readers, qrcode.NewQRCodeReader())
+		case FormatDataMatrix:
+			readers = append(readers, datamatrix.NewDataMatrixReader())
+		case FormatEAN13, FormatEAN8, FormatUPCA, FormatUPCE:
+			// One reader covers the UPC/EAN family, picking from the hints
+			if !upcean {
+				readers = append(readers, oned.NewMultiFormatUPCEANReader(hints))
+				upcean = true
+			}
+		case FormatCode128:
+			readers = append(readers, oned.NewCode128Reader())
+		case FormatCode39:
+			readers = append(readers, oned.NewCode39Reader())
+		case FormatITF:
+			readers = append(readers, oned.NewITFReader())
+		}
 	}
+	return readers, hints, nil
+}
 
-	return "", fmt.Errorf("could not decode barcode or QR code from image")
+// minDecodeRegion is the smallest strip of image worth searching for another
+// code, and maxDecodeDepth how many times to split the image
+const (
+	minDecodeRegion = 100
+	maxDecodeDepth  = 4
+)
+
+// decodeMultiple finds a code, then searches the parts of the image left of,
+// above, right of and below it in turn, like ZXing's generic multiple
+// barcode reader. offset places bmp within the original image.
+func decodeMultiple(reader multiFormatReader, bmp *gozxing.BinaryBitmap, hints map[gozxing.DecodeHintType]interface{}, offset image.Point, depth int, results *[]Result) {
+	if depth > maxDecodeDepth {
+		return
+	}
+	found, err := reader.decode(bmp, hints)
+	if err != nil {
+		return
+	}
+
+	result := toResult(found, offset)
+	duplicate := false
+	for _, r := range *results {
+		if r.Text == result.Text && r.Format == result.Format {
+			duplicate = true
+			break
+		}
+	}
+	if !duplicate {
+		*results = append(*results, result)
+	}
+
+	// Search around where it was found, within bmp
+	bounds := result.Bounds.Sub(offset)
+	if bounds.Empty() {
+		return
+	}
+	width, height := bmp.GetWidth(), bmp.GetHeight()
+	left, top, right, bottom := bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y
+	search := func(x, y, w, h int) {
+		if part, err := bmp.Crop(x, y, w, h); err == nil {
+			decodeMultiple(reader, part, hints, offset.Add(image.Pt(x, y)), depth+1, results)
+		}
+	}
+	if left > minDecodeRegion {
+		search(0, 0, left, height)
+	}
+	if top > minDecodeRegion {
+		search(0, 0, width, top)
+	}
+	if right < width-minDecodeRegion {
+		search(right, 0, width-right, height)
+	}
+	if bottom < height-minDecodeRegion {
+		search(0, bottom, width, height-bottom)
+	}
 }
 
+func toResult(found *gozxing.Result, offset image.Point) Result {
+	result := Result{Text: found.GetText(), Raw: found.GetRawBytes()}
+	for format, zx := range zxFormats {
+		if zx == found.GetBarcodeFormat() {
+			result.Format = format
+		}
+	}
+
+	// An EAN or UPC supplement is often a neighbouring code misread, so only
+	// the main symbol counts towards the position
+	points := found.GetResultPoints()
+	if _, ok := found.GetResultMetadata()[gozxing.ResultMetadataType_UPC_EAN_EXTENSION]; ok && len(points) > 2 {
+		points = points[:2]
+	}
+
+	first := true
+	for _, p := range points {
+		if p == nil {
+			continue
+		}
+		pt := image.Pt(int(p.GetX()), int(p.GetY())).Add(offset)
+		box := image.Rectangle{Min: pt, Max: pt.Add(image.Pt(1, 1))}
+		if first {
+			result.Bounds, first = box, false
+		} else {
+			result.Bounds = result.Bounds.Union(box)
+		}
+	}
+	return result
+}
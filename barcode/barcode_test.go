@@ -0,0 +1,133 @@
+package barcode
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+// sheetOf draws codes onto a white page, each at its point
+func sheetOf(t *testing.T, width, height int, codes map[image.Point]image.Image) *image.Gray {
+	sheet := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+	for at, img := range codes {
+		draw.Draw(sheet, img.Bounds().Add(at), img, img.Bounds().Min, draw.Src)
+	}
+	return sheet
+}
+
+func mustEncode(t *testing.T, contents string, format Format, width, height int) image.Image {
+	img, err := Encode(contents, format, width, height)
+	if err != nil {
+		t.Fatalf("Encode %q failed: %v", contents, err)
+	}
+	return img
+}
+
+func TestDecodeImage_Formats(t *testing.T) {
+	tests := []struct {
+		contents string
+		format   Format
+		want     string
+	}{
+		{"95012346", FormatEAN8, "95012346"},
+		{"012345678905", FormatUPCA, "012345678905"},
+		{"01234565", FormatUPCE, "01234565"},
+		{"ABC-123", FormatCode39, "ABC-123"},
+		{"12345678", FormatITF, "12345678"},
+		{"LOT 42 / 2029-12-31", FormatDataMatrix, "LOT 42 / 2029-12-31"},
+	}
+	for _, tt := range tests {
+		img := sheetOf(t, 400, 300, map[image.Point]image.Image{{40, 40}: mustEncode(t, tt.contents, tt.format, 300, 150)})
+		results, err := DecodeImage(img, DecodeOptions{TryHarder: true})
+		if err != nil {
+			t.Errorf("%s: DecodeImage failed: %v", tt.format, err)
+			continue
+		}
+		if results[0].Text != tt.want || results[0].Format != tt.format {
+			t.Errorf("%s: got %q as %s", tt.format, results[0].Text, results[0].Format)
+		}
+	}
+}
+
+func TestDecodeImage_Multiple(t *testing.T) {
+	// A delivery note with a code in each corner
+	sheet := sheetOf(t, 1000, 800, map[image.Point]image.Image{
+		{20, 20}:   mustEncode(t, "4006381333931", FormatEAN13, 300, 150),
+		{600, 20}:  mustEncode(t, "CL001", FormatCode128, 300, 150),
+		{20, 450}:  mustEncode(t, "https://example.com/po/7", FormatQR, 250, 250),
+		{600, 500}: mustEncode(t, "12345678", FormatITF, 300, 150),
+	})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sheet); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	results, err := DecodeReader(&buf, DecodeOptions{TryHarder: true, Multiple: true})
+	if err != nil {
+		t.Fatalf("DecodeReader failed: %v", err)
+	}
+
+	want := map[string]struct {
+		format Format
+		corner image.Rectangle
+	}{
+		"4006381333931":            {FormatEAN13, image.Rect(0, 0, 500, 400)},
+		"CL001":                    {FormatCode128, image.Rect(500, 0, 1000, 400)},
+		"https://example.com/po/7": {FormatQR, image.Rect(0, 400, 500, 800)},
+		"12345678":                 {FormatITF, image.Rect(500, 400, 1000, 800)},
+	}
+	if len(results) != len(want) {
+		t.Errorf("Expected %d codes, got %+v", len(want), results)
+	}
+	for _, r := range results {
+		w, ok := want[r.Text]
+		if !ok {
+			t.Errorf("Unexpected code %q", r.Text)
+			continue
+		}
+		if r.Format != w.format {
+			t.Errorf("%s: expected %s, got %s", r.Text, w.format, r.Format)
+		}
+		if !r.Bounds.In(w.corner) {
+			t.Errorf("%s: found at %v, expected within %v", r.Text, r.Bounds, w.corner)
+		}
+	}
+
+	// Without Multiple only one comes back
+	if results, err := DecodeImage(sheet, DecodeOptions{}); err != nil || len(results) != 1 {
+		t.Errorf("Expected a single code, got %+v, %v", results, err)
+	}
+
+	// Formats narrows the search
+	results, err = DecodeImage(sheet, DecodeOptions{Formats: []Format{FormatQR}, Multiple: true})
+	if err != nil || len(results) != 1 || results[0].Format != FormatQR || len(results[0].Raw) == 0 {
+		t.Errorf("Expected only the QR code with its raw bytes, got %+v, %v", results, err)
+	}
+	if _, err := DecodeImage(sheet, DecodeOptions{Formats: []Format{"pdf417"}}); err == nil {
+		t.Error("Expected error for a format that can't be read")
+	}
+}
+
+func TestDecodeImage_Rotated(t *testing.T) {
+	code := sheetOf(t, 400, 200, map[image.Point]image.Image{{30, 25}: mustEncode(t, "CL001", FormatCode128, 300, 150)})
+
+	// Turn it on its side
+	b := code.Bounds()
+	rotated := image.NewGray(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for x := 0; x < b.Dx(); x++ {
+		for y := 0; y < b.Dy(); y++ {
+			rotated.SetGray(b.Dy()-1-y, x, code.GrayAt(x, y))
+		}
+	}
+
+	if _, err := DecodeImage(rotated, DecodeOptions{}); err == nil {
+		t.Error("Expected a sideways barcode to need TryHarder")
+	}
+	results, err := DecodeImage(rotated, DecodeOptions{TryHarder: true})
+	if err != nil || results[0].Text != "CL001" {
+		t.Errorf("Expected CL001 when trying harder, got %+v, %v", results, err)
+	}
+}
@@ -0,0 +1,88 @@
+package barcode
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileResult is what was read from one image file
+type FileResult struct {
+	Path    string
+	Results []Result
+	Err     error
+}
+
+// imageExtensions are the file types DecodeDir reads
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true}
+
+// DecodeDir decodes every PNG and JPEG directly inside dir, using up to
+// workers goroutines, or one per CPU when workers is 0. There is a result
+// for each image, in file name order, with Err set when nothing could be
+// read from it. progress, if given, is called after each file, never from
+// two workers at once. Cancelling ctx skips the files not yet started and
+// returns the context's error along with the results so far.
+func DecodeDir(ctx context.Context, dir string, workers int, opts DecodeOptions, progress func(done, total int)) ([]FileResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var results []FileResult
+	for _, entry := range entries {
+		if !entry.IsDir() && imageExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			results = append(results, FileResult{Path: filepath.Join(dir, entry.Name())})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker writes only its own files' results
+			for i := range jobs {
+				results[i].Results, results[i].Err = decodeFile(results[i].Path, opts)
+				mu.Lock()
+				done++
+				if progress != nil {
+					progress(done, len(results))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range results {
+		if ctx.Err() == nil {
+			select {
+			case jobs <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		results[i].Err = ctx.Err()
+	}
+	close(jobs)
+	wg.Wait()
+	return results, ctx.Err()
+}
+
+func decodeFile(path string, opts DecodeOptions) ([]Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return DecodeReader(file, opts)
+}
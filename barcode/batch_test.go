@@ -0,0 +1,79 @@
+package barcode
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeDir(t *testing.T) {
+	dir := t.TempDir()
+	codes := map[string]string{"carton-1.png": "CL001", "carton-2.PNG": "4006381333931", "carton-3.png": "WT001"}
+	for name, code := range codes {
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to create image: %v", err)
+		}
+		if err := png.Encode(file, sheetOf(t, 400, 250, map[image.Point]image.Image{{40, 40}: mustEncode(t, code, FormatFor(code), 300, 150)})); err != nil {
+			t.Fatalf("Failed to write image: %v", err)
+		}
+		file.Close()
+	}
+	// Not an image despite its name, and files and folders that aren't read
+	os.WriteFile(filepath.Join(dir, "blurry.jpg"), []byte("not a photo"), 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("two cartons"), 0o644)
+	os.Mkdir(filepath.Join(dir, "old.png"), 0o755)
+
+	calls, last := 0, 0
+	results, err := DecodeDir(context.Background(), dir, 2, DecodeOptions{TryHarder: true}, func(done, total int) {
+		calls++
+		last = done
+		if total != 4 {
+			t.Errorf("Expected 4 files in total, got %d", total)
+		}
+	})
+	if err != nil {
+		t.Fatalf("DecodeDir failed: %v", err)
+	}
+	if calls != 4 || last != 4 {
+		t.Errorf("Expected progress for each of 4 files, got %d calls ending at %d", calls, last)
+	}
+
+	want := []struct{ name, code string }{
+		{"blurry.jpg", ""}, {"carton-1.png", "CL001"}, {"carton-2.PNG", "4006381333931"}, {"carton-3.png", "WT001"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %+v", len(want), results)
+	}
+	for i, w := range want {
+		r := results[i]
+		if filepath.Base(r.Path) != w.name {
+			t.Errorf("Result %d: expected %s, got %s", i, w.name, r.Path)
+			continue
+		}
+		if w.code == "" {
+			if r.Err == nil {
+				t.Errorf("%s: expected an error", w.name)
+			}
+			continue
+		}
+		if r.Err != nil || len(r.Results) != 1 || r.Results[0].Text != w.code {
+			t.Errorf("%s: expected %s, got %+v, %v", w.name, w.code, r.Results, r.Err)
+		}
+	}
+
+	// A cancelled run reads nothing more
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = DecodeDir(ctx, dir, 2, DecodeOptions{}, nil)
+	if err != context.Canceled || len(results) != 4 || results[3].Err != context.Canceled {
+		t.Errorf("Expected cancelled results, got %+v, %v", results, err)
+	}
+
+	if _, err := DecodeDir(context.Background(), filepath.Join(dir, "missing"), 2, DecodeOptions{}, nil); err == nil {
+		t.Error("Expected error for a missing folder")
+	}
+}
@@ -0,0 +1,108 @@
+package barcode
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// Format is a symbology a code can be printed in
+type Format string
+
+const (
+	FormatCode128    Format = "code128"
+	FormatCode39     Format = "code39"
+	FormatITF        Format = "itf"
+	FormatEAN13      Format = "ean13"
+	FormatEAN8       Format = "ean8"
+	FormatUPCA       Format = "upca"
+	FormatUPCE       Format = "upce"
+	FormatQR         Format = "qr"
+	FormatDataMatrix Format = "datamatrix"
+)
+
+// Encode draws contents as a barcode at least width by height pixels, black
+// on white, with the symbology's quiet zone around it. An EAN-13 may be given
+// as 12 digits and gets its check digit added.
+func Encode(contents string, format Format, width, height int) (image.Image, error) {
+	var writer gozxing.Writer
+	var zxFormat gozxing.BarcodeFormat
+	switch format {
+	case FormatCode128:
+		writer, zxFormat = oned.NewCode128Writer(), gozxing.BarcodeFormat_CODE_128
+	case FormatCode39:
+		writer, zxFormat = oned.NewCode39Writer(), gozxing.BarcodeFormat_CODE_39
+	case FormatITF:
+		writer, zxFormat = oned.NewITFWriter(), gozxing.BarcodeFormat_ITF
+	case FormatEAN13:
+		writer, zxFormat = oned.NewEAN13Writer(), gozxing.BarcodeFormat_EAN_13
+	case FormatEAN8:
+		writer, zxFormat = oned.NewEAN8Writer(), gozxing.BarcodeFormat_EAN_8
+	case FormatUPCA:
+		writer, zxFormat = oned.NewUPCAWriter(), gozxing.BarcodeFormat_UPC_A
+	case FormatUPCE:
+		writer, zxFormat = oned.NewUPCEWriter(), gozxing.BarcodeFormat_UPC_E
+	case FormatQR:
+		writer, zxFormat = qrcode.NewQRCodeWriter(), gozxing.BarcodeFormat_QR_CODE
+	case FormatDataMatrix:
+		writer, zxFormat = datamatrix.NewDataMatrixWriter(), gozxing.BarcodeFormat_DATA_MATRIX
+	default:
+		return nil, fmt.Errorf("unknown barcode format %q", format)
+	}
+
+	matrix, err := writer.Encode(contents, zxFormat, width, height, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode %q as %s: %v", contents, format, err)
+	}
+	return matrix, nil
+}
+
+// FormatFor picks the linear symbology for a code: EAN-13 for a valid
+// 13-digit EAN, otherwise Code128, which takes any ASCII
+func FormatFor(code string) Format {
+	if ValidEAN13(code) {
+		return FormatEAN13
+	}
+	return FormatCode128
+}
+
+// EAN13CheckDigit returns the check digit for the first 12 digits of an
+// EAN-13
+func EAN13CheckDigit(digits string) (int, error) {
+	if len(digits) != 12 {
+		return 0, fmt.Errorf("expected 12 digits, got %d", len(digits))
+	}
+	return checkDigit(digits)
+}
+
+// checkDigit is the GS1 check digit for any number of digits, as used by
+// every GTIN length
+func checkDigit(digits string) (int, error) {
+	sum := 0
+	for i, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid digit %q", r)
+		}
+		d := int(r - '0')
+		// Weights alternate 3 and 1, starting from the right
+		if (len(digits)-i)%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10, nil
+}
+
+// ValidEAN13 reports whether code is 13 digits with a correct check digit
+func ValidEAN13(code string) bool {
+	if len(code) != 13 {
+		return false
+	}
+	check, err := EAN13CheckDigit(code[:12])
+	return err == nil && strconv.Itoa(check) == code[12:]
+}
@@ -0,0 +1,132 @@
+package barcode
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ims-go/money"
+)
+
+// decodeImage saves img and reads it back with DecodeBarcodeFromImage
+func decodeImage(t *testing.T, img image.Image) string {
+	path := filepath.Join(t.TempDir(), "code.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create image file: %v", err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	file.Close()
+
+	decoded, err := DecodeBarcodeFromImage(path)
+	if err != nil {
+		t.Fatalf("DecodeBarcodeFromImage failed: %v", err)
+	}
+	return decoded
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	tests := []struct {
+		contents string
+		format   Format
+		want     string
+	}{
+		{"ABC-12345", FormatCode128, "ABC-12345"},
+		{"400638133393", FormatEAN13, "4006381333931"}, // check digit added
+		{"4006381333931", FormatEAN13, "4006381333931"},
+		{"https://example.com/item/42", FormatQR, "https://example.com/item/42"},
+	}
+	for _, tt := range tests {
+		img, err := Encode(tt.contents, tt.format, 300, 120)
+		if err != nil {
+			t.Fatalf("Encode %q failed: %v", tt.contents, err)
+		}
+		if got := decodeImage(t, img); got != tt.want {
+			t.Errorf("Encode %q as %s: decoded %q, want %q", tt.contents, tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := Encode("4006381333932", FormatEAN13, 0, 0); err == nil {
+		t.Error("Expected error for a wrong EAN-13 check digit")
+	}
+	if _, err := Encode("ABC", FormatEAN13, 0, 0); err == nil {
+		t.Error("Expected error for a non-numeric EAN-13")
+	}
+	if _, err := Encode("ABC", "pdf417", 0, 0); err == nil {
+		t.Error("Expected error for an unknown format")
+	}
+}
+
+func TestEAN13CheckDigit(t *testing.T) {
+	check, err := EAN13CheckDigit("500011263792")
+	if err != nil || check != 2 {
+		t.Errorf("Expected check digit 2, got %d, %v", check, err)
+	}
+	if _, err := EAN13CheckDigit("12345"); err == nil {
+		t.Error("Expected error for too few digits")
+	}
+	if !ValidEAN13("5000112637922") || ValidEAN13("5000112637923") || ValidEAN13("500011263792X") {
+		t.Error("ValidEAN13 gave the wrong answer")
+	}
+	if FormatFor("5000112637922") != FormatEAN13 || FormatFor("CL001") != FormatCode128 {
+		t.Error("FormatFor picked the wrong symbology")
+	}
+}
+
+func TestRenderSheets(t *testing.T) {
+	tmpl, err := TemplateByName("Shelf label")
+	if err != nil {
+		t.Fatalf("TemplateByName failed: %v", err)
+	}
+
+	// One more label than fits on a sheet needs a second sheet
+	expiry := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+	labels := make([]Label, tmpl.PerSheet()+1)
+	for i := range labels {
+		labels[i] = Label{Name: "Apple Juice 1L", Price: money.MustParse("2.49"), Code: "2000000000015", Expiry: &expiry}
+	}
+	sheets, err := RenderSheets(labels, tmpl, PaperA4)
+	if err != nil {
+		t.Fatalf("RenderSheets failed: %v", err)
+	}
+	if len(sheets) != 2 {
+		t.Fatalf("Expected 2 sheets, got %d", len(sheets))
+	}
+	if w := sheets[0].Bounds().Dx(); w != 1653 {
+		t.Errorf("Expected an A4 sheet 1653 pixels wide, got %d", w)
+	}
+
+	// A label cut from the sheet still scans
+	cellW := sheets[0].Bounds().Dx() / tmpl.Columns
+	cellH := sheets[0].Bounds().Dy() / tmpl.Rows
+	if got := decodeImage(t, sheets[1].SubImage(image.Rect(0, 0, cellW, cellH))); got != "2000000000015" {
+		t.Errorf("Expected the label to scan as 2000000000015, got %q", got)
+	}
+
+	qr, _ := TemplateByName("Stock label (QR)")
+	if _, err := RenderSheets(labels[:1], qr, PaperLetter); err != nil {
+		t.Errorf("RenderSheets with QR failed: %v", err)
+	}
+	if _, err := RenderSheets(nil, tmpl, PaperA4); err == nil {
+		t.Error("Expected error for no labels")
+	}
+	if _, err := TemplateByName("Nope"); err == nil {
+		t.Error("Expected error for an unknown template")
+	}
+
+	var pdf bytes.Buffer
+	if err := WritePDF(&pdf, sheets, PaperA4); err != nil {
+		t.Fatalf("WritePDF failed: %v", err)
+	}
+	out := pdf.String()
+	if !strings.HasPrefix(out, "%PDF-1.4") || !strings.HasSuffix(out, "%%EOF\n") || !strings.Contains(out, "/Count 2") {
+		t.Errorf("Unexpected PDF structure: %.60q...", out)
+	}
+}
@@ -0,0 +1,361 @@
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ims-go/money"
+)
+
+// GroupSeparator ends a variable-length field in a GS1 element string. Scanners
+// send it in place of the FNC1 character printed in the barcode.
+const GroupSeparator = "\x1d"
+
+// Scale labels are EAN-13s starting with 2 whose last digits carry a price or
+// weight: two prefix digits, a five-digit item reference, a five-digit value
+// and the check digit. Which prefixes carry which is up to each store; 20 is
+// left out so it can't be mistaken for a code allocated in store.
+var (
+	PricePrefixes  = []string{"21", "22", "23", "24"}
+	WeightPrefixes = []string{"25", "26", "27", "28", "29"}
+)
+
+// Currency is the ISO 4217 numeric code of the store's currency. An amount
+// payable in any other currency (393x) is refused rather than charged as if
+// it were in this one.
+var Currency = "840"
+
+// GS1 is what a GS1 barcode says about the goods it's on. Fields that weren't
+// in the code are left empty.
+type GS1 struct {
+	// GTIN identifies the product, always as 14 digits. For a scale label
+	// it's the label with its value zeroed.
+	GTIN   string
+	Lot    string
+	Expiry *time.Time
+	Weight *int // net weight in grams
+	Price  *money.Money
+	// Fields holds every application identifier read and its raw value
+	Fields map[string]string
+}
+
+// applicationIdentifier describes the data that follows an AI. Fixed fields
+// are exactly length characters, others up to length and ended by a group
+// separator unless they come last.
+type applicationIdentifier struct {
+	length int
+	fixed  bool
+}
+
+// applicationIdentifiers are the AIs that can be read from an element string
+// without brackets, by their first two digits. Four-digit AIs such as 3103
+// are looked up by their first three.
+var applicationIdentifiers = map[string]applicationIdentifier{
+	"00":  {18, true},  // SSCC
+	"01":  {14, true},  // GTIN
+	"02":  {14, true},  // GTIN of contained goods
+	"10":  {20, false}, // lot
+	"11":  {6, true},   // production date
+	"13":  {6, true},   // packaging date
+	"15":  {6, true},   // best before
+	"16":  {6, true},   // sell by
+	"17":  {6, true},   // expiry
+	"21":  {20, false}, // serial number
+	"30":  {8, false},  // count
+	"37":  {8, false},  // count of contained goods
+	"310": {6, true},   // net weight, kg
+	"320": {6, true},   // net weight, lb
+	"392": {15, false}, // amount payable
+	"393": {18, false}, // amount payable with ISO currency
+	"400": {30, false}, // customer's order number
+}
+
+// aiLength is how many digits the AI at the start of s takes
+func aiLength(s string) int {
+	if len(s) >= 3 {
+		switch s[:3] {
+		case "310", "320", "392", "393":
+			return 4
+		case "400":
+			return 3
+		}
+	}
+	return 2
+}
+
+// ParseGS1 reads a scanned code as GS1: a GS1-128, DataMatrix or QR element
+// string, with or without its symbology identifier or in the printed
+// "(01)...(17)..." form, a scale label, or a plain EAN-8, UPC-A, EAN-13 or
+// GTIN-14. Anything else is an error, so callers can fall back to treating
+// the code as their own.
+func ParseGS1(code string) (*GS1, error) {
+	code = strings.TrimSpace(code)
+
+	// A symbology identifier says outright that GS1 data follows
+	identified := false
+	for _, id := range []string{"]C1", "]d2", "]Q3", "]e0"} {
+		if strings.HasPrefix(code, id) {
+			code, identified = code[len(id):], true
+			break
+		}
+	}
+	if strings.HasPrefix(code, GroupSeparator) {
+		code, identified = code[1:], true
+	}
+
+	if !identified {
+		if strings.HasPrefix(code, "(") {
+			return parseBracketed(code)
+		}
+		if gtin, err := NormalizeGTIN(code); err == nil {
+			if len(code) == 13 {
+				if parsed := parseScaleLabel(code); parsed != nil {
+					return parsed, nil
+				}
+			}
+			return &GS1{GTIN: gtin, Fields: map[string]string{"01": gtin}}, nil
+		}
+	}
+
+	fields := make(map[string]string)
+	for code != "" {
+		n := aiLength(code)
+		if len(code) < n {
+			return nil, fmt.Errorf("incomplete application identifier %q", code)
+		}
+		ai := code[:n]
+		spec, ok := applicationIdentifiers[code[:2]]
+		if n > 2 {
+			spec, ok = applicationIdentifiers[code[:3]]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown application identifier %s", ai)
+		}
+		code = code[n:]
+
+		var value string
+		if spec.fixed {
+			if len(code) < spec.length {
+				return nil, fmt.Errorf("AI %s needs %d characters", ai, spec.length)
+			}
+			value, code = code[:spec.length], code[spec.length:]
+			code = strings.TrimPrefix(code, GroupSeparator)
+		} else if end := strings.Index(code, GroupSeparator); end >= 0 {
+			value, code = code[:end], code[end+1:]
+		} else {
+			value, code = code, ""
+		}
+		fields[ai] = value
+	}
+
+	// Without an identifier, only treat it as GS1 if it names a product
+	if !identified && fields["01"] == "" && fields["02"] == "" {
+		return nil, errors.New("not a GS1 code")
+	}
+	return fromFields(fields)
+}
+
+// parseBracketed reads the printed form, where every AI is in brackets
+func parseBracketed(code string) (*GS1, error) {
+	fields := make(map[string]string)
+	for code != "" {
+		if code[0] != '(' {
+			return nil, fmt.Errorf("expected ( at %q", code)
+		}
+		end := strings.IndexByte(code, ')')
+		if end < 0 {
+			return nil, errors.New("unclosed application identifier")
+		}
+		ai := code[1:end]
+		code = code[end+1:]
+		next := strings.IndexByte(code, '(')
+		if next < 0 {
+			next = len(code)
+		}
+		fields[ai], code = code[:next], code[next:]
+	}
+	return fromFields(fields)
+}
+
+// parseScaleLabel reads an EAN-13 whose prefix is one of PricePrefixes or
+// WeightPrefixes, or returns nil
+func parseScaleLabel(code string) *GS1 {
+	isPrice := hasPrefix(code, PricePrefixes)
+	if !isPrice && !hasPrefix(code, WeightPrefixes) {
+		return nil
+	}
+	value, err := strconv.Atoi(code[7:12])
+	if err != nil {
+		return nil
+	}
+
+	// The product is known by its label with no value
+	product := code[:7] + "00000"
+	check, err := EAN13CheckDigit(product)
+	if err != nil {
+		return nil
+	}
+	parsed := &GS1{GTIN: "0" + product + strconv.Itoa(check), Fields: map[string]string{}}
+	if isPrice {
+		price := money.FromCents(int64(value))
+		parsed.Price = &price
+	} else {
+		parsed.Weight = &value
+	}
+	return parsed
+}
+
+func hasPrefix(code string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(code, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromFields picks out the fields we use from a parsed element string
+func fromFields(fields map[string]string) (*GS1, error) {
+	parsed := &GS1{Lot: fields["10"], Fields: fields}
+
+	gtin := fields["01"]
+	if gtin == "" {
+		gtin = fields["02"]
+	}
+	if gtin != "" {
+		normalized, err := NormalizeGTIN(gtin)
+		if err != nil {
+			return nil, err
+		}
+		parsed.GTIN = normalized
+	}
+
+	if v, ok := fields["17"]; ok {
+		expiry, err := parseGS1Date(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry date: %v", err)
+		}
+		parsed.Expiry = &expiry
+	}
+
+	for ai, v := range fields {
+		if len(ai) != 4 {
+			continue
+		}
+		decimals := int(ai[3] - '0')
+		switch ai[:3] {
+		case "310", "320":
+			// Kilograms or pounds, as grams. A pound is 0.45359237 kg.
+			multiplier, divisor := int64(1000), int64(1)
+			if ai[:3] == "320" {
+				multiplier, divisor = 45359237, 100000
+			}
+			grams, err := decimalValue(v, decimals, multiplier, divisor)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight: %v", err)
+			}
+			weight := int(grams)
+			parsed.Weight = &weight
+		case "392", "393":
+			if ai[:3] == "393" {
+				if len(v) < 4 {
+					return nil, errors.New("invalid amount payable")
+				}
+				if currency := v[:3]; currency != Currency {
+					return nil, fmt.Errorf("amount payable is in currency %s, not the store's %s", currency, Currency)
+				}
+				v = v[3:]
+			}
+			cents, err := decimalValue(v, decimals, 100, 1)
+			if err != nil {
+				return nil, fmt.Errorf("invalid price: %v", err)
+			}
+			price := money.FromCents(cents)
+			parsed.Price = &price
+		}
+	}
+	return parsed, nil
+}
+
+// decimalValue reads digits with an implied decimal point decimals from the
+// right, times multiplier over divisor, rounded to the nearest whole number.
+// Kilograms with a multiplier of 1000 come out as grams, an amount with 100
+// as cents.
+func decimalValue(digits string, decimals int, multiplier, divisor int64) (int64, error) {
+	if digits == "" || len(digits) > 15 || strings.Trim(digits, "0123456789") != "" || decimals > 9 {
+		return 0, fmt.Errorf("%q is not a number", digits)
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", digits)
+	}
+	for ; decimals > 0; decimals-- {
+		divisor *= 10
+	}
+	// Neither side overflows: n has at most 15 digits and multiplier is
+	// 100 or 1000 for those long enough to come near it
+	return (n*multiplier + divisor/2) / divisor, nil
+}
+
+// parseGS1Date reads a YYMMDD date. A day of 00 means the end of the month,
+// and the century is whichever puts the year within 50 years of now.
+func parseGS1Date(v string) (time.Time, error) {
+	if len(v) != 6 {
+		return time.Time{}, fmt.Errorf("%q is not YYMMDD", v)
+	}
+	yy, err1 := strconv.Atoi(v[:2])
+	mm, err2 := strconv.Atoi(v[2:4])
+	dd, err3 := strconv.Atoi(v[4:])
+	if err1 != nil || err2 != nil || err3 != nil || mm < 1 || mm > 12 || dd > 31 {
+		return time.Time{}, fmt.Errorf("%q is not YYMMDD", v)
+	}
+
+	now := time.Now().Year()
+	year := now - now%100 + yy
+	if year-now > 50 {
+		year -= 100
+	} else if now-year > 49 {
+		year += 100
+	}
+	if dd == 0 {
+		return time.Date(year, time.Month(mm)+1, 0, 0, 0, 0, 0, time.UTC), nil
+	}
+	date := time.Date(year, time.Month(mm), dd, 0, 0, 0, 0, time.UTC)
+	if date.Day() != dd {
+		return time.Time{}, fmt.Errorf("%q is not a real date", v)
+	}
+	return date, nil
+}
+
+// NormalizeGTIN checks an EAN-8, UPC-A, EAN-13 or GTIN-14 and returns it
+// padded to 14 digits
+func NormalizeGTIN(code string) (string, error) {
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return "", fmt.Errorf("a GTIN has 8, 12, 13 or 14 digits, not %d", len(code))
+	}
+	check, err := checkDigit(code[:len(code)-1])
+	if err != nil {
+		return "", err
+	}
+	if strconv.Itoa(check) != code[len(code)-1:] {
+		return "", fmt.Errorf("wrong check digit in %s", code)
+	}
+	return strings.Repeat("0", 14-len(code)) + code, nil
+}
+
+// GTINForms lists the ways a 14-digit GTIN may have been entered as an item
+// code: as is, then without the leading zeros of an EAN-13, UPC-A or EAN-8
+func GTINForms(gtin string) []string {
+	forms := []string{gtin}
+	for _, n := range []int{13, 12, 8} {
+		if len(gtin) == 14 && strings.Trim(gtin[:14-n], "0") == "" {
+			forms = append(forms, gtin[14-n:])
+		}
+	}
+	return forms
+}
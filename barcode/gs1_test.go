@@ -0,0 +1,124 @@
+package barcode
+
+import (
+	"testing"
+	"time"
+
+	"ims-go/money"
+)
+
+func TestParseGS1(t *testing.T) {
+	date := func(y int, m time.Month, d int) *time.Time {
+		tm := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		return &tm
+	}
+	grams := func(g int) *int { return &g }
+	price := func(s string) *money.Money {
+		m := money.MustParse(s)
+		return &m
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		gtin   string
+		lot    string
+		expiry *time.Time
+		weight *int
+		price  *money.Money
+	}{
+		{"GS1-128", "]C101095011015300031729123110LOT42" + GroupSeparator + "3103000450",
+			"09501101530003", "LOT42", date(2029, 12, 31), grams(450), nil},
+		{"DataMatrix", GroupSeparator + "01095011015300031029" + GroupSeparator + "17300600",
+			"09501101530003", "29", date(2030, 6, 30), nil, nil},
+		{"no identifier", "010950110153000317291231",
+			"09501101530003", "", date(2029, 12, 31), nil, nil},
+		{"printed", "(01)09501101530003(3922)1299(10)A1",
+			"09501101530003", "A1", nil, nil, price("12.99")},
+		{"pounds", "(01)09501101530003(3202)000110",
+			"09501101530003", "", nil, grams(499), nil},
+		{"amount in store currency", "(01)09501101530003(3932)8401299",
+			"09501101530003", "", nil, nil, price("12.99")},
+		{"sub-cent amount", "(01)09501101530003(3925)1234567",
+			"09501101530003", "", nil, nil, price("12.35")},
+		{"weight label", "2512345007500", "02512345000006", "", nil, grams(750), nil},
+		{"price label", "2112345012995", "02112345000008", "", nil, nil, price("12.99")},
+		{"in-store code", "2000000000015", "02000000000015", "", nil, nil, nil},
+		{"EAN-8", "95012346", "00000095012346", "", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		parsed, err := ParseGS1(tt.code)
+		if err != nil {
+			t.Errorf("%s: ParseGS1 failed: %v", tt.name, err)
+			continue
+		}
+		if parsed.GTIN != tt.gtin || parsed.Lot != tt.lot {
+			t.Errorf("%s: got GTIN %q lot %q, want %q %q", tt.name, parsed.GTIN, parsed.Lot, tt.gtin, tt.lot)
+		}
+		if (parsed.Expiry == nil) != (tt.expiry == nil) || (tt.expiry != nil && !parsed.Expiry.Equal(*tt.expiry)) {
+			t.Errorf("%s: got expiry %v, want %v", tt.name, parsed.Expiry, tt.expiry)
+		}
+		if (parsed.Weight == nil) != (tt.weight == nil) || (tt.weight != nil && *parsed.Weight != *tt.weight) {
+			t.Errorf("%s: got weight %v, want %v", tt.name, parsed.Weight, tt.weight)
+		}
+		if (parsed.Price == nil) != (tt.price == nil) || (tt.price != nil && *parsed.Price != *tt.price) {
+			t.Errorf("%s: got price %v, want %v", tt.name, parsed.Price, tt.price)
+		}
+	}
+
+	for _, code := range []string{
+		"CL001",
+		"10LOT42",                             // no product
+		"]C19912",                             // unknown AI
+		"]C10109501101530004",                 // wrong check digit
+		"]C10109501101530003171399",           // too short
+		"]C101095011015300031713130",          // month 13
+		"(01)09501101530003(17)290231",        // no 31 February
+		"2512345007501",                       // bad EAN-13 check digit
+		"(01)09501101530003(17)291231(10)A1(", // unclosed
+		"(01)09501101530003(3932)9781299",     // euros
+		"(01)09501101530003(3922)+199",        // not digits
+	} {
+		if _, err := ParseGS1(code); err == nil {
+			t.Errorf("Expected error parsing %q", code)
+		}
+	}
+}
+
+func TestParseGS1_Scanned(t *testing.T) {
+	// The writer takes ñ for FNC1
+	img, err := Encode("ñ010950110153000317291231"+"10LOT42ñ"+"3103000450", FormatCode128, 600, 150)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	parsed, err := ParseGS1(decodeImage(t, img))
+	if err != nil {
+		t.Fatalf("ParseGS1 failed: %v", err)
+	}
+	if parsed.GTIN != "09501101530003" || parsed.Lot != "LOT42" || parsed.Weight == nil || *parsed.Weight != 450 {
+		t.Errorf("Unexpected scan %+v", parsed)
+	}
+}
+
+func TestGTINForms(t *testing.T) {
+	if gtin, err := NormalizeGTIN("4006381333931"); err != nil || gtin != "04006381333931" {
+		t.Errorf("Expected 04006381333931, got %q, %v", gtin, err)
+	}
+	if _, err := NormalizeGTIN("400638133393"); err == nil {
+		t.Error("Expected error for a bad UPC-A")
+	}
+
+	forms := GTINForms("00000095012346")
+	want := []string{"00000095012346", "0000095012346", "000095012346", "95012346"}
+	if len(forms) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, forms)
+	}
+	for i := range want {
+		if forms[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, forms)
+		}
+	}
+	if forms := GTINForms("19501101530000"); len(forms) != 1 {
+		t.Errorf("Expected only the GTIN-14 itself, got %v", forms)
+	}
+}
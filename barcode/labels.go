@@ -0,0 +1,258 @@
+package barcode
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+
+	"github.com/makiuchi-d/gozxing"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"ims-go/money"
+)
+
+// LabelDPI is the resolution label sheets are drawn at
+const LabelDPI = 200
+
+// Paper is a sheet size in millimetres
+type Paper struct {
+	Name              string
+	WidthMM, HeightMM float64
+}
+
+var (
+	PaperA4     = Paper{Name: "A4", WidthMM: 210, HeightMM: 297}
+	PaperLetter = Paper{Name: "Letter", WidthMM: 215.9, HeightMM: 279.4}
+
+	// Papers lists the sheet sizes labels can be printed on
+	Papers = []Paper{PaperA4, PaperLetter}
+)
+
+// Label is what gets printed for one item
+type Label struct {
+	Name   string
+	Price  money.Money
+	Code   string
+	Expiry *time.Time
+}
+
+// LabelTemplate lays out a grid of labels on a sheet and chooses what each
+// label shows. The barcode always fills whatever room the text leaves.
+type LabelTemplate struct {
+	Name          string
+	Columns, Rows int
+	// MarginMM is left around the sheet and GapMM between labels
+	MarginMM, GapMM float64
+	ShowName        bool
+	ShowPrice       bool
+	ShowCode        bool // the code in text under the barcode
+	ShowExpiry      bool
+	// QR prints a QR code instead of a linear barcode
+	QR bool
+}
+
+// Templates are the label layouts on offer
+var Templates = []LabelTemplate{
+	{Name: "Shelf label", Columns: 3, Rows: 8, MarginMM: 8, GapMM: 3, ShowName: true, ShowPrice: true, ShowCode: true},
+	{Name: "Product sticker", Columns: 4, Rows: 10, MarginMM: 8, GapMM: 2, ShowPrice: true, ShowCode: true},
+	{Name: "Stock label (QR)", Columns: 3, Rows: 7, MarginMM: 8, GapMM: 3, ShowName: true, ShowCode: true, ShowExpiry: true, QR: true},
+}
+
+// TemplateByName returns the template with the given name
+func TemplateByName(name string) (LabelTemplate, error) {
+	for _, t := range Templates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return LabelTemplate{}, fmt.Errorf("unknown label template %q", name)
+}
+
+// PerSheet is the number of labels that fit on one sheet
+func (t LabelTemplate) PerSheet() int {
+	return t.Columns * t.Rows
+}
+
+// RenderSheets draws labels onto as many sheets of paper as they need, at
+// LabelDPI. It fails if any code can't be printed in its symbology.
+func RenderSheets(labels []Label, tmpl LabelTemplate, paper Paper) ([]*image.Gray, error) {
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("no labels to print")
+	}
+	if tmpl.Columns <= 0 || tmpl.Rows <= 0 {
+		return nil, fmt.Errorf("invalid label grid %dx%d", tmpl.Columns, tmpl.Rows)
+	}
+
+	px := func(mm float64) int { return int(mm * LabelDPI / 25.4) }
+	width, height := px(paper.WidthMM), px(paper.HeightMM)
+	margin, gap := px(tmpl.MarginMM), px(tmpl.GapMM)
+	cellW := (width - 2*margin - (tmpl.Columns-1)*gap) / tmpl.Columns
+	cellH := (height - 2*margin - (tmpl.Rows-1)*gap) / tmpl.Rows
+	if cellW <= 0 || cellH <= 0 {
+		return nil, fmt.Errorf("%s labels don't fit on %s paper", tmpl.Name, paper.Name)
+	}
+
+	var sheets []*image.Gray
+	for i, label := range labels {
+		slot := i % tmpl.PerSheet()
+		if slot == 0 {
+			sheet := image.NewGray(image.Rect(0, 0, width, height))
+			draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+			sheets = append(sheets, sheet)
+		}
+		x := margin + (slot%tmpl.Columns)*(cellW+gap)
+		y := margin + (slot/tmpl.Columns)*(cellH+gap)
+		cell := image.Rect(x, y, x+cellW, y+cellH)
+		if err := drawLabel(sheets[len(sheets)-1], cell, label, tmpl); err != nil {
+			return nil, err
+		}
+	}
+	return sheets, nil
+}
+
+// drawLabel draws one label into cell, text from the top down and the
+// barcode in the space left at the bottom
+func drawLabel(dst *image.Gray, cell image.Rectangle, label Label, tmpl LabelTemplate) error {
+	padding := cell.Dy() / 12
+	area := cell.Inset(padding)
+	y := area.Min.Y
+
+	text := func(s string, size float64, bold bool) error {
+		face, err := labelFace(size, bold)
+		if err != nil {
+			return err
+		}
+		defer face.Close()
+		y += face.Metrics().Ascent.Ceil()
+		drawText(dst, face, fitText(face, s, area.Dx()), area.Min.X, y)
+		y += face.Metrics().Descent.Ceil()
+		return nil
+	}
+
+	if tmpl.ShowName {
+		if err := text(label.Name, 8, true); err != nil {
+			return err
+		}
+	}
+	if tmpl.ShowPrice {
+		if err := text(label.Price.Format(), 14, true); err != nil {
+			return err
+		}
+	}
+	if tmpl.ShowExpiry && label.Expiry != nil {
+		if err := text("Best before "+label.Expiry.Format("2006-01-02"), 6, false); err != nil {
+			return err
+		}
+	}
+
+	bottom := area.Max.Y
+	if tmpl.ShowCode {
+		face, err := labelFace(6, false)
+		if err != nil {
+			return err
+		}
+		defer face.Close()
+		drawText(dst, face, fitText(face, label.Code, area.Dx()), area.Min.X, bottom-face.Metrics().Descent.Ceil())
+		bottom -= face.Metrics().Height.Ceil()
+	}
+
+	codeArea := image.Rect(area.Min.X, y+padding/2, area.Max.X, bottom)
+	if codeArea.Dy() <= 0 {
+		return fmt.Errorf("no room for a barcode on a %s", tmpl.Name)
+	}
+	format := FormatFor(label.Code)
+	if tmpl.QR {
+		format = FormatQR
+	}
+	return drawCode(dst, codeArea, label.Code, format)
+}
+
+// drawCode scales a barcode by whole pixels per module, so bars stay
+// crisp, and draws it at the top left of r
+func drawCode(dst *image.Gray, r image.Rectangle, code string, format Format) error {
+	img, err := Encode(code, format, 0, 0)
+	if err != nil {
+		return err
+	}
+	matrix := img.(*gozxing.BitMatrix)
+	modulesW, modulesH := matrix.GetWidth(), matrix.GetHeight()
+
+	scale := r.Dx() / modulesW
+	if format == FormatQR && r.Dy()/modulesH < scale {
+		scale = r.Dy() / modulesH
+	}
+	if scale < 1 {
+		return fmt.Errorf("%s is too long to fit on the label", code)
+	}
+	barHeight := r.Dy()
+	if format == FormatQR {
+		barHeight = modulesH * scale
+	}
+
+	for mx := 0; mx < modulesW; mx++ {
+		for my := 0; my < modulesH; my++ {
+			if !matrix.Get(mx, my) {
+				continue
+			}
+			x := r.Min.X + mx*scale
+			module := image.Rect(x, r.Min.Y, x+scale, r.Min.Y+barHeight)
+			if format == FormatQR {
+				y := r.Min.Y + my*scale
+				module = image.Rect(x, y, x+scale, y+scale)
+			}
+			draw.Draw(dst, module, image.Black, image.Point{}, draw.Src)
+		}
+	}
+	return nil
+}
+
+var (
+	fontsOnce             sync.Once
+	regularFont, boldFont *opentype.Font
+	fontsErr              error
+)
+
+// labelFace returns the Go font at size points for LabelDPI
+func labelFace(size float64, bold bool) (font.Face, error) {
+	fontsOnce.Do(func() {
+		if regularFont, fontsErr = opentype.Parse(goregular.TTF); fontsErr != nil {
+			return
+		}
+		boldFont, fontsErr = opentype.Parse(gobold.TTF)
+	})
+	if fontsErr != nil {
+		return nil, fontsErr
+	}
+	f := regularFont
+	if bold {
+		f = boldFont
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: LabelDPI, Hinting: font.HintingFull})
+}
+
+func drawText(dst *image.Gray, face font.Face, s string, x, baseline int) {
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(color.Black), Face: face, Dot: fixed.P(x, baseline)}
+	d.DrawString(s)
+}
+
+// fitText shortens s with an ellipsis until it fits in width pixels
+func fitText(face font.Face, s string, width int) string {
+	if font.MeasureString(face, s).Ceil() <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if t := string(runes) + "…"; font.MeasureString(face, t).Ceil() <= width {
+			return t
+		}
+	}
+	return ""
+}
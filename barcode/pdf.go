@@ -0,0 +1,93 @@
+package barcode
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+)
+
+// WritePDF writes sheets as a PDF with one page per sheet, each page a
+// grayscale image filling paper
+func WritePDF(w io.Writer, sheets []*image.Gray, paper Paper) error {
+	if len(sheets) == 0 {
+		return fmt.Errorf("no pages to write")
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	// Objects are numbered from 1 in the order they're written
+	object := func(body string, stream []byte) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\n", len(offsets), body)
+		if stream != nil {
+			buf.WriteString("stream\n")
+			buf.Write(stream)
+			buf.WriteString("\nendstream\n")
+		}
+		buf.WriteString("endobj\n")
+	}
+
+	// Points are 1/72 inch
+	pageW, pageH := paper.WidthMM*72/25.4, paper.HeightMM*72/25.4
+
+	// Catalog and page tree come first; each page then takes three objects:
+	// the page, its contents and its image
+	kids := ""
+	for i := range sheets {
+		kids += fmt.Sprintf("%d 0 R ", 3+i*3)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(sheets)), nil)
+
+	for i, sheet := range sheets {
+		content, img := 4+i*3, 5+i*3
+		object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Contents %d 0 R /Resources << /XObject << /Im0 %d 0 R >> >> >>",
+			pageW, pageH, content, img,
+		), nil)
+
+		// Scale the unit square the image is drawn in up to the page
+		ops := []byte(fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q", pageW, pageH))
+		object(fmt.Sprintf("<< /Length %d >>", len(ops)), ops)
+
+		pixels, err := deflateGray(sheet)
+		if err != nil {
+			return err
+		}
+		bounds := sheet.Bounds()
+		object(fmt.Sprintf(
+			"<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>",
+			bounds.Dx(), bounds.Dy(), len(pixels),
+		), pixels)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// deflateGray compresses an image's pixels row by row, top to bottom
+func deflateGray(img *image.Gray) ([]byte, error) {
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		start := img.PixOffset(bounds.Min.X, y)
+		if _, err := zw.Write(img.Pix[start : start+bounds.Dx()]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
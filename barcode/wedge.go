@@ -0,0 +1,87 @@
+package barcode
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Wedge tells a keyboard wedge scanner, which types out each code it reads,
+// apart from a person typing. A scanner types a whole code within a few
+// milliseconds a key, so keystrokes are held back until either a complete
+// code has arrived that quickly, or there's a pause and they are handed back
+// as ordinary typing. Enter is fed as '\n'.
+//
+// A Wedge keeps no clock of its own, it is given the time of each keystroke.
+// It is not safe for concurrent use.
+type Wedge struct {
+	Prefix    string        // typed by the scanner before each code, if set up to
+	Suffix    string        // typed after each code; empty means Enter
+	MaxGap    time.Duration // keystrokes this far apart are typing, not a scan
+	MinLength int           // shortest code, not counting prefix and suffix
+
+	held []rune
+	last time.Time
+}
+
+// Feed takes a keystroke typed at now. It returns the code once a scan is
+// complete, and any keystrokes that turned out to be typing, in the order
+// typed, to pass on to whatever has focus.
+func (w *Wedge) Feed(r rune, now time.Time) (code string, typed []rune) {
+	if len(w.held) > 0 && now.Sub(w.last) >= w.MaxGap {
+		typed = w.Release()
+	}
+	w.held = append(w.held, r)
+	w.last = now
+
+	held := string(w.held)
+	prefix, suffix := w.Prefix, w.suffix()
+	if !strings.HasPrefix(held, prefix) {
+		if strings.HasPrefix(prefix, held) {
+			return "", typed // may yet be the prefix
+		}
+		return "", append(typed, w.Release()...)
+	}
+	if len(held) < len(prefix)+len(suffix) || !strings.HasSuffix(held, suffix) {
+		return "", typed
+	}
+
+	code = held[len(prefix) : len(held)-len(suffix)]
+	if utf8.RuneCountInString(code) < w.MinLength {
+		// Too short to be a code, such as a quantity and Enter typed quickly
+		return "", append(typed, w.Release()...)
+	}
+	w.held = nil
+	return code, typed
+}
+
+// Flush hands back the held keystrokes as typing once MaxGap has passed
+// since the last one, so a person's typing shows up after the briefest
+// delay. Call it when that time is up.
+func (w *Wedge) Flush(now time.Time) []rune {
+	if len(w.held) == 0 || now.Sub(w.last) < w.MaxGap {
+		return nil
+	}
+	return w.Release()
+}
+
+// Release hands back the held keystrokes as typing straight away, such as
+// when a key that can't be part of a code is pressed
+func (w *Wedge) Release() []rune {
+	held := w.held
+	w.held = nil
+	return held
+}
+
+// Holding reports whether keystrokes are held back, waiting to see if they
+// are a scan
+func (w *Wedge) Holding() bool {
+	return len(w.held) > 0
+}
+
+func (w *Wedge) suffix() string {
+	if w.Suffix == "" {
+		return "\n"
+	}
+	return w.Suffix
+}
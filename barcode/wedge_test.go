@@ -0,0 +1,95 @@
+package barcode
+
+import (
+	"testing"
+	"time"
+)
+
+// keys feeds text to a wedge a key every gap from start, flushing between
+// keys the way a timer would, and returns the codes and the typing let through
+func keys(w *Wedge, start time.Time, gap time.Duration, text string) (codes []string, typed string, end time.Time) {
+	now := start
+	for _, r := range text {
+		if flushed := w.Flush(now); flushed != nil {
+			typed += string(flushed)
+		}
+		code, t := w.Feed(r, now)
+		typed += string(t)
+		if code != "" {
+			codes = append(codes, code)
+		}
+		now = now.Add(gap)
+	}
+	return codes, typed, now
+}
+
+func TestWedge(t *testing.T) {
+	start := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+	fast, slow := 5*time.Millisecond, 150*time.Millisecond
+
+	tests := []struct {
+		name          string
+		wedge         Wedge
+		gap           time.Duration
+		text          string
+		codes         []string
+		typed, onHold string
+	}{
+		{"scan", Wedge{MaxGap: 30 * time.Millisecond, MinLength: 4}, fast, "4006381333931\n", []string{"4006381333931"}, "", ""},
+		{"typing", Wedge{MaxGap: 30 * time.Millisecond, MinLength: 4}, slow, "12\n", nil, "12\n", ""},
+		{"typing held until flushed", Wedge{MaxGap: 30 * time.Millisecond, MinLength: 4}, slow, "cola", nil, "col", "a"},
+		{"too short", Wedge{MaxGap: 30 * time.Millisecond, MinLength: 4}, fast, "12\n", nil, "12\n", ""},
+		{"two scans", Wedge{MaxGap: 30 * time.Millisecond, MinLength: 4}, fast, "CL001\nWT001\n", []string{"CL001", "WT001"}, "", ""},
+		{"prefix and suffix", Wedge{Prefix: "~", Suffix: "#", MaxGap: 30 * time.Millisecond, MinLength: 1}, fast, "~CL001#", []string{"CL001"}, "", ""},
+		{"no prefix", Wedge{Prefix: "~", Suffix: "#", MaxGap: 30 * time.Millisecond, MinLength: 1}, fast, "CL001#", nil, "CL001#", ""},
+		{"longer prefix", Wedge{Prefix: "]X", MaxGap: 30 * time.Millisecond, MinLength: 1}, fast, "]XCL001\n", []string{"CL001"}, "", ""},
+		{"partial prefix", Wedge{Prefix: "]X", MaxGap: 30 * time.Millisecond, MinLength: 1}, fast, "]Y\n", nil, "]Y\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.wedge
+			codes, typed, end := keys(&w, start, tt.gap, tt.text)
+			if len(codes) != len(tt.codes) {
+				t.Fatalf("Expected codes %q, got %q", tt.codes, codes)
+			}
+			for i := range codes {
+				if codes[i] != tt.codes[i] {
+					t.Errorf("Expected codes %q, got %q", tt.codes, codes)
+				}
+			}
+			if typed != tt.typed {
+				t.Errorf("Expected %q typed, got %q", tt.typed, typed)
+			}
+			if onHold := string(w.Flush(end.Add(w.MaxGap))); onHold != tt.onHold {
+				t.Errorf("Expected %q still held, got %q", tt.onHold, onHold)
+			}
+			if w.Holding() {
+				t.Error("Expected nothing held after flushing")
+			}
+		})
+	}
+}
+
+func TestWedge_PauseMidScan(t *testing.T) {
+	w := Wedge{MaxGap: 30 * time.Millisecond, MinLength: 4}
+	now := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+
+	// Typing just before a scan comes through as typing, not part of the code
+	codes, typed, now := keys(&w, now, 5*time.Millisecond, "3")
+	now = now.Add(200 * time.Millisecond)
+	more, typedMore, now := keys(&w, now, 5*time.Millisecond, "CL001\n")
+	codes = append(codes, more...)
+	typed += typedMore
+	if len(codes) != 1 || codes[0] != "CL001" || typed != "3" {
+		t.Errorf("Expected CL001 scanned and 3 typed, got %q and %q", codes, typed)
+	}
+
+	// Held keys aren't flushed before the gap is up
+	w.Feed('x', now)
+	if flushed := w.Flush(now.Add(10 * time.Millisecond)); flushed != nil {
+		t.Errorf("Expected nothing flushed yet, got %q", string(flushed))
+	}
+	if released := w.Release(); string(released) != "x" {
+		t.Errorf("Expected x released, got %q", string(released))
+	}
+}
@@ -0,0 +1,388 @@
+package categories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+type Database interface {
+	GetDB() *sql.DB
+}
+
+// pathSeparator joins category names into a path
+const pathSeparator = " > "
+
+// CreateCategory adds a category under parentID, or at the top level when
+// parentID is nil. Names must be unique among siblings.
+func CreateCategory(db Database, name string, parentID *int, userID int) (*models.Category, error) {
+	if err := roles.Require(db, userID, models.PermItemEdit); err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("category name is required")
+	}
+	if parentID != nil {
+		if _, err := GetCategoryByID(db, *parentID); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSiblingName(db, 0, name, parentID); err != nil {
+		return nil, err
+	}
+
+	result, err := db.GetDB().Exec(
+		"INSERT INTO categories (name, parent_id, created_at) VALUES (?, ?, ?)",
+		name, parentID, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetCategoryByID(db, int(id))
+}
+
+// GetCategoryByID returns a category with its full path
+func GetCategoryByID(db Database, id int) (*models.Category, error) {
+	categories, err := GetCategories(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range categories {
+		if categories[i].ID == id {
+			return &categories[i], nil
+		}
+	}
+	return nil, errors.New("category not found")
+}
+
+// GetCategories returns every category in tree order, each parent followed
+// by its subcategories
+func GetCategories(db Database) ([]models.Category, error) {
+	rows, err := db.GetDB().Query("SELECT id, name, parent_id, tax_class, created_at FROM categories")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []models.Category
+	for rows.Next() {
+		var category models.Category
+		var parentID sql.NullInt64
+		if err := rows.Scan(&category.ID, &category.Name, &parentID, &category.TaxClass, &category.CreatedAt); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			category.ParentID = &id
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*models.Category, len(categories))
+	for i := range categories {
+		byID[categories[i].ID] = &categories[i]
+	}
+	for i := range categories {
+		categories[i].Path = buildPath(byID, &categories[i])
+	}
+
+	// Sorting on the names along the path keeps each subtree together
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := strings.Split(categories[i].Path, pathSeparator), strings.Split(categories[j].Path, pathSeparator)
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if x, y := strings.ToLower(a[k]), strings.ToLower(b[k]); x != y {
+				return x < y
+			}
+		}
+		return len(a) < len(b)
+	})
+	return categories, nil
+}
+
+func buildPath(byID map[int]*models.Category, category *models.Category) string {
+	names := []string{category.Name}
+	// Bounded by the number of categories in case the tree is ever corrupted
+	for parent, steps := category.ParentID, 0; parent != nil && steps < len(byID); steps++ {
+		p, ok := byID[*parent]
+		if !ok {
+			break
+		}
+		names = append([]string{p.Name}, names...)
+		parent = p.ParentID
+	}
+	return strings.Join(names, pathSeparator)
+}
+
+// RenameCategory changes a category's name
+func RenameCategory(db Database, id int, name string, userID int) error {
+	if err := roles.Require(db, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("category name is required")
+	}
+	category, err := GetCategoryByID(db, id)
+	if err != nil {
+		return err
+	}
+	if err := checkSiblingName(db, id, name, category.ParentID); err != nil {
+		return err
+	}
+
+	_, err = db.GetDB().Exec("UPDATE categories SET name = ? WHERE id = ?", name, id)
+	return err
+}
+
+// MoveCategory puts a category, with its subcategories, under a new parent,
+// or at the top level when parentID is nil
+func MoveCategory(db Database, id int, parentID *int, userID int) error {
+	if err := roles.Require(db, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	category, err := GetCategoryByID(db, id)
+	if err != nil {
+		return err
+	}
+	if parentID != nil {
+		descendants, err := subtree(db, id)
+		if err != nil {
+			return err
+		}
+		for _, d := range descendants {
+			if d == *parentID {
+				return errors.New("a category can't be moved under itself")
+			}
+		}
+		if _, err := GetCategoryByID(db, *parentID); err != nil {
+			return err
+		}
+	}
+	if err := checkSiblingName(db, id, category.Name, parentID); err != nil {
+		return err
+	}
+
+	_, err = db.GetDB().Exec("UPDATE categories SET parent_id = ? WHERE id = ?", parentID, id)
+	return err
+}
+
+// DeleteCategory removes a category that has no subcategories. Its items
+// become uncategorised.
+func DeleteCategory(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	var children int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM categories WHERE parent_id = ?", id).Scan(&children); err != nil {
+		return err
+	}
+	if children > 0 {
+		return errors.New("category has subcategories; move or delete them first")
+	}
+
+	if _, err := tx.Exec("UPDATE items SET category_id = NULL WHERE category_id = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM categories WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("category not found")
+	}
+	return tx.Commit()
+}
+
+// SetTaxClass assigns a tax class to a category. Subcategories without a tax
+// class of their own inherit it; an empty class inherits from the parent.
+func SetTaxClass(db Database, id int, taxClass string, userID int) error {
+	if err := roles.Require(db, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	result, err := db.GetDB().Exec("UPDATE categories SET tax_class = ? WHERE id = ?", strings.TrimSpace(taxClass), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("category not found")
+	}
+	return nil
+}
+
+// EffectiveTaxClass returns the tax class that applies to a category: its
+// own, or else the nearest ancestor's. It is empty when none is set.
+func EffectiveTaxClass(db Database, id int) (string, error) {
+	categories, err := GetCategories(db)
+	if err != nil {
+		return "", err
+	}
+	byID := make(map[int]models.Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+
+	category, ok := byID[id]
+	if !ok {
+		return "", errors.New("category not found")
+	}
+	for steps := 0; steps <= len(byID); steps++ {
+		if category.TaxClass != "" || category.ParentID == nil {
+			return category.TaxClass, nil
+		}
+		if category, ok = byID[*category.ParentID]; !ok {
+			break
+		}
+	}
+	return "", nil
+}
+
+// SetItemCategory files an item, with any variants it has, under a
+// category, or leaves it uncategorised when categoryID is nil
+func SetItemCategory(db Database, itemID int, categoryID *int, userID int) error {
+	if err := roles.Require(db, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	if categoryID != nil {
+		if _, err := GetCategoryByID(db, *categoryID); err != nil {
+			return err
+		}
+	}
+	result, err := db.GetDB().Exec("UPDATE items SET category_id = ?, updated_at = ? WHERE id = ? OR parent_id = ?", categoryID, time.Now(), itemID, itemID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("item not found")
+	}
+	return nil
+}
+
+// AdjustPrices changes the price of every active item in a category and its
+// subcategories by basisPoints hundredths of a percent, e.g. 250 raises
+// prices by 2.5% and -1000 cuts them by 10%. New prices are rounded to the
+// nearest cent. It returns the number of items changed.
+func AdjustPrices(db Database, categoryID, basisPoints int, userID int) (int, error) {
+	if basisPoints == 0 {
+		return 0, errors.New("price change must not be zero")
+	}
+	if basisPoints < -10000 {
+		return 0, fmt.Errorf("can't cut prices by more than 100%%")
+	}
+
+	ids, err := subtree(db, categoryID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEditPrice); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	changed := 0
+	for _, id := range ids {
+		result, err := tx.Exec(
+			"UPDATE items SET price = (price * ? + 5000) / 10000, updated_at = ? WHERE category_id = ? AND archived_at IS NULL",
+			10000+basisPoints, now, id,
+		)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		changed += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// subtree returns a category's ID followed by the IDs of all its
+// subcategories
+func subtree(db Database, id int) ([]int, error) {
+	if _, err := GetCategoryByID(db, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.GetDB().Query(
+		`WITH RECURSIVE tree(id) AS (
+			SELECT ? UNION ALL SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+		)
+		SELECT id FROM tree`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// checkSiblingName fails if another category under parentID already uses name
+func checkSiblingName(db Database, id int, name string, parentID *int) error {
+	var count int
+	err := db.GetDB().QueryRow(
+		"SELECT COUNT(*) FROM categories WHERE parent_id IS ? AND name = ? COLLATE NOCASE AND id != ?",
+		parentID, name, id,
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("category %q already exists here", name)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package categories
+
+import (
+	"database/sql"
+	"testing"
+
+	"ims-go/money"
+
+	_ "modernc.org/sqlite"
+)
+
+type MockDB struct {
+	db *sql.DB
+}
+
+func (m *MockDB) GetDB() *sql.DB {
+	return m.db
+}
+
+func setupTestDB(t *testing.T) *MockDB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			is_root_admin INTEGER DEFAULT 0,
+			archived_at DATETIME
+		)`,
+		`INSERT INTO users (username, is_root_admin) VALUES ('admin', 1)`,
+		`CREATE TABLE roles (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL COLLATE NOCASE, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE role_permissions (role_id INTEGER NOT NULL, permission TEXT NOT NULL, PRIMARY KEY (role_id, permission))`,
+		`CREATE TABLE user_roles (user_id INTEGER NOT NULL, role_id INTEGER NOT NULL, PRIMARY KEY (user_id, role_id))`,
+		`CREATE TABLE items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			code TEXT UNIQUE NOT NULL,
+			price INTEGER NOT NULL,
+			category_id INTEGER,
+			parent_id INTEGER,
+			archived_at DATETIME,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			parent_id INTEGER,
+			tax_class TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE
+		)`,
+		`CREATE TABLE item_tags (
+			item_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (item_id, tag_id)
+		)`,
+		`INSERT INTO items (name, code, price) VALUES ('Apple Juice', 'AJ001', 200), ('Cola', 'CL001', 150), ('Bread', 'BR001', 299)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up schema: %v", err)
+		}
+	}
+
+	return &MockDB{db: db}
+}
+
+// createTree adds Drinks > Juice and Drinks > Soda > Diet
+func createTree(t *testing.T, mockDB *MockDB) (drinks, juice, soda, diet int) {
+	create := func(name string, parentID *int) int {
+		category, err := CreateCategory(mockDB, name, parentID, 1)
+		if err != nil {
+			t.Fatalf("CreateCategory %s failed: %v", name, err)
+		}
+		return category.ID
+	}
+	drinks = create("Drinks", nil)
+	juice = create("Juice", &drinks)
+	soda = create("Soda", &drinks)
+	diet = create("Diet", &soda)
+	return drinks, juice, soda, diet
+}
+
+func TestCategoryTree(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	drinks, _, soda, diet := createTree(t, mockDB)
+	if _, err := CreateCategory(mockDB, "Bakery", nil, 1); err != nil {
+		t.Fatalf("CreateCategory failed: %v", err)
+	}
+
+	categories, err := GetCategories(mockDB)
+	if err != nil {
+		t.Fatalf("GetCategories failed: %v", err)
+	}
+	var paths []string
+	for _, c := range categories {
+		paths = append(paths, c.Path)
+	}
+	want := []string{"Bakery", "Drinks", "Drinks > Juice", "Drinks > Soda", "Drinks > Soda > Diet"}
+	if len(paths) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, paths)
+			break
+		}
+	}
+
+	// Sibling names must be unique, ignoring case
+	if _, err := CreateCategory(mockDB, "soda", &drinks, 1); err == nil {
+		t.Error("Expected error for a duplicate sibling name")
+	}
+	if _, err := CreateCategory(mockDB, "Soda", nil, 1); err != nil {
+		t.Errorf("Expected the same name to be allowed elsewhere: %v", err)
+	}
+	missing := 999
+	if _, err := CreateCategory(mockDB, "Orphan", &missing, 1); err == nil {
+		t.Error("Expected error for an unknown parent")
+	}
+
+	// A category can't go under its own subtree
+	if err := MoveCategory(mockDB, drinks, &diet, 1); err == nil {
+		t.Error("Expected error moving a category under its own subcategory")
+	}
+	if err := MoveCategory(mockDB, diet, nil, 1); err != nil {
+		t.Fatalf("MoveCategory failed: %v", err)
+	}
+	if err := RenameCategory(mockDB, diet, "Diet Drinks", 1); err != nil {
+		t.Fatalf("RenameCategory failed: %v", err)
+	}
+	moved, err := GetCategoryByID(mockDB, diet)
+	if err != nil {
+		t.Fatalf("GetCategoryByID failed: %v", err)
+	}
+	if moved.Path != "Diet Drinks" || moved.ParentID != nil {
+		t.Errorf("Expected a top-level Diet Drinks, got %+v", moved)
+	}
+
+	// Only leaf categories can be deleted, and their items are kept
+	if _, err := mockDB.db.Exec("UPDATE items SET category_id = ? WHERE code = 'CL001'", soda); err != nil {
+		t.Fatalf("Failed to categorise item: %v", err)
+	}
+	if err := DeleteCategory(mockDB, drinks, 1); err == nil {
+		t.Error("Expected error deleting a category with subcategories")
+	}
+	if err := DeleteCategory(mockDB, soda, 1); err != nil {
+		t.Fatalf("DeleteCategory failed: %v", err)
+	}
+	var categoryID sql.NullInt64
+	if err := mockDB.db.QueryRow("SELECT category_id FROM items WHERE code = 'CL001'").Scan(&categoryID); err != nil {
+		t.Fatalf("Failed to read item: %v", err)
+	}
+	if categoryID.Valid {
+		t.Errorf("Expected item to become uncategorised, got category %d", categoryID.Int64)
+	}
+}
+
+func TestTaxClass(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	drinks, juice, soda, diet := createTree(t, mockDB)
+	if err := SetTaxClass(mockDB, drinks, "standard", 1); err != nil {
+		t.Fatalf("SetTaxClass failed: %v", err)
+	}
+	if err := SetTaxClass(mockDB, juice, "reduced", 1); err != nil {
+		t.Fatalf("SetTaxClass failed: %v", err)
+	}
+
+	expected := map[int]string{drinks: "standard", juice: "reduced", soda: "standard", diet: "standard"}
+	for id, want := range expected {
+		got, err := EffectiveTaxClass(mockDB, id)
+		if err != nil {
+			t.Fatalf("EffectiveTaxClass failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("Category %d: expected tax class %q, got %q", id, want, got)
+		}
+	}
+
+	if err := SetTaxClass(mockDB, 999, "standard", 1); err == nil {
+		t.Error("Expected error for an unknown category")
+	}
+}
+
+func TestAdjustPrices(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	drinks, juice, soda, _ := createTree(t, mockDB)
+	if err := SetItemCategory(mockDB, 1, &juice, 1); err != nil {
+		t.Fatalf("SetItemCategory failed: %v", err)
+	}
+	if err := SetItemCategory(mockDB, 2, &soda, 1); err != nil {
+		t.Fatalf("SetItemCategory failed: %v", err)
+	}
+
+	// 2.5% across Drinks reaches both subcategories but not the bread
+	changed, err := AdjustPrices(mockDB, drinks, 250, 1)
+	if err != nil {
+		t.Fatalf("AdjustPrices failed: %v", err)
+	}
+	if changed != 2 {
+		t.Errorf("Expected 2 items changed, got %d", changed)
+	}
+
+	expected := map[string]string{"AJ001": "2.05", "CL001": "1.54", "BR001": "2.99"}
+	for code, want := range expected {
+		var price money.Money
+		if err := mockDB.db.QueryRow("SELECT price FROM items WHERE code = ?", code).Scan(&price); err != nil {
+			t.Fatalf("Failed to read price: %v", err)
+		}
+		if price != money.MustParse(want) {
+			t.Errorf("%s: expected price %s, got %s", code, want, price)
+		}
+	}
+
+	if _, err := AdjustPrices(mockDB, drinks, 0, 1); err == nil {
+		t.Error("Expected error for no change")
+	}
+	if _, err := AdjustPrices(mockDB, drinks, -10001, 1); err == nil {
+		t.Error("Expected error for a cut of over 100%")
+	}
+	if _, err := AdjustPrices(mockDB, 999, 100, 1); err == nil {
+		t.Error("Expected error for an unknown category")
+	}
+}
+
+func TestItemTags(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	tags := ParseTags(" organic, Local ,, organic,ORGANIC ")
+	if len(tags) != 2 || tags[0] != "organic" || tags[1] != "Local" {
+		t.Fatalf("Unexpected parsed tags: %q", tags)
+	}
+
+	if err := SetItemTags(mockDB, 1, tags, 1); err != nil {
+		t.Fatalf("SetItemTags failed: %v", err)
+	}
+	if err := SetItemTags(mockDB, 2, []string{"Organic", "fizzy"}, 1); err != nil {
+		t.Fatalf("SetItemTags failed: %v", err)
+	}
+
+	all, err := GetAllTags(mockDB)
+	if err != nil {
+		t.Fatalf("GetAllTags failed: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Expected tags shared ignoring case, got %q", all)
+	}
+
+	// Replacing tags drops ones no longer used anywhere
+	if err := SetItemTags(mockDB, 2, nil, 1); err != nil {
+		t.Fatalf("SetItemTags failed: %v", err)
+	}
+	all, _ = GetAllTags(mockDB)
+	itemTags, err := GetItemTags(mockDB, 1)
+	if err != nil {
+		t.Fatalf("GetItemTags failed: %v", err)
+	}
+	if len(all) != 2 || len(itemTags) != 2 || itemTags[0] != "Local" {
+		t.Errorf("Expected only the apple juice tags left, got %q and %q", all, itemTags)
+	}
+}
@@ -0,0 +1,96 @@
+package categories
+
+import (
+	"strings"
+
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+// ParseTags splits comma-separated text into tags, dropping blanks and
+// repeats that differ only in case
+func ParseTags(text string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(text, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// SetItemTags replaces an item's tags. New tags are created as needed and
+// tags are matched ignoring case.
+func SetItemTags(db Database, itemID int, tags []string, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM item_tags WHERE item_id = ?", itemID); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO item_tags (item_id, tag_id) SELECT ?, id FROM tags WHERE name = ?",
+			itemID, tag,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Tags no item uses any more are dropped so they don't clutter filters
+	if _, err := tx.Exec("DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM item_tags)"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetItemTags returns an item's tags in alphabetical order
+func GetItemTags(db Database, itemID int) ([]string, error) {
+	return queryTags(db,
+		"SELECT t.name FROM tags t JOIN item_tags it ON it.tag_id = t.id WHERE it.item_id = ? ORDER BY t.name",
+		itemID,
+	)
+}
+
+// GetAllTags returns every tag in use in alphabetical order
+func GetAllTags(db Database) ([]string, error) {
+	return queryTags(db, "SELECT name FROM tags ORDER BY name")
+}
+
+func queryTags(db Database, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.GetDB().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
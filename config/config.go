@@ -0,0 +1,365 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds the application settings. Values are layered: built-in
+// defaults, then the config file, then IMS_* environment variables, then
+// command-line flags.
+type Config struct {
+	DBPath            string `json:"db_path"`
+	LowStockThreshold int    `json:"low_stock_threshold"`
+	CurrencySymbol    string `json:"currency_symbol"`
+	// CurrencyCode is the ISO 4217 numeric code of the currency, e.g. 840
+	// for US dollars, which GS1 codes give amounts payable in
+	CurrencyCode        string `json:"currency_code"`
+	StoreName           string `json:"store_name"`
+	TransactionLogLimit int    `json:"transaction_log_limit"`
+
+	// CostingMethod values the cost of goods sold: "fifo" or "average"
+	CostingMethod string `json:"costing_method"`
+
+	// BackupDir defaults to a "backups" folder next to the database
+	BackupDir             string `json:"backup_dir"`
+	BackupIntervalMinutes int    `json:"backup_interval_minutes"`
+	BackupKeep            int    `json:"backup_keep"`
+
+	// Scale labels starting with these EAN-13 prefixes carry a price or a
+	// weight in grams. 20 is where codes allocated in store go.
+	PriceLabelPrefixes  []string `json:"price_label_prefixes"`
+	WeightLabelPrefixes []string `json:"weight_label_prefixes"`
+
+	// A keyboard wedge scanner types codes faster than anyone can, with at
+	// most ScannerMaxGapMs between keys. It may be set up to type a prefix
+	// before each code; the suffix after it is Enter unless set. In the
+	// config file they use JSON escapes such as \u0002; environment
+	// variables and flags take Go escapes such as \t, \r or \x02.
+	ScannerPrefix    string `json:"scanner_prefix"`
+	ScannerSuffix    string `json:"scanner_suffix"`
+	ScannerMaxGapMs  int    `json:"scanner_max_gap_ms"`
+	ScannerMinLength int    `json:"scanner_min_length"`
+}
+
+// Default returns the built-in settings. The database lives beside the
+// config file unless there's already one in the working folder.
+func Default() *Config {
+	return &Config{
+		DBPath:              defaultDBPath(),
+		LowStockThreshold:   10,
+		CurrencySymbol:      "$",
+		CurrencyCode:        "840",
+		StoreName:           "Inventory Management System",
+		TransactionLogLimit: 1000,
+		CostingMethod:       "fifo",
+
+		BackupIntervalMinutes: 60,
+		BackupKeep:            24,
+
+		PriceLabelPrefixes:  []string{"21", "22", "23", "24"},
+		WeightLabelPrefixes: []string{"25", "26", "27", "28", "29"},
+
+		ScannerMaxGapMs:  30,
+		ScannerMinLength: 4,
+	}
+}
+
+// defaultDBPath keeps using an ims.db in the working folder, where older
+// versions kept it, and otherwise puts it in the user's config folder, e.g.
+// ~/.config/ims/ims.db on Linux. Beside the executable would be a new
+// temporary folder on every go run.
+func defaultDBPath() string {
+	if _, err := os.Stat("ims.db"); err == nil {
+		return "ims.db"
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "ims.db"
+	}
+	return filepath.Join(dir, "ims", "ims.db")
+}
+
+// DefaultPath returns the default config file location, e.g.
+// ~/.config/ims/config.json on Linux
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "config.json"
+	}
+	return filepath.Join(dir, "ims", "config.json")
+}
+
+// Load builds the configuration from defaults, the config file, environment
+// variables and the given command-line arguments (normally os.Args[1:]).
+// A missing config file is not an error.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("ims", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+DefaultPath()+")")
+	dbPath := fs.String("db", "", "path to the SQLite database")
+	lowStock := fs.Int("low-stock", 0, "quantity below which items are flagged as low stock")
+	currency := fs.String("currency", "", "currency symbol used when showing prices")
+	currencyCode := fs.String("currency-code", "", "ISO 4217 numeric code of the currency, e.g. 840 for US dollars")
+	storeName := fs.String("store-name", "", "store name shown in the window title")
+	logLimit := fs.Int("log-limit", 0, "number of transactions shown in the transaction log")
+	costing := fs.String("costing", "", "costing method for the cost of goods sold: fifo or average")
+	backupDir := fs.String("backup-dir", "", "folder for automatic backups")
+	backupInterval := fs.Int("backup-interval", 0, "minutes between automatic backups (0 disables them)")
+	backupKeep := fs.Int("backup-keep", 0, "number of automatic backups to keep")
+	pricePrefixes := fs.String("price-prefixes", "", "comma-separated EAN-13 prefixes of labels with an embedded price")
+	weightPrefixes := fs.String("weight-prefixes", "", "comma-separated EAN-13 prefixes of labels with an embedded weight")
+	scannerPrefix := fs.String("scanner-prefix", "", "characters the barcode scanner types before each code")
+	scannerSuffix := fs.String("scanner-suffix", "", "characters the barcode scanner types after each code (default Enter)")
+	scannerGap := fs.Int("scanner-gap", 0, "most milliseconds between the keys of a scanned code")
+	scannerMinLength := fs.Int("scanner-min-length", 0, "fewest characters in a scanned code")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Config file
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("IMS_CONFIG")
+	}
+	if path == "" {
+		path = DefaultPath()
+	}
+	if err := cfg.loadFile(path); err != nil {
+		return nil, err
+	}
+
+	// Environment variables
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Command-line flags, only the ones actually given
+	var flagErr error
+	setEscaped := func(field *string, name, v string) {
+		if s, err := unescapeSetting(name, v); err != nil {
+			flagErr = err
+		} else {
+			*field = s
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db":
+			cfg.DBPath = *dbPath
+		case "low-stock":
+			cfg.LowStockThreshold = *lowStock
+		case "currency":
+			cfg.CurrencySymbol = *currency
+		case "currency-code":
+			cfg.CurrencyCode = *currencyCode
+		case "store-name":
+			cfg.StoreName = *storeName
+		case "log-limit":
+			cfg.TransactionLogLimit = *logLimit
+		case "costing":
+			cfg.CostingMethod = *costing
+		case "backup-dir":
+			cfg.BackupDir = *backupDir
+		case "backup-interval":
+			cfg.BackupIntervalMinutes = *backupInterval
+		case "backup-keep":
+			cfg.BackupKeep = *backupKeep
+		case "price-prefixes":
+			cfg.PriceLabelPrefixes = splitList(*pricePrefixes)
+		case "weight-prefixes":
+			cfg.WeightLabelPrefixes = splitList(*weightPrefixes)
+		case "scanner-prefix":
+			setEscaped(&cfg.ScannerPrefix, "-scanner-prefix", *scannerPrefix)
+		case "scanner-suffix":
+			setEscaped(&cfg.ScannerSuffix, "-scanner-suffix", *scannerSuffix)
+		case "scanner-gap":
+			cfg.ScannerMaxGapMs = *scannerGap
+		case "scanner-min-length":
+			cfg.ScannerMinLength = *scannerMinLength
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	// Relative paths are relative to the config file, not the working directory
+	if c.DBPath != "" && !filepath.IsAbs(c.DBPath) {
+		c.DBPath = filepath.Join(filepath.Dir(path), c.DBPath)
+	}
+	if c.BackupDir != "" && !filepath.IsAbs(c.BackupDir) {
+		c.BackupDir = filepath.Join(filepath.Dir(path), c.BackupDir)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	if v := os.Getenv("IMS_DB_PATH"); v != "" {
+		c.DBPath = v
+	}
+	if v := os.Getenv("IMS_CURRENCY_SYMBOL"); v != "" {
+		c.CurrencySymbol = v
+	}
+	if v := os.Getenv("IMS_CURRENCY_CODE"); v != "" {
+		c.CurrencyCode = v
+	}
+	if v := os.Getenv("IMS_STORE_NAME"); v != "" {
+		c.StoreName = v
+	}
+	if v := os.Getenv("IMS_COSTING_METHOD"); v != "" {
+		c.CostingMethod = v
+	}
+	if v := os.Getenv("IMS_BACKUP_DIR"); v != "" {
+		c.BackupDir = v
+	}
+	if v := os.Getenv("IMS_PRICE_LABEL_PREFIXES"); v != "" {
+		c.PriceLabelPrefixes = splitList(v)
+	}
+	if v := os.Getenv("IMS_WEIGHT_LABEL_PREFIXES"); v != "" {
+		c.WeightLabelPrefixes = splitList(v)
+	}
+	// Control characters can't easily be typed into a variable, so these
+	// take escapes
+	for name, field := range map[string]*string{
+		"IMS_SCANNER_PREFIX": &c.ScannerPrefix,
+		"IMS_SCANNER_SUFFIX": &c.ScannerSuffix,
+	} {
+		if v := os.Getenv(name); v != "" {
+			s, err := unescapeSetting(name, v)
+			if err != nil {
+				return err
+			}
+			*field = s
+		}
+	}
+
+	ints := map[string]*int{
+		"IMS_LOW_STOCK_THRESHOLD":   &c.LowStockThreshold,
+		"IMS_TRANSACTION_LOG_LIMIT": &c.TransactionLogLimit,
+		"IMS_BACKUP_INTERVAL":       &c.BackupIntervalMinutes,
+		"IMS_BACKUP_KEEP":           &c.BackupKeep,
+		"IMS_SCANNER_MAX_GAP":       &c.ScannerMaxGapMs,
+		"IMS_SCANNER_MIN_LENGTH":    &c.ScannerMinLength,
+	}
+	for name, field := range ints {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %q is not a number", name, v)
+		}
+		*field = n
+	}
+	return nil
+}
+
+// Validate checks that every setting has a usable value
+func (c *Config) Validate() error {
+	if c.DBPath == "" {
+		return errors.New("database path must not be empty")
+	}
+	if c.LowStockThreshold < 0 {
+		return errors.New("low stock threshold must not be negative")
+	}
+	if len(c.CurrencyCode) != 3 || strings.Trim(c.CurrencyCode, "0123456789") != "" {
+		return fmt.Errorf("currency code must be the three-digit ISO 4217 number, not %q", c.CurrencyCode)
+	}
+	if c.TransactionLogLimit <= 0 {
+		return errors.New("transaction log limit must be positive")
+	}
+	if c.CostingMethod != "fifo" && c.CostingMethod != "average" {
+		return fmt.Errorf("costing method must be fifo or average, not %q", c.CostingMethod)
+	}
+	if c.BackupIntervalMinutes < 0 {
+		return errors.New("backup interval must not be negative")
+	}
+	if c.BackupKeep <= 0 {
+		return errors.New("number of backups to keep must be positive")
+	}
+
+	seen := make(map[string]bool)
+	for _, prefix := range append(append([]string{}, c.PriceLabelPrefixes...), c.WeightLabelPrefixes...) {
+		if len(prefix) != 2 || prefix[0] != '2' || prefix[1] < '0' || prefix[1] > '9' {
+			return fmt.Errorf("scale label prefix must be 20 to 29, not %q", prefix)
+		}
+		if seen[prefix] {
+			return fmt.Errorf("scale label prefix %s is listed more than once", prefix)
+		}
+		seen[prefix] = true
+	}
+
+	if c.ScannerMaxGapMs <= 0 {
+		return errors.New("scanner key gap must be positive")
+	}
+	if c.ScannerMinLength <= 0 {
+		return errors.New("scanner minimum code length must be positive")
+	}
+	if strings.ContainsRune(c.ScannerPrefix+c.ScannerSuffix, 0) {
+		return errors.New("scanner prefix and suffix must not contain NUL")
+	}
+	return nil
+}
+
+// unescapeSetting is unescape for the environment variable or flag name
+func unescapeSetting(name, s string) (string, error) {
+	v, err := unescape(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %w", name, s, err)
+	}
+	return v, nil
+}
+
+// unescape decodes the Go escapes in s, e.g. \t or \x02. Other characters,
+// quotes included, are taken as they are. Values from the config file aren't
+// unescaped, since JSON has escapes of its own such as \u0002.
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for s != "" {
+		r, _, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(r)
+		s = tail
+	}
+	return b.String(), nil
+}
+
+// splitList reads a comma-separated list, ignoring spaces and empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
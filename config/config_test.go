@@ -0,0 +1,228 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// clearEnv makes sure settings from the developer's shell don't leak into tests
+func clearEnv(t *testing.T) {
+	for _, name := range []string{"IMS_CONFIG", "IMS_DB_PATH", "IMS_LOW_STOCK_THRESHOLD", "IMS_CURRENCY_SYMBOL", "IMS_CURRENCY_CODE", "IMS_STORE_NAME", "IMS_TRANSACTION_LOG_LIMIT", "IMS_COSTING_METHOD", "IMS_BACKUP_DIR", "IMS_BACKUP_INTERVAL", "IMS_BACKUP_KEEP", "IMS_PRICE_LABEL_PREFIXES", "IMS_WEIGHT_LABEL_PREFIXES", "IMS_SCANNER_PREFIX", "IMS_SCANNER_SUFFIX", "IMS_SCANNER_MAX_GAP", "IMS_SCANNER_MIN_LENGTH"} {
+		t.Setenv(name, "")
+	}
+}
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	missing := filepath.Join(t.TempDir(), "missing.json")
+
+	cfg, err := Load([]string{"-config", missing})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	def := Default()
+	if !reflect.DeepEqual(cfg, def) {
+		t.Errorf("Expected defaults %+v, got %+v", def, cfg)
+	}
+	if filepath.Base(cfg.DBPath) != "ims.db" {
+		t.Errorf("Expected default database ims.db, got %s", cfg.DBPath)
+	}
+}
+
+func TestDefaultDBPath(t *testing.T) {
+	clearEnv(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("AppData", filepath.Join(home, "AppData"))
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	work := t.TempDir()
+	if err := os.Chdir(work); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		t.Skipf("No config folder: %v", err)
+	}
+	if path := Default().DBPath; path != filepath.Join(configDir, "ims", "ims.db") {
+		t.Errorf("Expected the database in the config folder, got %s", path)
+	}
+
+	// An existing install's database in the working folder is kept
+	if err := os.WriteFile(filepath.Join(work, "ims.db"), nil, 0o644); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+	if path := Default().DBPath; path != "ims.db" {
+		t.Errorf("Expected the existing ims.db, got %s", path)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, `{"db_path": "shop.db", "low_stock_threshold": 3, "store_name": "Corner Shop", "costing_method": "average", "backup_dir": "backups"}`)
+
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.DBPath != filepath.Join(filepath.Dir(path), "shop.db") {
+		t.Errorf("Expected database path relative to config file, got %s", cfg.DBPath)
+	}
+	if cfg.BackupDir != filepath.Join(filepath.Dir(path), "backups") {
+		t.Errorf("Expected backup dir relative to config file, got %s", cfg.BackupDir)
+	}
+	if cfg.LowStockThreshold != 3 {
+		t.Errorf("Expected low stock threshold 3, got %d", cfg.LowStockThreshold)
+	}
+	if cfg.StoreName != "Corner Shop" {
+		t.Errorf("Expected store name Corner Shop, got %s", cfg.StoreName)
+	}
+	if cfg.CostingMethod != "average" {
+		t.Errorf("Expected average costing, got %s", cfg.CostingMethod)
+	}
+	// Settings missing from the file keep their defaults
+	if cfg.CurrencySymbol != "$" {
+		t.Errorf("Expected default currency symbol, got %s", cfg.CurrencySymbol)
+	}
+}
+
+func TestLoadConfigPathFromEnv(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, `{"currency_symbol": "€"}`)
+	t.Setenv("IMS_CONFIG", path)
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.CurrencySymbol != "€" {
+		t.Errorf("Expected currency symbol from IMS_CONFIG file, got %s", cfg.CurrencySymbol)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, `{"db_path": "/file/ims.db", "low_stock_threshold": 3, "currency_symbol": "€", "scanner_prefix": "~"}`)
+	t.Setenv("IMS_DB_PATH", "/env/ims.db")
+	t.Setenv("IMS_LOW_STOCK_THRESHOLD", "5")
+	t.Setenv("IMS_PRICE_LABEL_PREFIXES", "21, 22")
+	t.Setenv("IMS_SCANNER_MAX_GAP", "20")
+
+	cfg, err := Load([]string{"-config", path, "-low-stock", "7", "-weight-prefixes", "28,29", "-scanner-suffix", "#"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.CurrencySymbol != "€" {
+		t.Errorf("Expected currency symbol from file, got %s", cfg.CurrencySymbol)
+	}
+	if cfg.DBPath != "/env/ims.db" {
+		t.Errorf("Expected environment to override file, got %s", cfg.DBPath)
+	}
+	if cfg.LowStockThreshold != 7 {
+		t.Errorf("Expected flag to override environment, got %d", cfg.LowStockThreshold)
+	}
+	if !reflect.DeepEqual(cfg.PriceLabelPrefixes, []string{"21", "22"}) || !reflect.DeepEqual(cfg.WeightLabelPrefixes, []string{"28", "29"}) {
+		t.Errorf("Expected scale label prefixes from environment and flags, got %v and %v", cfg.PriceLabelPrefixes, cfg.WeightLabelPrefixes)
+	}
+	if cfg.ScannerPrefix != "~" || cfg.ScannerSuffix != "#" || cfg.ScannerMaxGapMs != 20 || cfg.ScannerMinLength != 4 {
+		t.Errorf("Expected scanner settings from file, flags, environment and defaults, got %q %q %d %d", cfg.ScannerPrefix, cfg.ScannerSuffix, cfg.ScannerMaxGapMs, cfg.ScannerMinLength)
+	}
+}
+
+func TestLoadScannerEscapes(t *testing.T) {
+	clearEnv(t)
+	// The file uses JSON's own escapes, and a backslash is just a backslash
+	path := writeConfig(t, `{"scanner_prefix": "\u0002", "scanner_suffix": "\t"}`)
+
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ScannerPrefix != "\x02" || cfg.ScannerSuffix != "\t" {
+		t.Errorf("Expected STX and Tab from file, got %q %q", cfg.ScannerPrefix, cfg.ScannerSuffix)
+	}
+	cfg, err = Load([]string{"-config", writeConfig(t, `{"scanner_prefix": "\\x02"}`)})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ScannerPrefix != `\x02` {
+		t.Errorf("Expected a literal backslash from file, got %q", cfg.ScannerPrefix)
+	}
+
+	t.Setenv("IMS_SCANNER_PREFIX", `]C1"`)
+	cfg, err = Load([]string{"-config", path, "-scanner-suffix", `\r\n`})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ScannerPrefix != `]C1"` || cfg.ScannerSuffix != "\r\n" {
+		t.Errorf("Expected prefix from environment and CR LF from flags, got %q %q", cfg.ScannerPrefix, cfg.ScannerSuffix)
+	}
+
+	t.Setenv("IMS_SCANNER_PREFIX", `\x02`)
+	cfg, err = Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ScannerPrefix != "\x02" {
+		t.Errorf("Expected STX from environment, got %q", cfg.ScannerPrefix)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	clearEnv(t)
+	missing := filepath.Join(t.TempDir(), "missing.json")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"malformed file", []string{"-config", writeConfig(t, `{"low_stock_threshold": `)}, nil},
+		{"bad env number", []string{"-config", missing}, map[string]string{"IMS_LOW_STOCK_THRESHOLD": "ten"}},
+		{"negative threshold", []string{"-config", missing, "-low-stock", "-1"}, nil},
+		{"zero log limit", []string{"-config", missing, "-log-limit", "0"}, nil},
+		{"negative backup interval", []string{"-config", missing, "-backup-interval", "-5"}, nil},
+		{"zero backups kept", []string{"-config", missing}, map[string]string{"IMS_BACKUP_KEEP": "0"}},
+		{"empty db path", []string{"-config", missing, "-db", ""}, nil},
+		{"unknown costing method", []string{"-config", missing, "-costing", "lifo"}, nil},
+		{"currency code not three digits", []string{"-config", missing, "-currency-code", "US"}, nil},
+		{"scale label prefix out of range", []string{"-config", missing, "-price-prefixes", "30"}, nil},
+		{"scale label prefix in both lists", []string{"-config", missing, "-price-prefixes", "21,25"}, nil},
+		{"zero scanner gap", []string{"-config", missing, "-scanner-gap", "0"}, nil},
+		{"zero scanner code length", []string{"-config", missing}, map[string]string{"IMS_SCANNER_MIN_LENGTH": "0"}},
+		{"NUL in scanner suffix", []string{"-config", missing, "-scanner-suffix", "\\x00"}, nil},
+		{"bad escape in scanner prefix", []string{"-config", missing}, map[string]string{"IMS_SCANNER_PREFIX": "\\q"}},
+		{"bad escape in scanner suffix flag", []string{"-config", missing, "-scanner-suffix", `\q`}, nil},
+		{"unknown flag", []string{"-config", missing, "-bogus"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := Load(tt.args); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
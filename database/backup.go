@@ -0,0 +1,341 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+// backupTimeFormat sorts lexically in time order, so the newest backup is last
+const backupTimeFormat = "20060102-150405.000000"
+
+// SetBackupDir changes where BackupToDir and the backup schedule write files.
+// By default backups go in a "backups" folder next to the database.
+func (d *Database) SetBackupDir(dir string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.backupDir = dir
+}
+
+// BackupDir returns the folder that automatic backups are written to
+func (d *Database) BackupDir() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.backupDir
+}
+
+// Backup writes a consistent copy of the database to destPath using
+// VACUUM INTO, which is safe while the program is still using the database.
+// An existing file at destPath is replaced only once the copy is complete.
+func (d *Database) Backup(ctx context.Context, destPath string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.backup(ctx, destPath)
+}
+
+// BackupAs is Backup on behalf of a user, who needs the db.backup permission
+func (d *Database) BackupAs(ctx context.Context, destPath string, userID int) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	err := d.require(func(tx *sql.Tx) error {
+		return roles.RequireTx(tx, userID, models.PermDBBackup)
+	})
+	if err != nil {
+		return err
+	}
+	return d.backup(ctx, destPath)
+}
+
+func (d *Database) backup(ctx context.Context, destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return err
+	}
+
+	// VACUUM INTO refuses to overwrite, so write to a temporary file and rename it
+	tmpPath := destPath + ".tmp"
+	os.Remove(tmpPath)
+	if _, err := d.db.ExecContext(ctx, "VACUUM INTO ?", tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// BackupToDir writes a timestamped backup into the backup directory and
+// returns its path. The label says why it was taken, e.g. "auto" or
+// "pre-reset".
+func (d *Database) BackupToDir(ctx context.Context, label string) (string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.backupToDir(ctx, label)
+}
+
+func (d *Database) backupToDir(ctx context.Context, label string) (string, error) {
+	path := filepath.Join(d.backupDir, fmt.Sprintf("ims-%s-%s.db", label, time.Now().Format(backupTimeFormat)))
+	if err := d.backup(ctx, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// OnBackupError sets a function to be told when a scheduled backup fails,
+// so whoever is using the program finds out. Failures are logged either way.
+func (d *Database) OnBackupError(fn func(err error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onBackupError = fn
+}
+
+// backupFailed logs a scheduled backup's failure and passes it on
+func (d *Database) backupFailed(err error) {
+	log.Printf("%v\n", err)
+	d.mu.RLock()
+	fn := d.onBackupError
+	d.mu.RUnlock()
+	if fn != nil {
+		fn(err)
+	}
+}
+
+// ScheduleBackups writes an "auto" backup every interval until ctx is
+// cancelled, keeping only the newest keep automatic backups. Backups taken
+// for other reasons (before a reset or restore) are never deleted.
+// A zero interval disables scheduled backups.
+func (d *Database) ScheduleBackups(ctx context.Context, interval time.Duration, keep int) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				path, err := d.BackupToDir(ctx, "auto")
+				if err != nil {
+					d.backupFailed(fmt.Errorf("scheduled backup failed: %w", err))
+					continue
+				}
+				log.Printf("Scheduled backup written to %s\n", path)
+
+				if err := pruneBackups(d.BackupDir(), "auto", keep); err != nil {
+					d.backupFailed(fmt.Errorf("failed to remove old backups: %w", err))
+				}
+			}
+		}
+	}()
+}
+
+// pruneBackups deletes the oldest backups with the given label so that at
+// most keep remain
+func pruneBackups(dir, label string, keep int) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "ims-"+label+"-*.db"))
+	if err != nil {
+		return err
+	}
+	if len(matches) <= keep {
+		return nil
+	}
+
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-keep] {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces the database with the backup at srcPath. The backup must
+// be an intact IMS database no newer than this program's schema; older ones
+// are migrated forward once restored. The current database is backed up
+// first, and put back if the restored copy cannot be opened.
+func (d *Database) Restore(srcPath string) error {
+	if err := validateBackup(srcPath); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.restore(srcPath)
+}
+
+// RestoreAs is Restore on behalf of a user. Replacing every user and role
+// with a backup's is left to the root admin alone.
+func (d *Database) RestoreAs(srcPath string, userID int) error {
+	if err := validateBackup(srcPath); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.require(func(tx *sql.Tx) error {
+		return roles.RequireRootTx(tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+	return d.restore(srcPath)
+}
+
+func (d *Database) restore(srcPath string) error {
+	safetyPath, err := d.backupToDir(context.Background(), "pre-restore")
+	if err != nil {
+		return fmt.Errorf("backup before restore failed: %w", err)
+	}
+	log.Printf("Database backed up to %s before restore\n", safetyPath)
+
+	if err := d.db.Close(); err != nil {
+		return err
+	}
+
+	if err := d.replaceFile(srcPath); err != nil {
+		// Nothing was swapped, so reopen the original
+		if reopenErr := d.open(); reopenErr != nil {
+			return fmt.Errorf("restore failed: %v; reopening database also failed: %w", err, reopenErr)
+		}
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	if err := d.open(); err != nil {
+		log.Printf("Restored database could not be opened, rolling back: %v\n", err)
+		if rollbackErr := d.replaceFile(safetyPath); rollbackErr != nil {
+			return fmt.Errorf("restore failed: %v; rollback also failed: %w", err, rollbackErr)
+		}
+		if reopenErr := d.open(); reopenErr != nil {
+			return fmt.Errorf("restore failed: %v; reopening database also failed: %w", err, reopenErr)
+		}
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	return nil
+}
+
+// require runs a permission check inside a transaction of its own, with
+// d.mu held. Backups and restores work on the whole file, so they can't
+// share it, but holding the lock keeps the check and the work together.
+func (d *Database) require(check func(tx *sql.Tx) error) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return check(tx)
+}
+
+// validateBackup checks that a file is an intact IMS database this program
+// can open, without modifying it
+func validateBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", fileURI(path, ""))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return fmt.Errorf("%s is not a valid database: %w", filepath.Base(path), err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s is damaged: %s", filepath.Base(path), result)
+	}
+
+	tables := make(map[string]bool)
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, required := range []string{"users", "items", "transactions"} {
+		if !tables[required] {
+			return fmt.Errorf("%s is not an IMS database: missing %s table", filepath.Base(path), required)
+		}
+	}
+
+	// Databases from before versioned migrations have no schema_migrations
+	// table and are upgraded like version 0
+	if tables["schema_migrations"] {
+		version, err := schemaVersion(db)
+		if err != nil {
+			return err
+		}
+		if version > LatestVersion() {
+			return fmt.Errorf("backup schema version %d is newer than this program supports (%d)", version, LatestVersion())
+		}
+	}
+
+	return nil
+}
+
+// replaceFile copies src over the database file. The connection must already
+// be closed. The copy is written next to the database and renamed into place
+// so a failure part way through leaves the original untouched.
+func (d *Database) replaceFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := d.path + ".restore"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Leftover journal files belong to the old database and must not be
+	// applied to the restored one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(d.path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	return os.Rename(tmpPath, d.path)
+}
@@ -0,0 +1,392 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ims-go/roles"
+)
+
+func countItems(t *testing.T, d *Database) int {
+	var count int
+	if err := d.GetDB().QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	return count
+}
+
+func addItem(t *testing.T, d *Database, name string) {
+	_, err := d.GetDB().Exec("INSERT INTO items (name, code, price, quantity) VALUES (?, ?, 100, 1)", name, name)
+	if err != nil {
+		t.Fatalf("Failed to insert item: %v", err)
+	}
+}
+
+// addUser adds a user holding a role of their own with the given permissions
+// and returns their ID
+func addUser(t *testing.T, d *Database, name string, permissions ...string) int {
+	result, err := d.GetDB().Exec("INSERT INTO users (username, password_hash) VALUES (?, 'x')", name)
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+	result, err = d.GetDB().Exec("INSERT INTO roles (name) VALUES (?)", name)
+	if err != nil {
+		t.Fatalf("Failed to insert role: %v", err)
+	}
+	roleID, _ := result.LastInsertId()
+	for _, permission := range permissions {
+		if _, err := d.GetDB().Exec("INSERT INTO role_permissions (role_id, permission) VALUES (?, ?)", roleID, permission); err != nil {
+			t.Fatalf("Failed to insert permission: %v", err)
+		}
+	}
+	if _, err := d.GetDB().Exec("INSERT INTO user_roles (user_id, role_id) VALUES (?, ?)", userID, roleID); err != nil {
+		t.Fatalf("Failed to insert user role: %v", err)
+	}
+	return int(userID)
+}
+
+func newTestDatabase(t *testing.T) *Database {
+	d, err := openDatabase(filepath.Join(t.TempDir(), "ims.db"))
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestBackup(t *testing.T) {
+	d := newTestDatabase(t)
+	addItem(t, d, "Apple")
+
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	if err := d.Backup(context.Background(), dest); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	// Backing up over an existing file replaces it
+	addItem(t, d, "Banana")
+	if err := d.Backup(context.Background(), dest); err != nil {
+		t.Fatalf("Second backup failed: %v", err)
+	}
+
+	backup, err := openDatabase(dest)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer backup.Close()
+
+	if got := countItems(t, backup); got != 2 {
+		t.Errorf("Expected 2 items in backup, got %d", got)
+	}
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected temporary backup file to be removed")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	d := newTestDatabase(t)
+	addItem(t, d, "Apple")
+
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	if err := d.Backup(context.Background(), backupPath); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	addItem(t, d, "Banana")
+	addItem(t, d, "Coffee")
+
+	if err := d.Restore(backupPath); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if got := countItems(t, d); got != 1 {
+		t.Errorf("Expected 1 item after restore, got %d", got)
+	}
+
+	// The data being replaced is kept as a backup
+	matches, _ := filepath.Glob(filepath.Join(d.BackupDir(), "ims-pre-restore-*.db"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected 1 pre-restore backup, got %d", len(matches))
+	}
+	saved, err := openDatabase(matches[0])
+	if err != nil {
+		t.Fatalf("Failed to open pre-restore backup: %v", err)
+	}
+	defer saved.Close()
+	if got := countItems(t, saved); got != 3 {
+		t.Errorf("Expected 3 items in pre-restore backup, got %d", got)
+	}
+}
+
+func TestRestore_MigratesOldBackup(t *testing.T) {
+	d := newTestDatabase(t)
+
+	if err := d.Restore(copyFixture(t, "ims_v0.db")); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if version != LatestVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestVersion(), version)
+	}
+	if got := countItems(t, d); got != 3 {
+		t.Errorf("Expected 3 items from fixture, got %d", got)
+	}
+}
+
+func TestRestore_RejectsInvalidBackups(t *testing.T) {
+	d := newTestDatabase(t)
+	addItem(t, d, "Apple")
+
+	// A backup from a newer version of the program
+	newer := filepath.Join(t.TempDir(), "newer.db")
+	if err := d.Backup(context.Background(), newer); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	nd, err := openDatabase(newer)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	_, err = nd.GetDB().Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'future', CURRENT_TIMESTAMP)", LatestVersion()+1)
+	nd.Close()
+	if err != nil {
+		t.Fatalf("Failed to bump schema version: %v", err)
+	}
+
+	// A file that isn't a database at all
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// A SQLite database that isn't an IMS database
+	other := filepath.Join(t.TempDir(), "other.db")
+	od, err := openDatabase(other)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	_, err = od.GetDB().Exec("DROP TABLE transaction_items; DROP TABLE transactions")
+	od.Close()
+	if err != nil {
+		t.Fatalf("Failed to drop tables: %v", err)
+	}
+
+	tests := map[string]string{
+		"newer schema": newer,
+		"not sqlite":   garbage,
+		"missing":      filepath.Join(t.TempDir(), "missing.db"),
+		"not ims":      other,
+	}
+	for name, path := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := d.Restore(path); err == nil {
+				t.Error("Expected restore to be rejected")
+			}
+			if got := countItems(t, d); got != 1 {
+				t.Errorf("Expected current data untouched, got %d items", got)
+			}
+		})
+	}
+}
+
+func TestResetDatabase_BacksUpFirst(t *testing.T) {
+	d := newTestDatabase(t)
+	addItem(t, d, "Apple")
+	if _, err := d.GetDB().Exec("INSERT INTO stock_movements (item_id, delta, reason) VALUES (1, 1, 'restock')"); err != nil {
+		t.Fatalf("Failed to insert movement: %v", err)
+	}
+
+	var rootID int
+	if err := d.GetDB().QueryRow("SELECT id FROM users WHERE is_root_admin = 1").Scan(&rootID); err != nil {
+		t.Fatalf("Failed to find root admin: %v", err)
+	}
+
+	if err := d.ResetDatabaseAs(rootID); err != nil {
+		t.Fatalf("ResetDatabaseAs failed: %v", err)
+	}
+	if got := countItems(t, d); got != 0 {
+		t.Errorf("Expected no items after reset, got %d", got)
+	}
+	var movements int
+	d.GetDB().QueryRow("SELECT COUNT(*) FROM stock_movements").Scan(&movements)
+	if movements != 0 {
+		t.Errorf("Expected no stock movements after reset, got %d", movements)
+	}
+
+	// The ledger is protected again once the reset is done
+	if _, err := d.GetDB().Exec("INSERT INTO stock_movements (item_id, delta, reason) VALUES (1, 1, 'restock')"); err != nil {
+		t.Fatalf("Failed to insert movement: %v", err)
+	}
+	if _, err := d.GetDB().Exec("DELETE FROM stock_movements"); err == nil {
+		t.Error("Expected deleting a stock movement to fail after a reset")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(d.BackupDir(), "ims-pre-reset-*.db"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected 1 pre-reset backup, got %d", len(matches))
+	}
+	saved, err := openDatabase(matches[0])
+	if err != nil {
+		t.Fatalf("Failed to open pre-reset backup: %v", err)
+	}
+	defer saved.Close()
+	if got := countItems(t, saved); got != 1 {
+		t.Errorf("Expected 1 item in pre-reset backup, got %d", got)
+	}
+}
+
+func TestDatabaseActions_Permissions(t *testing.T) {
+	d := newTestDatabase(t)
+	addItem(t, d, "Apple")
+	keeper := addUser(t, d, "keeper", "db.backup")
+	clerk := addUser(t, d, "clerk", "stock.adjust")
+	var rootID int
+	if err := d.GetDB().QueryRow("SELECT id FROM users WHERE is_root_admin = 1").Scan(&rootID); err != nil {
+		t.Fatalf("Failed to find root admin: %v", err)
+	}
+
+	// Backing up needs db.backup
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	if err := d.BackupAs(context.Background(), dest, clerk); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted backing up without db.backup, got %v", err)
+	}
+	if err := d.BackupAs(context.Background(), dest, keeper); err != nil {
+		t.Fatalf("BackupAs failed: %v", err)
+	}
+
+	// Restoring and resetting are for the root admin, whatever roles say
+	if err := d.RestoreAs(dest, keeper); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted restoring as a non-root user, got %v", err)
+	}
+	if err := d.ResetDatabaseAs(keeper); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted resetting as a non-root user, got %v", err)
+	}
+	if got := countItems(t, d); got != 1 {
+		t.Errorf("Expected the refused reset to leave 1 item, got %d", got)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(d.BackupDir(), "ims-pre-*.db")); len(matches) != 0 {
+		t.Errorf("Expected no safety backups for refused changes, got %v", matches)
+	}
+
+	if err := d.RestoreAs(dest, rootID); err != nil {
+		t.Fatalf("RestoreAs failed: %v", err)
+	}
+	if err := d.ResetDatabaseAs(rootID); err != nil {
+		t.Fatalf("ResetDatabaseAs failed: %v", err)
+	}
+	if got := countItems(t, d); got != 0 {
+		t.Errorf("Expected no items after reset, got %d", got)
+	}
+}
+
+func TestOpenPragmas(t *testing.T) {
+	d := newTestDatabase(t)
+
+	var mode string
+	var timeout int
+	if err := d.GetDB().QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatalf("Failed to read journal mode: %v", err)
+	}
+	if err := d.GetDB().QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil {
+		t.Fatalf("Failed to read busy timeout: %v", err)
+	}
+	if mode != "wal" || timeout != 5000 {
+		t.Errorf("Expected WAL and a 5000ms busy timeout, got %s and %d", mode, timeout)
+	}
+}
+
+func TestOpenPathWithURICharacters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shop?#1 100%.db")
+	d, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	addItem(t, d, "Apple")
+	d.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected the database at %s: %v", path, err)
+	}
+	if err := validateBackup(path); err != nil {
+		t.Errorf("validateBackup failed: %v", err)
+	}
+	d, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	defer d.Close()
+	if got := countItems(t, d); got != 1 {
+		t.Errorf("Expected the item back after reopening, got %d", got)
+	}
+}
+
+func TestScheduleBackups_ReportsErrors(t *testing.T) {
+	d := newTestDatabase(t)
+
+	// A file where the backup folder should be makes every backup fail
+	blocked := filepath.Join(t.TempDir(), "backups")
+	if err := os.WriteFile(blocked, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	d.SetBackupDir(blocked)
+
+	failures := make(chan error, 1)
+	d.OnBackupError(func(err error) {
+		select {
+		case failures <- err:
+		default:
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.ScheduleBackups(ctx, 10*time.Millisecond, 2)
+
+	select {
+	case err := <-failures:
+		if err == nil {
+			t.Error("Expected the backup error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a failed backup to be reported")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	d := newTestDatabase(t)
+	d.SetBackupDir(t.TempDir())
+
+	var paths []string
+	for i := 0; i < 5; i++ {
+		path, err := d.BackupToDir(context.Background(), "auto")
+		if err != nil {
+			t.Fatalf("BackupToDir failed: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	manual, err := d.BackupToDir(context.Background(), "pre-reset")
+	if err != nil {
+		t.Fatalf("BackupToDir failed: %v", err)
+	}
+
+	if err := pruneBackups(d.BackupDir(), "auto", 2); err != nil {
+		t.Fatalf("pruneBackups failed: %v", err)
+	}
+
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		if kept := i >= 3; kept != (err == nil) {
+			t.Errorf("Backup %d: expected kept=%v, stat error %v", i, kept, err)
+		}
+	}
+	if _, err := os.Stat(manual); err != nil {
+		t.Errorf("Expected other backups to be left alone: %v", err)
+	}
+}
@@ -1,137 +1,147 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
 
 	"ims-go/auth"
+	"ims-go/roles"
 )
 
 type Database struct {
-	db *sql.DB
+	mu        sync.RWMutex
+	db        *sql.DB
+	path      string
+	backupDir string
+
+	onBackupError func(err error)
+}
+
+// NewDatabase opens (creating if needed) the database at path and brings its
+// schema up to date
+func NewDatabase(path string) (*Database, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Printf("Failed to create database directory: %v\n", err)
+		return nil, err
+	}
+	return openDatabase(path)
 }
 
-func NewDatabase() (*Database, error) {
-	log.Println("Opening SQLite database...")
+func openDatabase(path string) (*Database, error) {
+	d := &Database{
+		path:      path,
+		backupDir: filepath.Join(filepath.Dir(path), "backups"),
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// dsn adds the settings every connection needs to a database path. A
+// connection waits up to five seconds for another to finish writing instead
+// of failing with SQLITE_BUSY, and WAL lets scheduled backups read while the
+// program writes.
+func dsn(path string) string {
+	return fileURI(path, "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+}
+
+// fileURI is path as a file: URI with the given query, so that a '?', '#' or
+// '%' in the path is part of the file name rather than the settings
+func fileURI(path, query string) string {
+	p := filepath.ToSlash(path)
+	if filepath.VolumeName(path) != "" {
+		p = "/" + p // file:///C:/...
+	}
+	u := url.URL{Scheme: "file", Path: p, RawQuery: query}
+	return u.String()
+}
+
+// open connects to the file at d.path, migrates it and makes sure a root
+// admin exists. The connection is closed again if any step fails.
+func (d *Database) open() error {
+	log.Printf("Opening SQLite database %s...\n", d.path)
 	startTime := time.Now()
-	db, err := sql.Open("sqlite", "ims.db")
+	db, err := sql.Open("sqlite", dsn(d.path))
 	if err != nil {
 		log.Printf("Failed to open database: %v\n", err)
-		return nil, err
+		return err
 	}
 	log.Printf("Database opened in %v\n", time.Since(startTime))
 
-	d := &Database{db: db}
+	d.db = db
 
-	log.Println("Initializing database schema...")
+	log.Println("Migrating database schema...")
 	schemaStart := time.Now()
-	if err := d.initSchema(); err != nil {
-		log.Printf("Failed to initialize schema: %v\n", err)
-		return nil, err
+	if err := d.migrate(); err != nil {
+		log.Printf("Failed to migrate schema: %v\n", err)
+		db.Close()
+		return err
+	}
+	version, err := d.SchemaVersion()
+	if err != nil {
+		db.Close()
+		return err
 	}
-	log.Printf("Database schema initialized in %v\n", time.Since(schemaStart))
+	log.Printf("Database schema at version %d (migrated in %v)\n", version, time.Since(schemaStart))
 
 	// Create root admin if it doesn't exist
 	log.Println("Ensuring root admin exists...")
 	adminStart := time.Now()
 	if err := d.ensureRootAdmin(); err != nil {
 		log.Printf("Failed to ensure root admin: %v\n", err)
-		return nil, err
+		db.Close()
+		return err
 	}
 	log.Printf("Root admin check completed in %v\n", time.Since(adminStart))
 
 	log.Println("Database initialization completed successfully")
-	return d, nil
+	return nil
 }
 
 func (d *Database) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.db.Close()
 }
 
 func (d *Database) GetDB() *sql.DB {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	return d.db
 }
 
-func (d *Database) initSchema() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			is_root_admin INTEGER DEFAULT 0,
-			can_read INTEGER DEFAULT 0,
-			can_transaction INTEGER DEFAULT 0,
-			can_revenue INTEGER DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS items (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			code TEXT UNIQUE NOT NULL,
-			description TEXT,
-			price REAL NOT NULL,
-			cost REAL NOT NULL DEFAULT 0,
-			quantity INTEGER DEFAULT 0,
-			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
-			expiry_date DATETIME,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS item_stock (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			item_id INTEGER NOT NULL,
-			quantity INTEGER NOT NULL,
-			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
-			expiry_date DATETIME,
-			FOREIGN KEY (item_id) REFERENCES items(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS transactions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			total_amount REAL NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS transaction_items (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			transaction_id INTEGER NOT NULL,
-			item_id INTEGER NOT NULL,
-			quantity INTEGER NOT NULL,
-			price REAL NOT NULL,
-			FOREIGN KEY (transaction_id) REFERENCES transactions(id),
-			FOREIGN KEY (item_id) REFERENCES items(id)
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_items_code ON items(code)`,
-		`CREATE INDEX IF NOT EXISTS idx_items_name ON items(name)`,
-	}
-
-	for _, query := range queries {
-		if _, err := d.db.Exec(query); err != nil {
-			return err
-		}
-	}
+// Path returns the file the database is stored in
+func (d *Database) Path() string {
+	return d.path
+}
 
-	// Add new columns if they don't exist (for existing databases)
-	migrationQueries := []string{
-		`ALTER TABLE users ADD COLUMN can_revenue INTEGER DEFAULT 0`,
-		`ALTER TABLE items ADD COLUMN cost REAL NOT NULL DEFAULT 0`,
-		`ALTER TABLE items ADD COLUMN in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP`,
-		`ALTER TABLE items ADD COLUMN expiry_date DATETIME`,
+func (d *Database) ensureRootAdmin() error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
 	}
-
-	for _, query := range migrationQueries {
-		d.db.Exec(query) // Ignore error if column already exists
+	defer tx.Rollback()
+	if err := ensureRootAdminTx(tx); err != nil {
+		return err
 	}
-
-	return nil
+	return tx.Commit()
 }
 
-func (d *Database) ensureRootAdmin() error {
+// ensureRootAdminTx creates the default root admin, admin/admin, inside an
+// existing transaction if there is no root admin
+func ensureRootAdminTx(tx *sql.Tx) error {
 	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM users WHERE is_root_admin = 1").Scan(&count)
+	err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE is_root_admin = 1").Scan(&count)
 	if err != nil {
 		return err
 	}
@@ -143,42 +153,95 @@ func (d *Database) ensureRootAdmin() error {
 			return err
 		}
 
-		_, err = d.db.Exec(
-			"INSERT INTO users (username, password_hash, is_root_admin, can_read, can_transaction, can_revenue) VALUES (?, ?, 1, 1, 1, 1)",
+		result, err := tx.Exec(
+			"INSERT INTO users (username, password_hash, is_root_admin) VALUES (?, ?, 1)",
 			"admin", hashedPassword,
 		)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO user_roles (user_id, role_id) SELECT ?, id FROM roles WHERE name = 'Administrator'", id)
 		return err
 	}
 
 	return nil
 }
 
-// ResetDatabase deletes all data and reinitializes the database
-func (d *Database) ResetDatabase() error {
-	// Delete all data from all tables (in reverse order of dependencies)
+// ResetDatabaseAs deletes all data and reinitializes the database on behalf
+// of a user. Only the root admin may wipe the database. A backup is written
+// to the backup directory first so a reset can be undone by restoring it.
+// The check and the wipe are a single transaction, and no backup or restore
+// can run while they do.
+func (d *Database) ResetDatabaseAs(userID int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireRootTx(tx, userID); err != nil {
+		return err
+	}
+
+	path, err := d.backupToDir(context.Background(), "pre-reset")
+	if err != nil {
+		return fmt.Errorf("backup before reset failed: %w", err)
+	}
+	log.Printf("Database backed up to %s before reset\n", path)
+
+	// Delete all data from all tables (in reverse order of dependencies).
+	// Roles are kept, only who holds them goes.
 	tables := []string{
+		"item_units",
+		"item_barcodes",
+		"item_tags",
+		"tags",
+		"categories",
+		"stocktake_lines",
+		"stocktakes",
+		"stock_movements",
+		"purchase_order_lines",
+		"purchase_orders",
+		"suppliers",
+		"refund_items",
+		"refunds",
 		"transaction_items",
 		"transactions",
 		"item_stock",
 		"items",
+		"user_roles",
 		"users",
 	}
 
+	// The ledger can't be deleted from, except here
+	if _, err := tx.Exec("DROP TRIGGER stock_movements_no_delete"); err != nil {
+		return err
+	}
 	for _, table := range tables {
-		if _, err := d.db.Exec("DELETE FROM " + table); err != nil {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
 			return err
 		}
 	}
-
-	// Reset auto-increment counters
-	resetQueries := []string{
-		"DELETE FROM sqlite_sequence WHERE name IN ('users', 'items', 'item_stock', 'transactions', 'transaction_items')",
+	if _, err := tx.Exec(createMovementsNoDelete); err != nil {
+		return err
 	}
 
-	for _, query := range resetQueries {
-		d.db.Exec(query) // Ignore error if sequence doesn't exist
+	// Reset auto-increment counters
+	_, err = tx.Exec("DELETE FROM sqlite_sequence WHERE name IN ('users', 'items', 'item_stock', 'transactions', 'transaction_items', 'refunds', 'refund_items', 'suppliers', 'purchase_orders', 'purchase_order_lines', 'stock_movements', 'stocktakes', 'stocktake_lines', 'categories', 'tags', 'item_units', 'item_barcodes')")
+	if err != nil {
+		return err
 	}
 
 	// Recreate root admin
-	return d.ensureRootAdmin()
+	if err := ensureRootAdminTx(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,1048 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Migration is one numbered change to the database schema. Up moves the
+// schema forward to Version and Down reverses it. Each runs inside its own
+// transaction.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// migrations is the ordered list of every schema change. New migrations are
+// appended with the next version number; existing ones must never change.
+//
+// Versions 1-4 predate the schema_migrations table, so they are written to
+// cope with databases where some or all of their changes already exist.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      migrateInitialSchema,
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS transaction_items`,
+				`DROP TABLE IF EXISTS transactions`,
+				`DROP TABLE IF EXISTS item_stock`,
+				`DROP TABLE IF EXISTS items`,
+				`DROP TABLE IF EXISTS users`,
+			)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "refunds",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE IF NOT EXISTS refunds (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					transaction_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					total_amount REAL NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (transaction_id) REFERENCES transactions(id),
+					FOREIGN KEY (user_id) REFERENCES users(id)
+				)`,
+				`CREATE TABLE IF NOT EXISTS refund_items (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					refund_id INTEGER NOT NULL,
+					transaction_item_id INTEGER NOT NULL,
+					item_id INTEGER NOT NULL,
+					quantity INTEGER NOT NULL,
+					price REAL NOT NULL,
+					FOREIGN KEY (refund_id) REFERENCES refunds(id),
+					FOREIGN KEY (transaction_item_id) REFERENCES transaction_items(id),
+					FOREIGN KEY (item_id) REFERENCES items(id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_refunds_transaction ON refunds(transaction_id)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE IF EXISTS refund_items`,
+				`DROP TABLE IF EXISTS refunds`,
+			)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "void transactions",
+		Up: func(tx *sql.Tx) error {
+			columns := []struct{ table, column, definition string }{
+				{"users", "can_void", "INTEGER DEFAULT 0"},
+				{"transactions", "status", "TEXT NOT NULL DEFAULT 'completed'"},
+				{"transactions", "voided_by", "INTEGER"},
+				{"transactions", "void_reason", "TEXT"},
+				{"transactions", "voided_at", "DATETIME"},
+			}
+			for _, c := range columns {
+				if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec("UPDATE users SET can_void = 1 WHERE is_root_admin = 1")
+			return err
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE transactions DROP COLUMN voided_at`,
+				`ALTER TABLE transactions DROP COLUMN void_reason`,
+				`ALTER TABLE transactions DROP COLUMN voided_by`,
+				`ALTER TABLE transactions DROP COLUMN status`,
+				`ALTER TABLE users DROP COLUMN can_void`,
+			)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "money as integer cents",
+		Up: func(tx *sql.Tx) error {
+			for _, c := range moneyColumns {
+				err := convertColumn(tx, c.table, c.column, "REAL", "INTEGER NOT NULL DEFAULT 0", "CAST(ROUND(%s * 100) AS INTEGER)")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			for _, c := range moneyColumns {
+				err := convertColumn(tx, c.table, c.column, "INTEGER", "REAL NOT NULL DEFAULT 0", "%s / 100.0")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "purchasing",
+		Up: func(tx *sql.Tx) error {
+			err := execAll(tx,
+				`CREATE TABLE suppliers (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					name TEXT UNIQUE NOT NULL,
+					contact_name TEXT NOT NULL DEFAULT '',
+					phone TEXT NOT NULL DEFAULT '',
+					email TEXT NOT NULL DEFAULT '',
+					address TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE TABLE purchase_orders (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					supplier_id INTEGER NOT NULL,
+					status TEXT NOT NULL DEFAULT 'draft',
+					created_by INTEGER NOT NULL,
+					notes TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					ordered_at DATETIME,
+					received_at DATETIME,
+					FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+					FOREIGN KEY (created_by) REFERENCES users(id)
+				)`,
+				`CREATE TABLE purchase_order_lines (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					purchase_order_id INTEGER NOT NULL,
+					item_id INTEGER NOT NULL,
+					quantity_ordered INTEGER NOT NULL,
+					quantity_received INTEGER NOT NULL DEFAULT 0,
+					unit_cost INTEGER NOT NULL,
+					FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id),
+					FOREIGN KEY (item_id) REFERENCES items(id)
+				)`,
+				`CREATE INDEX idx_purchase_orders_supplier ON purchase_orders(supplier_id)`,
+				`CREATE INDEX idx_purchase_order_lines_order ON purchase_order_lines(purchase_order_id)`,
+			)
+			if err != nil {
+				return err
+			}
+			if err := addColumnIfMissing(tx, "item_stock", "unit_cost", "INTEGER"); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "item_stock", "purchase_order_line_id", "INTEGER")
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE item_stock DROP COLUMN purchase_order_line_id`,
+				`ALTER TABLE item_stock DROP COLUMN unit_cost`,
+				`DROP TABLE purchase_order_lines`,
+				`DROP TABLE purchase_orders`,
+				`DROP TABLE suppliers`,
+			)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "reorder points",
+		Up: func(tx *sql.Tx) error {
+			columns := []struct{ column, definition string }{
+				{"reorder_point", "INTEGER"},
+				{"reorder_quantity", "INTEGER NOT NULL DEFAULT 0"},
+				{"preferred_supplier_id", "INTEGER"},
+			}
+			for _, c := range columns {
+				if err := addColumnIfMissing(tx, "items", c.column, c.definition); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE items DROP COLUMN preferred_supplier_id`,
+				`ALTER TABLE items DROP COLUMN reorder_quantity`,
+				`ALTER TABLE items DROP COLUMN reorder_point`,
+			)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "batch depletion",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "transaction_items", "batch_id", "INTEGER"); err != nil {
+				return err
+			}
+			return reconcileBatches(tx)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `ALTER TABLE transaction_items DROP COLUMN batch_id`)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "stock movements",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE stock_movements (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					item_id INTEGER NOT NULL,
+					batch_id INTEGER,
+					delta INTEGER NOT NULL,
+					reason TEXT NOT NULL,
+					reference_id INTEGER,
+					user_id INTEGER,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE INDEX idx_stock_movements_item ON stock_movements(item_id, created_at)`,
+				// The ledger is append-only
+				`CREATE TRIGGER stock_movements_no_update BEFORE UPDATE ON stock_movements
+				 BEGIN
+					SELECT RAISE(ABORT, 'stock movements cannot be changed');
+				 END`,
+				// Open the ledger with the stock already on hand so movements add up to it
+				`INSERT INTO stock_movements (item_id, batch_id, delta, reason, created_at)
+				 SELECT item_id, id, quantity, 'adjustment', CURRENT_TIMESTAMP FROM item_stock WHERE quantity > 0`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE stock_movements`)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "stocktakes",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE stocktakes (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					status TEXT NOT NULL DEFAULT 'open',
+					filter TEXT NOT NULL DEFAULT '',
+					created_by INTEGER NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					posted_by INTEGER,
+					posted_at DATETIME,
+					FOREIGN KEY (created_by) REFERENCES users(id)
+				)`,
+				`CREATE TABLE stocktake_lines (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					stocktake_id INTEGER NOT NULL,
+					item_id INTEGER NOT NULL,
+					expected_quantity INTEGER NOT NULL,
+					counted_quantity INTEGER,
+					UNIQUE (stocktake_id, item_id),
+					FOREIGN KEY (stocktake_id) REFERENCES stocktakes(id),
+					FOREIGN KEY (item_id) REFERENCES items(id)
+				)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP TABLE stocktake_lines`,
+				`DROP TABLE stocktakes`,
+			)
+		},
+	},
+	{
+		Version: 10,
+		Name:    "cost of goods sold",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "transaction_items", "cost", "INTEGER"); err != nil {
+				return err
+			}
+			// Batches without a recorded cost are valued at today's item cost,
+			// the best figure available for stock bought before costs were kept
+			return execAll(tx,
+				`UPDATE item_stock SET unit_cost = (SELECT cost FROM items WHERE items.id = item_stock.item_id)
+				 WHERE unit_cost IS NULL`,
+				`UPDATE transaction_items SET cost = COALESCE(
+					(SELECT unit_cost FROM item_stock WHERE item_stock.id = transaction_items.batch_id),
+					(SELECT cost FROM items WHERE items.id = transaction_items.item_id),
+					0)
+				 WHERE cost IS NULL`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `ALTER TABLE transaction_items DROP COLUMN cost`)
+		},
+	},
+	{
+		Version: 11,
+		Name:    "sale item snapshot",
+		Up: func(tx *sql.Tx) error {
+			for _, column := range []string{"item_name", "item_code"} {
+				if err := addColumnIfMissing(tx, "transaction_items", column, "TEXT"); err != nil {
+					return err
+				}
+			}
+			// Sales of items that were already deleted have nothing to copy
+			// and are shown by item ID instead
+			return execAll(tx,
+				`UPDATE transaction_items SET
+					item_name = (SELECT name FROM items WHERE items.id = transaction_items.item_id),
+					item_code = (SELECT code FROM items WHERE items.id = transaction_items.item_id)
+				 WHERE item_name IS NULL`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE transaction_items DROP COLUMN item_code`,
+				`ALTER TABLE transaction_items DROP COLUMN item_name`,
+			)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "archiving",
+		Up: func(tx *sql.Tx) error {
+			for _, table := range []string{"items", "users"} {
+				if err := addColumnIfMissing(tx, table, "archived_at", "DATETIME"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE users DROP COLUMN archived_at`,
+				`ALTER TABLE items DROP COLUMN archived_at`,
+			)
+		},
+	},
+	{
+		Version: 13,
+		Name:    "categories and tags",
+		Up: func(tx *sql.Tx) error {
+			err := execAll(tx,
+				`CREATE TABLE categories (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					name TEXT NOT NULL,
+					parent_id INTEGER,
+					tax_class TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (parent_id) REFERENCES categories(id)
+				)`,
+				`CREATE INDEX idx_categories_parent ON categories(parent_id)`,
+				`CREATE TABLE tags (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					name TEXT NOT NULL UNIQUE COLLATE NOCASE
+				)`,
+				`CREATE TABLE item_tags (
+					item_id INTEGER NOT NULL,
+					tag_id INTEGER NOT NULL,
+					PRIMARY KEY (item_id, tag_id),
+					FOREIGN KEY (item_id) REFERENCES items(id),
+					FOREIGN KEY (tag_id) REFERENCES tags(id)
+				)`,
+			)
+			if err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "items", "category_id", "INTEGER")
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE items DROP COLUMN category_id`,
+				`DROP TABLE item_tags`,
+				`DROP TABLE tags`,
+				`DROP TABLE categories`,
+			)
+		},
+	},
+	{
+		Version: 14,
+		Name:    "product variants",
+		Up: func(tx *sql.Tx) error {
+			columns := []struct{ name, definition string }{
+				{"parent_id", "INTEGER REFERENCES items(id)"},
+				{"variant_name", "TEXT NOT NULL DEFAULT ''"},
+				{"price_override", "BOOLEAN NOT NULL DEFAULT 0"},
+			}
+			for _, c := range columns {
+				if err := addColumnIfMissing(tx, "items", c.name, c.definition); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_items_parent ON items(parent_id)`)
+			return err
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`DROP INDEX idx_items_parent`,
+				`ALTER TABLE items DROP COLUMN price_override`,
+				`ALTER TABLE items DROP COLUMN variant_name`,
+				`ALTER TABLE items DROP COLUMN parent_id`,
+			)
+		},
+	},
+	{
+		Version: 15,
+		Name:    "units of measure",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE item_units (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				item_id INTEGER NOT NULL,
+				name TEXT NOT NULL COLLATE NOCASE,
+				factor INTEGER NOT NULL,
+				UNIQUE (item_id, name),
+				FOREIGN KEY (item_id) REFERENCES items(id)
+			)`)
+			if err != nil {
+				return err
+			}
+			columns := []struct{ table, name, definition string }{
+				{"items", "base_unit", "TEXT NOT NULL DEFAULT 'each'"},
+				{"items", "sale_unit", "TEXT NOT NULL DEFAULT ''"},
+				{"items", "purchase_unit", "TEXT NOT NULL DEFAULT ''"},
+				{"transaction_items", "unit", "TEXT NOT NULL DEFAULT ''"},
+				{"transaction_items", "unit_factor", "INTEGER NOT NULL DEFAULT 1"},
+			}
+			for _, c := range columns {
+				if err := addColumnIfMissing(tx, c.table, c.name, c.definition); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`ALTER TABLE transaction_items DROP COLUMN unit_factor`,
+				`ALTER TABLE transaction_items DROP COLUMN unit`,
+				`ALTER TABLE items DROP COLUMN purchase_unit`,
+				`ALTER TABLE items DROP COLUMN sale_unit`,
+				`ALTER TABLE items DROP COLUMN base_unit`,
+				`DROP TABLE item_units`,
+			)
+		},
+	},
+	{
+		Version: 16,
+		Name:    "item barcodes",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE item_barcodes (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					item_id INTEGER NOT NULL,
+					code TEXT UNIQUE NOT NULL,
+					description TEXT NOT NULL DEFAULT '',
+					quantity INTEGER,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (item_id) REFERENCES items(id)
+				)`,
+				`CREATE INDEX idx_item_barcodes_item ON item_barcodes(item_id)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE item_barcodes`)
+		},
+	},
+	{
+		Version: 17,
+		Name:    "roles and permissions",
+		Up:      migrateRoles,
+		Down: func(tx *sql.Tx) error {
+			for _, c := range legacyPermissionColumns {
+				if err := addColumnIfMissing(tx, "users", c.column, "INTEGER DEFAULT 0"); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`UPDATE users SET `+c.column+` = 1 WHERE is_root_admin = 1 OR id IN (
+					SELECT ur.user_id FROM user_roles ur
+					JOIN role_permissions rp ON rp.role_id = ur.role_id
+					WHERE rp.permission = ?)`, c.permission)
+				if err != nil {
+					return err
+				}
+			}
+			return execAll(tx,
+				`DROP TABLE user_roles`,
+				`DROP TABLE role_permissions`,
+				`DROP TABLE roles`,
+			)
+		},
+	},
+	{
+		// Voids used to be recorded as returns, referring to the sale rather
+		// than a refund. They are the returns by the sale's voider that no
+		// refund of the same item explains. The ledger is otherwise never
+		// changed, so its guard is lifted only while relabelling.
+		Version: 18,
+		Name:    "void stock movements",
+		Up: func(tx *sql.Tx) error {
+			return relabelMovements(tx,
+				`UPDATE stock_movements SET reason = 'void'
+				 WHERE reason = 'return'
+				   AND EXISTS (SELECT 1 FROM transactions t
+					WHERE t.id = stock_movements.reference_id AND t.status = 'voided'
+					  AND t.voided_by = stock_movements.user_id)
+				   AND NOT EXISTS (SELECT 1 FROM refund_items ri
+					WHERE ri.refund_id = stock_movements.reference_id AND ri.item_id = stock_movements.item_id)`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return relabelMovements(tx, `UPDATE stock_movements SET reason = 'return' WHERE reason = 'void'`)
+		},
+	},
+	{
+		// Batch and sale costs are kept for cost_factor base units, such as
+		// the case or kilo they were bought in, so they aren't rounded to a
+		// whole cent per base unit. Existing costs are per base unit.
+		Version: 19,
+		Name:    "batch cost factors",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "item_stock", "cost_factor", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "transaction_items", "cost_factor", "INTEGER NOT NULL DEFAULT 1")
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`UPDATE item_stock SET unit_cost = CAST(ROUND(unit_cost * 1.0 / cost_factor) AS INTEGER) WHERE cost_factor != 1`,
+				`UPDATE transaction_items SET cost = CAST(ROUND(cost * 1.0 / cost_factor) AS INTEGER) WHERE cost_factor != 1`,
+				`ALTER TABLE item_stock DROP COLUMN cost_factor`,
+				`ALTER TABLE transaction_items DROP COLUMN cost_factor`,
+			)
+		},
+	},
+	{
+		// A line rung up from a label with a price on it keeps that price as
+		// its total, rather than a rounded price per unit times the weight
+		Version: 20,
+		Name:    "label line totals",
+		Up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "transaction_items", "line_total", "INTEGER")
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `ALTER TABLE transaction_items DROP COLUMN line_total`)
+		},
+	},
+	{
+		// The ledger was only protected from changes. Nothing deletes from it
+		// now, and a reset drops this trigger for the length of its wipe.
+		Version: 21,
+		Name:    "stock movements no delete",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx, createMovementsNoDelete)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TRIGGER stock_movements_no_delete`)
+		},
+	},
+	{
+		// Settings that decide how the books are kept live with the books,
+		// along with a log of when they changed
+		Version: 22,
+		Name:    "settings",
+		Up: func(tx *sql.Tx) error {
+			return execAll(tx,
+				`CREATE TABLE settings (
+					key TEXT PRIMARY KEY,
+					value TEXT NOT NULL,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE TABLE setting_changes (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					key TEXT NOT NULL,
+					old_value TEXT,
+					new_value TEXT NOT NULL,
+					changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`INSERT INTO settings (key, value) VALUES ('costing_method', 'fifo')`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return execAll(tx, `DROP TABLE setting_changes`, `DROP TABLE settings`)
+		},
+	},
+}
+
+// createMovementsNoDelete creates the trigger that stops movements being
+// deleted from the stock ledger
+const createMovementsNoDelete = `CREATE TRIGGER stock_movements_no_delete BEFORE DELETE ON stock_movements
+	BEGIN
+		SELECT RAISE(ABORT, 'stock movements cannot be deleted');
+	END`
+
+// relabelMovements runs an update on the stock ledger with the trigger that
+// stops movements being changed dropped for the duration
+func relabelMovements(tx *sql.Tx, update string) error {
+	return execAll(tx,
+		`DROP TRIGGER stock_movements_no_update`,
+		update,
+		`CREATE TRIGGER stock_movements_no_update BEFORE UPDATE ON stock_movements
+		 BEGIN
+			SELECT RAISE(ABORT, 'stock movements cannot be changed');
+		 END`,
+	)
+}
+
+// legacyPermissionColumns are the users columns that held permissions before
+// roles, each with the permission it became
+var legacyPermissionColumns = []struct{ column, permission, role string }{
+	{"can_read", "item.view", "Viewer"},
+	{"can_transaction", "txn.create", "Cashier"},
+	{"can_revenue", "report.view", "Reports"},
+	{"can_void", "txn.void", "Void Approver"},
+}
+
+// defaultRoles are created along with the roles tables. Each legacy
+// permission column maps to one of them, so users keep what they could do.
+var defaultRoles = []struct {
+	name        string
+	permissions []string
+}{
+	{"Administrator", []string{"item.view", "item.create", "item.edit", "item.edit_price", "stock.adjust", "purchase.manage", "txn.create", "txn.refund", "txn.void", "report.view", "user.manage", "db.backup"}},
+	{"Manager", []string{"item.view", "item.create", "item.edit", "item.edit_price", "stock.adjust", "purchase.manage", "txn.create", "txn.refund", "txn.void", "report.view"}},
+	{"Stock Clerk", []string{"item.view", "item.create", "item.edit", "stock.adjust", "purchase.manage"}},
+	{"Cashier", []string{"txn.create", "txn.refund"}},
+	{"Viewer", []string{"item.view"}},
+	{"Reports", []string{"report.view"}},
+	{"Void Approver", []string{"txn.void"}},
+}
+
+// migrateRoles replaces the users' permission columns with roles. The root
+// admin becomes an Administrator and everyone else gets the role standing for
+// each column they had set.
+func migrateRoles(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE roles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL COLLATE NOCASE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE role_permissions (
+			role_id INTEGER NOT NULL,
+			permission TEXT NOT NULL,
+			PRIMARY KEY (role_id, permission),
+			FOREIGN KEY (role_id) REFERENCES roles(id)
+		)`,
+		`CREATE TABLE user_roles (
+			user_id INTEGER NOT NULL,
+			role_id INTEGER NOT NULL,
+			PRIMARY KEY (user_id, role_id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (role_id) REFERENCES roles(id)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range defaultRoles {
+		result, err := tx.Exec("INSERT INTO roles (name) VALUES (?)", role.name)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, permission := range role.permissions {
+			if _, err := tx.Exec("INSERT INTO role_permissions (role_id, permission) VALUES (?, ?)", id, permission); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO user_roles (user_id, role_id)
+		SELECT u.id, r.id FROM users u, roles r WHERE u.is_root_admin = 1 AND r.name = 'Administrator'`)
+	if err != nil {
+		return err
+	}
+	for _, c := range legacyPermissionColumns {
+		// The root admin can do everything already
+		_, err := tx.Exec(`INSERT INTO user_roles (user_id, role_id)
+			SELECT u.id, r.id FROM users u, roles r
+			WHERE u.`+c.column+` = 1 AND u.is_root_admin = 0 AND r.name = ?`, c.role)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`ALTER TABLE users DROP COLUMN ` + c.column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moneyColumns lists every column that holds an amount of money
+var moneyColumns = []struct{ table, column string }{
+	{"items", "price"},
+	{"items", "cost"},
+	{"transactions", "total_amount"},
+	{"transaction_items", "price"},
+	{"refunds", "total_amount"},
+	{"refund_items", "price"},
+}
+
+func migrateInitialSchema(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			password_hash TEXT NOT NULL,
+			is_root_admin INTEGER DEFAULT 0,
+			can_read INTEGER DEFAULT 0,
+			can_transaction INTEGER DEFAULT 0,
+			can_revenue INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			code TEXT UNIQUE NOT NULL,
+			description TEXT,
+			price REAL NOT NULL,
+			cost REAL NOT NULL DEFAULT 0,
+			quantity INTEGER DEFAULT 0,
+			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expiry_date DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS item_stock (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expiry_date DATETIME,
+			FOREIGN KEY (item_id) REFERENCES items(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			total_amount REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS transaction_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			transaction_id INTEGER NOT NULL,
+			item_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			price REAL NOT NULL,
+			FOREIGN KEY (transaction_id) REFERENCES transactions(id),
+			FOREIGN KEY (item_id) REFERENCES items(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_items_code ON items(code)`,
+		`CREATE INDEX IF NOT EXISTS idx_items_name ON items(name)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Columns added to the first release after it shipped. SQLite cannot add
+	// a column with a CURRENT_TIMESTAMP default, so in_stock_date is
+	// backfilled from created_at instead.
+	columns := []struct{ table, column, definition string }{
+		{"users", "can_revenue", "INTEGER DEFAULT 0"},
+		{"items", "cost", "REAL NOT NULL DEFAULT 0"},
+		{"items", "in_stock_date", "DATETIME"},
+		{"items", "expiry_date", "DATETIME"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE items SET in_stock_date = created_at WHERE in_stock_date IS NULL")
+	return err
+}
+
+// reconcileBatches makes every item's stock batches add up to its quantity.
+// Before batch depletion, sales only reduced items.quantity, so batches were
+// left too large. Surplus is taken from batches first-expiry-first-out then
+// first-in-first-out, and any shortfall becomes a new batch.
+func reconcileBatches(tx *sql.Tx) error {
+	rows, err := tx.Query(
+		`SELECT i.id, i.quantity, COALESCE((SELECT SUM(s.quantity) FROM item_stock s WHERE s.item_id = i.id), 0)
+		 FROM items i`,
+	)
+	if err != nil {
+		return err
+	}
+	type itemStock struct{ id, quantity, batched int }
+	var mismatched []itemStock
+	for rows.Next() {
+		var s itemStock
+		if err := rows.Scan(&s.id, &s.quantity, &s.batched); err != nil {
+			rows.Close()
+			return err
+		}
+		if s.quantity != s.batched {
+			mismatched = append(mismatched, s)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, s := range mismatched {
+		if s.quantity < 0 {
+			if _, err := tx.Exec("UPDATE items SET quantity = 0 WHERE id = ?", s.id); err != nil {
+				return err
+			}
+			s.quantity = 0
+		}
+
+		if s.quantity > s.batched {
+			_, err := tx.Exec(
+				"INSERT INTO item_stock (item_id, quantity, in_stock_date) VALUES (?, ?, ?)",
+				s.id, s.quantity-s.batched, now,
+			)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		batchRows, err := tx.Query(
+			"SELECT id, quantity FROM item_stock WHERE item_id = ? AND quantity > 0 ORDER BY expiry_date IS NULL, expiry_date, in_stock_date, id",
+			s.id,
+		)
+		if err != nil {
+			return err
+		}
+		type batch struct{ id, quantity int }
+		var batches []batch
+		for batchRows.Next() {
+			var b batch
+			if err := batchRows.Scan(&b.id, &b.quantity); err != nil {
+				batchRows.Close()
+				return err
+			}
+			batches = append(batches, b)
+		}
+		batchRows.Close()
+		if err := batchRows.Err(); err != nil {
+			return err
+		}
+
+		surplus := s.batched - s.quantity
+		for _, b := range batches {
+			if surplus == 0 {
+				break
+			}
+			take := b.quantity
+			if take > surplus {
+				take = surplus
+			}
+			if _, err := tx.Exec("UPDATE item_stock SET quantity = quantity - ? WHERE id = ?", take, b.id); err != nil {
+				return err
+			}
+			surplus -= take
+		}
+	}
+
+	return nil
+}
+
+// LatestVersion is the schema version this build of the program expects
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the most recent migration applied to
+// the database, or 0 if none have been
+func (d *Database) SchemaVersion() (int, error) {
+	return schemaVersion(d.db)
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// migrate applies every migration newer than the database's current version,
+// each in its own transaction. It stops at the first failure.
+func (d *Database) migrate() error {
+	_, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("database schema version %d is newer than this program supports (%d)", current, LatestVersion())
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		log.Printf("Applying migration %d: %s\n", m.Version, m.Name)
+		if err := d.runMigration(m, true); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts migrations newest first until the schema is at the
+// target version
+func (d *Database) MigrateDown(target int) error {
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		if m.Down == nil {
+			return fmt.Errorf("migration %d (%s) cannot be reverted", m.Version, m.Name)
+		}
+
+		log.Printf("Reverting migration %d: %s\n", m.Version, m.Name)
+		if err := d.runMigration(m, false); err != nil {
+			return fmt.Errorf("reverting migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Database) runMigration(m Migration, up bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if err := m.Up(tx); err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now(),
+		)
+	} else {
+		if err := m.Down(tx); err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func execAll(tx *sql.Tx, queries ...string) error {
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column unless the table already has it
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	existing, err := columnType(tx, table, column)
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// convertColumn changes the declared type of a column by copying its values,
+// converted with valueExpr, into a new column that takes its place. Columns
+// that are not currently of fromType are left alone.
+func convertColumn(tx *sql.Tx, table, column, fromType, definition, valueExpr string) error {
+	existing, err := columnType(tx, table, column)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(existing, fromType) {
+		return nil
+	}
+
+	tmp := column + "_new"
+	return execAll(tx,
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, tmp, definition),
+		fmt.Sprintf("UPDATE %s SET %s = %s", table, tmp, fmt.Sprintf(valueExpr, column)),
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column),
+		fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, tmp, column),
+	)
+}
+
+// columnType returns the declared type of a column, or "" if it does not exist
+func columnType(tx *sql.Tx, table, column string) (string, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, declaredType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &declaredType, &notNull, &defaultValue, &pk); err != nil {
+			return "", err
+		}
+		if name == column {
+			return declaredType, nil
+		}
+	}
+	return "", rows.Err()
+}
@@ -0,0 +1,368 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyFixture copies a database from testdata into a temporary directory so
+// tests never modify the fixture itself
+func copyFixture(t *testing.T, name string) string {
+	src, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer src.Close()
+
+	path := filepath.Join(t.TempDir(), "ims.db")
+	dst, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create database copy: %v", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		t.Fatalf("Failed to copy fixture: %v", err)
+	}
+	return path
+}
+
+func TestMigrate_FreshDatabase(t *testing.T) {
+	d, err := openDatabase(filepath.Join(t.TempDir(), "ims.db"))
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	defer d.Close()
+
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if version != LatestVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestVersion(), version)
+	}
+
+	var admins int
+	if err := d.GetDB().QueryRow("SELECT COUNT(*) FROM users WHERE is_root_admin = 1").Scan(&admins); err != nil {
+		t.Fatalf("Failed to count admins: %v", err)
+	}
+	if admins != 1 {
+		t.Errorf("Expected 1 root admin, got %d", admins)
+	}
+}
+
+func TestMigrate_UpgradesOldDatabase(t *testing.T) {
+	d, err := openDatabase(copyFixture(t, "ims_v0.db"))
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	defer d.Close()
+
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if version != LatestVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestVersion(), version)
+	}
+
+	// Prices should now be exact integer cents
+	var price, cost int64
+	var priceType string
+	err = d.GetDB().QueryRow("SELECT price, cost, typeof(price) FROM items WHERE code = 'BAN001'").Scan(&price, &cost, &priceType)
+	if err != nil {
+		t.Fatalf("Failed to read item: %v", err)
+	}
+	if price != 35 || cost != 0 || priceType != "integer" {
+		t.Errorf("Expected price 35 cents stored as integer, got %d (%s), cost %d", price, priceType, cost)
+	}
+
+	var total int64
+	if err := d.GetDB().QueryRow("SELECT total_amount FROM transactions WHERE id = 1").Scan(&total); err != nil {
+		t.Fatalf("Failed to read transaction: %v", err)
+	}
+	if total != 405 {
+		t.Errorf("Expected total 405 cents, got %d", total)
+	}
+
+	// Columns added after the first release should exist and be filled in
+	var inStockDate sql.NullTime
+	if err := d.GetDB().QueryRow("SELECT in_stock_date FROM items WHERE code = 'APL001'").Scan(&inStockDate); err != nil {
+		t.Fatalf("Failed to read in_stock_date: %v", err)
+	}
+	if !inStockDate.Valid {
+		t.Error("Expected in_stock_date to be backfilled")
+	}
+
+	var status string
+	if err := d.GetDB().QueryRow("SELECT status FROM transactions WHERE id = 1").Scan(&status); err != nil {
+		t.Fatalf("Failed to read status: %v", err)
+	}
+	if status != "completed" {
+		t.Errorf("Expected existing sale to be completed, got %s", status)
+	}
+
+	// Stock batches were never reduced by sales before batch depletion, so
+	// they are brought back in line with item quantities
+	var mismatched int
+	err = d.GetDB().QueryRow(
+		"SELECT COUNT(*) FROM items i WHERE i.quantity != (SELECT COALESCE(SUM(s.quantity), 0) FROM item_stock s WHERE s.item_id = i.id)",
+	).Scan(&mismatched)
+	if err != nil {
+		t.Fatalf("Failed to compare batches: %v", err)
+	}
+	if mismatched != 0 {
+		t.Errorf("Expected batches to match item quantities, %d items differ", mismatched)
+	}
+
+	// The stock ledger opens with the stock on hand
+	err = d.GetDB().QueryRow(
+		"SELECT COUNT(*) FROM items i WHERE i.quantity != (SELECT COALESCE(SUM(m.delta), 0) FROM stock_movements m WHERE m.item_id = i.id)",
+	).Scan(&mismatched)
+	if err != nil {
+		t.Fatalf("Failed to compare movements: %v", err)
+	}
+	if mismatched != 0 {
+		t.Errorf("Expected movements to add up to item quantities, %d items differ", mismatched)
+	}
+
+	// Past sales and stock batches are costed so margins can be reported
+	var uncosted int
+	err = d.GetDB().QueryRow(
+		"SELECT (SELECT COUNT(*) FROM transaction_items WHERE cost IS NULL) + (SELECT COUNT(*) FROM item_stock WHERE unit_cost IS NULL)",
+	).Scan(&uncosted)
+	if err != nil {
+		t.Fatalf("Failed to check costs: %v", err)
+	}
+	if uncosted != 0 {
+		t.Errorf("Expected every sale line and batch to have a cost, %d do not", uncosted)
+	}
+
+	// Sale lines keep the item name and code they were sold under
+	var unnamed int
+	err = d.GetDB().QueryRow(
+		"SELECT COUNT(*) FROM transaction_items ti JOIN items i ON ti.item_id = i.id WHERE ti.item_name IS NOT i.name OR ti.item_code IS NOT i.code",
+	).Scan(&unnamed)
+	if err != nil {
+		t.Fatalf("Failed to check sale item names: %v", err)
+	}
+	if unnamed != 0 {
+		t.Errorf("Expected sale lines to be named after their items, %d are not", unnamed)
+	}
+
+	// The existing admin is kept rather than a default one being added
+	var admins int
+	if err := d.GetDB().QueryRow("SELECT COUNT(*) FROM users WHERE is_root_admin = 1").Scan(&admins); err != nil {
+		t.Fatalf("Failed to count admins: %v", err)
+	}
+	if admins != 1 {
+		t.Errorf("Expected the existing admin, got %d admins", admins)
+	}
+
+	// Permission columns become roles
+	roles := map[string]string{}
+	rows, err := d.GetDB().Query(
+		`SELECT u.username, GROUP_CONCAT(r.name, ',') FROM users u
+		 JOIN (SELECT ur.user_id, r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id ORDER BY r.name) r ON r.user_id = u.id
+		 GROUP BY u.id`,
+	)
+	if err != nil {
+		t.Fatalf("Failed to read user roles: %v", err)
+	}
+	for rows.Next() {
+		var username, names string
+		if err := rows.Scan(&username, &names); err != nil {
+			t.Fatalf("Failed to read user roles: %v", err)
+		}
+		roles[username] = names
+	}
+	rows.Close()
+	if roles["admin"] != "Administrator" || roles["cashier"] != "Cashier,Viewer" {
+		t.Errorf("Expected admin to be an Administrator and cashier a Cashier and Viewer, got %v", roles)
+	}
+	if err := d.GetDB().QueryRow("SELECT can_read FROM users").Scan(new(int)); err == nil {
+		t.Error("Expected the permission columns to be dropped")
+	}
+}
+
+func TestMigrate_VoidMovements(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ims.db")
+	d, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+
+	// Sale 1 was partly refunded by refund 5, then voided by the same user
+	for _, query := range []string{
+		`INSERT INTO items (id, name, code, price, quantity) VALUES (1, 'Cola', 'CL001', 150, 10)`,
+		`INSERT INTO transactions (id, user_id, total_amount, status, voided_by) VALUES (1, 1, 300, 'voided', 1)`,
+		`INSERT INTO transaction_items (id, transaction_id, item_id, quantity, price) VALUES (1, 1, 1, 2, 150)`,
+		`INSERT INTO refunds (id, transaction_id, user_id, total_amount) VALUES (5, 1, 1, 150)`,
+		`INSERT INTO refund_items (refund_id, transaction_item_id, item_id, quantity, price) VALUES (5, 1, 1, 1, 150)`,
+		`INSERT INTO stock_movements (item_id, delta, reason, reference_id, user_id) VALUES (1, 1, 'return', 5, 1), (1, 1, 'void', 1, 1)`,
+	} {
+		if _, err := d.GetDB().Exec(query); err != nil {
+			t.Fatalf("Failed to insert history: %v", err)
+		}
+	}
+	if err := d.MigrateDown(17); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+	d.Close()
+
+	// Migrating up tells the void apart from the refund again
+	d, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	defer d.Close()
+	var reasons string
+	if err := d.GetDB().QueryRow("SELECT GROUP_CONCAT(reason, ',') FROM (SELECT reason FROM stock_movements ORDER BY id)").Scan(&reasons); err != nil {
+		t.Fatalf("Failed to read movements: %v", err)
+	}
+	if reasons != "return,void" {
+		t.Errorf("Expected the refund and the void, got %s", reasons)
+	}
+}
+
+func TestMigrate_RunsEachMigrationOnce(t *testing.T) {
+	path := copyFixture(t, "ims_v0.db")
+
+	d, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("First openDatabase failed: %v", err)
+	}
+	d.Close()
+
+	d, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("Second openDatabase failed: %v", err)
+	}
+	defer d.Close()
+
+	var count int
+	if err := d.GetDB().QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("Failed to count migrations: %v", err)
+	}
+	if count != len(migrations) {
+		t.Errorf("Expected %d recorded migrations, got %d", len(migrations), count)
+	}
+}
+
+func TestMigrate_FailedMigrationRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ims.db")
+	d, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	d.Close()
+
+	original := migrations
+	defer func() { migrations = original }()
+	migrations = append(append([]Migration{}, original...), Migration{
+		Version: LatestVersion() + 1,
+		Name:    "broken",
+		Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE half_done (id INTEGER)"); err != nil {
+				return err
+			}
+			return errors.New("something went wrong")
+		},
+	})
+
+	if _, err := openDatabase(path); err == nil {
+		t.Fatal("openDatabase should refuse to start when a migration fails")
+	}
+
+	migrations = original
+	d, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	defer d.Close()
+
+	version, _ := d.SchemaVersion()
+	if version != LatestVersion() {
+		t.Errorf("Expected schema version to stay at %d, got %d", LatestVersion(), version)
+	}
+
+	var tables int
+	if err := d.GetDB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'").Scan(&tables); err != nil {
+		t.Fatalf("Failed to look up table: %v", err)
+	}
+	if tables != 0 {
+		t.Error("Changes from the failed migration should have been rolled back")
+	}
+}
+
+func TestMigrate_RefusesNewerDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ims.db")
+	d, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	_, err = d.GetDB().Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'from the future', CURRENT_TIMESTAMP)", LatestVersion()+1)
+	d.Close()
+	if err != nil {
+		t.Fatalf("Failed to record future migration: %v", err)
+	}
+
+	if _, err := openDatabase(path); err == nil {
+		t.Error("openDatabase should refuse a database from a newer version")
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	path := copyFixture(t, "ims_v0.db")
+	d, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+
+	if err := d.MigrateDown(3); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+
+	version, _ := d.SchemaVersion()
+	if version != 3 {
+		t.Errorf("Expected schema version 3, got %d", version)
+	}
+
+	var price float64
+	var priceType string
+	if err := d.GetDB().QueryRow("SELECT price, typeof(price) FROM items WHERE code = 'COF001'").Scan(&price, &priceType); err != nil {
+		t.Fatalf("Failed to read item: %v", err)
+	}
+	if price != 7.99 || priceType != "real" {
+		t.Errorf("Expected price 7.99 stored as real, got %v (%s)", price, priceType)
+	}
+
+	// Roles go back to permission columns
+	var canRead, canTransaction, canRevenue int
+	if err := d.GetDB().QueryRow("SELECT can_read, can_transaction, can_revenue FROM users WHERE username = 'cashier'").Scan(&canRead, &canTransaction, &canRevenue); err != nil {
+		t.Fatalf("Failed to read permissions: %v", err)
+	}
+	if canRead != 1 || canTransaction != 1 || canRevenue != 0 {
+		t.Errorf("Expected cashier to read and sell only, got %d %d %d", canRead, canTransaction, canRevenue)
+	}
+	d.Close()
+
+	// Migrating back up should restore the latest schema
+	d, err = openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase failed: %v", err)
+	}
+	defer d.Close()
+
+	var cents int64
+	if err := d.GetDB().QueryRow("SELECT price FROM items WHERE code = 'COF001'").Scan(&cents); err != nil {
+		t.Fatalf("Failed to read item: %v", err)
+	}
+	if cents != 799 {
+		t.Errorf("Expected 799 cents, got %d", cents)
+	}
+}
@@ -7,6 +7,17 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+// runOnWindow runs fn on the goroutine that handles a window's input events,
+// so that code woken by a timer or a background task can safely update its
+// widgets. Drivers that don't queue events run fn straight away.
+func runOnWindow(window fyne.Window, fn func()) {
+	if queue, ok := window.(interface{ QueueEvent(fn func()) }); ok {
+		queue.QueueEvent(fn)
+		return
+	}
+	fn()
+}
+
 // showStyledDialog creates a custom dialog window with rounded corners and custom button labels
 func showStyledDialog(parent fyne.Window, title string, content fyne.CanvasObject, actionLabel string, onAction func(), onDone func()) {
 	// Get the app from parent window
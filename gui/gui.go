@@ -1,6 +1,7 @@
 package gui
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -12,42 +13,55 @@ import (
 	"fyne.io/fyne/v2/widget"
 
 	"ims-go/auth"
+	"ims-go/categories"
 	"ims-go/database"
 	"ims-go/inventory"
 	"ims-go/models"
+	"ims-go/money"
+	"ims-go/purchasing"
 )
 
 func ShowMainWindow(myApp fyne.App, appState *auth.AppState, user *models.User) {
-	mainWindow := myApp.NewWindow("Inventory Management System")
+	mainWindow := myApp.NewWindow(appState.GetConfig().StoreName)
 	mainWindow.Resize(fyne.NewSize(1000, 700))
 	mainWindow.CenterOnScreen()
 
 	// Create tabs based on user permissions
 	tabs := container.NewAppTabs()
 
-	// Inventory tab (if user can read)
-	if user.CanRead || user.IsRootAdmin {
+	// Inventory tab (if user can view items)
+	if user.Can(models.PermItemView) {
 		tabs.Append(&container.TabItem{Text: "Inventory", Content: createInventoryTab(mainWindow, appState, user)})
 	}
 
-	// Transaction mode (if user has transaction permission)
-	if user.CanTransaction || user.IsRootAdmin {
+	// Transaction mode (if user can ring up sales)
+	if user.Can(models.PermTxnCreate) {
 		tabs.Append(&container.TabItem{Text: "Transaction", Content: createTransactionTab(mainWindow, appState, user)})
 	}
 
-	// Revenue tab (if user has revenue permission)
-	if user.CanRevenue || user.IsRootAdmin {
+	// Stocktake tab (posting changes stock)
+	if user.Can(models.PermStockAdjust) {
+		tabs.Append(&container.TabItem{Text: "Stocktake", Content: createStocktakeTab(mainWindow, appState, user)})
+	}
+
+	// Purchasing tab
+	if user.Can(models.PermPurchasing) {
+		tabs.Append(&container.TabItem{Text: "Purchasing", Content: createPurchasingTab(mainWindow, appState, user)})
+	}
+
+	// Revenue tab (if user can view reports)
+	if user.Can(models.PermReportView) {
 		tabs.Append(&container.TabItem{Text: "Revenue", Content: createRevenueTab(mainWindow, appState, user)})
 	}
 
-	// Transaction log (if user has transaction permission)
-	if user.CanTransaction || user.IsRootAdmin {
+	// Transaction log (for anyone who sells, refunds or voids)
+	if user.Can(models.PermTxnCreate) || user.Can(models.PermTxnRefund) || user.Can(models.PermTxnVoid) {
 		tabs.Append(&container.TabItem{Text: "Transaction Log", Content: createTransactionLogTab(mainWindow, appState, user)})
 	}
 
-	// User management (only for root admin)
-	if user.IsRootAdmin {
-		tabs.Append(&container.TabItem{Text: "User Management", Content: createUserManagementTab(mainWindow, appState)})
+	// User and role management
+	if user.Can(models.PermUserManage) {
+		tabs.Append(&container.TabItem{Text: "User Management", Content: createUserManagementTab(mainWindow, appState, user)})
 	}
 
 	// Logout button
@@ -57,7 +71,7 @@ func ShowMainWindow(myApp fyne.App, appState *auth.AppState, user *models.User)
 		ShowLoginWindow(appState)
 	})
 
-	// Reset database button (only for root admin)
+	// Reset database button (for the root admin alone)
 	var resetBtn *widget.Button
 	if user.IsRootAdmin {
 		resetBtn = widget.NewButton("Reset Database", func() {
@@ -90,14 +104,14 @@ func ShowMainWindow(myApp fyne.App, appState *auth.AppState, user *models.User)
 										func(confirmed3 bool) {
 											if confirmed3 && confirmEntry.Text == "RESET" {
 												// Perform reset
-												err := appState.GetDB().ResetDatabase()
-												if err != nil {
+												db := appState.GetDB().(*database.Database)
+												if err := db.ResetDatabaseAs(user.ID); err != nil {
 													dialog.ShowError(fmt.Errorf("Failed to reset database: %v", err), mainWindow)
 													return
 												}
 
 												// Show success message
-												dialog.ShowInformation("Database Reset", "Database has been reset successfully. You will be logged out.", mainWindow)
+												dialog.ShowInformation("Database Reset", fmt.Sprintf("Database has been reset successfully. A backup of the old data was saved in %s.\n\nYou will be logged out.", db.BackupDir()), mainWindow)
 
 												// Log out and return to login
 												appState.SetUser(nil)
@@ -121,6 +135,24 @@ func ShowMainWindow(myApp fyne.App, appState *auth.AppState, user *models.User)
 		resetBtn.Importance = widget.DangerImportance
 	}
 
+	// Backup and restore buttons. Restoring replaces every user, so like
+	// a reset it is for the root admin alone.
+	var backupBtn, restoreBtn *widget.Button
+	if user.Can(models.PermDBBackup) {
+		backupBtn = widget.NewButton("Backup Database", func() {
+			showBackupDialog(mainWindow, appState)
+		})
+	}
+	if user.IsRootAdmin {
+		restoreBtn = widget.NewButton("Restore Database", func() {
+			showRestoreDialog(mainWindow, appState, func() {
+				appState.SetUser(nil)
+				mainWindow.Close()
+				ShowLoginWindow(appState)
+			})
+		})
+	}
+
 	// User icon and label
 	userIcon := widget.NewIcon(theme.AccountIcon())
 	userText := user.Username
@@ -139,6 +171,12 @@ func ShowMainWindow(myApp fyne.App, appState *auth.AppState, user *models.User)
 	var headerButtons []fyne.CanvasObject
 	headerButtons = append(headerButtons, container.NewPadded(userContainer))
 	headerButtons = append(headerButtons, widget.NewSeparator())
+	if backupBtn != nil {
+		headerButtons = append(headerButtons, backupBtn)
+	}
+	if restoreBtn != nil {
+		headerButtons = append(headerButtons, restoreBtn)
+	}
 	if resetBtn != nil {
 		headerButtons = append(headerButtons, resetBtn)
 		headerButtons = append(headerButtons, widget.NewSeparator())
@@ -148,6 +186,18 @@ func ShowMainWindow(myApp fyne.App, appState *auth.AppState, user *models.User)
 	header := container.NewHBox(headerButtons...)
 	content := container.NewBorder(header, nil, nil, nil, tabs)
 
+	// Tell those who look after backups when a scheduled one fails
+	if db, ok := appState.GetDB().(*database.Database); ok && backupBtn != nil {
+		db.OnBackupError(func(err error) {
+			runOnWindow(mainWindow, func() {
+				dialog.ShowError(err, mainWindow)
+			})
+		})
+		mainWindow.SetOnClosed(func() {
+			db.OnBackupError(nil)
+		})
+	}
+
 	mainWindow.SetContent(content)
 	mainWindow.Show()
 }
@@ -156,13 +206,16 @@ func createInventoryTab(parent fyne.Window, appState *auth.AppState, user *model
 	// Search bar
 	searchEntry := widget.NewEntry()
 	searchEntry.SetPlaceHolder("Search by name or code...")
+	statusSelect := widget.NewSelect([]string{"Active", "Archived"}, nil)
+	statusSelect.SetSelected("Active")
 
-	// Store items list
+	// Store items list, with the stock held by each product's variants
 	var currentItems []models.Item
+	var productStock map[int]int
 	var selectedID widget.ListItemID = -1
 
 	// Low stock threshold - items below this show a warning
-	const lowStockThreshold = 10
+	lowStockThreshold := appState.GetConfig().LowStockThreshold
 
 	// Item list with aligned columns using fixed-width containers
 	list := widget.NewList(
@@ -195,14 +248,18 @@ func createInventoryTab(parent fyne.Window, appState *auth.AppState, user *model
 				codeLabel.SetText(item.Code)
 				codeLabel.Resize(fyne.NewSize(150, codeLabel.MinSize().Height))
 				priceLabel := box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label)
-				priceLabel.SetText(fmt.Sprintf("$%.2f", item.Price))
+				priceLabel.SetText(item.Price.Format())
 				priceLabel.Resize(fyne.NewSize(100, priceLabel.MinSize().Height))
 				qtyLabel := box.Objects[3].(*fyne.Container).Objects[0].(*widget.Label)
-				qtyLabel.SetText(fmt.Sprintf("%d", item.Quantity))
+				if stock, ok := productStock[item.ID]; ok {
+					qtyLabel.SetText(fmt.Sprintf("%d (variants)", stock))
+				} else {
+					qtyLabel.SetText(formatStock(item.Quantity, item.BaseUnit))
+				}
 				qtyLabel.Resize(fyne.NewSize(100, qtyLabel.MinSize().Height))
 				// Show warning for low stock items
 				warningLabel := box.Objects[4].(*fyne.Container).Objects[0].(*widget.Label)
-				if item.Quantity < lowStockThreshold {
+				if _, isProduct := productStock[item.ID]; !isProduct && item.IsLowStock(lowStockThreshold) {
 					warningLabel.SetText("[!] LOW STOCK")
 					warningLabel.TextStyle = fyne.TextStyle{Bold: true}
 				} else {
@@ -217,65 +274,175 @@ func createInventoryTab(parent fyne.Window, appState *auth.AppState, user *model
 	}
 
 	// Refresh function
+	var filters *itemFilterBar
 	refreshList := func() {
-		query := strings.TrimSpace(searchEntry.Text)
-		var items []models.Item
-		var err error
-
 		db := appState.GetDB().(*database.Database)
-		if query == "" {
-			items, err = inventory.GetAllItems(db)
-		} else {
-			items, err = inventory.SearchItems(db, query)
+		items, err := inventory.FilterItems(db, inventory.ItemFilter{
+			Query:      searchEntry.Text,
+			CategoryID: filters.categoryID(),
+			Tag:        filters.tag(),
+			Archived:   statusSelect.Selected == "Archived",
+		})
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
 		}
-
+		stock, err := inventory.GetProductStock(db)
 		if err != nil {
 			dialog.ShowError(err, parent)
 			return
 		}
 
 		currentItems = items
+		productStock = stock
 		list.Refresh()
 		selectedID = -1
 	}
 
+	filters = newItemFilterBar(refreshList)
+	// Tags and categories can change from the item dialogs
+	reloadList := func() {
+		filters.reload(appState.GetDB().(*database.Database))
+		refreshList()
+	}
+
 	searchEntry.OnChanged = func(_ string) {
 		refreshList()
 	}
 
-	// Buttons
+	// Buttons, each shown to those permitted to use it
 	var buttons *fyne.Container
-	if user.IsRootAdmin {
+	canCreate := user.Can(models.PermItemCreate)
+	canEdit := user.Can(models.PermItemEdit)
+	canAdjust := user.Can(models.PermStockAdjust)
+	if canCreate || canEdit || canAdjust {
 		addBtn := widget.NewButton("Add Item", func() {
-			showAddItemDialog(parent, appState, refreshList)
+			showAddItemDialog(parent, appState, user, reloadList)
 		})
 		editBtn := widget.NewButton("Edit Item", func() {
 			if selectedID < 0 || selectedID >= len(currentItems) {
 				dialog.ShowInformation("No Selection", "Please select an item to edit", parent)
 				return
 			}
-			showEditItemDialog(parent, appState, &currentItems[selectedID], refreshList)
+			showEditItemDialog(parent, appState, user, &currentItems[selectedID], reloadList)
 		})
 		restockBtn := widget.NewButton("Restock", func() {
 			if selectedID < 0 || selectedID >= len(currentItems) {
 				dialog.ShowInformation("No Selection", "Please select an item to restock", parent)
 				return
 			}
-			showRestockDialog(parent, appState, &currentItems[selectedID], refreshList)
+			showRestockDialog(parent, appState, user, &currentItems[selectedID], refreshList)
+		})
+		adjustBtn := widget.NewButton("Adjust Stock", func() {
+			if selectedID < 0 || selectedID >= len(currentItems) {
+				dialog.ShowInformation("No Selection", "Please select an item to adjust", parent)
+				return
+			}
+			showAdjustStockDialog(parent, appState, user, &currentItems[selectedID], refreshList)
+		})
+		archiveBtn := widget.NewButton("Archive Item", func() {
+			if selectedID < 0 || selectedID >= len(currentItems) {
+				dialog.ShowInformation("No Selection", "Please select an item to archive or restore", parent)
+				return
+			}
+			showArchiveItemDialog(parent, appState, &currentItems[selectedID], refreshList)
 		})
 		deleteBtn := widget.NewButton("Delete Item", func() {
 			if selectedID < 0 || selectedID >= len(currentItems) {
 				dialog.ShowInformation("No Selection", "Please select an item to delete", parent)
 				return
 			}
-			showDeleteItemDialog(parent, appState, &currentItems[selectedID], refreshList)
+			showDeleteItemDialog(parent, appState, &currentItems[selectedID], reloadList)
 		})
-		buttons = container.NewHBox(addBtn, editBtn, restockBtn, deleteBtn)
+		variantBtn := widget.NewButton("Add Variant", func() {
+			if selectedID < 0 || selectedID >= len(currentItems) {
+				dialog.ShowInformation("No Selection", "Please select a product to add a variant to", parent)
+				return
+			}
+			showAddVariantDialog(parent, appState, user, &currentItems[selectedID], refreshList)
+		})
+		unitsBtn := widget.NewButton("Units", func() {
+			if selectedID < 0 || selectedID >= len(currentItems) {
+				dialog.ShowInformation("No Selection", "Please select an item to set units for", parent)
+				return
+			}
+			showUnitsDialog(parent, appState, &currentItems[selectedID], refreshList)
+		})
+		photosBtn := widget.NewButton("Receive Photos", func() {
+			showPhotoReceivingWindow(parent, appState, user, refreshList)
+		})
+		categoriesBtn := widget.NewButton("Categories", func() {
+			showCategoriesWindow(parent, appState, reloadList)
+		})
+		buttons = container.NewHBox()
+		if canCreate {
+			buttons.Add(addBtn)
+		}
+		if canEdit {
+			buttons.Add(editBtn)
+		}
+		if canCreate {
+			buttons.Add(variantBtn)
+		}
+		if canEdit {
+			buttons.Add(unitsBtn)
+		}
+		if canAdjust {
+			buttons.Add(restockBtn)
+			buttons.Add(photosBtn)
+			buttons.Add(adjustBtn)
+		}
+		if canEdit {
+			buttons.Add(archiveBtn)
+			buttons.Add(deleteBtn)
+			buttons.Add(categoriesBtn)
+		}
+
+		// Archived items can only be restored or deleted
+		statusSelect.OnChanged = func(status string) {
+			if status == "Archived" {
+				archiveBtn.SetText("Restore Item")
+				editBtn.Disable()
+				variantBtn.Disable()
+				unitsBtn.Disable()
+				restockBtn.Disable()
+				adjustBtn.Disable()
+			} else {
+				archiveBtn.SetText("Archive Item")
+				editBtn.Enable()
+				variantBtn.Enable()
+				unitsBtn.Enable()
+				restockBtn.Enable()
+				adjustBtn.Enable()
+			}
+			refreshList()
+		}
 	} else {
 		buttons = container.NewHBox()
+		statusSelect.OnChanged = func(_ string) {
+			refreshList()
+		}
 	}
 
-	refreshBtn := widget.NewButton("Refresh", refreshList)
+	historyBtn := widget.NewButton("Stock History", func() {
+		if selectedID < 0 || selectedID >= len(currentItems) {
+			dialog.ShowInformation("No Selection", "Please select an item to view its stock history", parent)
+			return
+		}
+		showStockHistory(parent, appState, &currentItems[selectedID])
+	})
+	buttons.Add(historyBtn)
+
+	labelsBtn := widget.NewButton("Print Labels", func() {
+		var preselected []int
+		if selectedID >= 0 && selectedID < len(currentItems) {
+			preselected = append(preselected, currentItems[selectedID].ID)
+		}
+		showLabelsWindow(parent, appState, append([]models.Item(nil), currentItems...), preselected...)
+	})
+	buttons.Add(labelsBtn)
+
+	refreshBtn := widget.NewButton("Refresh", reloadList)
 	buttons.Add(refreshBtn)
 
 	// Column headers for inventory with fixed widths
@@ -303,7 +470,7 @@ func createInventoryTab(parent fyne.Window, appState *auth.AppState, user *model
 
 	content := container.NewBorder(
 		container.NewVBox(
-			searchEntry,
+			container.NewBorder(nil, nil, nil, container.NewHBox(filters.widget(), statusSelect), searchEntry),
 			widget.NewSeparator(),
 			headerRow,
 			widget.NewSeparator(),
@@ -314,15 +481,15 @@ func createInventoryTab(parent fyne.Window, appState *auth.AppState, user *model
 		container.NewScroll(list),
 	)
 
-	refreshList()
+	reloadList()
 	return container.NewScroll(content)
 }
 
-func showAddItemDialog(parent fyne.Window, appState *auth.AppState, onSuccess func()) {
+func showAddItemDialog(parent fyne.Window, appState *auth.AppState, user *models.User, onSuccess func()) {
 	nameEntry := widget.NewEntry()
 	nameEntry.SetPlaceHolder("Item Name")
 	codeEntry := widget.NewEntry()
-	codeEntry.SetPlaceHolder("Barcode/Code")
+	codeEntry.SetPlaceHolder("Barcode/Code (blank for an in-store code)")
 	descEntry := widget.NewMultiLineEntry()
 	descEntry.SetPlaceHolder("Description")
 	priceEntry := widget.NewEntry()
@@ -332,6 +499,17 @@ func showAddItemDialog(parent fyne.Window, appState *auth.AppState, onSuccess fu
 	quantityEntry := widget.NewEntry()
 	quantityEntry.SetPlaceHolder("Quantity")
 
+	db := appState.GetDB().(*database.Database)
+	categoryList, err := categories.GetCategories(db)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+	categorySelect := widget.NewSelect(categoryOptions(categoryList, "None"), nil)
+	categorySelect.SetSelectedIndex(0)
+	tagsEntry := widget.NewEntry()
+	tagsEntry.SetPlaceHolder("Comma-separated, e.g. organic, local")
+
 	formContent := container.NewVBox(
 		createStyledFormField("Name", nameEntry),
 		createStyledFormField("Code", codeEntry),
@@ -339,16 +517,18 @@ func showAddItemDialog(parent fyne.Window, appState *auth.AppState, onSuccess fu
 		createStyledFormField("Price", priceEntry),
 		createStyledFormField("Cost", costEntry),
 		createStyledFormField("Quantity", quantityEntry),
+		createStyledFormField("Category", categorySelect),
+		createStyledFormField("Tags", tagsEntry),
 	)
 
 	onAction := func() {
-		price, err := strconv.ParseFloat(priceEntry.Text, 64)
+		price, err := money.Parse(priceEntry.Text)
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("invalid price"), parent)
 			return
 		}
 
-		cost, err := strconv.ParseFloat(costEntry.Text, 64)
+		cost, err := money.Parse(costEntry.Text)
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("invalid cost"), parent)
 			return
@@ -360,51 +540,127 @@ func showAddItemDialog(parent fyne.Window, appState *auth.AppState, onSuccess fu
 			return
 		}
 
-		db := appState.GetDB().(*database.Database)
-		_, err = inventory.CreateItem(db, nameEntry.Text, codeEntry.Text, descEntry.Text, price, cost, quantity)
+		item, err := inventory.CreateItem(db, nameEntry.Text, codeEntry.Text, descEntry.Text, price, cost, quantity, user.ID)
 		if err != nil {
 			dialog.ShowError(err, parent)
 			return
 		}
 
-		showStyledInformation(parent, "Success", "Item added successfully")
+		if err := saveItemGrouping(db, item.ID, selectedCategoryID(categorySelect, categoryList), tagsEntry.Text, user.ID); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
 		onSuccess()
+		dialog.ShowConfirm("Item Added",
+			fmt.Sprintf("%s was added with code %s. Print a label for it?", item.Name, item.Code),
+			func(confirmed bool) {
+				if confirmed {
+					showLabelsWindow(parent, appState, []models.Item{*item}, item.ID)
+				}
+			}, parent)
 	}
 
 	showStyledDialog(parent, "Add Item", formContent, "Add", onAction, nil)
 }
 
-func showEditItemDialog(parent fyne.Window, appState *auth.AppState, item *models.Item, onSuccess func()) {
+func showEditItemDialog(parent fyne.Window, appState *auth.AppState, user *models.User, item *models.Item, onSuccess func()) {
+	// A variant's name comes from its product, so only the variant part is edited
+	nameLabel := "Name"
 	nameEntry := widget.NewEntry()
 	nameEntry.SetText(item.Name)
+	if item.IsVariant() {
+		nameLabel = "Variant"
+		nameEntry.SetText(item.VariantName)
+	}
 	codeEntry := widget.NewEntry()
 	codeEntry.SetText(item.Code)
 	descEntry := widget.NewMultiLineEntry()
 	descEntry.SetText(item.Description)
 	priceEntry := widget.NewEntry()
-	priceEntry.SetText(fmt.Sprintf("%.2f", item.Price))
+	priceEntry.SetText(item.Price.String())
 	costEntry := widget.NewEntry()
-	costEntry.SetText(fmt.Sprintf("%.2f", item.Cost))
+	costEntry.SetText(item.Cost.String())
 	quantityEntry := widget.NewEntry()
 	quantityEntry.SetText(fmt.Sprintf("%d", item.Quantity))
+	// Price and stock changes need permissions of their own
+	if !user.Can(models.PermItemEditPrice) {
+		priceEntry.Disable()
+		costEntry.Disable()
+	}
+	if !user.Can(models.PermStockAdjust) {
+		quantityEntry.Disable()
+	}
+
+	// Reorder settings
+	db := appState.GetDB().(*database.Database)
+	reorderPointEntry := widget.NewEntry()
+	reorderPointEntry.SetPlaceHolder(fmt.Sprintf("Default (%d)", appState.GetConfig().LowStockThreshold))
+	if item.ReorderPoint != nil {
+		reorderPointEntry.SetText(strconv.Itoa(*item.ReorderPoint))
+	}
+	reorderQtyEntry := widget.NewEntry()
+	reorderQtyEntry.SetPlaceHolder("Usual order quantity (optional)")
+	if item.ReorderQuantity > 0 {
+		reorderQtyEntry.SetText(strconv.Itoa(item.ReorderQuantity))
+	}
+
+	suppliers, err := purchasing.GetAllSuppliers(db)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+	supplierOptions := []string{"None"}
+	for _, s := range suppliers {
+		supplierOptions = append(supplierOptions, s.Name)
+	}
+	supplierSelect := widget.NewSelect(supplierOptions, nil)
+	supplierSelect.SetSelectedIndex(0)
+	for i, s := range suppliers {
+		if item.PreferredSupplierID != nil && *item.PreferredSupplierID == s.ID {
+			supplierSelect.SetSelectedIndex(i + 1)
+		}
+	}
+
+	categoryList, err := categories.GetCategories(db)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+	categorySelect := widget.NewSelect(categoryOptions(categoryList, "None"), nil)
+	selectCategory(categorySelect, categoryList, item.CategoryID)
+	tags, err := categories.GetItemTags(db, item.ID)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+	tagsEntry := widget.NewEntry()
+	tagsEntry.SetText(strings.Join(tags, ", "))
+	tagsEntry.SetPlaceHolder("Comma-separated, e.g. organic, local")
 
 	formContent := container.NewVBox(
-		createStyledFormField("Name", nameEntry),
+		createStyledFormField(nameLabel, nameEntry),
 		createStyledFormField("Code", codeEntry),
 		createStyledFormField("Description", descEntry),
 		createStyledFormField("Price", priceEntry),
 		createStyledFormField("Cost", costEntry),
 		createStyledFormField("Quantity", quantityEntry),
+		createStyledFormField("Reorder Point", reorderPointEntry),
+		createStyledFormField("Reorder Qty", reorderQtyEntry),
+		createStyledFormField("Supplier", supplierSelect),
+		createStyledFormField("Category", categorySelect),
+		createStyledFormField("Tags", tagsEntry),
+		createStyledFormField("Barcodes", newBarcodesEditor(parent, db, item, user)),
 	)
 
 	onAction := func() {
-		price, err := strconv.ParseFloat(priceEntry.Text, 64)
+		price, err := money.Parse(priceEntry.Text)
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("invalid price"), parent)
 			return
 		}
 
-		cost, err := strconv.ParseFloat(costEntry.Text, 64)
+		cost, err := money.Parse(costEntry.Text)
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("invalid cost"), parent)
 			return
@@ -415,14 +671,65 @@ func showEditItemDialog(parent fyne.Window, appState *auth.AppState, item *model
 			dialog.ShowError(fmt.Errorf("invalid quantity"), parent)
 			return
 		}
+		// Stock is only set when the field was edited, so sales since the
+		// dialog opened aren't undone
+		var newQuantity *int
+		if quantity != item.Quantity {
+			newQuantity = &quantity
+		}
 
-		db := appState.GetDB().(*database.Database)
-		err = inventory.UpdateItem(db, item.ID, nameEntry.Text, codeEntry.Text, descEntry.Text, price, cost, quantity)
+		var reorderPoint *int
+		if strings.TrimSpace(reorderPointEntry.Text) != "" {
+			point, err := strconv.Atoi(strings.TrimSpace(reorderPointEntry.Text))
+			if err != nil || point < 0 {
+				dialog.ShowError(fmt.Errorf("invalid reorder point"), parent)
+				return
+			}
+			reorderPoint = &point
+		}
+
+		reorderQty := 0
+		if strings.TrimSpace(reorderQtyEntry.Text) != "" {
+			reorderQty, err = strconv.Atoi(strings.TrimSpace(reorderQtyEntry.Text))
+			if err != nil || reorderQty < 0 {
+				dialog.ShowError(fmt.Errorf("invalid reorder quantity"), parent)
+				return
+			}
+		}
+
+		var supplierID *int
+		if i := supplierSelect.SelectedIndex(); i > 0 {
+			supplierID = &suppliers[i-1].ID
+		}
+
+		name := nameEntry.Text
+		if item.IsVariant() {
+			name = item.Name
+		}
+		err = inventory.UpdateItem(db, item.ID, name, codeEntry.Text, descEntry.Text, price, cost, newQuantity, user.ID)
 		if err != nil {
 			dialog.ShowError(err, parent)
 			return
 		}
 
+		if item.IsVariant() && nameEntry.Text != item.VariantName {
+			if err := inventory.RenameVariant(db, item.ID, nameEntry.Text, user.ID); err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+		}
+
+		err = inventory.SetReorderSettings(db, item.ID, reorderPoint, reorderQty, supplierID, user.ID)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		if err := saveItemGrouping(db, item.ID, selectedCategoryID(categorySelect, categoryList), tagsEntry.Text, user.ID); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
 		showStyledInformation(parent, "Success", "Item updated successfully")
 		onSuccess()
 	}
@@ -430,11 +737,57 @@ func showEditItemDialog(parent fyne.Window, appState *auth.AppState, item *model
 	showStyledDialog(parent, "Edit Item", formContent, "Update", onAction, nil)
 }
 
+// saveItemGrouping files an item under a category and replaces its tags
+// with the comma-separated tagsText
+func saveItemGrouping(db *database.Database, itemID int, categoryID *int, tagsText string, userID int) error {
+	if err := categories.SetItemCategory(db, itemID, categoryID, userID); err != nil {
+		return err
+	}
+	return categories.SetItemTags(db, itemID, categories.ParseTags(tagsText), userID)
+}
+
+// showArchiveItemDialog archives an active item or restores an archived one
+func showArchiveItemDialog(parent fyne.Window, appState *auth.AppState, item *models.Item, onSuccess func()) {
+	user := appState.GetCurrentUser()
+	title, message := "Archive Item", fmt.Sprintf("Archive '%s'? It will no longer be sold, scanned or counted, but stays in reports.", item.Name)
+	if item.ArchivedAt != nil {
+		title, message = "Restore Item", fmt.Sprintf("Restore '%s' to the active inventory?", item.Name)
+	}
+
+	dialog.ShowConfirm(title, message, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		db := appState.GetDB().(*database.Database)
+		var err error
+		if item.ArchivedAt != nil {
+			err = inventory.RestoreItem(db, item.ID, user.ID)
+		} else {
+			err = inventory.ArchiveItem(db, item.ID, user.ID)
+		}
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		onSuccess()
+	}, parent)
+}
+
+// showDeleteItemDialog deletes an item that has never had stock, sales,
+// orders or counts. Any other item, including one created with opening
+// stock, is offered for archiving instead.
 func showDeleteItemDialog(parent fyne.Window, appState *auth.AppState, item *models.Item, onSuccess func()) {
-	dialog.ShowConfirm("Delete Item", fmt.Sprintf("Are you sure you want to delete '%s'?", item.Name), func(confirmed bool) {
+	user := appState.GetCurrentUser()
+	message := fmt.Sprintf("Are you sure you want to delete '%s'?\n\n"+
+		"Only items that have never had stock, sales, orders or counts can be deleted. Others are archived instead.", item.Name)
+	dialog.ShowConfirm("Delete Item", message, func(confirmed bool) {
 		if confirmed {
 			db := appState.GetDB().(*database.Database)
-			err := inventory.DeleteItem(db, item.ID)
+			err := inventory.DeleteItem(db, item.ID, user.ID)
+			if errors.Is(err, inventory.ErrItemHasHistory) {
+				showArchiveItemDialog(parent, appState, item, onSuccess)
+				return
+			}
 			if err != nil {
 				dialog.ShowError(err, parent)
 				return
@@ -0,0 +1,95 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+
+	"ims-go/auth"
+	"ims-go/database"
+)
+
+// showBackupDialog asks where to save a copy of the database and writes it
+// there
+func showBackupDialog(parent fyne.Window, appState *auth.AppState) {
+	db := appState.GetDB().(*database.Database)
+
+	saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		if writer == nil {
+			return // cancelled
+		}
+
+		// The backup is written by SQLite itself, so only the chosen path is needed
+		path := writer.URI().Path()
+		writer.Close()
+		os.Remove(path)
+
+		if err := db.BackupAs(context.Background(), path, appState.GetCurrentUser().ID); err != nil {
+			dialog.ShowError(fmt.Errorf("Failed to back up database: %v", err), parent)
+			return
+		}
+		dialog.ShowInformation("Backup Complete", fmt.Sprintf("Database backed up to %s", path), parent)
+	}, parent)
+
+	saveDialog.SetFileName(fmt.Sprintf("ims-backup-%s.db", time.Now().Format("20060102-150405")))
+	saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{".db"}))
+	saveDialog.Show()
+}
+
+// showRestoreDialog lets the root admin pick a backup and replaces the database
+// with it after confirmation. onRestored is called once the swap succeeds,
+// since the logged-in user may no longer exist in the restored data.
+func showRestoreDialog(parent fyne.Window, appState *auth.AppState, onRestored func()) {
+	db := appState.GetDB().(*database.Database)
+
+	openDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		if reader == nil {
+			return // cancelled
+		}
+		path := reader.URI().Path()
+		reader.Close()
+
+		dialog.ShowConfirm(
+			"Restore Database",
+			fmt.Sprintf("WARNING: This will replace ALL current data with the contents of %s.\n\nThe current data will be backed up to %s first.\n\nAre you sure you want to restore?", filepath.Base(path), db.BackupDir()),
+			func(confirmed bool) {
+				if !confirmed {
+					return
+				}
+
+				if err := db.RestoreAs(path, appState.GetCurrentUser().ID); err != nil {
+					dialog.ShowError(fmt.Errorf("Failed to restore database: %v", err), parent)
+					return
+				}
+
+				dialog.ShowInformation("Database Restored", "Database has been restored successfully. You will be logged out.", parent)
+				onRestored()
+			},
+			parent,
+		)
+	}, parent)
+
+	openDialog.SetFilter(storage.NewExtensionFileFilter([]string{".db"}))
+
+	// Start in the automatic backup folder if it exists
+	if _, err := os.Stat(db.BackupDir()); err == nil {
+		if lister, err := storage.ListerForURI(storage.NewFileURI(db.BackupDir())); err == nil {
+			openDialog.SetLocation(lister)
+		}
+	}
+	openDialog.Show()
+}
@@ -0,0 +1,90 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/database"
+	"ims-go/inventory"
+	"ims-go/models"
+)
+
+// newBarcodesEditor lists an item's extra barcodes with controls to add and
+// remove them. Changes are saved straight away rather than with the rest of
+// the item.
+func newBarcodesEditor(parent fyne.Window, db *database.Database, item *models.Item, user *models.User) fyne.CanvasObject {
+	rows := container.NewVBox()
+
+	var reload func()
+	reload = func() {
+		barcodes, err := inventory.GetBarcodes(db, item.ID)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		rows.RemoveAll()
+		if len(barcodes) == 0 {
+			rows.Add(widget.NewLabel("No extra barcodes"))
+		}
+		for _, barcode := range barcodes {
+			text := barcode.Code
+			if barcode.Description != "" {
+				text += " - " + barcode.Description
+			}
+			if barcode.Quantity != nil {
+				text += fmt.Sprintf(" (x%s)", formatStock(*barcode.Quantity, item.BaseUnit))
+			}
+			id := barcode.ID
+			removeBtn := widget.NewButton("Remove", func() {
+				if err := inventory.RemoveBarcode(db, id, user.ID); err != nil {
+					dialog.ShowError(err, parent)
+					return
+				}
+				reload()
+			})
+			rows.Add(container.NewBorder(nil, nil, nil, removeBtn, widget.NewLabel(text)))
+		}
+	}
+	reload()
+
+	codeEntry := widget.NewEntry()
+	codeEntry.SetPlaceHolder("Barcode")
+	descEntry := widget.NewEntry()
+	descEntry.SetPlaceHolder("e.g. Case, Supplier code")
+	quantityEntry := widget.NewEntry()
+	quantityEntry.SetPlaceHolder(fmt.Sprintf("Qty in %s (optional)", item.BaseUnit))
+
+	addBtn := widget.NewButton("Add Barcode", func() {
+		var quantity *int
+		if text := strings.TrimSpace(quantityEntry.Text); text != "" {
+			q, err := strconv.Atoi(text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("invalid quantity"), parent)
+				return
+			}
+			quantity = &q
+		}
+
+		if _, err := inventory.AddBarcode(db, item.ID, codeEntry.Text, descEntry.Text, quantity, user.ID); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		codeEntry.SetText("")
+		descEntry.SetText("")
+		quantityEntry.SetText("")
+		reload()
+	})
+
+	return container.NewVBox(
+		rows,
+		container.NewGridWithColumns(3, codeEntry, descEntry, quantityEntry),
+		addBtn,
+	)
+}
@@ -0,0 +1,315 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/categories"
+	"ims-go/database"
+	"ims-go/models"
+)
+
+// categoryOptions lists category paths for a select, led by noneLabel
+func categoryOptions(list []models.Category, noneLabel string) []string {
+	options := []string{noneLabel}
+	for _, c := range list {
+		options = append(options, c.Path)
+	}
+	return options
+}
+
+// selectedCategoryID returns the category picked in a select built by
+// categoryOptions, or nil for the leading option
+func selectedCategoryID(sel *widget.Select, list []models.Category) *int {
+	if i := sel.SelectedIndex(); i > 0 && i <= len(list) {
+		return &list[i-1].ID
+	}
+	return nil
+}
+
+// selectCategory picks categoryID in a select built by categoryOptions
+func selectCategory(sel *widget.Select, list []models.Category, categoryID *int) {
+	sel.SetSelectedIndex(0)
+	if categoryID == nil {
+		return
+	}
+	for i, c := range list {
+		if c.ID == *categoryID {
+			sel.SetSelectedIndex(i + 1)
+		}
+	}
+}
+
+// itemFilterBar holds the category and tag selects used to narrow item lists
+type itemFilterBar struct {
+	categorySelect *widget.Select
+	tagSelect      *widget.Select
+	categories     []models.Category
+}
+
+// newItemFilterBar builds category and tag selects that call onChanged when
+// either changes
+func newItemFilterBar(onChanged func()) *itemFilterBar {
+	bar := &itemFilterBar{
+		categorySelect: widget.NewSelect([]string{"All categories"}, nil),
+		tagSelect:      widget.NewSelect([]string{"All tags"}, nil),
+	}
+	bar.categorySelect.SetSelectedIndex(0)
+	bar.tagSelect.SetSelectedIndex(0)
+	bar.categorySelect.OnChanged = func(_ string) { onChanged() }
+	bar.tagSelect.OnChanged = func(_ string) { onChanged() }
+	return bar
+}
+
+// reload refreshes the options, keeping the current choices where they
+// still exist
+func (b *itemFilterBar) reload(db *database.Database) {
+	list, err := categories.GetCategories(db)
+	if err != nil {
+		return
+	}
+	tags, err := categories.GetAllTags(db)
+	if err != nil {
+		return
+	}
+
+	category, tag := selectedCategoryID(b.categorySelect, b.categories), b.tagSelect.Selected
+	b.categories = list
+	b.categorySelect.Options = categoryOptions(list, "All categories")
+	b.tagSelect.Options = append([]string{"All tags"}, tags...)
+
+	// Quietly restore the choices so onChanged isn't fired mid-reload
+	onCategory, onTag := b.categorySelect.OnChanged, b.tagSelect.OnChanged
+	b.categorySelect.OnChanged, b.tagSelect.OnChanged = nil, nil
+	selectCategory(b.categorySelect, list, category)
+	b.tagSelect.SetSelectedIndex(0)
+	for i, t := range b.tagSelect.Options {
+		if i > 0 && t == tag {
+			b.tagSelect.SetSelectedIndex(i)
+		}
+	}
+	b.categorySelect.OnChanged, b.tagSelect.OnChanged = onCategory, onTag
+}
+
+// categoryID returns the chosen category, or nil for all categories
+func (b *itemFilterBar) categoryID() *int {
+	return selectedCategoryID(b.categorySelect, b.categories)
+}
+
+// tag returns the chosen tag, or "" for all tags
+func (b *itemFilterBar) tag() string {
+	if b.tagSelect.SelectedIndex() <= 0 {
+		return ""
+	}
+	return b.tagSelect.Selected
+}
+
+func (b *itemFilterBar) widget() fyne.CanvasObject {
+	return container.NewHBox(b.categorySelect, b.tagSelect)
+}
+
+// showCategoriesWindow manages the category tree and category-wide changes
+func showCategoriesWindow(parent fyne.Window, appState *auth.AppState, onChanged func()) {
+	user := appState.GetCurrentUser()
+	window := fyne.CurrentApp().NewWindow("Categories")
+	window.Resize(fyne.NewSize(600, 500))
+	window.CenterOnScreen()
+
+	db := appState.GetDB().(*database.Database)
+	var list []models.Category
+	var selectedID widget.ListItemID = -1
+
+	categoryList := widget.NewList(
+		func() int {
+			return len(list)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(widget.NewLabel(""), widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(list) {
+				category := list[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*widget.Label).SetText(category.Path)
+				taxLabel := ""
+				if category.TaxClass != "" {
+					taxLabel = fmt.Sprintf("Tax: %s", category.TaxClass)
+				}
+				box.Objects[1].(*widget.Label).SetText(taxLabel)
+			}
+		},
+	)
+	categoryList.OnSelected = func(id widget.ListItemID) {
+		selectedID = id
+	}
+
+	refresh := func() {
+		categoriesList, err := categories.GetCategories(db)
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		list = categoriesList
+		selectedID = -1
+		categoryList.UnselectAll()
+		categoryList.Refresh()
+		onChanged()
+	}
+
+	selected := func(action string) *models.Category {
+		if selectedID < 0 || selectedID >= len(list) {
+			dialog.ShowInformation("No Selection", "Please select a category to "+action, window)
+			return nil
+		}
+		return &list[selectedID]
+	}
+
+	addBtn := widget.NewButton("Add", func() {
+		nameEntry := widget.NewEntry()
+		nameEntry.SetPlaceHolder("Category Name")
+		parentSelect := widget.NewSelect(categoryOptions(list, "None (top level)"), nil)
+		parentSelect.SetSelectedIndex(0)
+		if selectedID >= 0 && selectedID < len(list) {
+			parentSelect.SetSelectedIndex(selectedID + 1)
+		}
+
+		formContent := container.NewVBox(
+			createStyledFormField("Name", nameEntry),
+			createStyledFormField("Parent", parentSelect),
+		)
+		showStyledDialog(window, "Add Category", formContent, "Add", func() {
+			if _, err := categories.CreateCategory(db, nameEntry.Text, selectedCategoryID(parentSelect, list), user.ID); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refresh()
+		}, nil)
+	})
+
+	renameBtn := widget.NewButton("Rename", func() {
+		category := selected("rename")
+		if category == nil {
+			return
+		}
+		nameEntry := widget.NewEntry()
+		nameEntry.SetText(category.Name)
+		showStyledDialog(window, "Rename Category", createStyledFormField("Name", nameEntry), "Rename", func() {
+			if err := categories.RenameCategory(db, category.ID, nameEntry.Text, user.ID); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refresh()
+		}, nil)
+	})
+
+	moveBtn := widget.NewButton("Move", func() {
+		category := selected("move")
+		if category == nil {
+			return
+		}
+		parentSelect := widget.NewSelect(categoryOptions(list, "None (top level)"), nil)
+		selectCategory(parentSelect, list, category.ParentID)
+		showStyledDialog(window, "Move Category", createStyledFormField("Parent", parentSelect), "Move", func() {
+			if err := categories.MoveCategory(db, category.ID, selectedCategoryID(parentSelect, list), user.ID); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refresh()
+		}, nil)
+	})
+
+	taxBtn := widget.NewButton("Tax Class", func() {
+		category := selected("assign a tax class to")
+		if category == nil {
+			return
+		}
+		taxEntry := widget.NewEntry()
+		taxEntry.SetText(category.TaxClass)
+		taxEntry.SetPlaceHolder("Blank to inherit from the parent")
+		showStyledDialog(window, "Tax Class", createStyledFormField("Tax Class", taxEntry), "Save", func() {
+			if err := categories.SetTaxClass(db, category.ID, taxEntry.Text, user.ID); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refresh()
+		}, nil)
+	})
+
+	priceBtn := widget.NewButton("Adjust Prices", func() {
+		category := selected("adjust prices for")
+		if category == nil {
+			return
+		}
+		percentEntry := widget.NewEntry()
+		percentEntry.SetPlaceHolder("e.g. 5 or -10")
+		formContent := container.NewVBox(
+			widget.NewLabel(fmt.Sprintf("Change the price of every item in %s and its subcategories.", category.Path)),
+			createStyledFormField("Change (%)", percentEntry),
+		)
+		showStyledDialog(window, "Adjust Prices", formContent, "Apply", func() {
+			basisPoints, err := parsePercent(percentEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			changed, err := categories.AdjustPrices(db, category.ID, basisPoints, user.ID)
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			showStyledInformation(window, "Prices Updated", fmt.Sprintf("Updated the price of %d items", changed))
+			onChanged()
+		}, nil)
+	})
+
+	deleteBtn := widget.NewButton("Delete", func() {
+		category := selected("delete")
+		if category == nil {
+			return
+		}
+		message := fmt.Sprintf("Delete '%s'? Its items will become uncategorised.", category.Path)
+		dialog.ShowConfirm("Delete Category", message, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			if err := categories.DeleteCategory(db, category.ID, user.ID); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refresh()
+		}, window)
+	})
+
+	closeBtn := widget.NewButton("Close", window.Close)
+
+	content := container.NewBorder(
+		nil,
+		container.NewHBox(addBtn, renameBtn, moveBtn, taxBtn, priceBtn, deleteBtn, closeBtn),
+		nil,
+		nil,
+		container.NewScroll(categoryList),
+	)
+	window.SetContent(content)
+
+	refresh()
+	window.Show()
+}
+
+// parsePercent turns a percentage such as "2.5" into basis points
+func parsePercent(text string) (int, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "%")), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage")
+	}
+	if percent < 0 {
+		return int(percent*100 - 0.5), nil
+	}
+	return int(percent*100 + 0.5), nil
+}
@@ -0,0 +1,244 @@
+package gui
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/barcode"
+	"ims-go/database"
+	"ims-go/inventory"
+	"ims-go/models"
+)
+
+// showLabelsWindow exports a sheet of labels for the ticked items as a PDF or
+// PNG. preselected items start ticked.
+func showLabelsWindow(parent fyne.Window, appState *auth.AppState, items []models.Item, preselected ...int) {
+	user := appState.GetCurrentUser()
+	db := appState.GetDB().(*database.Database)
+
+	labelsWindow := fyne.CurrentApp().NewWindow("Print Labels")
+	labelsWindow.Resize(fyne.NewSize(600, 500))
+	labelsWindow.CenterOnScreen()
+
+	selected := make(map[int]bool)
+	for _, id := range preselected {
+		selected[id] = true
+	}
+
+	list := widget.NewList(
+		func() int {
+			return len(items)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(widget.NewCheck("", nil), widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(items) {
+				item := items[id]
+				box := obj.(*fyne.Container)
+				check := box.Objects[0].(*widget.Check)
+				check.OnChanged = nil
+				check.SetChecked(selected[item.ID])
+				check.OnChanged = func(on bool) {
+					selected[item.ID] = on
+				}
+				code := item.Code
+				if strings.TrimSpace(code) == "" {
+					code = "no code"
+				}
+				box.Objects[1].(*widget.Label).SetText(fmt.Sprintf("%s (%s) %s", item.Name, code, item.Price.Format()))
+			}
+		},
+	)
+
+	selectAll := func(on bool) {
+		for _, item := range items {
+			selected[item.ID] = on
+		}
+		list.Refresh()
+	}
+
+	var templateNames []string
+	for _, t := range barcode.Templates {
+		templateNames = append(templateNames, t.Name)
+	}
+	templateSelect := widget.NewSelect(templateNames, nil)
+	templateSelect.SetSelectedIndex(0)
+	var paperNames []string
+	for _, p := range barcode.Papers {
+		paperNames = append(paperNames, p.Name)
+	}
+	paperSelect := widget.NewSelect(paperNames, nil)
+	paperSelect.SetSelectedIndex(0)
+	formatSelect := widget.NewSelect([]string{"PDF", "PNG"}, nil)
+	formatSelect.SetSelected("PDF")
+	copiesEntry := widget.NewEntry()
+	copiesEntry.SetText("1")
+
+	exportBtn := widget.NewButton("Export", func() {
+		copies, err := strconv.Atoi(strings.TrimSpace(copiesEntry.Text))
+		if err != nil || copies <= 0 {
+			dialog.ShowError(fmt.Errorf("invalid number of copies"), labelsWindow)
+			return
+		}
+
+		var chosen []models.Item
+		missing := 0
+		for _, item := range items {
+			if selected[item.ID] {
+				chosen = append(chosen, item)
+				if strings.TrimSpace(item.Code) == "" {
+					missing++
+				}
+			}
+		}
+		if len(chosen) == 0 {
+			dialog.ShowInformation("No Items", "Tick the items to print labels for", labelsWindow)
+			return
+		}
+		if missing > 0 {
+			dialog.ShowConfirm("Missing Codes",
+				fmt.Sprintf("%d of the items have no code. Assign in-store codes to every item without one?", missing),
+				func(confirmed bool) {
+					if !confirmed {
+						return
+					}
+					if _, err := inventory.AssignMissingCodes(db, user.ID); err != nil {
+						dialog.ShowError(err, labelsWindow)
+						return
+					}
+					// Pick up the new codes
+					for i := range items {
+						if item, err := inventory.GetItemByID(db, items[i].ID); err == nil {
+							items[i] = *item
+						}
+					}
+					list.Refresh()
+				}, labelsWindow)
+			return
+		}
+
+		tmpl, err := barcode.TemplateByName(templateSelect.Selected)
+		if err != nil {
+			dialog.ShowError(err, labelsWindow)
+			return
+		}
+		paper := barcode.Papers[paperSelect.SelectedIndex()]
+
+		var labels []barcode.Label
+		for _, item := range chosen {
+			label := barcode.Label{Name: item.Name, Price: item.Price, Code: item.Code, Expiry: item.ExpiryDate}
+			// The earliest expiry still in stock goes on the label
+			if batches, err := inventory.GetItemStockBatches(db, item.ID); err == nil {
+				for _, batch := range batches {
+					if batch.ExpiryDate != nil && (label.Expiry == nil || batch.ExpiryDate.Before(*label.Expiry)) {
+						label.Expiry = batch.ExpiryDate
+					}
+				}
+			}
+			for i := 0; i < copies; i++ {
+				labels = append(labels, label)
+			}
+		}
+
+		sheets, err := barcode.RenderSheets(labels, tmpl, paper)
+		if err != nil {
+			dialog.ShowError(err, labelsWindow)
+			return
+		}
+
+		asPNG := formatSelect.Selected == "PNG"
+		saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, labelsWindow)
+				return
+			}
+			if writer == nil {
+				return // cancelled
+			}
+			defer writer.Close()
+
+			path := writer.URI().Path()
+			if !asPNG {
+				if err := barcode.WritePDF(writer, sheets, paper); err != nil {
+					dialog.ShowError(fmt.Errorf("Failed to export labels: %v", err), labelsWindow)
+					return
+				}
+				showStyledInformation(parent, "Export Complete", fmt.Sprintf("%d labels saved to %s", len(labels), path))
+				return
+			}
+
+			// A PNG holds one sheet, so later sheets go in numbered files alongside
+			if err := png.Encode(writer, sheets[0]); err != nil {
+				dialog.ShowError(fmt.Errorf("Failed to export labels: %v", err), labelsWindow)
+				return
+			}
+			base := strings.TrimSuffix(path, filepath.Ext(path))
+			for i, sheet := range sheets[1:] {
+				if err := writePNGFile(fmt.Sprintf("%s-%d.png", base, i+2), sheet); err != nil {
+					dialog.ShowError(fmt.Errorf("Failed to export labels: %v", err), labelsWindow)
+					return
+				}
+			}
+			showStyledInformation(parent, "Export Complete", fmt.Sprintf("%d labels on %d sheets saved to %s", len(labels), len(sheets), path))
+		}, labelsWindow)
+
+		extension := ".pdf"
+		if asPNG {
+			extension = ".png"
+		}
+		saveDialog.SetFileName("labels" + extension)
+		saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{extension}))
+		saveDialog.Show()
+	})
+
+	closeBtn := widget.NewButton("Close", func() {
+		labelsWindow.Close()
+	})
+
+	options := container.NewVBox(
+		createStyledFormField("Template", templateSelect),
+		createStyledFormField("Paper", paperSelect),
+		createStyledFormField("Format", formatSelect),
+		createStyledFormField("Copies", copiesEntry),
+	)
+
+	content := container.NewBorder(
+		container.NewHBox(
+			widget.NewButton("Select All", func() { selectAll(true) }),
+			widget.NewButton("Clear", func() { selectAll(false) }),
+		),
+		container.NewVBox(options, container.NewHBox(exportBtn, closeBtn)),
+		nil,
+		nil,
+		container.NewScroll(list),
+	)
+
+	labelsWindow.SetContent(content)
+	labelsWindow.Show()
+}
+
+// writePNGFile saves one label sheet as a PNG at path
+func writePNGFile(path string, sheet *image.Gray) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, sheet); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
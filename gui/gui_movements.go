@@ -0,0 +1,170 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/database"
+	"ims-go/inventory"
+	"ims-go/models"
+)
+
+// movementReasonLabel turns a stock movement reason into display text
+func movementReasonLabel(reason string) string {
+	switch reason {
+	case models.MovementSale:
+		return "Sale"
+	case models.MovementRestock:
+		return "Restock"
+	case models.MovementAdjustment:
+		return "Adjustment"
+	case models.MovementDamage:
+		return "Damage"
+	case models.MovementTheft:
+		return "Theft"
+	case models.MovementReturn:
+		return "Refund"
+	case models.MovementVoid:
+		return "Void"
+	case models.MovementCount:
+		return "Stock Count"
+	default:
+		return reason
+	}
+}
+
+// movementReferenceLabel names the record a stock movement refers to, which
+// depends on why the stock moved
+func movementReferenceLabel(reason string, id int) string {
+	switch reason {
+	case models.MovementSale, models.MovementVoid:
+		return fmt.Sprintf("Sale #%d", id)
+	case models.MovementReturn:
+		return fmt.Sprintf("Refund #%d", id)
+	case models.MovementRestock:
+		return fmt.Sprintf("Order #%d", id)
+	case models.MovementCount:
+		return fmt.Sprintf("Stocktake #%d", id)
+	default:
+		return fmt.Sprintf("Ref #%d", id)
+	}
+}
+
+// showStockHistory opens a window listing every stock movement for an item,
+// newest first
+func showStockHistory(parent fyne.Window, appState *auth.AppState, item *models.Item) {
+	db := appState.GetDB().(*database.Database)
+	movements, err := inventory.GetItemMovements(db, item.ID)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+
+	list := widget.NewList(
+		func() int {
+			return len(movements)
+		},
+		func() fyne.CanvasObject {
+			dateLabel := widget.NewLabel("")
+			dateLabel.Resize(fyne.NewSize(150, dateLabel.MinSize().Height))
+			reasonLabel := widget.NewLabel("")
+			reasonLabel.Resize(fyne.NewSize(110, reasonLabel.MinSize().Height))
+			deltaLabel := widget.NewLabel("")
+			deltaLabel.TextStyle = fyne.TextStyle{Bold: true}
+			deltaLabel.Resize(fyne.NewSize(70, deltaLabel.MinSize().Height))
+			return container.NewHBox(
+				container.NewBorder(nil, nil, nil, nil, dateLabel),
+				container.NewBorder(nil, nil, nil, nil, reasonLabel),
+				container.NewBorder(nil, nil, nil, nil, deltaLabel),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(movements) {
+				m := movements[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*fyne.Container).Objects[0].(*widget.Label).SetText(m.CreatedAt.Format("2006-01-02 15:04"))
+				box.Objects[1].(*fyne.Container).Objects[0].(*widget.Label).SetText(movementReasonLabel(m.Reason))
+				box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label).SetText(fmt.Sprintf("%+d", m.Delta))
+
+				details := ""
+				if m.BatchID != nil {
+					details = fmt.Sprintf("Batch #%d", *m.BatchID)
+				}
+				if m.ReferenceID != nil {
+					details += "  " + movementReferenceLabel(m.Reason, *m.ReferenceID)
+				}
+				if m.Username != "" {
+					details += "  by " + m.Username
+				}
+				box.Objects[3].(*widget.Label).SetText(details)
+			}
+		},
+	)
+
+	historyWindow := fyne.CurrentApp().NewWindow(fmt.Sprintf("Stock History - %s", item.Name))
+	historyWindow.Resize(fyne.NewSize(700, 500))
+	historyWindow.CenterOnScreen()
+
+	summary := widget.NewLabel(fmt.Sprintf("%s (%s): %d in stock, %d movements", item.Name, item.Code, item.Quantity, len(movements)))
+	summary.TextStyle = fyne.TextStyle{Bold: true}
+
+	closeBtn := widget.NewButton("Close", func() {
+		historyWindow.Close()
+	})
+
+	historyWindow.SetContent(container.NewPadded(container.NewBorder(
+		container.NewVBox(summary, widget.NewSeparator()),
+		container.NewPadded(closeBtn),
+		nil,
+		nil,
+		list,
+	)))
+	historyWindow.Show()
+}
+
+// showAdjustStockDialog records a manual stock correction such as damaged or
+// stolen goods
+func showAdjustStockDialog(parent fyne.Window, appState *auth.AppState, user *models.User, item *models.Item, onSuccess func()) {
+	reasons := map[string]string{
+		"Damage":     models.MovementDamage,
+		"Theft":      models.MovementTheft,
+		"Adjustment": models.MovementAdjustment,
+	}
+	reasonSelect := widget.NewSelect([]string{"Damage", "Theft", "Adjustment"}, nil)
+	reasonSelect.SetSelected("Damage")
+
+	changeEntry := widget.NewEntry()
+	changeEntry.SetPlaceHolder("e.g. -3 to remove, 2 to add")
+
+	formContent := container.NewVBox(
+		createStyledFormField("Current Quantity", widget.NewLabel(fmt.Sprintf("%d", item.Quantity))),
+		createStyledFormField("Reason", reasonSelect),
+		createStyledFormField("Change", changeEntry),
+	)
+
+	onAction := func() {
+		delta, err := strconv.Atoi(changeEntry.Text)
+		if err != nil || delta == 0 {
+			dialog.ShowError(fmt.Errorf("invalid change"), parent)
+			return
+		}
+
+		db := appState.GetDB().(*database.Database)
+		if err := inventory.AdjustStock(db, item.ID, nil, delta, reasons[reasonSelect.Selected], user.ID); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		showStyledInformation(parent, "Success", fmt.Sprintf("Stock adjusted. New quantity: %d", item.Quantity+delta))
+		onSuccess()
+	}
+
+	showStyledDialog(parent, "Adjust Stock", formContent, "Adjust", onAction, nil)
+}
@@ -0,0 +1,600 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/database"
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+	"ims-go/purchasing"
+)
+
+// purchaseOrderStatusLabels are the status filter choices, in display order
+var purchaseOrderStatusLabels = []struct{ label, status string }{
+	{"All", ""},
+	{"Draft", models.PurchaseOrderDraft},
+	{"Ordered", models.PurchaseOrderOrdered},
+	{"Partially Received", models.PurchaseOrderPartial},
+	{"Received", models.PurchaseOrderReceived},
+	{"Cancelled", models.PurchaseOrderCancelled},
+}
+
+func purchaseOrderStatusLabel(status string) string {
+	for _, s := range purchaseOrderStatusLabels {
+		if s.status == status {
+			return s.label
+		}
+	}
+	return status
+}
+
+func createPurchasingTab(parent fyne.Window, appState *auth.AppState, user *models.User) fyne.CanvasObject {
+	return container.NewAppTabs(
+		container.NewTabItem("Purchase Orders", createPurchaseOrdersView(parent, appState, user)),
+		container.NewTabItem("Suppliers", createSuppliersView(parent, appState)),
+		container.NewTabItem("Reorder Suggestions", createReorderView(parent, appState, user)),
+	)
+}
+
+func createPurchaseOrdersView(parent fyne.Window, appState *auth.AppState, user *models.User) fyne.CanvasObject {
+	var orders []models.PurchaseOrder
+	var selectedID widget.ListItemID = -1
+
+	var statusOptions []string
+	for _, s := range purchaseOrderStatusLabels {
+		statusOptions = append(statusOptions, s.label)
+	}
+	statusSelect := widget.NewSelect(statusOptions, nil)
+
+	list := widget.NewList(
+		func() int {
+			return len(orders)
+		},
+		func() fyne.CanvasObject {
+			idLabel := widget.NewLabel("")
+			idLabel.Resize(fyne.NewSize(80, idLabel.MinSize().Height))
+			supplierLabel := widget.NewLabel("")
+			supplierLabel.Resize(fyne.NewSize(200, supplierLabel.MinSize().Height))
+			statusLabel := widget.NewLabel("")
+			statusLabel.Resize(fyne.NewSize(150, statusLabel.MinSize().Height))
+			totalLabel := widget.NewLabel("")
+			totalLabel.Resize(fyne.NewSize(100, totalLabel.MinSize().Height))
+			return container.NewHBox(
+				container.NewBorder(nil, nil, nil, nil, idLabel),
+				container.NewBorder(nil, nil, nil, nil, supplierLabel),
+				container.NewBorder(nil, nil, nil, nil, statusLabel),
+				container.NewBorder(nil, nil, nil, nil, totalLabel),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(orders) {
+				order := orders[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*fyne.Container).Objects[0].(*widget.Label).SetText(fmt.Sprintf("PO #%d", order.ID))
+				box.Objects[1].(*fyne.Container).Objects[0].(*widget.Label).SetText(order.SupplierName)
+				box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label).SetText(purchaseOrderStatusLabel(order.Status))
+				box.Objects[3].(*fyne.Container).Objects[0].(*widget.Label).SetText(order.Total().Format())
+				box.Objects[4].(*widget.Label).SetText(order.CreatedAt.Format("2006-01-02 15:04"))
+			}
+		},
+	)
+
+	list.OnSelected = func(id widget.ListItemID) {
+		selectedID = id
+	}
+
+	refreshList := func() {
+		status := purchaseOrderStatusLabels[0].status
+		for _, s := range purchaseOrderStatusLabels {
+			if s.label == statusSelect.Selected {
+				status = s.status
+			}
+		}
+
+		db := appState.GetDB().(*database.Database)
+		allOrders, err := purchasing.GetPurchaseOrders(db, status)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		orders = allOrders
+		list.UnselectAll()
+		list.Refresh()
+		selectedID = -1
+	}
+
+	statusSelect.OnChanged = func(_ string) {
+		refreshList()
+	}
+
+	selectedOrder := func(action string) *models.PurchaseOrder {
+		if selectedID < 0 || selectedID >= len(orders) {
+			dialog.ShowInformation("No Selection", fmt.Sprintf("Please select a purchase order to %s", action), parent)
+			return nil
+		}
+		return &orders[selectedID]
+	}
+
+	newBtn := widget.NewButton("New Order", func() {
+		showCreatePurchaseOrderDialog(parent, appState, user, refreshList)
+	})
+	viewBtn := widget.NewButton("View Order", func() {
+		if order := selectedOrder("view"); order != nil {
+			showPurchaseOrderDetails(parent, order)
+		}
+	})
+	orderedBtn := widget.NewButton("Mark Ordered", func() {
+		order := selectedOrder("mark as ordered")
+		if order == nil {
+			return
+		}
+		db := appState.GetDB().(*database.Database)
+		if err := purchasing.MarkOrdered(db, order.ID, user.ID); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		refreshList()
+	})
+	receiveBtn := widget.NewButton("Receive", func() {
+		if order := selectedOrder("receive"); order != nil {
+			showReceivePurchaseOrderDialog(parent, appState, user, order, refreshList)
+		}
+	})
+	cancelBtn := widget.NewButton("Cancel Order", func() {
+		order := selectedOrder("cancel")
+		if order == nil {
+			return
+		}
+		dialog.ShowConfirm("Cancel Purchase Order", fmt.Sprintf("Are you sure you want to cancel PO #%d?", order.ID), func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			db := appState.GetDB().(*database.Database)
+			if err := purchasing.CancelPurchaseOrder(db, order.ID, user.ID); err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			refreshList()
+		}, parent)
+	})
+	refreshBtn := widget.NewButton("Refresh", refreshList)
+
+	buttons := container.NewHBox(newBtn, viewBtn, orderedBtn, receiveBtn, cancelBtn, refreshBtn)
+
+	content := container.NewBorder(
+		container.NewVBox(
+			createStyledFormField("Status", statusSelect),
+			widget.NewSeparator(),
+		),
+		buttons,
+		nil,
+		nil,
+		list,
+	)
+
+	statusSelect.SetSelected(purchaseOrderStatusLabels[0].label)
+	return content
+}
+
+func showCreatePurchaseOrderDialog(parent fyne.Window, appState *auth.AppState, user *models.User, onSuccess func()) {
+	db := appState.GetDB().(*database.Database)
+	suppliers, err := purchasing.GetAllSuppliers(db)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+	if len(suppliers) == 0 {
+		dialog.ShowInformation("No Suppliers", "Add a supplier before creating a purchase order", parent)
+		return
+	}
+
+	var supplierNames []string
+	for _, s := range suppliers {
+		supplierNames = append(supplierNames, s.Name)
+	}
+	supplierSelect := widget.NewSelect(supplierNames, nil)
+	notesEntry := widget.NewEntry()
+	notesEntry.SetPlaceHolder("Notes (optional)")
+
+	var lines []models.PurchaseOrderLine
+	linesLabel := widget.NewLabel("No lines yet")
+	totalLabel := widget.NewLabel("Total: " + money.Money(0).Format())
+	totalLabel.TextStyle = fyne.TextStyle{Bold: true}
+
+	refreshLines := func() {
+		var text []string
+		var total money.Money
+		for _, line := range lines {
+			text = append(text, fmt.Sprintf("%s x%d @ %s", line.ItemName, line.QuantityOrdered, line.UnitCost.Format()))
+			total += line.UnitCost.Mul(line.QuantityOrdered)
+		}
+		if len(text) == 0 {
+			linesLabel.SetText("No lines yet")
+		} else {
+			linesLabel.SetText(strings.Join(text, "\n"))
+		}
+		totalLabel.SetText("Total: " + total.Format())
+	}
+
+	codeEntry := widget.NewEntry()
+	codeEntry.SetPlaceHolder("Item code")
+	qtyEntry := widget.NewEntry()
+	qtyEntry.SetPlaceHolder("Quantity")
+	costEntry := widget.NewEntry()
+	costEntry.SetPlaceHolder("Unit cost (defaults to item cost)")
+
+	addLineBtn := widget.NewButton("Add Line", func() {
+		item, err := inventory.GetItemByCode(db, strings.TrimSpace(codeEntry.Text))
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		qty, err := strconv.Atoi(qtyEntry.Text)
+		if err != nil || qty <= 0 {
+			dialog.ShowError(fmt.Errorf("invalid quantity"), parent)
+			return
+		}
+		cost := item.Cost
+		if strings.TrimSpace(costEntry.Text) != "" {
+			cost, err = money.Parse(costEntry.Text)
+			if err != nil || cost < 0 {
+				dialog.ShowError(fmt.Errorf("invalid unit cost"), parent)
+				return
+			}
+		}
+
+		lines = append(lines, models.PurchaseOrderLine{ItemID: item.ID, ItemName: item.Name, QuantityOrdered: qty, UnitCost: cost})
+		codeEntry.SetText("")
+		qtyEntry.SetText("")
+		costEntry.SetText("")
+		refreshLines()
+	})
+
+	formContent := container.NewVBox(
+		createStyledFormField("Supplier", supplierSelect),
+		createStyledFormField("Notes", notesEntry),
+		widget.NewSeparator(),
+		createStyledFormField("Item Code", codeEntry),
+		createStyledFormField("Quantity", qtyEntry),
+		createStyledFormField("Unit Cost", costEntry),
+		addLineBtn,
+		widget.NewSeparator(),
+		createStyledFormField("Lines", linesLabel),
+		createStyledFormField("", totalLabel),
+	)
+
+	onAction := func() {
+		if supplierSelect.SelectedIndex() < 0 {
+			dialog.ShowError(fmt.Errorf("please select a supplier"), parent)
+			return
+		}
+		supplier := suppliers[supplierSelect.SelectedIndex()]
+
+		order, err := purchasing.CreatePurchaseOrder(db, supplier.ID, user.ID, notesEntry.Text, lines)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		showStyledInformation(parent, "Success", fmt.Sprintf("Purchase order #%d created as a draft. Mark it as ordered once it has been sent to %s.", order.ID, supplier.Name))
+		onSuccess()
+	}
+
+	showStyledDialog(parent, "New Purchase Order", formContent, "Create", onAction, nil)
+}
+
+func showPurchaseOrderDetails(parent fyne.Window, order *models.PurchaseOrder) {
+	var details []string
+	details = append(details, fmt.Sprintf("Supplier: %s", order.SupplierName))
+	details = append(details, fmt.Sprintf("Status: %s", purchaseOrderStatusLabel(order.Status)))
+	details = append(details, fmt.Sprintf("Created: %s", order.CreatedAt.Format("2006-01-02 15:04")))
+	if order.OrderedAt != nil {
+		details = append(details, fmt.Sprintf("Ordered: %s", order.OrderedAt.Format("2006-01-02 15:04")))
+	}
+	if order.ReceivedAt != nil {
+		details = append(details, fmt.Sprintf("Received: %s", order.ReceivedAt.Format("2006-01-02 15:04")))
+	}
+	if order.Notes != "" {
+		details = append(details, fmt.Sprintf("Notes: %s", order.Notes))
+	}
+	details = append(details, "")
+	for _, line := range order.Lines {
+		details = append(details, fmt.Sprintf("%s: %d of %d received @ %s", line.ItemName, line.QuantityReceived, line.QuantityOrdered, line.UnitCost.Format()))
+	}
+	details = append(details, "", fmt.Sprintf("Total: %s", order.Total().Format()))
+
+	showStyledInformation(parent, fmt.Sprintf("Purchase Order #%d", order.ID), strings.Join(details, "\n"))
+}
+
+// showReceivePurchaseOrderDialog books in a delivery. Each outstanding line
+// starts filled in with the full outstanding quantity at the ordered cost.
+func showReceivePurchaseOrderDialog(parent fyne.Window, appState *auth.AppState, user *models.User, order *models.PurchaseOrder, onSuccess func()) {
+	if order.Status != models.PurchaseOrderOrdered && order.Status != models.PurchaseOrderPartial {
+		dialog.ShowInformation("Cannot Receive", "Only ordered or partially received purchase orders can be received", parent)
+		return
+	}
+
+	type lineEntries struct {
+		line              models.PurchaseOrderLine
+		qty, cost, expiry *widget.Entry
+		units             *unitPicker
+	}
+	var entries []lineEntries
+
+	db := appState.GetDB().(*database.Database)
+	formContent := container.NewVBox()
+	for _, line := range order.Lines {
+		if line.Outstanding() <= 0 {
+			continue
+		}
+
+		item, err := inventory.GetItemByID(db, line.ItemID)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		qtyEntry := widget.NewEntry()
+		costEntry := widget.NewEntry()
+		expiryEntry := widget.NewEntry()
+		expiryEntry.SetPlaceHolder("Expiry Date (YYYY-MM-DD, optional)")
+
+		// Lines are ordered in base units; refill them whenever the unit changes
+		var units *unitPicker
+		fill := func() {
+			factor := units.factor()
+			qtyEntry.SetText(inventory.FormatQuantity(float64(line.Outstanding()) / float64(factor)))
+			costEntry.SetText(line.UnitCost.Mul(factor).String())
+		}
+		units, err = newUnitPicker(db, item, item.PurchaseUnit, fill)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		fill()
+
+		title := widget.NewLabel(fmt.Sprintf("%s (%s outstanding)", line.ItemName, formatStock(line.Outstanding(), item.BaseUnit)))
+		title.TextStyle = fyne.TextStyle{Bold: true}
+		formContent.Add(title)
+		formContent.Add(createStyledFormField("Quantity", qtyEntry))
+		formContent.Add(createStyledFormField("Unit", units.sel))
+		formContent.Add(createStyledFormField("Unit Cost", costEntry))
+		formContent.Add(createStyledFormField("Expiry Date", expiryEntry))
+		formContent.Add(widget.NewSeparator())
+
+		entries = append(entries, lineEntries{line: line, qty: qtyEntry, cost: costEntry, expiry: expiryEntry, units: units})
+	}
+
+	// Scanning a supplier's GS1 label fills in the expiry of its line
+	scanStatus := widget.NewLabel("Scan a GS1 label to fill in its expiry date")
+	var labelEntry *scanEntry
+	scanner := newScannerInput(parent, appState.GetConfig(), func(code string) {
+		labelEntry.OnSubmitted(code)
+	})
+	labelEntry, uploadBtn := newCodeEntry(parent, scanner, func(code string) {
+		labelEntry.SetText("")
+		item, _, scan, err := inventory.LookupScan(db, code)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		for _, e := range entries {
+			if e.line.ItemID != item.ID {
+				continue
+			}
+			status := e.line.ItemName
+			if scan != nil && scan.Lot != "" {
+				status += ", lot " + scan.Lot
+			}
+			if scan != nil && scan.Expiry != nil {
+				e.expiry.SetText(scan.Expiry.Format("2006-01-02"))
+				status += ", expires " + e.expiry.Text
+			} else {
+				status += ", no expiry date in the code"
+			}
+			scanStatus.SetText(status)
+			return
+		}
+		dialog.ShowError(fmt.Errorf("%s has nothing outstanding on PO #%d", item.Name, order.ID), parent)
+	})
+	formContent.Objects = append([]fyne.CanvasObject{
+		createStyledFormField("Scan", labelEntry),
+		uploadBtn,
+		scanStatus,
+		widget.NewSeparator(),
+	}, formContent.Objects...)
+
+	onAction := func() {
+		var receipts []purchasing.Receipt
+		for _, e := range entries {
+			qty := 0.0
+			if strings.TrimSpace(e.qty.Text) != "" {
+				var err error
+				qty, err = strconv.ParseFloat(e.qty.Text, 64)
+				if err != nil || qty < 0 {
+					dialog.ShowError(fmt.Errorf("invalid quantity for %s", e.line.ItemName), parent)
+					return
+				}
+			}
+			if qty == 0 {
+				continue // nothing arrived for this line
+			}
+
+			cost, err := money.Parse(e.cost.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("invalid unit cost for %s", e.line.ItemName), parent)
+				return
+			}
+
+			var expiryDate *time.Time
+			if e.expiry.Text != "" {
+				parsedDate, err := time.Parse("2006-01-02", e.expiry.Text)
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("invalid date format. Use YYYY-MM-DD"), parent)
+					return
+				}
+				expiryDate = &parsedDate
+			}
+
+			receipts = append(receipts, purchasing.Receipt{LineID: e.line.ID, Quantity: qty, Unit: e.units.unit(), UnitCost: cost, ExpiryDate: expiryDate})
+		}
+
+		if err := purchasing.ReceivePurchaseOrder(db, order.ID, user.ID, receipts); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		showStyledInformation(parent, "Success", fmt.Sprintf("Delivery for PO #%d received into stock", order.ID))
+		onSuccess()
+	}
+
+	showStyledDialog(parent, fmt.Sprintf("Receive PO #%d", order.ID), container.NewVScroll(formContent), "Receive", onAction, nil)
+}
+
+func createSuppliersView(parent fyne.Window, appState *auth.AppState) fyne.CanvasObject {
+	user := appState.GetCurrentUser()
+	var suppliers []models.Supplier
+	var selectedID widget.ListItemID = -1
+
+	list := widget.NewList(
+		func() int {
+			db := appState.GetDB().(*database.Database)
+			allSuppliers, err := purchasing.GetAllSuppliers(db)
+			if err != nil {
+				return 0
+			}
+			suppliers = allSuppliers
+			return len(suppliers)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(suppliers) {
+				supplier := suppliers[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*widget.Label).SetText(supplier.Name)
+				box.Objects[1].(*widget.Label).SetText(supplier.ContactName)
+				box.Objects[2].(*widget.Label).SetText(supplier.Phone)
+				box.Objects[3].(*widget.Label).SetText(supplier.Email)
+			}
+		},
+	)
+
+	list.OnSelected = func(id widget.ListItemID) {
+		selectedID = id
+	}
+
+	refreshList := func() {
+		list.UnselectAll()
+		list.Refresh()
+		selectedID = -1
+	}
+
+	addBtn := widget.NewButton("Add Supplier", func() {
+		showSupplierDialog(parent, appState, nil, refreshList)
+	})
+	editBtn := widget.NewButton("Edit Supplier", func() {
+		if selectedID < 0 || selectedID >= len(suppliers) {
+			dialog.ShowInformation("No Selection", "Please select a supplier to edit", parent)
+			return
+		}
+		showSupplierDialog(parent, appState, &suppliers[selectedID], refreshList)
+	})
+	deleteBtn := widget.NewButton("Delete Supplier", func() {
+		if selectedID < 0 || selectedID >= len(suppliers) {
+			dialog.ShowInformation("No Selection", "Please select a supplier to delete", parent)
+			return
+		}
+		supplier := suppliers[selectedID]
+		dialog.ShowConfirm("Delete Supplier", fmt.Sprintf("Are you sure you want to delete supplier '%s'?", supplier.Name), func(confirmed bool) {
+			if confirmed {
+				db := appState.GetDB().(*database.Database)
+				if err := purchasing.DeleteSupplier(db, supplier.ID, user.ID); err != nil {
+					dialog.ShowError(err, parent)
+					return
+				}
+				showStyledInformation(parent, "Success", "Supplier deleted successfully")
+				refreshList()
+			}
+		}, parent)
+	})
+	refreshBtn := widget.NewButton("Refresh", refreshList)
+
+	return container.NewBorder(
+		nil,
+		container.NewHBox(addBtn, editBtn, deleteBtn, refreshBtn),
+		nil,
+		nil,
+		list,
+	)
+}
+
+// showSupplierDialog adds a new supplier, or edits supplier when it is not nil
+func showSupplierDialog(parent fyne.Window, appState *auth.AppState, supplier *models.Supplier, onSuccess func()) {
+	user := appState.GetCurrentUser()
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Supplier Name")
+	contactEntry := widget.NewEntry()
+	contactEntry.SetPlaceHolder("Contact Name")
+	phoneEntry := widget.NewEntry()
+	phoneEntry.SetPlaceHolder("Phone")
+	emailEntry := widget.NewEntry()
+	emailEntry.SetPlaceHolder("Email")
+	addressEntry := widget.NewMultiLineEntry()
+	addressEntry.SetPlaceHolder("Address")
+
+	title, actionLabel := "Add Supplier", "Add"
+	if supplier != nil {
+		title, actionLabel = "Edit Supplier", "Update"
+		nameEntry.SetText(supplier.Name)
+		contactEntry.SetText(supplier.ContactName)
+		phoneEntry.SetText(supplier.Phone)
+		emailEntry.SetText(supplier.Email)
+		addressEntry.SetText(supplier.Address)
+	}
+
+	formContent := container.NewVBox(
+		createStyledFormField("Name", nameEntry),
+		createStyledFormField("Contact", contactEntry),
+		createStyledFormField("Phone", phoneEntry),
+		createStyledFormField("Email", emailEntry),
+		createStyledFormField("Address", addressEntry),
+	)
+
+	onAction := func() {
+		db := appState.GetDB().(*database.Database)
+		var err error
+		if supplier == nil {
+			_, err = purchasing.CreateSupplier(db, nameEntry.Text, contactEntry.Text, phoneEntry.Text, emailEntry.Text, addressEntry.Text, user.ID)
+		} else {
+			err = purchasing.UpdateSupplier(db, supplier.ID, nameEntry.Text, contactEntry.Text, phoneEntry.Text, emailEntry.Text, addressEntry.Text, user.ID)
+		}
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		showStyledInformation(parent, "Success", "Supplier saved successfully")
+		onSuccess()
+	}
+
+	showStyledDialog(parent, title, formContent, actionLabel, onAction, nil)
+}
@@ -0,0 +1,210 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/barcode"
+	"ims-go/database"
+	"ims-go/inventory"
+	"ims-go/models"
+)
+
+// showPhotoReceivingWindow decodes every photo in a folder, such as pictures
+// of the cartons in a delivery, and restocks the items found
+func showPhotoReceivingWindow(parent fyne.Window, appState *auth.AppState, user *models.User, onSuccess func()) {
+	db := appState.GetDB().(*database.Database)
+
+	receiveWindow := fyne.CurrentApp().NewWindow("Receive from Photos")
+	receiveWindow.Resize(fyne.NewSize(700, 600))
+	receiveWindow.CenterOnScreen()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	receiveWindow.SetOnClosed(cancel)
+
+	progress := widget.NewProgressBar()
+	statusLabel := widget.NewLabel("Choose the folder holding the photos of the delivery")
+	filesLabel := widget.NewLabel("")
+	linesBox := container.NewVBox()
+
+	type lineEntries struct {
+		line        inventory.ReceivingLine
+		qty, expiry *widget.Entry
+	}
+	var entries []lineEntries
+
+	receiveBtn := widget.NewButton("Receive", nil)
+	receiveBtn.Disable()
+
+	// showList lays out the receiving list built from the decoded codes
+	showList := func(results []barcode.FileResult) {
+		var files, codes []string
+		failed := 0
+		for _, r := range results {
+			name := filepath.Base(r.Path)
+			if r.Err != nil {
+				failed++
+				files = append(files, fmt.Sprintf("%s: failed, %v", name, r.Err))
+				continue
+			}
+			var found []string
+			for _, result := range r.Results {
+				found = append(found, result.Text)
+				codes = append(codes, result.Text)
+			}
+			files = append(files, fmt.Sprintf("%s: %s", name, strings.Join(found, ", ")))
+		}
+		filesLabel.SetText(strings.Join(files, "\n"))
+
+		lines, unknown, err := inventory.BuildReceivingList(db, codes)
+		if err != nil {
+			dialog.ShowError(err, receiveWindow)
+			return
+		}
+		statusLabel.SetText(fmt.Sprintf("%d photos, %d could not be read, %d codes for %d items", len(results), failed, len(codes), len(lines)))
+
+		entries = nil
+		linesBox.RemoveAll()
+		for _, line := range lines {
+			qtyEntry := widget.NewEntry()
+			qtyEntry.SetText(strconv.Itoa(line.Quantity))
+			expiryEntry := widget.NewEntry()
+			expiryEntry.SetPlaceHolder("Expiry Date (YYYY-MM-DD, optional)")
+			if line.Expiry != nil {
+				expiryEntry.SetText(line.Expiry.Format("2006-01-02"))
+			}
+
+			title := widget.NewLabel(fmt.Sprintf("%s (%d scans)", line.Item.Name, line.Scans))
+			title.TextStyle = fyne.TextStyle{Bold: true}
+			linesBox.Add(title)
+			linesBox.Add(createStyledFormField("Quantity ("+line.Item.BaseUnit+")", qtyEntry))
+			linesBox.Add(createStyledFormField("Expiry Date", expiryEntry))
+			entries = append(entries, lineEntries{line: line, qty: qtyEntry, expiry: expiryEntry})
+		}
+		if len(unknown) > 0 {
+			linesBox.Add(widget.NewLabel("Not matched to an item to stock: " + strings.Join(unknown, ", ")))
+		}
+		if len(entries) > 0 {
+			receiveBtn.Enable()
+		}
+	}
+
+	var chooseBtn *widget.Button
+	chooseBtn = widget.NewButton("Choose Folder", func() {
+		dialog.ShowFolderOpen(func(folder fyne.ListableURI, err error) {
+			if err != nil {
+				dialog.ShowError(err, receiveWindow)
+				return
+			}
+			if folder == nil {
+				return // cancelled
+			}
+
+			chooseBtn.Disable()
+			receiveBtn.Disable()
+			progress.SetValue(0)
+			statusLabel.SetText("Reading " + folder.Path())
+			// Photos are decoded off the window's goroutine, and the
+			// widgets only updated from it
+			go func() {
+				results, err := barcode.DecodeDir(ctx, folder.Path(), 0, barcode.DecodeOptions{TryHarder: true, Multiple: true}, func(done, total int) {
+					runOnWindow(receiveWindow, func() {
+						progress.SetValue(float64(done) / float64(total))
+					})
+				})
+				runOnWindow(receiveWindow, func() {
+					chooseBtn.Enable()
+					if ctx.Err() != nil {
+						return // window closed
+					}
+					if err != nil {
+						dialog.ShowError(err, receiveWindow)
+						return
+					}
+					if len(results) == 0 {
+						statusLabel.SetText("There are no PNG or JPEG photos in " + folder.Path())
+						return
+					}
+					showList(results)
+				})
+			}()
+		}, receiveWindow)
+	})
+
+	receiveBtn.OnTapped = func() {
+		type receipt struct {
+			line   inventory.ReceivingLine
+			qty    int
+			expiry *time.Time
+		}
+		var receipts []receipt
+		for _, e := range entries {
+			qty, err := strconv.Atoi(strings.TrimSpace(e.qty.Text))
+			if err != nil || qty < 0 {
+				dialog.ShowError(fmt.Errorf("invalid quantity for %s", e.line.Item.Name), receiveWindow)
+				return
+			}
+			if qty == 0 {
+				continue // not received after all
+			}
+			var expiryDate *time.Time
+			if text := strings.TrimSpace(e.expiry.Text); text != "" {
+				parsedDate, err := time.Parse("2006-01-02", text)
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("invalid date format. Use YYYY-MM-DD"), receiveWindow)
+					return
+				}
+				expiryDate = &parsedDate
+			}
+			receipts = append(receipts, receipt{line: e.line, qty: qty, expiry: expiryDate})
+		}
+
+		// Each line is its own restock, so report any that fail and carry on.
+		// What went through can't be received twice.
+		receiveBtn.Disable()
+		var failures []string
+		for _, r := range receipts {
+			if err := inventory.RestockItem(db, r.line.Item.ID, float64(r.qty), "", r.expiry, nil, user.ID); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", r.line.Item.Name, err))
+			}
+		}
+		onSuccess()
+		if len(failures) > 0 {
+			dialog.ShowError(fmt.Errorf("%d of %d lines could not be received:\n%s", len(failures), len(receipts), strings.Join(failures, "\n")), receiveWindow)
+			return
+		}
+		showStyledInformation(parent, "Success", fmt.Sprintf("%d lines received into stock", len(receipts)))
+		receiveWindow.Close()
+	}
+
+	closeBtn := widget.NewButton("Close", func() {
+		receiveWindow.Close()
+	})
+
+	content := container.NewBorder(
+		container.NewVBox(chooseBtn, progress, statusLabel),
+		container.NewHBox(receiveBtn, closeBtn),
+		nil,
+		nil,
+		container.NewVScroll(container.NewVBox(
+			widget.NewLabelWithStyle("Photos", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			filesLabel,
+			widget.NewSeparator(),
+			widget.NewLabelWithStyle("To Receive", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			linesBox,
+		)),
+	)
+
+	receiveWindow.SetContent(content)
+	receiveWindow.Show()
+}
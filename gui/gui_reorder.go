@@ -0,0 +1,161 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/database"
+	"ims-go/models"
+	"ims-go/purchasing"
+)
+
+func createReorderView(parent fyne.Window, appState *auth.AppState, user *models.User) fyne.CanvasObject {
+	var suggestions []purchasing.ReorderSuggestion
+
+	windowEntry := widget.NewEntry()
+	windowEntry.SetText("30")
+	coverEntry := widget.NewEntry()
+	coverEntry.SetText("14")
+
+	list := widget.NewList(
+		func() int {
+			return len(suggestions)
+		},
+		func() fyne.CanvasObject {
+			nameLabel := widget.NewLabel("")
+			nameLabel.Resize(fyne.NewSize(200, nameLabel.MinSize().Height))
+			supplierLabel := widget.NewLabel("")
+			supplierLabel.Resize(fyne.NewSize(150, supplierLabel.MinSize().Height))
+			stockLabel := widget.NewLabel("")
+			stockLabel.Resize(fyne.NewSize(200, stockLabel.MinSize().Height))
+			return container.NewHBox(
+				container.NewBorder(nil, nil, nil, nil, nameLabel),
+				container.NewBorder(nil, nil, nil, nil, supplierLabel),
+				container.NewBorder(nil, nil, nil, nil, stockLabel),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(suggestions) {
+				s := suggestions[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*fyne.Container).Objects[0].(*widget.Label).SetText(s.Item.Name)
+				supplier := s.SupplierName
+				if supplier == "" {
+					supplier = "(no supplier)"
+				}
+				box.Objects[1].(*fyne.Container).Objects[0].(*widget.Label).SetText(supplier)
+				box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label).SetText(fmt.Sprintf("Stock %d / point %d, %d on order", s.Item.Quantity, s.ReorderPoint, s.OnOrder))
+				orderLabel := box.Objects[3].(*widget.Label)
+				orderLabel.SetText(fmt.Sprintf("Order %d (%.1f/day)", s.SuggestedQuantity, s.DailySales))
+				orderLabel.TextStyle = fyne.TextStyle{Bold: true}
+			}
+		},
+	)
+
+	generate := func() {
+		windowDays, err := strconv.Atoi(windowEntry.Text)
+		if err != nil || windowDays <= 0 {
+			dialog.ShowError(fmt.Errorf("invalid sales window"), parent)
+			return
+		}
+		coverDays, err := strconv.Atoi(coverEntry.Text)
+		if err != nil || coverDays < 0 {
+			dialog.ShowError(fmt.Errorf("invalid cover days"), parent)
+			return
+		}
+
+		db := appState.GetDB().(*database.Database)
+		result, err := purchasing.GetReorderSuggestions(db, purchasing.ReorderOptions{
+			DefaultReorderPoint: appState.GetConfig().LowStockThreshold,
+			SalesWindowDays:     windowDays,
+			CoverDays:           coverDays,
+		})
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		suggestions = result
+		list.Refresh()
+	}
+
+	generateBtn := widget.NewButton("Generate", generate)
+
+	exportBtn := widget.NewButton("Export CSV", func() {
+		if len(suggestions) == 0 {
+			dialog.ShowInformation("Nothing to Export", "There are no reorder suggestions", parent)
+			return
+		}
+		saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			if writer == nil {
+				return // cancelled
+			}
+			defer writer.Close()
+
+			if err := purchasing.WriteReorderCSV(writer, suggestions); err != nil {
+				dialog.ShowError(fmt.Errorf("Failed to export: %v", err), parent)
+				return
+			}
+			showStyledInformation(parent, "Export Complete", fmt.Sprintf("Reorder suggestions saved to %s", writer.URI().Path()))
+		}, parent)
+		saveDialog.SetFileName(fmt.Sprintf("reorder-%s.csv", time.Now().Format("2006-01-02")))
+		saveDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv"}))
+		saveDialog.Show()
+	})
+
+	draftBtn := widget.NewButton("Create Draft Orders", func() {
+		if len(suggestions) == 0 {
+			dialog.ShowInformation("Nothing to Order", "There are no reorder suggestions", parent)
+			return
+		}
+
+		missing := 0
+		for _, s := range suggestions {
+			if s.Item.PreferredSupplierID == nil {
+				missing++
+			}
+		}
+
+		db := appState.GetDB().(*database.Database)
+		orders, err := purchasing.CreateDraftOrders(db, user.ID, suggestions)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		message := fmt.Sprintf("Created %d draft purchase order(s). Review them in the Purchase Orders tab before marking them as ordered.", len(orders))
+		if missing > 0 {
+			message += fmt.Sprintf("\n\n%d item(s) have no preferred supplier and were left out.", missing)
+		}
+		showStyledInformation(parent, "Draft Orders Created", message)
+		generate()
+	})
+
+	options := container.NewVBox(
+		createStyledFormField("Sales Window (days)", windowEntry),
+		createStyledFormField("Cover (days)", coverEntry),
+		widget.NewSeparator(),
+	)
+
+	generate()
+	return container.NewBorder(
+		options,
+		container.NewHBox(generateBtn, exportBtn, draftBtn),
+		nil,
+		nil,
+		list,
+	)
+}
@@ -3,6 +3,7 @@ package gui
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -14,9 +15,10 @@ import (
 	"ims-go/database"
 	"ims-go/inventory"
 	"ims-go/models"
+	"ims-go/money"
 )
 
-func showRestockDialog(parent fyne.Window, appState *auth.AppState, item *models.Item, onSuccess func()) {
+func showRestockDialog(parent fyne.Window, appState *auth.AppState, user *models.User, item *models.Item, onSuccess func()) {
 	// Get current quantity
 	db := appState.GetDB().(*database.Database)
 	currentQty, err := inventory.GetItemQuantity(db, item.ID)
@@ -29,17 +31,29 @@ func showRestockDialog(parent fyne.Window, appState *auth.AppState, item *models
 	restockQtyEntry.SetPlaceHolder("Quantity to add")
 	expiryDateEntry := widget.NewEntry()
 	expiryDateEntry.SetPlaceHolder("Expiry Date (YYYY-MM-DD, optional)")
+	unitCostEntry := widget.NewEntry()
+	unitCostEntry.SetPlaceHolder(fmt.Sprintf("Cost per unit (default %s per %s)", item.Cost.Format(), item.BaseUnit))
 
-	totalQtyLabel := widget.NewLabel(fmt.Sprintf("Total after restock: %d", currentQty))
+	totalQtyLabel := widget.NewLabel(fmt.Sprintf("Total after restock: %s", formatStock(currentQty, item.BaseUnit)))
 	totalQtyLabel.TextStyle = fyne.TextStyle{Bold: true}
 
+	// Quantities are entered in the chosen unit and stocked in base units
+	var units *unitPicker
 	updateTotal := func() {
-		restockQty, err := strconv.Atoi(restockQtyEntry.Text)
+		restockQty, err := strconv.ParseFloat(restockQtyEntry.Text, 64)
 		if err == nil && restockQty > 0 {
-			totalQtyLabel.SetText(fmt.Sprintf("Total after restock: %d", currentQty+restockQty))
-		} else {
-			totalQtyLabel.SetText(fmt.Sprintf("Total after restock: %d", currentQty))
+			if added, err := inventory.ToBaseQuantity(restockQty, units.factor()); err == nil {
+				totalQtyLabel.SetText(fmt.Sprintf("Total after restock: %s", formatStock(currentQty+added, item.BaseUnit)))
+				return
+			}
 		}
+		totalQtyLabel.SetText(fmt.Sprintf("Total after restock: %s", formatStock(currentQty, item.BaseUnit)))
+	}
+
+	units, err = newUnitPicker(db, item, item.PurchaseUnit, updateTotal)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
 	}
 
 	restockQtyEntry.OnChanged = func(_ string) {
@@ -47,14 +61,16 @@ func showRestockDialog(parent fyne.Window, appState *auth.AppState, item *models
 	}
 
 	formContent := container.NewVBox(
-		createStyledFormField("Current Quantity", widget.NewLabel(fmt.Sprintf("%d", currentQty))),
+		createStyledFormField("Current Quantity", widget.NewLabel(formatStock(currentQty, item.BaseUnit))),
 		createStyledFormField("Quantity to Add", restockQtyEntry),
+		createStyledFormField("Unit", units.sel),
 		createStyledFormField("Expiry Date (optional)", expiryDateEntry),
+		createStyledFormField("Unit Cost (optional)", unitCostEntry),
 		createStyledFormField("", totalQtyLabel),
 	)
 
 	onAction := func() {
-		restockQty, err := strconv.Atoi(restockQtyEntry.Text)
+		restockQty, err := strconv.ParseFloat(restockQtyEntry.Text, 64)
 		if err != nil || restockQty <= 0 {
 			dialog.ShowError(fmt.Errorf("invalid quantity"), parent)
 			return
@@ -70,16 +86,30 @@ func showRestockDialog(parent fyne.Window, appState *auth.AppState, item *models
 			expiryDate = &parsedDate
 		}
 
-		err = inventory.RestockItem(db, item.ID, restockQty, expiryDate)
+		var unitCost *money.Money
+		if strings.TrimSpace(unitCostEntry.Text) != "" {
+			cost, err := money.Parse(unitCostEntry.Text)
+			if err != nil || cost < 0 {
+				dialog.ShowError(fmt.Errorf("invalid unit cost"), parent)
+				return
+			}
+			unitCost = &cost
+		}
+
+		err = inventory.RestockItem(db, item.ID, restockQty, units.unit(), expiryDate, unitCost, user.ID)
 		if err != nil {
 			dialog.ShowError(err, parent)
 			return
 		}
 
-		showStyledInformation(parent, "Success", fmt.Sprintf("Item restocked successfully. New quantity: %d", currentQty+restockQty))
+		newQty, err := inventory.GetItemQuantity(db, item.ID)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		showStyledInformation(parent, "Success", fmt.Sprintf("Item restocked successfully. New quantity: %s", formatStock(newQty, item.BaseUnit)))
 		onSuccess()
 	}
 
 	showStyledDialog(parent, "Restock Item", formContent, "Restock", onAction, nil)
 }
-
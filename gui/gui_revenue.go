@@ -11,21 +11,21 @@ import (
 
 	"ims-go/auth"
 	"ims-go/database"
+	"ims-go/inventory"
 	"ims-go/models"
+	"ims-go/transactions"
 )
 
-type RevenueItem struct {
-	ItemID      int
-	ItemName    string
-	TotalRevenue float64
-	QuantitySold int
-}
-
 func createRevenueTab(parent fyne.Window, appState *auth.AppState, user *models.User) *container.Scroll {
 	// Revenue items list
-	var revenueItems []RevenueItem
+	var revenueItems []transactions.ItemSales
+	var categorySales []transactions.CategorySales
 	var oldestItems []models.Item
 
+	// Variants can be listed on their own or rolled up into their product
+	groupSelect := widget.NewSelect([]string{"By Item", "By Product"}, nil)
+	groupSelect.SetSelected("By Item")
+
 	// Get revenue data
 	revenueList := widget.NewList(
 		func() int {
@@ -36,6 +36,7 @@ func createRevenueTab(parent fyne.Window, appState *auth.AppState, user *models.
 				widget.NewLabel(""),
 				widget.NewLabel(""),
 				widget.NewLabel(""),
+				widget.NewLabel(""),
 			)
 		},
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
@@ -43,8 +44,34 @@ func createRevenueTab(parent fyne.Window, appState *auth.AppState, user *models.
 				item := revenueItems[id]
 				box := obj.(*fyne.Container)
 				box.Objects[0].(*widget.Label).SetText(item.ItemName)
-				box.Objects[1].(*widget.Label).SetText(fmt.Sprintf("Sold: %d", item.QuantitySold))
-				box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Revenue: $%.2f", item.TotalRevenue))
+				box.Objects[1].(*widget.Label).SetText("Sold: " + inventory.FormatQuantity(item.QuantitySold))
+				box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Revenue: %s", item.Revenue.Format()))
+				box.Objects[3].(*widget.Label).SetText(fmt.Sprintf("Profit: %s", item.Profit().Format()))
+			}
+		},
+	)
+
+	// Sales by category, each parent listed before its subcategories
+	categoryList := widget.NewList(
+		func() int {
+			return len(categorySales)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(categorySales) {
+				sales := categorySales[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*widget.Label).SetText(sales.Path)
+				box.Objects[1].(*widget.Label).SetText("Sold: " + inventory.FormatQuantity(sales.QuantitySold))
+				box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Revenue: %s", sales.Revenue.Format()))
+				box.Objects[3].(*widget.Label).SetText(fmt.Sprintf("Profit: %s", sales.Profit().Format()))
 			}
 		},
 	)
@@ -78,34 +105,24 @@ func createRevenueTab(parent fyne.Window, appState *auth.AppState, user *models.
 	refreshData := func() {
 		// Get revenue data
 		db := appState.GetDB().(*database.Database)
-		rows, err := db.GetDB().Query(`
-			SELECT 
-				i.id,
-				i.name,
-				SUM((i.price - i.cost) * ti.quantity) as total_revenue,
-				SUM(ti.quantity) as quantity_sold
-			FROM items i
-			JOIN transaction_items ti ON i.id = ti.item_id
-			GROUP BY i.id, i.name
-			ORDER BY total_revenue DESC
-		`)
-		if err == nil {
-			defer rows.Close()
-			revenueItems = []RevenueItem{}
-			for rows.Next() {
-				var item RevenueItem
-				err := rows.Scan(&item.ItemID, &item.ItemName, &item.TotalRevenue, &item.QuantitySold)
-				if err == nil {
-					revenueItems = append(revenueItems, item)
-				}
-			}
+		getSales := transactions.GetSalesByItem
+		if groupSelect.Selected == "By Product" {
+			getSales = transactions.GetSalesByProduct
+		}
+		if sales, err := getSales(db); err == nil {
+			revenueItems = sales
+		}
+		if sales, err := transactions.GetSalesByCategory(db); err == nil {
+			categorySales = sales
 		}
 
-		// Get oldest items
+		// Get oldest items. Archived items are off the shelf, and a product
+		// with variants has no stock of its own.
 		rows2, err := db.GetDB().Query(`
 			SELECT id, name, code, description, price, cost, quantity, in_stock_date, expiry_date, created_at, updated_at
 			FROM items
-			WHERE quantity > 0
+			WHERE quantity > 0 AND archived_at IS NULL
+				AND id NOT IN (SELECT parent_id FROM items WHERE parent_id IS NOT NULL AND archived_at IS NULL)
 			ORDER BY in_stock_date ASC
 		`)
 		if err == nil {
@@ -129,22 +146,41 @@ func createRevenueTab(parent fyne.Window, appState *auth.AppState, user *models.
 		}
 
 		revenueList.Refresh()
+		categoryList.Refresh()
 		oldestList.Refresh()
 	}
 
 	refreshBtn := widget.NewButton("Refresh", refreshData)
+	groupSelect.OnChanged = func(_ string) {
+		refreshData()
+	}
 
-	content := container.NewHSplit(
+	salesSplit := container.NewVSplit(
 		container.NewBorder(
 			container.NewVBox(
-				widget.NewLabel("Top Revenue Items"),
+				container.NewBorder(nil, nil, nil, groupSelect, widget.NewLabel("Top Revenue Items")),
 				widget.NewSeparator(),
 			),
-			refreshBtn,
+			nil,
 			nil,
 			nil,
 			container.NewScroll(revenueList),
 		),
+		container.NewBorder(
+			container.NewVBox(
+				widget.NewLabel("Sales by Category"),
+				widget.NewSeparator(),
+			),
+			nil,
+			nil,
+			nil,
+			container.NewScroll(categoryList),
+		),
+	)
+	salesSplit.SetOffset(0.6)
+
+	content := container.NewHSplit(
+		container.NewBorder(nil, refreshBtn, nil, nil, salesSplit),
 		container.NewBorder(
 			container.NewVBox(
 				widget.NewLabel("Oldest Items on Shelf"),
@@ -161,4 +197,3 @@ func createRevenueTab(parent fyne.Window, appState *auth.AppState, user *models.
 	refreshData()
 	return container.NewScroll(content)
 }
-
@@ -0,0 +1,74 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/barcode"
+)
+
+// newCodeEntry returns an entry for typing or scanning item codes, and a
+// button that fills it in by decoding a barcode/QR image. Its typing goes
+// through scanner, which should pass scans on to the entry's OnSubmitted.
+// onCode is called with each submitted code, trimmed and never empty, and
+// with every code in an image holding several once the user confirms.
+func newCodeEntry(parent fyne.Window, scanner *scannerInput, onCode func(code string)) (*scanEntry, *widget.Button) {
+	codeEntry := newScanEntry(scanner)
+	codeEntry.SetPlaceHolder("Enter or scan barcode/QR code...")
+	codeEntry.OnSubmitted = func(code string) {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			return
+		}
+		onCode(code)
+	}
+
+	// File upload button for barcode/QR image
+	uploadBtn := widget.NewButton("Upload Barcode/QR Image", func() {
+		showFilePickerWithMemory(parent, func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			// Read image and decode every barcode/QR in it
+			results, err := barcode.DecodeReader(reader, barcode.DecodeOptions{TryHarder: true, Multiple: true})
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("failed to decode barcode/QR: %v", err), parent)
+				return
+			}
+
+			// Process the code
+			if len(results) == 1 {
+				codeEntry.SetText(results[0].Text)
+				codeEntry.OnSubmitted(results[0].Text)
+				return
+			}
+
+			// A photo of a delivery note or a shelf can hold several
+			var lines []string
+			for _, result := range results {
+				lines = append(lines, fmt.Sprintf("%s (%s)", result.Text, result.Format))
+			}
+			content := container.NewVBox(
+				widget.NewLabel(fmt.Sprintf("Found %d codes in the image:", len(results))),
+				widget.NewLabel(strings.Join(lines, "\n")),
+			)
+			showStyledDialog(parent, "Codes Found", content, "Add All", func() {
+				for _, result := range results {
+					onCode(result.Text)
+				}
+			}, nil)
+		})
+	})
+
+	return codeEntry, uploadBtn
+}
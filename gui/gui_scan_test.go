@@ -0,0 +1,99 @@
+package gui
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+
+	"ims-go/config"
+)
+
+// typeScan types keys into the code field the way a scanner does, all within
+// the scanner's gap
+func typeScan(entry *scanEntry, keys string) {
+	for _, r := range keys {
+		switch r {
+		case '\n':
+			entry.TypedKey(&fyne.KeyEvent{Name: fyne.KeyReturn})
+		case '\t':
+			entry.TypedKey(&fyne.KeyEvent{Name: fyne.KeyTab})
+		default:
+			entry.TypedRune(r)
+		}
+	}
+}
+
+func TestCodeEntry_PrefixedScan(t *testing.T) {
+	test.NewApp()
+	defer test.NewApp()
+
+	tests := []struct {
+		name           string
+		prefix, suffix string
+		keys           string
+	}{
+		{"prefix and Enter", "\x02", "", "\x02ABC123\n"},
+		{"prefix and Tab", "\x02", "\t", "\x02ABC123\t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := test.NewWindow(nil)
+			defer window.Close()
+			cfg := config.Default()
+			cfg.ScannerPrefix = tt.prefix
+			cfg.ScannerSuffix = tt.suffix
+			cfg.ScannerMaxGapMs = 1000
+
+			var codeEntry *scanEntry
+			scanner := newScannerInput(window, cfg, func(code string) {
+				codeEntry.OnSubmitted(code)
+			})
+			var codes []string
+			codeEntry, _ = newCodeEntry(window, scanner, func(code string) {
+				codes = append(codes, code)
+			})
+			window.SetContent(codeEntry)
+			window.Canvas().Focus(codeEntry)
+
+			typeScan(codeEntry, tt.keys)
+			if len(codes) != 1 || codes[0] != "ABC123" {
+				t.Errorf("Expected the scan to submit ABC123, got %q", codes)
+			}
+			if codeEntry.Text != "" {
+				t.Errorf("Expected nothing typed into the field, got %q", codeEntry.Text)
+			}
+		})
+	}
+}
+
+func TestCodeEntry_Typing(t *testing.T) {
+	test.NewApp()
+	defer test.NewApp()
+
+	window := test.NewWindow(nil)
+	defer window.Close()
+	cfg := config.Default()
+	cfg.ScannerPrefix = "\x02"
+
+	var codeEntry *scanEntry
+	scanner := newScannerInput(window, cfg, func(code string) {
+		codeEntry.OnSubmitted(code)
+	})
+	var codes []string
+	codeEntry, _ = newCodeEntry(window, scanner, func(code string) {
+		codes = append(codes, code)
+	})
+	window.SetContent(codeEntry)
+	window.Canvas().Focus(codeEntry)
+
+	// Without the prefix the keys are typing, submitted with Enter as usual
+	typeScan(codeEntry, "AB12")
+	if codeEntry.Text != "AB12" {
+		t.Errorf("Expected AB12 typed into the field, got %q", codeEntry.Text)
+	}
+	typeScan(codeEntry, "\n")
+	if len(codes) != 1 || codes[0] != "AB12" {
+		t.Errorf("Expected AB12 to be submitted, got %q", codes)
+	}
+}
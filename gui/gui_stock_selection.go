@@ -72,4 +72,3 @@ func showItemStockSelectionDialog(parent fyne.Window, appState *auth.AppState, i
 	selectionWindow.SetContent(content)
 	selectionWindow.Show()
 }
-
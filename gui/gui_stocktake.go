@@ -0,0 +1,346 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/database"
+	"ims-go/models"
+	"ims-go/stocktake"
+)
+
+func stocktakeStatusLabel(status string) string {
+	switch status {
+	case models.StocktakeOpen:
+		return "Open"
+	case models.StocktakePosted:
+		return "Posted"
+	case models.StocktakeCancelled:
+		return "Cancelled"
+	default:
+		return status
+	}
+}
+
+func createStocktakeTab(parent fyne.Window, appState *auth.AppState, user *models.User) fyne.CanvasObject {
+	var stocktakes []models.Stocktake
+	var selectedID widget.ListItemID = -1
+
+	list := widget.NewList(
+		func() int {
+			return len(stocktakes)
+		},
+		func() fyne.CanvasObject {
+			idLabel := widget.NewLabel("")
+			idLabel.Resize(fyne.NewSize(100, idLabel.MinSize().Height))
+			filterLabel := widget.NewLabel("")
+			filterLabel.Resize(fyne.NewSize(200, filterLabel.MinSize().Height))
+			statusLabel := widget.NewLabel("")
+			statusLabel.Resize(fyne.NewSize(100, statusLabel.MinSize().Height))
+			countedLabel := widget.NewLabel("")
+			countedLabel.Resize(fyne.NewSize(150, countedLabel.MinSize().Height))
+			return container.NewHBox(
+				container.NewBorder(nil, nil, nil, nil, idLabel),
+				container.NewBorder(nil, nil, nil, nil, filterLabel),
+				container.NewBorder(nil, nil, nil, nil, statusLabel),
+				container.NewBorder(nil, nil, nil, nil, countedLabel),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(stocktakes) {
+				st := stocktakes[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*fyne.Container).Objects[0].(*widget.Label).SetText(fmt.Sprintf("Count #%d", st.ID))
+				filter := st.Filter
+				if filter == "" {
+					filter = "(all items)"
+				}
+				box.Objects[1].(*fyne.Container).Objects[0].(*widget.Label).SetText(filter)
+				box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label).SetText(stocktakeStatusLabel(st.Status))
+				box.Objects[3].(*fyne.Container).Objects[0].(*widget.Label).SetText(fmt.Sprintf("%d of %d counted", st.Counted(), len(st.Lines)))
+				box.Objects[4].(*widget.Label).SetText(st.CreatedAt.Format("2006-01-02 15:04"))
+			}
+		},
+	)
+
+	list.OnSelected = func(id widget.ListItemID) {
+		selectedID = id
+	}
+
+	refreshList := func() {
+		db := appState.GetDB().(*database.Database)
+		all, err := stocktake.GetStocktakes(db)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		stocktakes = all
+		list.UnselectAll()
+		list.Refresh()
+		selectedID = -1
+	}
+
+	selectedStocktake := func(action string) *models.Stocktake {
+		if selectedID < 0 || selectedID >= len(stocktakes) {
+			dialog.ShowInformation("No Selection", fmt.Sprintf("Please select a stocktake to %s", action), parent)
+			return nil
+		}
+		return &stocktakes[selectedID]
+	}
+
+	newBtn := widget.NewButton("New Stocktake", func() {
+		filterEntry := widget.NewEntry()
+		filterEntry.SetPlaceHolder("Name or code contains... (blank for all items)")
+
+		formContent := container.NewVBox(
+			createStyledFormField("Items", filterEntry),
+		)
+
+		showStyledDialog(parent, "New Stocktake", formContent, "Open", func() {
+			db := appState.GetDB().(*database.Database)
+			st, err := stocktake.OpenStocktake(db, user.ID, filterEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			refreshList()
+			showStocktakeCountWindow(parent, appState, st.ID, refreshList)
+		}, nil)
+	})
+	countBtn := widget.NewButton("Count", func() {
+		if st := selectedStocktake("count"); st != nil {
+			showStocktakeCountWindow(parent, appState, st.ID, refreshList)
+		}
+	})
+	reportBtn := widget.NewButton("Variance Report", func() {
+		if st := selectedStocktake("review"); st != nil {
+			showVarianceReport(parent, appState, user, st, refreshList)
+		}
+	})
+	cancelBtn := widget.NewButton("Cancel Stocktake", func() {
+		st := selectedStocktake("cancel")
+		if st == nil {
+			return
+		}
+		dialog.ShowConfirm("Cancel Stocktake", fmt.Sprintf("Are you sure you want to cancel count #%d? No stock will be changed.", st.ID), func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			db := appState.GetDB().(*database.Database)
+			if err := stocktake.CancelStocktake(db, st.ID, user.ID); err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			refreshList()
+		}, parent)
+	})
+	refreshBtn := widget.NewButton("Refresh", refreshList)
+
+	refreshList()
+	return container.NewBorder(
+		nil,
+		container.NewHBox(newBtn, countBtn, reportBtn, cancelBtn, refreshBtn),
+		nil,
+		nil,
+		list,
+	)
+}
+
+// showStocktakeCountWindow lets items be counted by scanning their codes, one
+// unit per scan, or by typing a count for the selected line
+func showStocktakeCountWindow(parent fyne.Window, appState *auth.AppState, stocktakeID int, onChange func()) {
+	user := appState.GetCurrentUser()
+	db := appState.GetDB().(*database.Database)
+	st, err := stocktake.GetStocktakeByID(db, stocktakeID)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+	if st.Status != models.StocktakeOpen {
+		dialog.ShowInformation("Stocktake Closed", fmt.Sprintf("Count #%d is %s and can no longer be counted", st.ID, stocktakeStatusLabel(st.Status)), parent)
+		return
+	}
+
+	countWindow := fyne.CurrentApp().NewWindow(fmt.Sprintf("Stocktake - Count #%d", st.ID))
+	countWindow.Resize(fyne.NewSize(700, 600))
+	countWindow.CenterOnScreen()
+
+	lines := st.Lines
+	var selectedLine widget.ListItemID = -1
+	progressLabel := widget.NewLabel("")
+	progressLabel.TextStyle = fyne.TextStyle{Bold: true}
+
+	list := widget.NewList(
+		func() int {
+			return len(lines)
+		},
+		func() fyne.CanvasObject {
+			nameLabel := widget.NewLabel("")
+			nameLabel.Resize(fyne.NewSize(200, nameLabel.MinSize().Height))
+			codeLabel := widget.NewLabel("")
+			codeLabel.Resize(fyne.NewSize(120, codeLabel.MinSize().Height))
+			return container.NewHBox(
+				container.NewBorder(nil, nil, nil, nil, nameLabel),
+				container.NewBorder(nil, nil, nil, nil, codeLabel),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(lines) {
+				line := lines[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*fyne.Container).Objects[0].(*widget.Label).SetText(line.ItemName)
+				box.Objects[1].(*fyne.Container).Objects[0].(*widget.Label).SetText(line.ItemCode)
+				counted := "not counted"
+				if line.CountedQuantity != nil {
+					counted = fmt.Sprintf("counted %d", *line.CountedQuantity)
+				}
+				box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Expected %d, %s", line.ExpectedQuantity, counted))
+			}
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		selectedLine = id
+	}
+
+	reload := func() {
+		updated, err := stocktake.GetStocktakeByID(db, stocktakeID)
+		if err != nil {
+			dialog.ShowError(err, countWindow)
+			return
+		}
+		lines = updated.Lines
+		progressLabel.SetText(fmt.Sprintf("%d of %d items counted", updated.Counted(), len(lines)))
+		list.Refresh()
+		onChange()
+	}
+
+	// Scans are counted whichever field in the window has focus
+	var codeEntry *scanEntry
+	scanner := newScannerInput(countWindow, appState.GetConfig(), func(code string) {
+		codeEntry.OnSubmitted(code)
+	})
+	scanner.attach()
+	codeEntry, uploadBtn := newCodeEntry(countWindow, scanner, func(code string) {
+		if _, err := stocktake.AddCountByCode(db, stocktakeID, code, 1, user.ID); err != nil {
+			dialog.ShowError(fmt.Errorf("%s: %v", code, err), countWindow)
+			return
+		}
+		codeEntry.SetText("")
+		reload()
+	})
+
+	countEntry := widget.NewEntry()
+	countEntry.SetPlaceHolder("Counted quantity")
+	setCountBtn := widget.NewButton("Set Count", func() {
+		if selectedLine < 0 || selectedLine >= len(lines) {
+			dialog.ShowInformation("No Selection", "Please select an item to set its count", countWindow)
+			return
+		}
+		quantity, err := strconv.Atoi(strings.TrimSpace(countEntry.Text))
+		if err != nil || quantity < 0 {
+			dialog.ShowError(fmt.Errorf("invalid count"), countWindow)
+			return
+		}
+		if err := stocktake.SetCount(db, stocktakeID, lines[selectedLine].ItemID, quantity, user.ID); err != nil {
+			dialog.ShowError(err, countWindow)
+			return
+		}
+		countEntry.SetText("")
+		reload()
+	})
+
+	closeBtn := widget.NewButton("Close", func() {
+		countWindow.Close()
+	})
+
+	countWindow.SetContent(container.NewPadded(container.NewBorder(
+		container.NewVBox(
+			widget.NewLabel("Scan or Enter Code (adds one per scan):"),
+			codeEntry,
+			uploadBtn,
+			widget.NewSeparator(),
+			progressLabel,
+		),
+		container.NewVBox(
+			widget.NewSeparator(),
+			container.NewBorder(nil, nil, nil, setCountBtn, countEntry),
+			container.NewPadded(closeBtn),
+		),
+		nil,
+		nil,
+		list,
+	)))
+	reload()
+	countWindow.Show()
+	countWindow.Canvas().Focus(codeEntry)
+}
+
+// showVarianceReport shows how the counts differ from the system and, for an
+// open stocktake, offers to post them as stock adjustments
+func showVarianceReport(parent fyne.Window, appState *auth.AppState, user *models.User, st *models.Stocktake, onPosted func()) {
+	db := appState.GetDB().(*database.Database)
+	report, err := stocktake.GetVarianceReport(db, st.ID)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+
+	var details []string
+	for _, v := range report.Variances {
+		line := fmt.Sprintf("%s: counted %d, expected %d, difference %+d (%s)",
+			v.Line.ItemName, *v.Line.CountedQuantity, v.Line.ExpectedQuantity, v.Difference, v.Value.Format())
+		if v.SystemQuantity != v.Line.ExpectedQuantity {
+			line += fmt.Sprintf(", %d now", v.SystemQuantity)
+		}
+		if v.BatchQuantity != v.SystemQuantity {
+			line += fmt.Sprintf(" [!] batches total %d", v.BatchQuantity)
+		}
+		details = append(details, line)
+	}
+	if len(details) == 0 {
+		details = append(details, "Nothing has been counted yet.")
+	}
+	details = append(details, "",
+		fmt.Sprintf("Not counted (left unchanged): %d", report.Uncounted),
+		fmt.Sprintf("Shrinkage: %s", report.Shrinkage.Format()),
+		fmt.Sprintf("Surplus: %s", report.Surplus.Format()),
+		fmt.Sprintf("Net: %s", report.Net().Format()),
+	)
+
+	label := widget.NewLabel(strings.Join(details, "\n"))
+	label.Wrapping = fyne.TextWrapWord
+	content := container.NewScroll(label)
+	title := fmt.Sprintf("Variance Report - Count #%d", st.ID)
+
+	if st.Status != models.StocktakeOpen {
+		showStyledDialog(parent, title, content, "OK", nil, nil)
+		return
+	}
+
+	showStyledDialog(parent, title, content, "Post Adjustments", func() {
+		dialog.ShowConfirm("Post Stocktake",
+			fmt.Sprintf("Set %d counted item(s) to their counted quantities? This closes count #%d.", len(report.Variances), st.ID),
+			func(confirmed bool) {
+				if !confirmed {
+					return
+				}
+				posted, err := stocktake.PostStocktake(db, st.ID, user.ID)
+				if err != nil {
+					dialog.ShowError(err, parent)
+					return
+				}
+				showStyledInformation(parent, "Stocktake Posted", fmt.Sprintf("Count #%d posted. Shrinkage %s, net %s.", st.ID, posted.Shrinkage.Format(), posted.Net().Format()))
+				onPosted()
+			}, parent)
+	}, nil)
+}
@@ -1,9 +1,9 @@
 package gui
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -11,43 +11,76 @@ import (
 	"fyne.io/fyne/v2/widget"
 
 	"ims-go/auth"
-	"ims-go/barcode"
+	barcodePkg "ims-go/barcode"
 	"ims-go/database"
 	"ims-go/inventory"
 	"ims-go/models"
+	"ims-go/money"
 	transactionsPkg "ims-go/transactions"
 )
 
+// shortageKey identifies a sale line's stock: an item, and the batch asked
+// for or 0 for none
+type shortageKey struct {
+	itemID  int
+	batchID int
+}
+
+func newShortageKey(itemID int, batchID *int) shortageKey {
+	key := shortageKey{itemID: itemID}
+	if batchID != nil {
+		key.batchID = *batchID
+	}
+	return key
+}
+
 func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *models.User) *container.Scroll {
 	// Transaction items
 	var transactionItems []models.TransactionItem
-	var totalAmount float64
+	var totalAmount money.Money
+
+	// Stock shortages reported by the last failed checkout, keyed by item and
+	// batch, since one batch can run short while the item as a whole doesn't
+	shortages := make(map[shortageKey]transactionsPkg.StockShortage)
 
 	// Transaction items list (declare early)
 	var itemList *widget.List
-	totalLabel := widget.NewLabel("Total: $0.00")
+	totalLabel := widget.NewLabel(fmt.Sprintf("Total: %s", money.Money(0).Format()))
 	totalLabel.TextStyle = fyne.TextStyle{Bold: true}
 
-	// Code entry for barcode/QR scanning
-	codeEntry := widget.NewEntry()
-	codeEntry.SetPlaceHolder("Enter or scan barcode/QR code...")
-	codeEntry.OnSubmitted = func(code string) {
-		code = strings.TrimSpace(code)
-		if code == "" {
-			return
+	// Scans are looked up whichever field has focus, while this tab is showing
+	var tab *container.Scroll
+	var codeEntry *scanEntry
+	scanner := newScannerInput(parent, appState.GetConfig(), func(code string) {
+		if tab.Visible() {
+			codeEntry.OnSubmitted(code)
 		}
+	})
+	scanner.attach()
 
+	// Code entry for barcode/QR scanning
+	codeEntry, uploadBtn := newCodeEntry(parent, scanner, func(code string) {
 		db := appState.GetDB().(*database.Database)
-		item, err := inventory.GetItemByCode(db, code)
+		item, barcode, scan, err := inventory.LookupScan(db, code)
+		if err != nil && !errors.Is(err, inventory.ErrItemNotFound) {
+			dialog.ShowError(err, parent)
+			return
+		}
 		if err != nil {
+			// A GS1 code gives the new item its GTIN, as short as it goes
+			if parsed, err := barcodePkg.ParseGS1(code); err == nil && parsed.GTIN != "" {
+				forms := barcodePkg.GTINForms(parsed.GTIN)
+				code = forms[len(forms)-1]
+			}
+
 			// Item not found - ask to add it
-			dialog.ShowConfirm("Item Not Found", 
+			dialog.ShowConfirm("Item Not Found",
 				fmt.Sprintf("Item with code '%s' not found. Would you like to add it to inventory?", code),
 				func(confirmed bool) {
 					if confirmed {
-						showAddItemFromTransactionDialog(parent, appState, code, func(newItem *models.Item) {
+						showAddItemFromTransactionDialog(parent, appState, user, code, func(newItem *models.Item) {
 							// Add to transaction after creating
-							addItemToTransaction(newItem, 1, &transactionItems, &totalAmount, itemList, totalLabel)
+							addItemToTransaction(newItem, nil, 1, nil, &transactionItems, &totalAmount, itemList, totalLabel)
 							codeEntry.SetText("")
 						})
 					} else {
@@ -57,8 +90,37 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 			return
 		}
 
+		// A product with variants is sold as one of them
+		if variants, err := inventory.GetVariants(db, item.ID); err == nil && len(variants) > 0 {
+			showVariantSelectionDialog(parent, item, variants, func(variant *models.Item) {
+				addItemToTransaction(variant, nil, scannedQuantity(db, variant, barcode), nil, &transactionItems, &totalAmount, itemList, totalLabel)
+			})
+			codeEntry.SetText("")
+			return
+		}
+		quantity := scannedQuantity(db, item, barcode)
+		var lineTotal *money.Money
+		if scan != nil {
+			quantity, lineTotal, err = scannedLine(db, item, scan, quantity)
+			if err != nil {
+				dialog.ShowError(err, parent)
+				codeEntry.SetText("")
+				return
+			}
+		}
+
 		// Item found - check for different expiry dates
 		batches, err := inventory.GetItemStockBatches(db, item.ID)
+		if err == nil && scan != nil && scan.Expiry != nil {
+			// The code says which batch the pack came from
+			for _, batch := range batches {
+				if batch.ExpiryDate != nil && batch.ExpiryDate.Equal(*scan.Expiry) {
+					addItemToTransaction(item, &batch.ID, quantity, lineTotal, &transactionItems, &totalAmount, itemList, totalLabel)
+					codeEntry.SetText("")
+					return
+				}
+			}
+		}
 		if err == nil && len(batches) > 1 {
 			// Check if batches have different expiry dates
 			hasDifferentExpiry := false
@@ -72,10 +134,10 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 					}
 				}
 			}
-			
+
 			if hasDifferentExpiry {
 				showItemStockSelectionDialog(parent, appState, item, batches, func(selectedBatch *models.ItemStock) {
-					addItemToTransaction(item, 1, &transactionItems, &totalAmount, itemList, totalLabel)
+					addItemToTransaction(item, &selectedBatch.ID, quantity, lineTotal, &transactionItems, &totalAmount, itemList, totalLabel)
 				})
 				codeEntry.SetText("")
 				return
@@ -83,14 +145,14 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 		}
 
 		// Item found - add to transaction
-		addItemToTransaction(item, 1, &transactionItems, &totalAmount, itemList, totalLabel)
+		addItemToTransaction(item, nil, quantity, lineTotal, &transactionItems, &totalAmount, itemList, totalLabel)
 		codeEntry.SetText("")
-	}
+	})
 
 	// Search entry
-	searchEntry := widget.NewEntry()
+	searchEntry := newScanEntry(scanner)
 	searchEntry.SetPlaceHolder("Search by name or code...")
-		var searchResults []models.Item
+	var searchResults []models.Item
 	var searchList *widget.List
 
 	// Create search list first with aligned columns
@@ -122,12 +184,19 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 				codeLabel.SetText(item.Code)
 				codeLabel.Resize(fyne.NewSize(150, codeLabel.MinSize().Height))
 				priceLabel := box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label)
-				priceLabel.SetText(fmt.Sprintf("$%.2f", item.Price))
+				priceLabel.SetText(item.Price.Format())
 				priceLabel.Resize(fyne.NewSize(100, priceLabel.MinSize().Height))
 				btn := box.Objects[3].(*fyne.Container).Objects[0].(*widget.Button)
 				btn.OnTapped = func() {
-					// Check for different expiry dates
 					db := appState.GetDB().(*database.Database)
+					if variants, err := inventory.GetVariants(db, item.ID); err == nil && len(variants) > 0 {
+						showVariantSelectionDialog(parent, &item, variants, func(variant *models.Item) {
+							addItemToTransaction(variant, nil, 1, nil, &transactionItems, &totalAmount, itemList, totalLabel)
+						})
+						return
+					}
+
+					// Check for different expiry dates
 					batches, err := inventory.GetItemStockBatches(db, item.ID)
 					if err == nil && len(batches) > 1 {
 						hasDifferentExpiry := false
@@ -141,34 +210,30 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 								}
 							}
 						}
-						
+
 						if hasDifferentExpiry {
 							showItemStockSelectionDialog(parent, appState, &item, batches, func(selectedBatch *models.ItemStock) {
-								addItemToTransaction(&item, 1, &transactionItems, &totalAmount, itemList, totalLabel)
+								addItemToTransaction(&item, &selectedBatch.ID, 1, nil, &transactionItems, &totalAmount, itemList, totalLabel)
 							})
 							return
 						}
 					}
 					// Add item to transaction (will increment if already exists)
-					addItemToTransaction(&item, 1, &transactionItems, &totalAmount, itemList, totalLabel)
+					addItemToTransaction(&item, nil, 1, nil, &transactionItems, &totalAmount, itemList, totalLabel)
 				}
 			}
 		},
 	)
 
 	// Load all items by default
+	var filters *itemFilterBar
 	loadSearchResults := func() {
-		query := strings.TrimSpace(searchEntry.Text)
-		var items []models.Item
-		var err error
-
 		db := appState.GetDB().(*database.Database)
-		if query == "" {
-			items, err = inventory.GetAllItems(db)
-		} else {
-			items, err = inventory.SearchItems(db, query)
-		}
-
+		items, err := inventory.FilterItems(db, inventory.ItemFilter{
+			Query:      searchEntry.Text,
+			CategoryID: filters.categoryID(),
+			Tag:        filters.tag(),
+		})
 		if err == nil {
 			searchResults = items
 			searchList.Refresh()
@@ -179,6 +244,9 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 		loadSearchResults()
 	}
 
+	filters = newItemFilterBar(loadSearchResults)
+	filters.reload(appState.GetDB().(*database.Database))
+
 	// Load all items initially
 	loadSearchResults()
 
@@ -190,94 +258,124 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 		func() fyne.CanvasObject {
 			// Create all widgets
 			itemLabel := widget.NewLabel("")
-			qtyEntry := widget.NewEntry()
+			qtyEntry := newScanEntry(scanner)
 			priceLabel := widget.NewLabel("")
+			stockLabel := widget.NewLabel("")
+			stockLabel.TextStyle = fyne.TextStyle{Bold: true}
 			removeBtn := widget.NewButton("Remove", nil)
-			
+
 			// Create fixed-width containers for each column
 			// Item name column: 200px - set width during creation
 			itemLabelContainer := container.NewBorder(nil, nil, nil, nil, itemLabel)
 			itemLabelContainer.Resize(fyne.NewSize(200, 0))
-			
+
 			// Quantity entry column: 80px - constrain entry to prevent expansion
 			qtyContainer := container.NewBorder(nil, nil, nil, nil, qtyEntry)
 			qtyContainer.Resize(fyne.NewSize(80, 0))
-			
+
 			// Price column: 100px
 			priceLabelContainer := container.NewBorder(nil, nil, nil, nil, priceLabel)
 			priceLabelContainer.Resize(fyne.NewSize(100, 0))
-			
+
 			// Remove button - no fixed width, let it size naturally
 			removeBtnContainer := container.NewBorder(nil, nil, nil, nil, removeBtn)
-			
+
+			// Stock warning column - only filled in after a failed checkout
+			stockLabelContainer := container.NewBorder(nil, nil, nil, nil, stockLabel)
+
 			// Use HBox with fixed-width containers
 			return container.NewHBox(
 				itemLabelContainer,
 				qtyContainer,
 				priceLabelContainer,
 				removeBtnContainer,
+				stockLabelContainer,
 			)
 		},
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
 			if id < len(transactionItems) {
 				ti := transactionItems[id]
 				box := obj.(*fyne.Container)
-				
+
 				// Update item label
 				itemLabelContainer := box.Objects[0].(*fyne.Container)
 				itemLabel := itemLabelContainer.Objects[0].(*widget.Label)
-				itemLabel.SetText(ti.ItemName)
-				
+				name := ti.ItemName
+				if ti.Unit != "" {
+					name = fmt.Sprintf("%s per %s", name, ti.Unit)
+				}
+				if ti.BatchID != nil {
+					itemLabel.SetText(fmt.Sprintf("%s (batch #%d)", name, *ti.BatchID))
+				} else {
+					itemLabel.SetText(name)
+				}
+
 				// Update quantity entry - get the container and entry
 				qtyContainer := box.Objects[1].(*fyne.Container)
-				qtyEntry := qtyContainer.Objects[0].(*widget.Entry)
-				
+				qtyEntry := qtyContainer.Objects[0].(*scanEntry)
+
 				// Store the current item ID to avoid closure issues
 				currentID := id
-				
+
 				// Update entry text - just update the text, don't resize
-				qtyEntry.SetText(fmt.Sprintf("%d", ti.Quantity))
-				
+				qtyEntry.SetText(inventory.FormatQuantity(ti.Quantity))
+
 				// Update price label
 				priceLabelContainer := box.Objects[2].(*fyne.Container)
 				priceLabel := priceLabelContainer.Objects[0].(*widget.Label)
-				
+
 				// Function to update price when quantity changes
-				updatePrice := func(qty int) {
-					priceLabel.SetText(fmt.Sprintf("$%.2f", ti.Price*float64(qty)))
-					totalAmount = 0
-					for _, item := range transactionItems {
-						totalAmount += item.Price * float64(item.Quantity)
-					}
-					totalLabel.SetText(fmt.Sprintf("Total: $%.2f", totalAmount))
+				updatePrice := func(qty float64) {
+					priceLabel.SetText(ti.Price.MulFloat(qty).Format())
+					totalAmount = cartTotal(transactionItems)
+					totalLabel.SetText(fmt.Sprintf("Total: %s", totalAmount.Format()))
+				}
+
+				// Set initial price. A labelled pack is sold as printed, so
+				// its quantity can't be changed.
+				priceLabel.SetText(ti.Total().Format())
+				if ti.LineTotal != nil {
+					qtyEntry.Disable()
+				} else {
+					qtyEntry.Enable()
+				}
+
+				// Show the stock shortage for this line, if any
+				stockLabel := box.Objects[4].(*fyne.Container).Objects[0].(*widget.Label)
+				if shortage, ok := shortages[newShortageKey(ti.ItemID, ti.BatchID)]; ok && shortage.BatchID != nil {
+					stockLabel.SetText(fmt.Sprintf("[!] Only %s %s in batch #%d", inventory.FormatQuantity(shortage.SaleQuantity(shortage.Available)), shortage.Unit, *shortage.BatchID))
+				} else if ok {
+					stockLabel.SetText(fmt.Sprintf("[!] Only %s %s in stock", inventory.FormatQuantity(shortage.SaleQuantity(shortage.Available)), shortage.Unit))
+				} else {
+					stockLabel.SetText("")
 				}
-				
-				// Set initial price
-				priceLabel.SetText(fmt.Sprintf("$%.2f", ti.Price*float64(ti.Quantity)))
-				
+
 				// Set up quantity entry change handler
 				qtyEntry.OnChanged = func(text string) {
-					newQty, err := strconv.Atoi(text)
+					newQty, err := strconv.ParseFloat(text, 64)
 					if err == nil && newQty > 0 && currentID < len(transactionItems) {
 						transactionItems[currentID].Quantity = newQty
+						// Shortages are in base units, so checkout checks the new quantity again
+						if _, ok := shortages[newShortageKey(ti.ItemID, ti.BatchID)]; ok {
+							delete(shortages, newShortageKey(ti.ItemID, ti.BatchID))
+							stockLabel.SetText("")
+						}
 						updatePrice(newQty)
 						// Don't call Refresh() here as it can cause the field to disappear
 					}
 				}
-				
+
 				// Set up remove button
 				removeBtnContainer := box.Objects[3].(*fyne.Container)
 				btn := removeBtnContainer.Objects[0].(*widget.Button)
 				btn.OnTapped = func() {
 					if currentID < len(transactionItems) {
 						// Remove item
+						delete(shortages, newShortageKey(transactionItems[currentID].ItemID, transactionItems[currentID].BatchID))
 						transactionItems = append(transactionItems[:currentID], transactionItems[currentID+1:]...)
-						totalAmount = 0
-						for _, item := range transactionItems {
-							totalAmount += item.Price * float64(item.Quantity)
-						}
+						totalAmount = cartTotal(transactionItems)
 						itemList.Refresh()
-						totalLabel.SetText(fmt.Sprintf("Total: $%.2f", totalAmount))
+						totalLabel.SetText(fmt.Sprintf("Total: %s", totalAmount.Format()))
 					}
 				}
 			}
@@ -287,9 +385,10 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 	// Buttons
 	clearBtn := widget.NewButton("Clear Transaction", func() {
 		transactionItems = []models.TransactionItem{}
+		shortages = make(map[shortageKey]transactionsPkg.StockShortage)
 		totalAmount = 0
 		itemList.Refresh()
-		totalLabel.SetText("Total: $0.00")
+		totalLabel.SetText(fmt.Sprintf("Total: %s", totalAmount.Format()))
 	})
 
 	completeBtn := widget.NewButton("Complete Transaction", func() {
@@ -307,39 +406,25 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 		db := appState.GetDB().(*database.Database)
 		_, err := transactionsPkg.CreateTransaction(db, user.ID, transactionItems)
 		if err != nil {
+			// Mark each line that cannot be fulfilled so the cashier can adjust it
+			var stockErr *transactionsPkg.ErrInsufficientStock
+			if errors.As(err, &stockErr) {
+				shortages = make(map[shortageKey]transactionsPkg.StockShortage)
+				for _, shortage := range stockErr.Items {
+					shortages[newShortageKey(shortage.ItemID, shortage.BatchID)] = shortage
+				}
+				itemList.Refresh()
+			}
 			dialog.ShowError(err, parent)
 			return
 		}
 
-		showStyledInformation(parent, "Success", fmt.Sprintf("Transaction completed. Total: $%.2f", totalAmount))
+		showStyledInformation(parent, "Success", fmt.Sprintf("Transaction completed. Total: %s", totalAmount.Format()))
 		transactionItems = []models.TransactionItem{}
+		shortages = make(map[shortageKey]transactionsPkg.StockShortage)
 		totalAmount = 0
 		itemList.Refresh()
-		totalLabel.SetText("Total: $0.00")
-	})
-
-	// File upload button for barcode/QR image
-	uploadBtn := widget.NewButton("Upload Barcode/QR Image", func() {
-		showFilePickerWithMemory(parent, func(reader fyne.URIReadCloser, err error) {
-			if err != nil {
-				return
-			}
-			if reader == nil {
-				return
-			}
-			defer reader.Close()
-
-			// Read image and decode barcode/QR
-			code, err := barcode.DecodeBarcodeFromImage(reader.URI().Path())
-			if err != nil {
-				dialog.ShowError(fmt.Errorf("failed to decode barcode/QR: %v", err), parent)
-				return
-			}
-
-			// Process the code
-			codeEntry.SetText(code)
-			codeEntry.OnSubmitted(code)
-		})
+		totalLabel.SetText(fmt.Sprintf("Total: %s", totalAmount.Format()))
 	})
 
 	// Column headers for search results with fixed widths
@@ -368,6 +453,7 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 			widget.NewSeparator(),
 			widget.NewLabel("Search Items:"),
 			searchEntry,
+			filters.widget(),
 			widget.NewSeparator(),
 			searchHeaderRow,
 			widget.NewSeparator(),
@@ -415,41 +501,85 @@ func createTransactionTab(parent fyne.Window, appState *auth.AppState, user *mod
 	content := container.NewHSplit(leftPanel, rightPanel)
 	content.SetOffset(0.4)
 
-	return container.NewScroll(content)
+	tab = container.NewScroll(content)
+	return tab
+}
+
+// scannedQuantity is how much of an item one scan adds to the cart, in the
+// unit it is sold in. A barcode such as a case barcode can stand for several
+// base units; anything else adds one.
+func scannedQuantity(db *database.Database, item *models.Item, barcode *models.ItemBarcode) float64 {
+	if barcode == nil || barcode.Quantity == nil {
+		return 1
+	}
+	factor, err := inventory.UnitFactor(db, item.ID, item.SaleUnit)
+	if err != nil {
+		return float64(*barcode.Quantity)
+	}
+	return float64(*barcode.Quantity) / float64(factor)
+}
+
+// scannedLine applies what a GS1 code says to the line it adds: an embedded
+// weight is the quantity and an embedded price the total for the pack,
+// charged as printed rather than turned back into a price per unit
+func scannedLine(db *database.Database, item *models.Item, scan *barcodePkg.GS1, quantity float64) (float64, *money.Money, error) {
+	if scan.Weight != nil {
+		weighed, err := inventory.WeightQuantity(db, item, *scan.Weight)
+		if err != nil {
+			return 0, nil, err
+		}
+		if weighed <= 0 {
+			return 0, nil, fmt.Errorf("the label for %s has no weight", item.Name)
+		}
+		quantity = weighed
+	}
+	return quantity, scan.Price, nil
 }
 
-func addItemToTransaction(item *models.Item, quantity int, transactionItems *[]models.TransactionItem, totalAmount *float64, itemList *widget.List, totalLabel *widget.Label) {
+// addItemToTransaction adds quantity of an item to the cart. batchID picks the
+// stock batch to sell from; nil leaves the choice to checkout. lineTotal is
+// the price on a label for the whole line, or nil to charge the item's price.
+// Lines are only merged when the item, the batch and the price all match and
+// neither came from a priced label.
+func addItemToTransaction(item *models.Item, batchID *int, quantity float64, lineTotal *money.Money, transactionItems *[]models.TransactionItem, totalAmount *money.Money, itemList *widget.List, totalLabel *widget.Label) {
 	// Check if item already in transaction
 	for i, ti := range *transactionItems {
-		if ti.ItemID == item.ID {
+		sameBatch := (ti.BatchID == nil && batchID == nil) || (ti.BatchID != nil && batchID != nil && *ti.BatchID == *batchID)
+		if ti.ItemID == item.ID && sameBatch && ti.Price == item.Price && ti.LineTotal == nil && lineTotal == nil {
 			(*transactionItems)[i].Quantity += quantity
-			*totalAmount = 0
-			for _, tItem := range *transactionItems {
-				*totalAmount += tItem.Price * float64(tItem.Quantity)
-			}
+			*totalAmount = cartTotal(*transactionItems)
 			itemList.Refresh()
-			totalLabel.SetText(fmt.Sprintf("Total: $%.2f", *totalAmount))
+			totalLabel.SetText(fmt.Sprintf("Total: %s", (*totalAmount).Format()))
 			return
 		}
 	}
 
 	// Add new item
 	*transactionItems = append(*transactionItems, models.TransactionItem{
-		ItemID:   item.ID,
-		ItemName: item.Name,
-		Quantity: quantity,
-		Price:    item.Price,
+		ItemID:    item.ID,
+		ItemName:  item.Name,
+		Quantity:  quantity,
+		Unit:      item.SaleUnit,
+		Price:     item.Price,
+		BatchID:   batchID,
+		LineTotal: lineTotal,
 	})
 
-	*totalAmount = 0
-	for _, tItem := range *transactionItems {
-		*totalAmount += tItem.Price * float64(tItem.Quantity)
-	}
+	*totalAmount = cartTotal(*transactionItems)
 	itemList.Refresh()
-	totalLabel.SetText(fmt.Sprintf("Total: $%.2f", *totalAmount))
+	totalLabel.SetText(fmt.Sprintf("Total: %s", (*totalAmount).Format()))
+}
+
+// cartTotal is what the lines in the cart come to
+func cartTotal(items []models.TransactionItem) money.Money {
+	var total money.Money
+	for _, item := range items {
+		total += item.Total()
+	}
+	return total
 }
 
-func showAddItemFromTransactionDialog(parent fyne.Window, appState *auth.AppState, code string, onSuccess func(*models.Item)) {
+func showAddItemFromTransactionDialog(parent fyne.Window, appState *auth.AppState, user *models.User, code string, onSuccess func(*models.Item)) {
 	nameEntry := widget.NewEntry()
 	nameEntry.SetPlaceHolder("Item Name")
 	descEntry := widget.NewMultiLineEntry()
@@ -472,13 +602,13 @@ func showAddItemFromTransactionDialog(parent fyne.Window, appState *auth.AppStat
 	)
 
 	onAction := func() {
-		price, err := strconv.ParseFloat(priceEntry.Text, 64)
+		price, err := money.Parse(priceEntry.Text)
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("invalid price"), parent)
 			return
 		}
 
-		cost, err := strconv.ParseFloat(costEntry.Text, 64)
+		cost, err := money.Parse(costEntry.Text)
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("invalid cost"), parent)
 			return
@@ -491,7 +621,7 @@ func showAddItemFromTransactionDialog(parent fyne.Window, appState *auth.AppStat
 		}
 
 		db := appState.GetDB().(*database.Database)
-		item, err := inventory.CreateItem(db, nameEntry.Text, code, descEntry.Text, price, cost, quantity)
+		item, err := inventory.CreateItem(db, nameEntry.Text, code, descEntry.Text, price, cost, quantity, user.ID)
 		if err != nil {
 			dialog.ShowError(err, parent)
 			return
@@ -503,4 +633,3 @@ func showAddItemFromTransactionDialog(parent fyne.Window, appState *auth.AppStat
 
 	showStyledDialog(parent, "Add New Item", formContent, "Add", onAction, nil)
 }
-
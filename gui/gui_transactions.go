@@ -2,6 +2,8 @@ package gui
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -12,6 +14,7 @@ import (
 
 	"ims-go/auth"
 	"ims-go/database"
+	"ims-go/inventory"
 	"ims-go/models"
 	transactionsPkg "ims-go/transactions"
 )
@@ -19,17 +22,25 @@ import (
 func createTransactionLogTab(parent fyne.Window, appState *auth.AppState, user *models.User) *container.Scroll {
 	var transactions []models.Transaction
 
-	list := widget.NewList(
+	// Voided sales are hidden unless asked for
+	showVoidedCheck := widget.NewCheck("Show voided", nil)
+
+	var list *widget.List
+	list = widget.NewList(
 		func() int {
 			var err error
 			db := appState.GetDB().(*database.Database)
-			txns, err := transactionsPkg.GetRecentTransactions(db, 1000)
-			if err == nil {
-				transactions = txns
-			}
+			txns, err := transactionsPkg.GetRecentTransactions(db, appState.GetConfig().TransactionLogLimit)
 			if err != nil {
 				return 0
 			}
+			transactions = transactions[:0]
+			for _, txn := range txns {
+				if txn.IsVoided() && !showVoidedCheck.Checked {
+					continue
+				}
+				transactions = append(transactions, txn)
+			}
 			return len(transactions)
 		},
 		func() fyne.CanvasObject {
@@ -50,17 +61,28 @@ func createTransactionLogTab(parent fyne.Window, appState *auth.AppState, user *
 				txn := transactions[id]
 				box := obj.(*fyne.Container)
 				idLabel := box.Objects[0].(*fyne.Container).Objects[0].(*widget.Label)
-				idLabel.SetText(fmt.Sprintf("#%d", txn.ID))
+				if txn.IsVoided() {
+					idLabel.SetText(fmt.Sprintf("#%d (VOID)", txn.ID))
+				} else {
+					idLabel.SetText(fmt.Sprintf("#%d", txn.ID))
+				}
 				idLabel.Resize(fyne.NewSize(80, idLabel.MinSize().Height))
 				dateLabel := box.Objects[1].(*fyne.Container).Objects[0].(*widget.Label)
 				dateLabel.SetText(txn.CreatedAt.Format("2006-01-02 15:04:05"))
 				dateLabel.Resize(fyne.NewSize(180, dateLabel.MinSize().Height))
 				totalLabel := box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label)
-				totalLabel.SetText(fmt.Sprintf("$%.2f", txn.TotalAmount))
+				// Show the net amount so refunded sales are not counted twice
+				if txn.IsVoided() {
+					totalLabel.SetText(fmt.Sprintf("VOID (%s)", txn.TotalAmount.Format()))
+				} else if txn.RefundedAmount > 0 {
+					totalLabel.SetText(fmt.Sprintf("%s (refunded %s)", txn.NetAmount().Format(), txn.RefundedAmount.Format()))
+				} else {
+					totalLabel.SetText(txn.TotalAmount.Format())
+				}
 				totalLabel.Resize(fyne.NewSize(120, totalLabel.MinSize().Height))
 				btn := box.Objects[3].(*fyne.Container).Objects[0].(*widget.Button)
 				btn.OnTapped = func() {
-					showTransactionDetails(parent, appState, &txn)
+					showTransactionDetails(parent, appState, &txn, list.Refresh)
 				}
 			}
 		},
@@ -73,7 +95,7 @@ func createTransactionLogTab(parent fyne.Window, appState *auth.AppState, user *
 		now := time.Now().UnixNano()
 		if id == lastSelectedID && (now-lastSelectedTime) < 500000000 { // 500ms double-click window
 			if id < len(transactions) {
-				showTransactionDetails(parent, appState, &transactions[id])
+				showTransactionDetails(parent, appState, &transactions[id], list.Refresh)
 			}
 		}
 		lastSelectedID = id
@@ -84,6 +106,10 @@ func createTransactionLogTab(parent fyne.Window, appState *auth.AppState, user *
 		list.Refresh()
 	})
 
+	showVoidedCheck.OnChanged = func(_ bool) {
+		list.Refresh()
+	}
+
 	// Column headers for transaction log with fixed widths
 	idHeader := widget.NewLabel("ID")
 	idHeader.TextStyle = fyne.TextStyle{Bold: true}
@@ -108,7 +134,7 @@ func createTransactionLogTab(parent fyne.Window, appState *auth.AppState, user *
 			headerRow,
 			widget.NewSeparator(),
 		),
-		refreshBtn,
+		container.NewHBox(refreshBtn, showVoidedCheck),
 		nil,
 		nil,
 		list,
@@ -118,7 +144,7 @@ func createTransactionLogTab(parent fyne.Window, appState *auth.AppState, user *
 	return container.NewScroll(content)
 }
 
-func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *models.Transaction) {
+func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *models.Transaction, onChange func()) {
 	// Reload transaction with items
 	db := appState.GetDB().(*database.Database)
 	fullTxn, err := transactionsPkg.GetTransactionByID(db, txn.ID)
@@ -137,14 +163,29 @@ func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *mo
 	transactionIDLabel.TextStyle = fyne.TextStyle{Bold: true}
 	dateLabel := widget.NewLabel(fmt.Sprintf("Date: %s", fullTxn.CreatedAt.Format("2006-01-02 15:04:05")))
 	dateLabel.TextStyle = fyne.TextStyle{Bold: true}
-	totalLabel := widget.NewLabel(fmt.Sprintf("Total: $%.2f", fullTxn.TotalAmount))
+	totalLabel := widget.NewLabel(fmt.Sprintf("Total: %s", fullTxn.TotalAmount.Format()))
 	totalLabel.TextStyle = fyne.TextStyle{Bold: true}
-	
+
 	infoSection := container.NewVBox(
 		container.NewPadded(transactionIDLabel),
 		container.NewPadded(dateLabel),
 		container.NewPadded(totalLabel),
 	)
+	if fullTxn.IsVoided() {
+		voidText := fmt.Sprintf("VOIDED: %s", fullTxn.VoidReason)
+		if fullTxn.VoidedAt != nil {
+			voidText = fmt.Sprintf("VOIDED on %s: %s", fullTxn.VoidedAt.Format("2006-01-02 15:04:05"), fullTxn.VoidReason)
+		}
+		voidLabel := widget.NewLabel(voidText)
+		voidLabel.TextStyle = fyne.TextStyle{Bold: true}
+		voidLabel.Wrapping = fyne.TextWrapWord
+		infoSection.Add(container.NewPadded(voidLabel))
+	}
+	if fullTxn.RefundedAmount > 0 {
+		refundedLabel := widget.NewLabel(fmt.Sprintf("Refunded: %s    Net: %s", fullTxn.RefundedAmount.Format(), fullTxn.NetAmount().Format()))
+		refundedLabel.TextStyle = fyne.TextStyle{Bold: true}
+		infoSection.Add(container.NewPadded(refundedLabel))
+	}
 
 	// Column headers for items with fixed widths
 	itemNameHeader := widget.NewLabel("Item Name")
@@ -156,10 +197,13 @@ func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *mo
 	subtotalHeader := widget.NewLabel("Subtotal")
 	subtotalHeader.TextStyle = fyne.TextStyle{Bold: true}
 	subtotalHeader.Resize(fyne.NewSize(120, subtotalHeader.MinSize().Height))
+	refundedHeader := widget.NewLabel("Refunded")
+	refundedHeader.TextStyle = fyne.TextStyle{Bold: true}
 	itemsHeaderRow := container.NewHBox(
 		container.NewBorder(nil, nil, nil, nil, itemNameHeader),
 		container.NewBorder(nil, nil, nil, nil, qtyHeader),
 		container.NewBorder(nil, nil, nil, nil, subtotalHeader),
+		container.NewBorder(nil, nil, nil, nil, refundedHeader),
 	)
 
 	itemsList := widget.NewList(
@@ -170,10 +214,12 @@ func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *mo
 			itemLabel := widget.NewLabel("")
 			qtyLabel := widget.NewLabel("")
 			subtotalLabel := widget.NewLabel("")
+			refundedLabel := widget.NewLabel("")
 			return container.NewHBox(
 				container.NewBorder(nil, nil, nil, nil, itemLabel),
 				container.NewBorder(nil, nil, nil, nil, qtyLabel),
 				container.NewBorder(nil, nil, nil, nil, subtotalLabel),
+				container.NewBorder(nil, nil, nil, nil, refundedLabel),
 			)
 		},
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
@@ -184,11 +230,17 @@ func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *mo
 				itemLabel.SetText(item.ItemName)
 				itemLabel.Resize(fyne.NewSize(200, itemLabel.MinSize().Height))
 				qtyLabel := box.Objects[1].(*fyne.Container).Objects[0].(*widget.Label)
-				qtyLabel.SetText(fmt.Sprintf("%d", item.Quantity))
+				qtyLabel.SetText(strings.TrimSpace(inventory.FormatQuantity(item.Quantity) + " " + item.Unit))
 				qtyLabel.Resize(fyne.NewSize(100, qtyLabel.MinSize().Height))
 				subtotalLabel := box.Objects[2].(*fyne.Container).Objects[0].(*widget.Label)
-				subtotalLabel.SetText(fmt.Sprintf("$%.2f", item.Price*float64(item.Quantity)))
+				subtotalLabel.SetText(item.Total().Format())
 				subtotalLabel.Resize(fyne.NewSize(120, subtotalLabel.MinSize().Height))
+				refundedLabel := box.Objects[3].(*fyne.Container).Objects[0].(*widget.Label)
+				if item.RefundedQuantity > 0 {
+					refundedLabel.SetText(inventory.FormatQuantity(item.RefundedQuantity))
+				} else {
+					refundedLabel.SetText("")
+				}
 			}
 		},
 	)
@@ -197,6 +249,27 @@ func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *mo
 		detailWindow.Close()
 	})
 
+	afterChange := func() {
+		detailWindow.Close()
+		if onChange != nil {
+			onChange()
+		}
+	}
+
+	refundBtn := widget.NewButton("Refund", func() {
+		showRefundDialog(parent, appState, fullTxn, afterChange)
+	})
+
+	voidBtn := widget.NewButton("Void", func() {
+		showVoidDialog(parent, appState, fullTxn, afterChange)
+	})
+	voidBtn.Importance = widget.DangerImportance
+
+	actionButtons := container.NewHBox(refundBtn, voidBtn, closeBtn)
+	if fullTxn.IsVoided() {
+		actionButtons = container.NewHBox(closeBtn)
+	}
+
 	itemsSection := container.NewBorder(
 		container.NewVBox(
 			widget.NewLabel("Items:"),
@@ -215,7 +288,7 @@ func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *mo
 			container.NewPadded(infoSection),
 			widget.NewSeparator(),
 		),
-		container.NewPadded(closeBtn),
+		container.NewPadded(actionButtons),
 		nil,
 		nil,
 		container.NewPadded(itemsSection),
@@ -225,3 +298,123 @@ func showTransactionDetails(parent fyne.Window, appState *auth.AppState, txn *mo
 	detailWindow.Show()
 }
 
+func showRefundDialog(parent fyne.Window, appState *auth.AppState, txn *models.Transaction, onSuccess func()) {
+	// One quantity entry per line that still has something left to refund
+	var refundable []models.TransactionItem
+	var qtyEntries []*widget.Entry
+	formContent := container.NewVBox()
+	for _, item := range txn.Items {
+		remaining := item.Quantity - item.RefundedQuantity
+		if remaining <= 0 {
+			continue
+		}
+		qtyEntry := widget.NewEntry()
+		qtyEntry.SetPlaceHolder(fmt.Sprintf("0 - %s", inventory.FormatQuantity(remaining)))
+		refundable = append(refundable, item)
+		qtyEntries = append(qtyEntries, qtyEntry)
+		formContent.Add(createStyledFormField(fmt.Sprintf("%s (max %s)", item.ItemName, inventory.FormatQuantity(remaining)), qtyEntry))
+	}
+
+	if len(refundable) == 0 {
+		dialog.ShowInformation("Nothing to Refund", "Every item in this transaction has already been refunded", parent)
+		return
+	}
+
+	onAction := func() {
+		var lines []models.RefundItem
+		for i, qtyEntry := range qtyEntries {
+			text := strings.TrimSpace(qtyEntry.Text)
+			if text == "" {
+				continue
+			}
+			qty, err := strconv.ParseFloat(text, 64)
+			if err != nil || qty < 0 {
+				dialog.ShowError(fmt.Errorf("invalid quantity for %s", refundable[i].ItemName), parent)
+				return
+			}
+			if qty == 0 {
+				continue
+			}
+			lines = append(lines, models.RefundItem{TransactionItemID: refundable[i].ID, Quantity: qty})
+		}
+
+		if len(lines) == 0 {
+			dialog.ShowInformation("Nothing to Refund", "Enter a quantity for at least one item", parent)
+			return
+		}
+
+		user := appState.GetCurrentUser()
+		if user == nil {
+			dialog.ShowError(fmt.Errorf("user not authenticated"), parent)
+			return
+		}
+
+		db := appState.GetDB().(*database.Database)
+		refund, err := transactionsPkg.CreateRefund(db, txn.ID, user.ID, lines)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		showStyledInformation(parent, "Success", fmt.Sprintf("Refund recorded. Amount refunded: %s", refund.TotalAmount.Format()))
+		onSuccess()
+	}
+
+	showStyledDialog(parent, fmt.Sprintf("Refund Transaction #%d", txn.ID), formContent, "Refund", onAction, nil)
+}
+
+func showVoidDialog(parent fyne.Window, appState *auth.AppState, txn *models.Transaction, onSuccess func()) {
+	user := appState.GetCurrentUser()
+	if user == nil {
+		dialog.ShowError(fmt.Errorf("user not authenticated"), parent)
+		return
+	}
+
+	reasonEntry := widget.NewMultiLineEntry()
+	reasonEntry.SetPlaceHolder("Reason for voiding this sale")
+
+	formContent := container.NewVBox(
+		createStyledFormField("Transaction", widget.NewLabel(fmt.Sprintf("#%d - %s", txn.ID, txn.TotalAmount.Format()))),
+		createStyledFormField("Reason", reasonEntry),
+	)
+
+	// Users without the void permission need someone who has it to approve
+	canApprove := transactionsPkg.CanApproveVoid(user)
+	approverEntry := widget.NewEntry()
+	approverEntry.SetPlaceHolder("Approver username")
+	approverPasswordEntry := widget.NewPasswordEntry()
+	approverPasswordEntry.SetPlaceHolder("Approver password")
+	if !canApprove {
+		formContent.Add(widget.NewLabel("A manager must approve this void:"))
+		formContent.Add(createStyledFormField("Approver", approverEntry))
+		formContent.Add(createStyledFormField("Password", approverPasswordEntry))
+	}
+
+	onAction := func() {
+		approver := user
+		if !canApprove {
+			var err error
+			approver, err = appState.VerifyCredentials(approverEntry.Text, approverPasswordEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("approval failed: %v", err), parent)
+				return
+			}
+			if !transactionsPkg.CanApproveVoid(approver) {
+				dialog.ShowError(transactionsPkg.ErrVoidNotPermitted, parent)
+				return
+			}
+		}
+
+		db := appState.GetDB().(*database.Database)
+		err := transactionsPkg.VoidTransaction(db, txn.ID, approver.ID, reasonEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		showStyledInformation(parent, "Success", fmt.Sprintf("Transaction #%d has been voided and its stock restored", txn.ID))
+		onSuccess()
+	}
+
+	showStyledDialog(parent, fmt.Sprintf("Void Transaction #%d", txn.ID), formContent, "Void", onAction, nil)
+}
@@ -0,0 +1,130 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/database"
+	"ims-go/inventory"
+	"ims-go/models"
+)
+
+// unitPicker selects one of an item's units of measure, base unit first
+type unitPicker struct {
+	item    *models.Item
+	factors map[string]int
+	sel     *widget.Select
+}
+
+// newUnitPicker loads an item's units and selects preferred, falling back to
+// the base unit
+func newUnitPicker(db *database.Database, item *models.Item, preferred string, onChanged func()) (*unitPicker, error) {
+	units, err := inventory.GetUnits(db, item.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &unitPicker{item: item, factors: map[string]int{item.BaseUnit: 1}}
+	options := []string{item.BaseUnit}
+	for _, unit := range units {
+		p.factors[unit.Name] = unit.Factor
+		options = append(options, unit.Name)
+	}
+	p.sel = widget.NewSelect(options, nil)
+	if _, ok := p.factors[preferred]; ok {
+		p.sel.SetSelected(preferred)
+	} else {
+		p.sel.SetSelected(item.BaseUnit)
+	}
+	// Only report changes made after the picker is returned
+	p.sel.OnChanged = func(_ string) {
+		if onChanged != nil {
+			onChanged()
+		}
+	}
+	return p, nil
+}
+
+// unit returns the selected unit, empty for the base unit
+func (p *unitPicker) unit() string {
+	if p.sel.Selected == p.item.BaseUnit {
+		return ""
+	}
+	return p.sel.Selected
+}
+
+// factor returns the number of base units in the selected unit
+func (p *unitPicker) factor() int {
+	return p.factors[p.sel.Selected]
+}
+
+// formatStock formats a stock level with its base unit, leaving out "each"
+func formatStock(quantity int, baseUnit string) string {
+	if baseUnit == "" || baseUnit == "each" {
+		return fmt.Sprintf("%d", quantity)
+	}
+	return fmt.Sprintf("%d %s", quantity, baseUnit)
+}
+
+// showUnitsDialog edits the units an item is stocked, sold and bought in
+func showUnitsDialog(parent fyne.Window, appState *auth.AppState, item *models.Item, onSuccess func()) {
+	user := appState.GetCurrentUser()
+	db := appState.GetDB().(*database.Database)
+	units, err := inventory.GetUnits(db, item.ID)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+
+	baseEntry := widget.NewEntry()
+	baseEntry.SetText(item.BaseUnit)
+	baseEntry.SetPlaceHolder("e.g. each, can, g")
+	var written []string
+	for _, unit := range units {
+		written = append(written, fmt.Sprintf("%s = %d", unit.Name, unit.Factor))
+	}
+	unitsEntry := widget.NewMultiLineEntry()
+	unitsEntry.SetText(strings.Join(written, "\n"))
+	unitsEntry.SetPlaceHolder("One per line, e.g.\ncase = 24\nkg = 1000")
+	saleEntry := widget.NewEntry()
+	saleEntry.SetText(item.SaleUnit)
+	saleEntry.SetPlaceHolder("Base unit")
+	purchaseEntry := widget.NewEntry()
+	purchaseEntry.SetText(item.PurchaseUnit)
+	purchaseEntry.SetPlaceHolder("Base unit")
+
+	formContent := container.NewVBox(
+		widget.NewLabel(fmt.Sprintf("Units for %s. Stock is counted in whole base units;\nsell by weight with a base unit of g and kg = 1000.", item.Name)),
+		createStyledFormField("Base Unit", baseEntry),
+		createStyledFormField("Units", unitsEntry),
+		createStyledFormField("Sold In", saleEntry),
+		createStyledFormField("Bought In", purchaseEntry),
+	)
+
+	onAction := func() {
+		parsed, err := inventory.ParseUnits(unitsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		if err := inventory.SetUnits(db, item.ID, baseEntry.Text, parsed, saleEntry.Text, purchaseEntry.Text, user.ID); err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		message := "Units saved successfully"
+		if !strings.EqualFold(strings.TrimSpace(saleEntry.Text), item.SaleUnit) {
+			message += ". The price is per sale unit, so check it still matches."
+		}
+		showStyledInformation(parent, "Success", message)
+		onSuccess()
+	}
+
+	showStyledDialog(parent, "Units of Measure", formContent, "Save", onAction, nil)
+}
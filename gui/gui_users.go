@@ -2,6 +2,7 @@ package gui
 
 import (
 	"fmt"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -11,19 +12,27 @@ import (
 	"ims-go/auth"
 	"ims-go/database"
 	"ims-go/models"
+	"ims-go/roles"
 	usersPkg "ims-go/users"
 )
 
-func createUserManagementTab(parent fyne.Window, appState *auth.AppState) *container.Scroll {
+func createUserManagementTab(parent fyne.Window, appState *auth.AppState, currentUser *models.User) *container.Scroll {
 	// User list
 	var users []models.User
 	var selectedID widget.ListItemID = -1
+	statusSelect := widget.NewSelect([]string{"Active", "Archived"}, nil)
+	statusSelect.SetSelected("Active")
 
 	list := widget.NewList(
 		func() int {
 			var err error
+			var allUsers []models.User
 			db := appState.GetDB().(*database.Database)
-			allUsers, err := usersPkg.GetAllUsers(db)
+			if statusSelect.Selected == "Archived" {
+				allUsers, err = usersPkg.GetArchivedUsers(db)
+			} else {
+				allUsers, err = usersPkg.GetAllUsers(db)
+			}
 			if err != nil {
 				return 0
 			}
@@ -35,7 +44,6 @@ func createUserManagementTab(parent fyne.Window, appState *auth.AppState) *conta
 				widget.NewLabel(""),
 				widget.NewLabel(""),
 				widget.NewLabel(""),
-				widget.NewLabel(""),
 			)
 		},
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
@@ -43,28 +51,23 @@ func createUserManagementTab(parent fyne.Window, appState *auth.AppState) *conta
 				user := users[id]
 				box := obj.(*fyne.Container)
 				box.Objects[0].(*widget.Label).SetText(user.Username)
-				
-				roleText := "User"
-				if user.IsRootAdmin {
-					roleText = "Root Admin"
-				}
-				box.Objects[1].(*widget.Label).SetText(roleText)
 
-				perms := []string{}
-				if user.CanRead {
-					perms = append(perms, "Read")
+				roleNames := []string{}
+				if user.IsRootAdmin {
+					roleNames = append(roleNames, "Root Admin")
 				}
-				if user.CanTransaction {
-					perms = append(perms, "Transaction")
+				for _, role := range user.Roles {
+					roleNames = append(roleNames, role.Name)
 				}
-				if user.CanRevenue {
-					perms = append(perms, "Revenue")
+				if len(roleNames) == 0 {
+					roleNames = append(roleNames, "None")
 				}
-				if len(perms) == 0 {
-					perms = append(perms, "None")
+				box.Objects[1].(*widget.Label).SetText(fmt.Sprintf("Roles: %s", strings.Join(roleNames, ", ")))
+				if user.ArchivedAt != nil {
+					box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Archived: %s", user.ArchivedAt.Format("2006-01-02")))
+				} else {
+					box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Created: %s", user.CreatedAt.Format("2006-01-02")))
 				}
-				box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Permissions: %s", fmt.Sprint(perms)))
-				box.Objects[3].(*widget.Label).SetText(fmt.Sprintf("Created: %s", user.CreatedAt.Format("2006-01-02")))
 			}
 		},
 	)
@@ -80,15 +83,15 @@ func createUserManagementTab(parent fyne.Window, appState *auth.AppState) *conta
 
 	// Buttons
 	addBtn := widget.NewButton("Create User", func() {
-		showCreateUserDialog(parent, appState, refreshList)
+		showCreateUserDialog(parent, appState, currentUser, refreshList)
 	})
 
-	editBtn := widget.NewButton("Edit Permissions", func() {
+	editBtn := widget.NewButton("Edit Roles", func() {
 		if selectedID < 0 || selectedID >= len(users) {
 			dialog.ShowInformation("No Selection", "Please select a user to edit", parent)
 			return
 		}
-		showEditUserDialog(parent, appState, &users[selectedID], refreshList)
+		showEditUserDialog(parent, appState, currentUser, &users[selectedID], refreshList)
 	})
 
 	deleteBtn := widget.NewButton("Delete User", func() {
@@ -96,16 +99,39 @@ func createUserManagementTab(parent fyne.Window, appState *auth.AppState) *conta
 			dialog.ShowInformation("No Selection", "Please select a user to delete", parent)
 			return
 		}
-		showDeleteUserDialog(parent, appState, &users[selectedID], refreshList)
+		showDeleteUserDialog(parent, appState, currentUser, &users[selectedID], refreshList)
+	})
+
+	archiveBtn := widget.NewButton("Archive User", func() {
+		if selectedID < 0 || selectedID >= len(users) {
+			dialog.ShowInformation("No Selection", "Please select a user to archive or restore", parent)
+			return
+		}
+		showArchiveUserDialog(parent, appState, currentUser, &users[selectedID], refreshList)
+	})
+
+	rolesBtn := widget.NewButton("Roles", func() {
+		showRolesWindow(appState, currentUser, refreshList)
 	})
 
 	refreshBtn := widget.NewButton("Refresh", refreshList)
 
-	buttons := container.NewHBox(addBtn, editBtn, deleteBtn, refreshBtn)
+	buttons := container.NewHBox(addBtn, editBtn, archiveBtn, deleteBtn, rolesBtn, refreshBtn)
+
+	statusSelect.OnChanged = func(status string) {
+		if status == "Archived" {
+			archiveBtn.SetText("Restore User")
+			editBtn.Disable()
+		} else {
+			archiveBtn.SetText("Archive User")
+			editBtn.Enable()
+		}
+		refreshList()
+	}
 
 	content := container.NewBorder(
 		container.NewVBox(
-			widget.NewLabel("User Management"),
+			container.NewBorder(nil, nil, nil, statusSelect, widget.NewLabel("User Management")),
 			widget.NewSeparator(),
 		),
 		buttons,
@@ -118,29 +144,60 @@ func createUserManagementTab(parent fyne.Window, appState *auth.AppState) *conta
 	return container.NewScroll(content)
 }
 
-func showCreateUserDialog(parent fyne.Window, appState *auth.AppState, onSuccess func()) {
+// newRoleChecks lists every role as a check box, ticking those held
+func newRoleChecks(db *database.Database, held []models.Role) (*widget.CheckGroup, func() []int, error) {
+	all, err := roles.GetAllRoles(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, len(all))
+	for i, role := range all {
+		names[i] = role.Name
+	}
+	checks := widget.NewCheckGroup(names, nil)
+	for _, role := range held {
+		checks.Selected = append(checks.Selected, role.Name)
+	}
+	checks.Refresh()
+
+	selectedIDs := func() []int {
+		var ids []int
+		for _, role := range all {
+			for _, name := range checks.Selected {
+				if role.Name == name {
+					ids = append(ids, role.ID)
+				}
+			}
+		}
+		return ids
+	}
+	return checks, selectedIDs, nil
+}
+
+func showCreateUserDialog(parent fyne.Window, appState *auth.AppState, currentUser *models.User, onSuccess func()) {
+	db := appState.GetDB().(*database.Database)
 	usernameEntry := widget.NewEntry()
 	usernameEntry.SetPlaceHolder("Username")
 	passwordEntry := widget.NewPasswordEntry()
 	passwordEntry.SetPlaceHolder("Password")
-	canReadCheck := widget.NewCheck("Can Read", nil)
-	canTransactionCheck := widget.NewCheck("Can Transaction", nil)
-	canRevenueCheck := widget.NewCheck("Can Revenue", nil)
+	roleChecks, selectedRoles, err := newRoleChecks(db, nil)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
 
 	formContent := container.NewVBox(
 		createStyledFormField("Username", usernameEntry),
 		createStyledFormField("Password", passwordEntry),
-		createStyledFormField("Permissions", container.NewVBox(canReadCheck, canTransactionCheck, canRevenueCheck)),
+		createStyledFormField("Roles", roleChecks),
 	)
 
 	onAction := func() {
-		db := appState.GetDB().(*database.Database)
 		_, err := usersPkg.CreateUser(db,
 			usernameEntry.Text,
 			passwordEntry.Text,
-			canReadCheck.Checked,
-			canTransactionCheck.Checked,
-			canRevenueCheck.Checked,
+			selectedRoles(),
+			currentUser.ID,
 		)
 		if err != nil {
 			dialog.ShowError(err, parent)
@@ -154,45 +211,206 @@ func showCreateUserDialog(parent fyne.Window, appState *auth.AppState, onSuccess
 	showStyledDialog(parent, "Create User", formContent, "Create", onAction, nil)
 }
 
-func showEditUserDialog(parent fyne.Window, appState *auth.AppState, user *models.User, onSuccess func()) {
+func showEditUserDialog(parent fyne.Window, appState *auth.AppState, currentUser *models.User, user *models.User, onSuccess func()) {
 	if user.IsRootAdmin {
 		dialog.ShowInformation("Cannot Edit", "Root admin permissions cannot be modified", parent)
 		return
 	}
 
-	canReadCheck := widget.NewCheck("Can Read", nil)
-	canReadCheck.SetChecked(user.CanRead)
-	canTransactionCheck := widget.NewCheck("Can Transaction", nil)
-	canTransactionCheck.SetChecked(user.CanTransaction)
-	canRevenueCheck := widget.NewCheck("Can Revenue", nil)
-	canRevenueCheck.SetChecked(user.CanRevenue)
+	db := appState.GetDB().(*database.Database)
+	roleChecks, selectedRoles, err := newRoleChecks(db, user.Roles)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
 
 	formContent := container.NewVBox(
 		createStyledFormField("Username", widget.NewLabel(user.Username)),
-		createStyledFormField("Permissions", container.NewVBox(canReadCheck, canTransactionCheck, canRevenueCheck)),
+		createStyledFormField("Roles", roleChecks),
 	)
 
 	onAction := func() {
-		db := appState.GetDB().(*database.Database)
-		err := usersPkg.UpdateUserPermissions(db,
-			user.ID,
-			canReadCheck.Checked,
-			canTransactionCheck.Checked,
-			canRevenueCheck.Checked,
-		)
-		if err != nil {
+		if err := roles.SetUserRoles(db, user.ID, selectedRoles(), currentUser.ID); err != nil {
 			dialog.ShowError(err, parent)
 			return
 		}
 
-		showStyledInformation(parent, "Success", "User permissions updated successfully")
+		showStyledInformation(parent, "Success", "User roles updated successfully")
 		onSuccess()
 	}
 
-	showStyledDialog(parent, "Edit User Permissions", formContent, "Update", onAction, nil)
+	showStyledDialog(parent, "Edit User Roles", formContent, "Update", onAction, nil)
+}
+
+// showRolesWindow lists the roles, with the permissions each grants, for
+// adding, changing and removing them
+func showRolesWindow(appState *auth.AppState, currentUser *models.User, onChanged func()) {
+	window := fyne.CurrentApp().NewWindow("Roles")
+	window.Resize(fyne.NewSize(700, 500))
+	window.CenterOnScreen()
+
+	db := appState.GetDB().(*database.Database)
+	var list []models.Role
+	var selectedID widget.ListItemID = -1
+
+	roleList := widget.NewList(
+		func() int {
+			return len(list)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(widget.NewLabel(""), widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(list) {
+				role := list[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*widget.Label).SetText(role.Name)
+				permissions := make([]string, len(role.Permissions))
+				for i, p := range role.Permissions {
+					permissions[i] = string(p)
+				}
+				box.Objects[1].(*widget.Label).SetText(strings.Join(permissions, ", "))
+			}
+		},
+	)
+	roleList.OnSelected = func(id widget.ListItemID) {
+		selectedID = id
+	}
+
+	refresh := func() {
+		allRoles, err := roles.GetAllRoles(db)
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		list = allRoles
+		selectedID = -1
+		roleList.UnselectAll()
+		roleList.Refresh()
+		onChanged()
+	}
+
+	// showRoleDialog adds a role, or changes one when role is set
+	showRoleDialog := func(role *models.Role) {
+		nameEntry := widget.NewEntry()
+		nameEntry.SetPlaceHolder("Role Name")
+		names := make([]string, len(models.Permissions))
+		for i, p := range models.Permissions {
+			names[i] = string(p)
+		}
+		permissionChecks := widget.NewCheckGroup(names, nil)
+		title, action := "Add Role", "Add"
+		if role != nil {
+			title, action = "Edit Role", "Update"
+			nameEntry.SetText(role.Name)
+			for _, p := range role.Permissions {
+				permissionChecks.Selected = append(permissionChecks.Selected, string(p))
+			}
+			permissionChecks.Refresh()
+		}
+
+		formContent := container.NewVBox(
+			createStyledFormField("Name", nameEntry),
+			createStyledFormField("Permissions", permissionChecks),
+		)
+		showStyledDialog(window, title, formContent, action, func() {
+			permissions := make([]models.Permission, len(permissionChecks.Selected))
+			for i, name := range permissionChecks.Selected {
+				permissions[i] = models.Permission(name)
+			}
+			var err error
+			if role != nil {
+				err = roles.UpdateRole(db, role.ID, nameEntry.Text, permissions, currentUser.ID)
+			} else {
+				_, err = roles.CreateRole(db, nameEntry.Text, permissions, currentUser.ID)
+			}
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refresh()
+		}, nil)
+	}
+
+	selected := func(action string) *models.Role {
+		if selectedID < 0 || selectedID >= len(list) {
+			dialog.ShowInformation("No Selection", "Please select a role to "+action, window)
+			return nil
+		}
+		return &list[selectedID]
+	}
+
+	addBtn := widget.NewButton("Add", func() {
+		showRoleDialog(nil)
+	})
+
+	editBtn := widget.NewButton("Edit", func() {
+		if role := selected("edit"); role != nil {
+			showRoleDialog(role)
+		}
+	})
+
+	deleteBtn := widget.NewButton("Delete", func() {
+		role := selected("delete")
+		if role == nil {
+			return
+		}
+		dialog.ShowConfirm("Delete Role", fmt.Sprintf("Are you sure you want to delete the role '%s'?", role.Name), func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			if err := roles.DeleteRole(db, role.ID, currentUser.ID); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refresh()
+		}, window)
+	})
+
+	content := container.NewBorder(
+		nil,
+		container.NewHBox(addBtn, editBtn, deleteBtn),
+		nil,
+		nil,
+		roleList,
+	)
+	window.SetContent(content)
+	refresh()
+	window.Show()
 }
 
-func showDeleteUserDialog(parent fyne.Window, appState *auth.AppState, user *models.User, onSuccess func()) {
+// showArchiveUserDialog archives an active user or restores an archived one
+func showArchiveUserDialog(parent fyne.Window, appState *auth.AppState, currentUser *models.User, user *models.User, onSuccess func()) {
+	if user.IsRootAdmin {
+		dialog.ShowInformation("Cannot Archive", "Root admin cannot be archived", parent)
+		return
+	}
+
+	title, message := "Archive User", fmt.Sprintf("Archive user '%s'? They will no longer be able to log in.", user.Username)
+	if user.ArchivedAt != nil {
+		title, message = "Restore User", fmt.Sprintf("Restore user '%s' so they can log in again?", user.Username)
+	}
+
+	dialog.ShowConfirm(title, message, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		db := appState.GetDB().(*database.Database)
+		var err error
+		if user.ArchivedAt != nil {
+			err = usersPkg.RestoreUser(db, user.ID, currentUser.ID)
+		} else {
+			err = usersPkg.ArchiveUser(db, user.ID, currentUser.ID)
+		}
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		onSuccess()
+	}, parent)
+}
+
+func showDeleteUserDialog(parent fyne.Window, appState *auth.AppState, currentUser *models.User, user *models.User, onSuccess func()) {
 	if user.IsRootAdmin {
 		dialog.ShowInformation("Cannot Delete", "Root admin cannot be deleted", parent)
 		return
@@ -201,7 +419,7 @@ func showDeleteUserDialog(parent fyne.Window, appState *auth.AppState, user *mod
 	dialog.ShowConfirm("Delete User", fmt.Sprintf("Are you sure you want to delete user '%s'?", user.Username), func(confirmed bool) {
 		if confirmed {
 			db := appState.GetDB().(*database.Database)
-			err := usersPkg.DeleteUser(db, user.ID)
+			err := usersPkg.DeleteUser(db, user.ID, currentUser.ID)
 			if err != nil {
 				dialog.ShowError(err, parent)
 				return
@@ -211,4 +429,3 @@ func showDeleteUserDialog(parent fyne.Window, appState *auth.AppState, user *mod
 		}
 	}, parent)
 }
-
@@ -0,0 +1,142 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/auth"
+	"ims-go/database"
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+)
+
+// showAddVariantDialog adds a variant, such as a size or colour, under a
+// parent product
+func showAddVariantDialog(parent fyne.Window, appState *auth.AppState, user *models.User, item *models.Item, onSuccess func()) {
+	if item.IsVariant() {
+		dialog.ShowInformation("Variant Selected", "Please select the parent product to add a variant to", parent)
+		return
+	}
+
+	variantEntry := widget.NewEntry()
+	variantEntry.SetPlaceHolder("e.g. M / Blue")
+	codeEntry := widget.NewEntry()
+	codeEntry.SetPlaceHolder("Barcode/Code")
+	priceEntry := widget.NewEntry()
+	priceEntry.SetPlaceHolder(fmt.Sprintf("Same as product (%s)", item.Price.Format()))
+	costEntry := widget.NewEntry()
+	costEntry.SetText(item.Cost.String())
+	quantityEntry := widget.NewEntry()
+	quantityEntry.SetPlaceHolder("Quantity")
+
+	formContent := container.NewVBox(
+		widget.NewLabel(fmt.Sprintf("New variant of %s", item.Name)),
+		createStyledFormField("Variant", variantEntry),
+		createStyledFormField("Code", codeEntry),
+		createStyledFormField("Price", priceEntry),
+		createStyledFormField("Cost", costEntry),
+		createStyledFormField("Quantity", quantityEntry),
+	)
+
+	onAction := func() {
+		var price *money.Money
+		if strings.TrimSpace(priceEntry.Text) != "" {
+			p, err := money.Parse(priceEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("invalid price"), parent)
+				return
+			}
+			price = &p
+		}
+
+		cost, err := money.Parse(costEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("invalid cost"), parent)
+			return
+		}
+
+		quantity, err := strconv.Atoi(quantityEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("invalid quantity"), parent)
+			return
+		}
+
+		db := appState.GetDB().(*database.Database)
+		_, err = inventory.CreateVariant(db, item.ID, variantEntry.Text, codeEntry.Text, price, cost, quantity, user.ID)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+
+		showStyledInformation(parent, "Success", "Variant added successfully")
+		onSuccess()
+	}
+
+	showStyledDialog(parent, "Add Variant", formContent, "Add", onAction, nil)
+}
+
+// showVariantSelectionDialog asks which variant of a product is being sold
+func showVariantSelectionDialog(parent fyne.Window, item *models.Item, variants []models.Item, onSelect func(*models.Item)) {
+	selectionWindow := fyne.CurrentApp().NewWindow("Select Variant")
+	selectionWindow.Resize(fyne.NewSize(500, 400))
+	selectionWindow.CenterOnScreen()
+
+	list := widget.NewList(
+		func() int {
+			return len(variants)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			if id < len(variants) {
+				variant := variants[id]
+				box := obj.(*fyne.Container)
+				box.Objects[0].(*widget.Label).SetText(variant.VariantName)
+				box.Objects[1].(*widget.Label).SetText(variant.Price.Format())
+				box.Objects[2].(*widget.Label).SetText(fmt.Sprintf("Qty: %d", variant.Quantity))
+			}
+		},
+	)
+
+	var selectedID widget.ListItemID = -1
+	list.OnSelected = func(id widget.ListItemID) {
+		selectedID = id
+	}
+
+	selectBtn := widget.NewButton("Select", func() {
+		if selectedID >= 0 && selectedID < len(variants) {
+			onSelect(&variants[selectedID])
+			selectionWindow.Close()
+		}
+	})
+
+	cancelBtn := widget.NewButton("Cancel", func() {
+		selectionWindow.Close()
+	})
+
+	content := container.NewBorder(
+		container.NewVBox(
+			widget.NewLabel(fmt.Sprintf("Select variant of: %s", item.Name)),
+			widget.NewSeparator(),
+		),
+		container.NewHBox(selectBtn, cancelBtn),
+		nil,
+		nil,
+		container.NewScroll(list),
+	)
+
+	selectionWindow.SetContent(content)
+	selectionWindow.Show()
+}
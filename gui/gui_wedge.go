@@ -0,0 +1,180 @@
+package gui
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+
+	"ims-go/barcode"
+	"ims-go/config"
+)
+
+// scannerInput passes keystrokes through a barcode.Wedge, so that codes
+// typed by a USB scanner reach onScan instead of whichever field has focus.
+// Fyne only gives the window the keys typed while nothing has focus, so the
+// fields a scan could land in are scanEntries feeding the same scannerInput.
+type scannerInput struct {
+	onScan func(code string)
+	window fyne.Window // held keys are typed on its event goroutine
+
+	mu      sync.Mutex
+	wedge   barcode.Wedge
+	timer   *time.Timer
+	deliver func(r rune) // where held keys go if they turn out to be typing
+}
+
+func newScannerInput(window fyne.Window, cfg *config.Config, onScan func(code string)) *scannerInput {
+	return &scannerInput{
+		onScan: onScan,
+		window: window,
+		wedge: barcode.Wedge{
+			Prefix:    asKeys(cfg.ScannerPrefix),
+			Suffix:    asKeys(cfg.ScannerSuffix),
+			MaxGap:    time.Duration(cfg.ScannerMaxGapMs) * time.Millisecond,
+			MinLength: cfg.ScannerMinLength,
+		},
+	}
+}
+
+// asKeys is s as it arrives from the keyboard, where a scanner's CR or
+// CR LF is the Enter key, fed as '\n'
+func asKeys(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
+
+// takesTab reports whether the scanner types Tab around its codes, so Tab
+// has to be fed to the wedge rather than move the focus
+func (s *scannerInput) takesTab() bool {
+	return strings.ContainsRune(s.wedge.Prefix+s.wedge.Suffix, '\t')
+}
+
+// attach takes the keys typed into the window while nothing has focus
+func (s *scannerInput) attach() {
+	canvas := s.window.Canvas()
+	ignore := func(rune) {}
+	canvas.SetOnTypedRune(func(r rune) {
+		s.feed(r, ignore)
+	})
+	canvas.SetOnTypedKey(func(ev *fyne.KeyEvent) {
+		if isEnter(ev) {
+			s.feed('\n', ignore)
+			return
+		}
+		s.release()
+	})
+}
+
+// feed passes on a keystroke, with Enter as '\n'. Keys that turn out to be
+// typing go to deliver, straight away or once the scanner's gap is up.
+func (s *scannerInput) feed(r rune, deliver func(r rune)) {
+	s.mu.Lock()
+	code, typed := s.wedge.Feed(r, time.Now())
+	s.deliver = deliver
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if s.wedge.Holding() {
+		// The timer fires on its own goroutine, so the keys are typed
+		// into the widgets from the window's
+		window := s.window
+		s.timer = time.AfterFunc(s.wedge.MaxGap, func() {
+			runOnWindow(window, s.flush)
+		})
+	}
+	s.mu.Unlock()
+
+	for _, t := range typed {
+		deliver(t)
+	}
+	if code != "" {
+		s.onScan(code)
+	}
+}
+
+// flush types the held keys once the gap is up without another
+func (s *scannerInput) flush() {
+	s.mu.Lock()
+	typed := s.wedge.Flush(time.Now())
+	deliver := s.deliver
+	s.mu.Unlock()
+
+	for _, t := range typed {
+		deliver(t)
+	}
+}
+
+// release types the held keys now, before a key that can't be in a code
+func (s *scannerInput) release() {
+	s.mu.Lock()
+	typed := s.wedge.Release()
+	deliver := s.deliver
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+
+	for _, t := range typed {
+		deliver(t)
+	}
+}
+
+func isEnter(ev *fyne.KeyEvent) bool {
+	return ev.Name == fyne.KeyReturn || ev.Name == fyne.KeyEnter
+}
+
+// scanEntry is an Entry whose typing goes through a scannerInput, so that a
+// scan made while it has focus is looked up rather than typed into it
+type scanEntry struct {
+	widget.Entry
+	scanner *scannerInput
+}
+
+func newScanEntry(scanner *scannerInput) *scanEntry {
+	entry := &scanEntry{scanner: scanner}
+	entry.ExtendBaseWidget(entry)
+	return entry
+}
+
+// TypedRune is called when a character is typed while the entry has focus
+func (e *scanEntry) TypedRune(r rune) {
+	e.scanner.feed(r, e.typed)
+}
+
+// AcceptsTab is true when the scanner types Tab, so that Fyne hands it to
+// TypedKey instead of moving the focus
+func (e *scanEntry) AcceptsTab() bool {
+	return e.scanner.takesTab()
+}
+
+// TypedKey is called when a key is pressed while the entry has focus
+func (e *scanEntry) TypedKey(ev *fyne.KeyEvent) {
+	if isEnter(ev) {
+		e.scanner.feed('\n', e.typed)
+		return
+	}
+	if ev.Name == fyne.KeyTab {
+		e.scanner.feed('\t', e.typed)
+		return
+	}
+	e.scanner.release()
+	e.Entry.TypedKey(ev)
+}
+
+// typed hands the entry a key that turned out to be typing
+func (e *scanEntry) typed(r rune) {
+	if r == '\n' {
+		e.Entry.TypedKey(&fyne.KeyEvent{Name: fyne.KeyReturn})
+		return
+	}
+	if r == '\t' {
+		// Tab that was typing moves the focus on, as it would have
+		if c := fyne.CurrentApp().Driver().CanvasForObject(e); c != nil {
+			c.FocusNext()
+		}
+		return
+	}
+	e.Entry.TypedRune(r)
+}
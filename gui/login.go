@@ -1,7 +1,6 @@
 package gui
 
 import (
-
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
@@ -12,7 +11,7 @@ import (
 
 func ShowLoginWindow(appState *auth.AppState) {
 	myApp := app.New()
-	loginWindow := myApp.NewWindow("Login - Inventory Management System")
+	loginWindow := myApp.NewWindow("Login - " + appState.GetConfig().StoreName)
 	loginWindow.Resize(fyne.NewSize(400, 300))
 	loginWindow.CenterOnScreen()
 
@@ -56,4 +55,3 @@ func ShowLoginWindow(appState *auth.AppState) {
 	loginWindow.SetContent(container.NewCenter(content))
 	loginWindow.ShowAndRun()
 }
-
@@ -0,0 +1,164 @@
+package inventory
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+// GetBarcodes returns the extra barcodes an item can be scanned by
+func GetBarcodes(db Database, itemID int) ([]models.ItemBarcode, error) {
+	rows, err := db.GetDB().Query(
+		"SELECT id, item_id, code, description, quantity, created_at FROM item_barcodes WHERE item_id = ? ORDER BY code",
+		itemID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var barcodes []models.ItemBarcode
+	for rows.Next() {
+		barcode, err := scanBarcode(rows)
+		if err != nil {
+			return nil, err
+		}
+		barcodes = append(barcodes, *barcode)
+	}
+	return barcodes, rows.Err()
+}
+
+func scanBarcode(row scanner) (*models.ItemBarcode, error) {
+	var barcode models.ItemBarcode
+	var quantity sql.NullInt64
+	var createdAt sql.NullTime
+	if err := row.Scan(&barcode.ID, &barcode.ItemID, &barcode.Code, &barcode.Description, &quantity, &createdAt); err != nil {
+		return nil, err
+	}
+	if quantity.Valid {
+		q := int(quantity.Int64)
+		barcode.Quantity = &q
+	}
+	if createdAt.Valid {
+		barcode.CreatedAt = createdAt.Time
+	}
+	return &barcode, nil
+}
+
+// AddBarcode gives an item another code to be scanned by. quantity is how
+// many base units one scan stands for, or nil for one of the item as sold.
+// The code can't already belong to any item, archived or not.
+func AddBarcode(db Database, itemID int, code, description string, quantity *int, userID int) (*models.ItemBarcode, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, errors.New("barcode is required")
+	}
+	if quantity != nil && *quantity <= 0 {
+		return nil, fmt.Errorf("invalid quantity %d", *quantity)
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return nil, err
+	}
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM items WHERE id = ?", itemID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, errors.New("item not found")
+	}
+	if err := checkCodeFreeTx(tx, code, 0); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO item_barcodes (item_id, code, description, quantity, created_at) VALUES (?, ?, ?, ?, ?)",
+		itemID, code, strings.TrimSpace(description), quantity, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	barcode, err := scanBarcode(tx.QueryRow("SELECT id, item_id, code, description, quantity, created_at FROM item_barcodes WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return barcode, tx.Commit()
+}
+
+// RemoveBarcode removes one of an item's extra barcodes
+func RemoveBarcode(db Database, id int, userID int) error {
+	if err := roles.Require(db, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	result, err := db.GetDB().Exec("DELETE FROM item_barcodes WHERE id = ?", id)
+	return checkChanged(result, err, "barcode not found")
+}
+
+// checkCodeFreeTx fails when code is already the own code of an item other
+// than itemID, or anyone's extra barcode
+func checkCodeFreeTx(tx *sql.Tx, code string, itemID int) error {
+	var name string
+	err := tx.QueryRow(
+		`SELECT name FROM items WHERE code = ? AND id != ?
+		 UNION ALL
+		 SELECT i.name FROM item_barcodes b JOIN items i ON b.item_id = i.id WHERE b.code = ?`,
+		code, itemID, code,
+	).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("code %s is already used by %s", code, name)
+}
+
+// LookupCode finds the active item a scanned code belongs to, by its own code
+// or one of its extra barcodes. The barcode is nil when the item's own code
+// was scanned.
+func LookupCode(db Database, code string) (*models.Item, *models.ItemBarcode, error) {
+	item, err := scanItem(db.GetDB().QueryRow("SELECT "+itemColumns+" FROM items WHERE code = ? AND archived_at IS NULL", code))
+	if err == nil {
+		return item, nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	barcode, err := scanBarcode(db.GetDB().QueryRow(
+		`SELECT b.id, b.item_id, b.code, b.description, b.quantity, b.created_at
+		 FROM item_barcodes b JOIN items i ON b.item_id = i.id
+		 WHERE b.code = ? AND i.archived_at IS NULL`,
+		code,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	item, err = GetItemByID(db, barcode.ItemID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, barcode, nil
+}
@@ -0,0 +1,84 @@
+package inventory
+
+import (
+	"testing"
+
+	"ims-go/money"
+)
+
+func TestItemBarcodes(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	cola, _ := CreateItem(mockDB, "Cola", "CL001", "", money.MustParse("1.50"), money.MustParse("0.60"), 24, 1)
+	water, _ := CreateItem(mockDB, "Water", "WT001", "", money.MustParse("0.80"), money.MustParse("0.30"), 12, 1)
+
+	ean, err := AddBarcode(mockDB, cola.ID, "5000112637922", "Manufacturer EAN", nil, 1)
+	if err != nil {
+		t.Fatalf("AddBarcode failed: %v", err)
+	}
+	twelve := 12
+	if _, err := AddBarcode(mockDB, cola.ID, " 15000112637929 ", "Case", &twelve, 1); err != nil {
+		t.Fatalf("AddBarcode failed: %v", err)
+	}
+
+	barcodes, err := GetBarcodes(mockDB, cola.ID)
+	if err != nil {
+		t.Fatalf("GetBarcodes failed: %v", err)
+	}
+	if len(barcodes) != 2 || barcodes[0].Code != "15000112637929" || *barcodes[0].Quantity != 12 || barcodes[1].Quantity != nil {
+		t.Errorf("Unexpected barcodes: %+v", barcodes)
+	}
+
+	// Either code finds the item, and the case barcode brings its quantity
+	item, barcode, err := LookupCode(mockDB, "CL001")
+	if err != nil || item.ID != cola.ID || barcode != nil {
+		t.Errorf("Expected cola by its own code, got %+v, %+v, %v", item, barcode, err)
+	}
+	item, barcode, err = LookupCode(mockDB, "15000112637929")
+	if err != nil || item.ID != cola.ID || barcode == nil || *barcode.Quantity != 12 {
+		t.Errorf("Expected a case of cola, got %+v, %+v, %v", item, barcode, err)
+	}
+	if item, err := GetItemByCode(mockDB, "5000112637922"); err != nil || item.ID != cola.ID {
+		t.Errorf("Expected GetItemByCode to resolve the alias, got %+v, %v", item, err)
+	}
+	if found, _ := SearchItems(mockDB, "50001126"); len(found) != 1 || found[0].ID != cola.ID {
+		t.Errorf("Expected search to match the alias, got %+v", found)
+	}
+
+	// Codes are unique across items and aliases
+	if _, err := AddBarcode(mockDB, water.ID, "5000112637922", "", nil, 1); err == nil {
+		t.Error("Expected error reusing another item's alias")
+	}
+	if _, err := AddBarcode(mockDB, water.ID, "CL001", "", nil, 1); err == nil {
+		t.Error("Expected error reusing another item's code")
+	}
+	if _, err := CreateItem(mockDB, "Lemonade", "5000112637922", "", 100, 50, 0, 1); err == nil {
+		t.Error("Expected error creating an item with an alias as its code")
+	}
+	if err := UpdateItem(mockDB, water.ID, "Water", "15000112637929", "", water.Price, water.Cost, nil, 1); err == nil {
+		t.Error("Expected error changing an item's code to an alias")
+	}
+	zero := 0
+	if _, err := AddBarcode(mockDB, water.ID, "WT-CASE", "", &zero, 1); err == nil {
+		t.Error("Expected error for a zero quantity")
+	}
+
+	// Archived items can't be scanned by any code
+	if err := ArchiveItem(mockDB, cola.ID, 1); err != nil {
+		t.Fatalf("ArchiveItem failed: %v", err)
+	}
+	if _, _, err := LookupCode(mockDB, "5000112637922"); err == nil {
+		t.Error("Expected error scanning an archived item's alias")
+	}
+
+	if err := RemoveBarcode(mockDB, ean.ID, 1); err != nil {
+		t.Fatalf("RemoveBarcode failed: %v", err)
+	}
+	if err := RemoveBarcode(mockDB, ean.ID, 1); err == nil {
+		t.Error("Expected error removing a barcode twice")
+	}
+	if _, err := AddBarcode(mockDB, water.ID, "5000112637922", "", nil, 1); err != nil {
+		t.Errorf("Expected a removed code to be reusable: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package inventory
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ims-go/barcode"
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+// InStorePrefix starts every code allocated in store. EAN-13 codes starting
+// with 2 are kept for in-store use, so they never clash with a manufacturer's.
+const InStorePrefix = "200"
+
+// allocateCodeTx returns the next unused in-store EAN-13: InStorePrefix, a
+// 9-digit sequence number and a check digit
+func allocateCodeTx(tx *sql.Tx) (string, error) {
+	var last sql.NullInt64
+	err := tx.QueryRow(
+		`SELECT MAX(CAST(substr(code, 4, 9) AS INTEGER)) FROM (
+			SELECT code FROM items UNION ALL SELECT code FROM item_barcodes
+		 ) WHERE code GLOB ? AND length(code) = 13`,
+		InStorePrefix+"[0-9]*",
+	).Scan(&last)
+	if err != nil {
+		return "", err
+	}
+
+	next := last.Int64 + 1
+	if next > 999999999 {
+		return "", fmt.Errorf("no in-store codes left")
+	}
+	digits := fmt.Sprintf("%s%09d", InStorePrefix, next)
+	check, err := barcode.EAN13CheckDigit(digits)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d", digits, check), nil
+}
+
+// AssignMissingCodes gives an in-store code to every item whose code is
+// blank, returning how many were assigned
+func AssignMissingCodes(db Database, userID int) (int, error) {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query("SELECT id FROM items WHERE trim(code) = ''")
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		code, err := allocateCodeTx(tx)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE items SET code = ?, updated_at = ? WHERE id = ?", code, time.Now(), id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
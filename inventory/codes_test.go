@@ -0,0 +1,61 @@
+package inventory
+
+import (
+	"testing"
+
+	"ims-go/barcode"
+	"ims-go/money"
+)
+
+func TestCreateItem_AllocatesCode(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	first, err := CreateItem(mockDB, "Bread Roll", "", "", money.MustParse("0.40"), money.MustParse("0.10"), 0, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	second, err := CreateItem(mockDB, "Bagel", "  ", "", money.MustParse("0.60"), money.MustParse("0.20"), 0, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	if first.Code != "2000000000015" || second.Code != "2000000000022" {
+		t.Errorf("Expected sequential in-store codes, got %s and %s", first.Code, second.Code)
+	}
+	if !barcode.ValidEAN13(second.Code) {
+		t.Errorf("Expected a valid EAN-13, got %s", second.Code)
+	}
+
+	// Codes already taken by aliases are skipped
+	if _, err := AddBarcode(mockDB, first.ID, "2000000000053", "", nil, 1); err != nil {
+		t.Fatalf("AddBarcode failed: %v", err)
+	}
+	third, _ := CreateItem(mockDB, "Croissant", "", "", money.MustParse("1.10"), money.MustParse("0.40"), 0, 1)
+	if third.Code != "2000000000060" {
+		t.Errorf("Expected 2000000000060 after the alias, got %s", third.Code)
+	}
+}
+
+func TestAssignMissingCodes(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	if _, err := mockDB.db.Exec("INSERT INTO items (name, code, description, price) VALUES ('Old Stock', '', '', 100)"); err != nil {
+		t.Fatalf("Failed to insert item: %v", err)
+	}
+	CreateItem(mockDB, "Cola", "CL001", "", money.MustParse("1.50"), money.MustParse("0.60"), 0, 1)
+
+	assigned, err := AssignMissingCodes(mockDB, 1)
+	if err != nil {
+		t.Fatalf("AssignMissingCodes failed: %v", err)
+	}
+	if assigned != 1 {
+		t.Errorf("Expected 1 code assigned, got %d", assigned)
+	}
+	if item, err := GetItemByCode(mockDB, "2000000000015"); err != nil || item.Name != "Old Stock" {
+		t.Errorf("Expected Old Stock to get 2000000000015, got %+v, %v", item, err)
+	}
+	if assigned, _ := AssignMissingCodes(mockDB, 1); assigned != 0 {
+		t.Errorf("Expected nothing left to assign, got %d", assigned)
+	}
+}
@@ -0,0 +1,105 @@
+package inventory
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ims-go/money"
+)
+
+// Costing methods for valuing the cost of goods sold
+const (
+	// CostingFIFO costs each sale at what the batches it used up cost
+	CostingFIFO = "fifo"
+	// CostingAverage costs each sale at the weighted average cost of the
+	// stock on hand
+	CostingAverage = "average"
+)
+
+// CostingMethodTx returns the costing method sales are costed with, as
+// stored in the database, inside an existing database transaction
+func CostingMethodTx(tx *sql.Tx) (string, error) {
+	var method string
+	err := tx.QueryRow("SELECT value FROM settings WHERE key = 'costing_method'").Scan(&method)
+	if err == sql.ErrNoRows {
+		return CostingFIFO, nil
+	}
+	return method, err
+}
+
+// SetCostingMethod stores the costing method sales are costed with, as set
+// in the configuration at startup. A change is logged in setting_changes, so
+// the method any sale was costed with can be told from when it was made.
+func SetCostingMethod(db Database, method string) error {
+	if method != CostingFIFO && method != CostingAverage {
+		return fmt.Errorf("unknown costing method %q", method)
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old sql.NullString
+	err = tx.QueryRow("SELECT value FROM settings WHERE key = 'costing_method'").Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if old.Valid && old.String == method {
+		return nil
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(
+		`INSERT INTO settings (key, value, updated_at) VALUES ('costing_method', ?, ?)
+		 ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		method, now,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO setting_changes (key, old_value, new_value, changed_at) VALUES ('costing_method', ?, ?, ?)",
+		old, method, now,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AverageCostTx returns the weighted average cost of an item's stock on hand
+// inside an existing database transaction, as the value of the stock and the
+// number of base units it is for. Costing a sale at its share of the value
+// doesn't round the average to a whole cent per base unit first. Batches with
+// no recorded cost count at the item's cost, which is also returned, for one
+// base unit, when the item is out of stock.
+func AverageCostTx(tx *sql.Tx, itemID int) (money.Money, int, error) {
+	var itemCost money.Money
+	var total money.Money
+	var quantity int
+	err := tx.QueryRow(
+		`SELECT i.cost,
+			CAST(ROUND(COALESCE(SUM(s.quantity * COALESCE(s.unit_cost, i.cost) * 1.0
+				/ CASE WHEN s.unit_cost IS NULL THEN 1 ELSE s.cost_factor END), 0)) AS INTEGER),
+			COALESCE(SUM(s.quantity), 0)
+		 FROM items i
+		 LEFT JOIN item_stock s ON s.item_id = i.id AND s.quantity > 0
+		 WHERE i.id = ?
+		 GROUP BY i.id`,
+		itemID,
+	).Scan(&itemCost, &total, &quantity)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrItemNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if quantity == 0 {
+		return itemCost, 1, nil
+	}
+	return total, quantity, nil
+}
@@ -0,0 +1,68 @@
+package inventory
+
+import (
+	"fmt"
+
+	"ims-go/barcode"
+	"ims-go/models"
+)
+
+// LookupGTIN finds the active item whose code or extra barcode is a GTIN,
+// stored at any of the lengths it's printed at
+func LookupGTIN(db Database, gtin string) (*models.Item, *models.ItemBarcode, error) {
+	err := ErrItemNotFound
+	for _, code := range barcode.GTINForms(gtin) {
+		var item *models.Item
+		var itemBarcode *models.ItemBarcode
+		if item, itemBarcode, err = LookupCode(db, code); err == nil {
+			return item, itemBarcode, nil
+		}
+	}
+	return nil, nil, err
+}
+
+// LookupScan finds the item a scanned code is for. A code that belongs to an
+// item as it is always wins; otherwise the code is read as GS1 and the item
+// looked up by its GTIN, and the parsed code is returned for its lot, expiry,
+// weight and price. It is nil when the code matched exactly.
+func LookupScan(db Database, code string) (*models.Item, *models.ItemBarcode, *barcode.GS1, error) {
+	item, itemBarcode, err := LookupCode(db, code)
+	if err == nil {
+		return item, itemBarcode, nil, nil
+	}
+	parsed, parseErr := barcode.ParseGS1(code)
+	if parseErr != nil || parsed.GTIN == "" {
+		return nil, nil, nil, err
+	}
+	item, itemBarcode, err = LookupGTIN(db, parsed.GTIN)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return item, itemBarcode, parsed, nil
+}
+
+// WeightQuantity converts a weight in grams to a quantity of an item's sale
+// unit. The item has to be counted by weight: a base unit or unit of "g", or
+// a "kg" unit holding a whole number of base units per gram.
+func WeightQuantity(db Database, item *models.Item, grams int) (float64, error) {
+	base, err := weightBaseUnits(db, item, grams)
+	if err != nil {
+		return 0, err
+	}
+	saleFactor, err := UnitFactor(db, item.ID, item.SaleUnit)
+	if err != nil {
+		return 0, err
+	}
+	return float64(base) / float64(saleFactor), nil
+}
+
+// weightBaseUnits converts a weight in grams to the item's base units
+func weightBaseUnits(db Database, item *models.Item, grams int) (int, error) {
+	if factor, err := UnitFactor(db, item.ID, "g"); err == nil {
+		return grams * factor, nil
+	}
+	if factor, err := UnitFactor(db, item.ID, "kg"); err == nil && factor%1000 == 0 {
+		return grams * factor / 1000, nil
+	}
+	return 0, fmt.Errorf("%s is not sold by weight", item.Name)
+}
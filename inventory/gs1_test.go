@@ -0,0 +1,63 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+
+	"ims-go/barcode"
+	"ims-go/models"
+	"ims-go/money"
+)
+
+func TestLookupScan(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	juice, _ := CreateItem(mockDB, "Apple Juice", "9501101530003", "", money.MustParse("2.49"), money.MustParse("1.10"), 10, 1)
+	ham, _ := CreateItem(mockDB, "Ham", "2512345000006", "", money.MustParse("18.00"), money.MustParse("9.00"), 5000, 1)
+	if err := SetUnits(mockDB, ham.ID, "g", []models.ItemUnit{{Name: "kg", Factor: 1000}}, "kg", "", 1); err != nil {
+		t.Fatalf("SetUnits failed: %v", err)
+	}
+
+	// A GS1-128 from the supplier finds the juice by the EAN-13 in its GTIN
+	item, _, parsed, err := LookupScan(mockDB, "]C10109501101530003172912311042")
+	if err != nil || item.ID != juice.ID || parsed == nil || parsed.Lot != "42" || parsed.Expiry == nil {
+		t.Fatalf("Expected juice with lot and expiry, got %+v, %+v, %v", item, parsed, err)
+	}
+	if item, err := GetItemByCode(mockDB, "(01)09501101530003(10)42"); err != nil || item.ID != juice.ID {
+		t.Errorf("Expected GetItemByCode to read the GTIN, got %+v, %v", item, err)
+	}
+
+	// An exact code doesn't need parsing
+	if _, _, parsed, err := LookupScan(mockDB, "9501101530003"); err != nil || parsed != nil {
+		t.Errorf("Expected an exact match, got %+v, %v", parsed, err)
+	}
+
+	// A weight label is ham whatever it weighs
+	item, _, parsed, err = LookupScan(mockDB, "2512345007500")
+	if err != nil || item.ID != ham.ID || parsed.Weight == nil {
+		t.Fatalf("Expected ham by weight, got %+v, %+v, %v", item, parsed, err)
+	}
+	ham, _ = GetItemByID(mockDB, ham.ID)
+	if quantity, err := WeightQuantity(mockDB, ham, *parsed.Weight); err != nil || quantity != 0.75 {
+		t.Errorf("Expected 0.75 kg, got %v, %v", quantity, err)
+	}
+	if _, err := WeightQuantity(mockDB, juice, 750); err == nil {
+		t.Error("Expected error weighing an item not sold by weight")
+	}
+
+	if _, _, _, err := LookupScan(mockDB, "]C10104006381333931"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Expected ErrItemNotFound for a GTIN nobody has, got %v", err)
+	}
+	if _, _, _, err := LookupScan(mockDB, "nothing"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Expected ErrItemNotFound for an unknown code, got %v", err)
+	}
+
+	// Which prefixes carry a weight is configurable
+	saved := barcode.WeightPrefixes
+	barcode.WeightPrefixes = nil
+	defer func() { barcode.WeightPrefixes = saved }()
+	if _, _, _, err := LookupScan(mockDB, "2512345007500"); err == nil {
+		t.Error("Expected no match once 25 is not a weight prefix")
+	}
+}
@@ -3,100 +3,143 @@ package inventory
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
 )
 
 type Database interface {
 	GetDB() *sql.DB
 }
 
-func CreateItem(db Database, name, code, description string, price, cost float64, quantity int) (*models.Item, error) {
-	now := time.Now()
-	result, err := db.GetDB().Exec(
-		"INSERT INTO items (name, code, description, price, cost, quantity, in_stock_date, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		name, code, description, price, cost, quantity, now, now, now,
-	)
+// CreateItem adds an item with its opening stock as the first batch. The
+// opening stock is recorded in the movement ledger against userID. An item
+// without a code is given an in-store one.
+func CreateItem(db Database, name, code, description string, price, cost money.Money, quantity int, userID int) (*models.Item, error) {
+	tx, err := db.GetDB().Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	id, err := result.LastInsertId()
-	if err != nil {
+	if err := roles.RequireTx(tx, userID, models.PermItemCreate); err != nil {
 		return nil, err
 	}
 
-	// Create stock entry
-	_, err = db.GetDB().Exec(
-		"INSERT INTO item_stock (item_id, quantity, in_stock_date) VALUES (?, ?, ?)",
-		id, quantity, now,
-	)
+	id, err := createItemTx(tx, name, code, description, price, cost, quantity, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return GetItemByID(db, int(id))
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetItemByID(db, id)
 }
 
-func GetItemByID(db Database, id int) (*models.Item, error) {
-	var item models.Item
-	var createdAt, updatedAt time.Time
+// createItemTx inserts an item and its opening stock batch, returning the
+// new item's ID
+func createItemTx(tx *sql.Tx, name, code, description string, price, cost money.Money, quantity int, userID int) (int, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		allocated, err := allocateCodeTx(tx)
+		if err != nil {
+			return 0, err
+		}
+		code = allocated
+	}
+	if err := checkCodeFreeTx(tx, code, 0); err != nil {
+		return 0, err
+	}
 
-	var inStockDate time.Time
-	var expiryDate sql.NullTime
-	err := db.GetDB().QueryRow(
-		"SELECT id, name, code, description, price, cost, quantity, in_stock_date, expiry_date, created_at, updated_at FROM items WHERE id = ?",
-		id,
-	).Scan(&item.ID, &item.Name, &item.Code, &item.Description, &item.Price, &item.Cost, &item.Quantity, &inStockDate, &expiryDate, &createdAt, &updatedAt)
+	now := time.Now()
+	result, err := tx.Exec(
+		"INSERT INTO items (name, code, description, price, cost, quantity, in_stock_date, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		name, code, description, price, cost, quantity, now, now, now,
+	)
+	if err != nil {
+		return 0, err
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, errors.New("item not found")
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
 	}
+
+	// Create stock entry
+	result, err = tx.Exec(
+		"INSERT INTO item_stock (item_id, quantity, in_stock_date, unit_cost) VALUES (?, ?, ?, ?)",
+		id, quantity, now, cost,
+	)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	item.InStockDate = inStockDate
-	if expiryDate.Valid {
-		item.ExpiryDate = &expiryDate.Time
+	if quantity != 0 {
+		batchID, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		batch := int(batchID)
+		if err := recordMovementTx(tx, int(id), &batch, quantity, StockChange{Reason: models.MovementRestock, UserID: userID}); err != nil {
+			return 0, err
+		}
 	}
-	item.CreatedAt = createdAt
-	item.UpdatedAt = updatedAt
-	return &item, nil
+
+	return int(id), nil
 }
 
-func GetItemByCode(db Database, code string) (*models.Item, error) {
-	var item models.Item
-	var createdAt, updatedAt, inStockDate time.Time
-	var expiryDate sql.NullTime
+// itemColumns is the column list every item query selects, in the order
+// scanItem expects
+const itemColumns = "id, name, code, description, price, cost, quantity, in_stock_date, expiry_date, reorder_point, reorder_quantity, preferred_supplier_id, created_at, updated_at, archived_at, category_id, parent_id, variant_name, price_override, base_unit, sale_unit, purchase_unit"
 
-	err := db.GetDB().QueryRow(
-		"SELECT id, name, code, description, price, cost, quantity, in_stock_date, expiry_date, created_at, updated_at FROM items WHERE code = ?",
-		code,
-	).Scan(&item.ID, &item.Name, &item.Code, &item.Description, &item.Price, &item.Cost, &item.Quantity, &inStockDate, &expiryDate, &createdAt, &updatedAt)
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	if err == sql.ErrNoRows {
-		return nil, errors.New("item not found")
-	}
+func scanItem(row scanner) (*models.Item, error) {
+	var item models.Item
+	var expiryDate, archivedAt sql.NullTime
+	var reorderPoint, supplierID, categoryID, parentID sql.NullInt64
+
+	err := row.Scan(&item.ID, &item.Name, &item.Code, &item.Description, &item.Price, &item.Cost, &item.Quantity, &item.InStockDate, &expiryDate,
+		&reorderPoint, &item.ReorderQuantity, &supplierID, &item.CreatedAt, &item.UpdatedAt, &archivedAt, &categoryID,
+		&parentID, &item.VariantName, &item.PriceOverride, &item.BaseUnit, &item.SaleUnit, &item.PurchaseUnit)
 	if err != nil {
 		return nil, err
 	}
 
-	item.InStockDate = inStockDate
 	if expiryDate.Valid {
 		item.ExpiryDate = &expiryDate.Time
 	}
-	item.CreatedAt = createdAt
-	item.UpdatedAt = updatedAt
+	if archivedAt.Valid {
+		item.ArchivedAt = &archivedAt.Time
+	}
+	if reorderPoint.Valid {
+		point := int(reorderPoint.Int64)
+		item.ReorderPoint = &point
+	}
+	if supplierID.Valid {
+		id := int(supplierID.Int64)
+		item.PreferredSupplierID = &id
+	}
+	if categoryID.Valid {
+		id := int(categoryID.Int64)
+		item.CategoryID = &id
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		item.ParentID = &id
+	}
 	return &item, nil
 }
 
-func SearchItems(db Database, query string) ([]models.Item, error) {
-	rows, err := db.GetDB().Query(
-		"SELECT id, name, code, description, price, cost, quantity, in_stock_date, expiry_date, created_at, updated_at FROM items WHERE name LIKE ? OR code LIKE ? ORDER BY name",
-		"%"+query+"%", "%"+query+"%",
-	)
+func queryItems(db Database, query string, args ...interface{}) ([]models.Item, error) {
+	rows, err := db.GetDB().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -104,110 +147,374 @@ func SearchItems(db Database, query string) ([]models.Item, error) {
 
 	var items []models.Item
 	for rows.Next() {
-		var item models.Item
-		var createdAt, updatedAt, inStockDate time.Time
-		var expiryDate sql.NullTime
-
-		err := rows.Scan(&item.ID, &item.Name, &item.Code, &item.Description, &item.Price, &item.Cost, &item.Quantity, &inStockDate, &expiryDate, &createdAt, &updatedAt)
+		item, err := scanItem(rows)
 		if err != nil {
 			return nil, err
 		}
+		items = append(items, *item)
+	}
 
-		item.InStockDate = inStockDate
-		if expiryDate.Valid {
-			item.ExpiryDate = &expiryDate.Time
-		}
-		item.CreatedAt = createdAt
-		item.UpdatedAt = updatedAt
-		items = append(items, item)
+	return items, rows.Err()
+}
+
+// ErrItemNotFound is returned when no item has the ID or code asked for
+var ErrItemNotFound = errors.New("item not found")
+
+// GetItemByID returns an item, archived or not, so past sales and orders can
+// always be resolved
+func GetItemByID(db Database, id int) (*models.Item, error) {
+	item, err := scanItem(db.GetDB().QueryRow("SELECT "+itemColumns+" FROM items WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrItemNotFound
 	}
+	return item, err
+}
 
-	return items, nil
+// GetItemByCode returns the active item with the given code or extra
+// barcode, or with the GTIN in a GS1 code. Archived items can't be scanned.
+func GetItemByCode(db Database, code string) (*models.Item, error) {
+	item, _, _, err := LookupScan(db, code)
+	return item, err
 }
 
+// SearchItems returns the active items whose name or code contains query
+func SearchItems(db Database, query string) ([]models.Item, error) {
+	return FilterItems(db, ItemFilter{Query: query})
+}
+
+// GetAllItems returns every active item
 func GetAllItems(db Database) ([]models.Item, error) {
-	rows, err := db.GetDB().Query(
-		"SELECT id, name, code, description, price, cost, quantity, in_stock_date, expiry_date, created_at, updated_at FROM items ORDER BY name",
-	)
+	return queryItems(db, "SELECT "+itemColumns+" FROM items WHERE archived_at IS NULL ORDER BY name")
+}
+
+// GetArchivedItems returns the archived items whose name or code contains
+// query, or all of them when query is empty
+func GetArchivedItems(db Database, query string) ([]models.Item, error) {
+	return FilterItems(db, ItemFilter{Query: query, Archived: true})
+}
+
+// UpdateItem changes an item's details. A non-nil quantity sets the stock
+// level, applied to its stock batches so they stay in step with the item
+// total, and recorded as an adjustment by userID. A nil quantity leaves stock
+// as it is, so sales made while the item was being edited aren't undone.
+//
+// Renaming a parent product renames its variants, and a new price is passed
+// on to variants without a price of their own. A variant given a price other
+// than its parent's keeps it as an override.
+//
+// The user needs item.edit, and item.edit_price to change the price or cost
+// or stock.adjust to change the quantity.
+func UpdateItem(db Database, id int, name, code, description string, price, cost money.Money, quantity *int, userID int) error {
+	tx, err := db.GetDB().Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var items []models.Item
-	for rows.Next() {
-		var item models.Item
-		var createdAt, updatedAt, inStockDate time.Time
-		var expiryDate sql.NullTime
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
 
-		err := rows.Scan(&item.ID, &item.Name, &item.Code, &item.Description, &item.Price, &item.Cost, &item.Quantity, &inStockDate, &expiryDate, &createdAt, &updatedAt)
-		if err != nil {
-			return nil, err
+	// Prices and stock levels need permissions of their own
+	var oldPrice, oldCost money.Money
+	var oldQuantity int
+	err = tx.QueryRow("SELECT price, cost, quantity FROM items WHERE id = ?", id).Scan(&oldPrice, &oldCost, &oldQuantity)
+	if err == sql.ErrNoRows {
+		return errors.New("item not found")
+	}
+	if err != nil {
+		return err
+	}
+	if price != oldPrice || cost != oldCost {
+		if err := roles.RequireTx(tx, userID, models.PermItemEditPrice); err != nil {
+			return err
+		}
+	}
+	if quantity != nil && *quantity != oldQuantity {
+		if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+			return err
 		}
+	}
+
+	if err := checkCodeFreeTx(tx, code, id); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(
+		`UPDATE items SET name = ?, code = ?, description = ?, price = ?, cost = ?, updated_at = ?,
+			price_override = COALESCE((SELECT p.price != ? FROM items p WHERE p.id = items.parent_id), 0)
+		 WHERE id = ?`,
+		name, code, description, price, cost, now, price, id,
+	)
+	if err != nil {
+		return err
+	}
 
-		item.InStockDate = inStockDate
-		if expiryDate.Valid {
-			item.ExpiryDate = &expiryDate.Time
+	_, err = tx.Exec(
+		`UPDATE items SET name = ? || ' (' || variant_name || ')',
+			price = CASE WHEN price_override THEN price ELSE ? END, updated_at = ?
+		 WHERE parent_id = ?`,
+		name, price, now, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	if quantity != nil {
+		if err := SetStockLevelTx(tx, id, *quantity, StockChange{Reason: models.MovementAdjustment, UserID: userID}); err != nil {
+			return err
 		}
-		item.CreatedAt = createdAt
-		item.UpdatedAt = updatedAt
-		items = append(items, item)
 	}
+	return tx.Commit()
+}
 
-	return items, nil
+// ItemFilter narrows down an item listing. Empty fields match everything.
+type ItemFilter struct {
+	// Query matches part of the name, code or an extra barcode
+	Query string
+	// CategoryID matches items in the category or any of its subcategories
+	CategoryID *int
+	// Tag matches items with that tag, ignoring case
+	Tag string
+	// Archived lists archived items instead of active ones
+	Archived bool
 }
 
-func UpdateItem(db Database, id int, name, code, description string, price, cost float64, quantity int) error {
-	_, err := db.GetDB().Exec(
-		"UPDATE items SET name = ?, code = ?, description = ?, price = ?, cost = ?, quantity = ?, updated_at = ? WHERE id = ?",
-		name, code, description, price, cost, quantity, time.Now(), id,
-	)
-	return err
+// FilterItems returns the items matching filter, ordered by name
+func FilterItems(db Database, filter ItemFilter) ([]models.Item, error) {
+	query := "SELECT " + itemColumns + " FROM items WHERE archived_at IS NULL"
+	if filter.Archived {
+		query = "SELECT " + itemColumns + " FROM items WHERE archived_at IS NOT NULL"
+	}
+	var args []interface{}
+
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		query += " AND (name LIKE ? OR code LIKE ? OR id IN (SELECT item_id FROM item_barcodes WHERE code LIKE ?))"
+		args = append(args, "%"+q+"%", "%"+q+"%", "%"+q+"%")
+	}
+	if filter.CategoryID != nil {
+		query += ` AND category_id IN (
+			WITH RECURSIVE tree(id) AS (
+				SELECT ? UNION ALL SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+			)
+			SELECT id FROM tree)`
+		args = append(args, *filter.CategoryID)
+	}
+	if tag := strings.TrimSpace(filter.Tag); tag != "" {
+		query += " AND id IN (SELECT it.item_id FROM item_tags it JOIN tags t ON it.tag_id = t.id WHERE t.name = ?)"
+		args = append(args, tag)
+	}
+
+	return queryItems(db, query+" ORDER BY name", args...)
 }
 
-func DeleteItem(db Database, id int) error {
-	_, err := db.GetDB().Exec("DELETE FROM items WHERE id = ?", id)
-	return err
+// ErrItemHasHistory is returned when deleting an item that has been sold,
+// ordered or counted, or that has ever had stock, opening stock included.
+// The stock ledger is never rewritten, so such items are archived instead.
+var ErrItemHasHistory = errors.New("item has sales or stock history; archive it instead")
+
+// DeleteItem removes an item that has no history, along with its tags,
+// units and barcodes
+func DeleteItem(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	var history int
+	err = tx.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM transaction_items WHERE item_id = ?)
+			+ (SELECT COUNT(*) FROM purchase_order_lines WHERE item_id = ?)
+			+ (SELECT COUNT(*) FROM stocktake_lines WHERE item_id = ?)
+			+ (SELECT COUNT(*) FROM stock_movements WHERE item_id = ?)`,
+		id, id, id, id,
+	).Scan(&history)
+	if err != nil {
+		return err
+	}
+	if history > 0 {
+		return ErrItemHasHistory
+	}
+
+	var variants int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM items WHERE parent_id = ?", id).Scan(&variants); err != nil {
+		return err
+	}
+	if variants > 0 {
+		return errors.New("item has variants; delete or archive them first")
+	}
+
+	for _, table := range []string{"item_stock", "item_tags", "item_units", "item_barcodes"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE item_id = ?", id); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM items WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("item not found")
+	}
+	return tx.Commit()
 }
 
-func UpdateItemQuantity(db Database, id int, quantity int) error {
-	_, err := db.GetDB().Exec(
-		"UPDATE items SET quantity = ?, updated_at = ? WHERE id = ?",
-		quantity, time.Now(), id,
-	)
-	return err
+// ArchiveItem hides an item from searches, scanning and sales while keeping
+// it for reports and past transactions. Archiving a parent product archives
+// its active variants with it.
+func ArchiveItem(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := tx.Exec("UPDATE items SET archived_at = ?, updated_at = ? WHERE id = ? AND archived_at IS NULL", now, now, id)
+	if err := checkChanged(result, err, "item not found or already archived"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE items SET archived_at = ?, updated_at = ? WHERE parent_id = ? AND archived_at IS NULL", now, now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// GetLowStockItems returns items with quantity below the threshold
-func GetLowStockItems(db Database, threshold int) ([]models.Item, error) {
-	rows, err := db.GetDB().Query(
-		"SELECT id, name, code, description, price, cost, quantity, in_stock_date, expiry_date, created_at, updated_at FROM items WHERE quantity < ? ORDER BY quantity ASC",
-		threshold,
+// RestoreItem makes an archived item active again. Restoring a parent
+// product brings back the variants archived along with it; a variant can't
+// be restored while its parent is archived.
+func RestoreItem(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	var archivedAt sql.NullTime
+	var parentArchived bool
+	err = tx.QueryRow(
+		"SELECT archived_at, COALESCE((SELECT p.archived_at IS NOT NULL FROM items p WHERE p.id = items.parent_id), 0) FROM items WHERE id = ?",
+		id,
+	).Scan(&archivedAt, &parentArchived)
+	if err == sql.ErrNoRows || (err == nil && !archivedAt.Valid) {
+		return errors.New("item not found or not archived")
+	}
+	if err != nil {
+		return err
+	}
+	if parentArchived {
+		return errors.New("the item's parent product is archived; restore it first")
+	}
+
+	// Variants archived along with the parent share its archived_at
+	now := time.Now()
+	_, err = tx.Exec(
+		"UPDATE items SET archived_at = NULL, updated_at = ? WHERE parent_id = ? AND archived_at = (SELECT archived_at FROM items WHERE id = ?)",
+		now, id, id,
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	if _, err := tx.Exec("UPDATE items SET archived_at = NULL, updated_at = ? WHERE id = ?", now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	var items []models.Item
-	for rows.Next() {
-		var item models.Item
-		var createdAt, updatedAt, inStockDate time.Time
-		var expiryDate sql.NullTime
+// checkChanged turns an update that matched no rows into an error
+func checkChanged(result sql.Result, err error, message string) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New(message)
+	}
+	return nil
+}
 
-		err := rows.Scan(&item.ID, &item.Name, &item.Code, &item.Description, &item.Price, &item.Cost, &item.Quantity, &inStockDate, &expiryDate, &createdAt, &updatedAt)
-		if err != nil {
-			return nil, err
-		}
+// UpdateItemQuantity sets an item's quantity, adjusting its stock batches to
+// match and recording the change as an adjustment by userID
+func UpdateItemQuantity(db Database, id int, quantity int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		item.InStockDate = inStockDate
-		if expiryDate.Valid {
-			item.ExpiryDate = &expiryDate.Time
-		}
-		item.CreatedAt = createdAt
-		item.UpdatedAt = updatedAt
-		items = append(items, item)
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return err
+	}
+
+	if err := SetStockLevelTx(tx, id, quantity, StockChange{Reason: models.MovementAdjustment, UserID: userID}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLowStockItems returns items with quantity below their reorder point.
+// Items without their own reorder point use defaultThreshold. Parent products
+// are left out since their stock is held by their variants.
+func GetLowStockItems(db Database, defaultThreshold int) ([]models.Item, error) {
+	return queryItems(db,
+		`SELECT `+itemColumns+` FROM items
+		 WHERE archived_at IS NULL AND quantity < COALESCE(reorder_point, ?)
+			AND id NOT IN (SELECT parent_id FROM items WHERE parent_id IS NOT NULL AND archived_at IS NULL)
+		 ORDER BY quantity ASC`,
+		defaultThreshold,
+	)
+}
+
+// SetReorderSettings sets when an item should be reordered and how. A nil
+// reorderPoint falls back to the global low stock threshold. reorderQuantity
+// is the usual order size (0 for none) and preferredSupplierID the supplier
+// it is normally bought from (nil for none).
+func SetReorderSettings(db Database, id int, reorderPoint *int, reorderQuantity int, preferredSupplierID *int, userID int) error {
+	if reorderPoint != nil && *reorderPoint < 0 {
+		return errors.New("reorder point must not be negative")
+	}
+	if reorderQuantity < 0 {
+		return errors.New("reorder quantity must not be negative")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	return items, nil
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		"UPDATE items SET reorder_point = ?, reorder_quantity = ?, preferred_supplier_id = ?, updated_at = ? WHERE id = ?",
+		reorderPoint, reorderQuantity, preferredSupplierID, time.Now(), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrItemNotFound
+	}
+	return tx.Commit()
 }
@@ -2,7 +2,13 @@ package inventory
 
 import (
 	"database/sql"
+	"errors"
 	"testing"
+	"time"
+
+	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
 
 	_ "modernc.org/sqlite"
 )
@@ -26,13 +32,24 @@ func setupTestDB(t *testing.T) *MockDB {
 		name TEXT NOT NULL,
 		code TEXT UNIQUE NOT NULL,
 		description TEXT,
-		price REAL NOT NULL,
-		cost REAL NOT NULL DEFAULT 0,
+		price INTEGER NOT NULL,
+		cost INTEGER NOT NULL DEFAULT 0,
 		quantity INTEGER DEFAULT 0,
 		in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
 		expiry_date DATETIME,
+		reorder_point INTEGER,
+		reorder_quantity INTEGER NOT NULL DEFAULT 0,
+		preferred_supplier_id INTEGER,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		archived_at DATETIME,
+		category_id INTEGER,
+		parent_id INTEGER,
+		variant_name TEXT NOT NULL DEFAULT '',
+		price_override BOOLEAN NOT NULL DEFAULT 0,
+		base_unit TEXT NOT NULL DEFAULT 'each',
+		sale_unit TEXT NOT NULL DEFAULT '',
+		purchase_unit TEXT NOT NULL DEFAULT ''
 	)`)
 	if err != nil {
 		t.Fatalf("Failed to create items table: %v", err)
@@ -43,12 +60,124 @@ func setupTestDB(t *testing.T) *MockDB {
 		item_id INTEGER NOT NULL,
 		quantity INTEGER NOT NULL,
 		in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
-		expiry_date DATETIME
+		expiry_date DATETIME,
+		unit_cost INTEGER,
+		cost_factor INTEGER NOT NULL DEFAULT 1,
+		purchase_order_line_id INTEGER
 	)`)
 	if err != nil {
 		t.Fatalf("Failed to create item_stock table: %v", err)
 	}
 
+	_, err = db.Exec(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		is_root_admin INTEGER DEFAULT 0,
+		archived_at DATETIME
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create users table: %v", err)
+	}
+
+	// User 1 is the root admin; clerk, user 2, can't change prices
+	roleSetup := []string{
+		`CREATE TABLE roles (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL COLLATE NOCASE, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE role_permissions (role_id INTEGER NOT NULL, permission TEXT NOT NULL, PRIMARY KEY (role_id, permission))`,
+		`CREATE TABLE user_roles (user_id INTEGER NOT NULL, role_id INTEGER NOT NULL, PRIMARY KEY (user_id, role_id))`,
+		`INSERT INTO users (username, is_root_admin) VALUES ('admin', 1), ('clerk', 0)`,
+		`INSERT INTO roles (name) VALUES ('Stock Clerk')`,
+		`INSERT INTO role_permissions (role_id, permission) VALUES (1, 'item.view'), (1, 'item.create'), (1, 'item.edit'), (1, 'stock.adjust')`,
+		`INSERT INTO user_roles (user_id, role_id) VALUES (2, 1)`,
+	}
+	for _, stmt := range roleSetup {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up users: %v", err)
+		}
+	}
+
+	_, err = db.Exec(`CREATE TABLE stock_movements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		batch_id INTEGER,
+		delta INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		reference_id INTEGER,
+		user_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create stock_movements table: %v", err)
+	}
+
+	for _, query := range []string{
+		`CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT NOT NULL, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE setting_changes (id INTEGER PRIMARY KEY AUTOINCREMENT, key TEXT NOT NULL, old_value TEXT, new_value TEXT NOT NULL, changed_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO settings (key, value) VALUES ('costing_method', 'fifo')`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Failed to create settings: %v", err)
+		}
+	}
+
+	// Tables that refer to items, checked before an item is deleted
+	for _, table := range []string{"transaction_items", "purchase_order_lines", "stocktake_lines"} {
+		_, err = db.Exec("CREATE TABLE " + table + " (id INTEGER PRIMARY KEY AUTOINCREMENT, item_id INTEGER NOT NULL)")
+		if err != nil {
+			t.Fatalf("Failed to create %s table: %v", table, err)
+		}
+	}
+
+	_, err = db.Exec(`CREATE TABLE item_barcodes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		code TEXT UNIQUE NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		quantity INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create item_barcodes table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE item_units (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		name TEXT NOT NULL COLLATE NOCASE,
+		factor INTEGER NOT NULL,
+		UNIQUE (item_id, name)
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create item_units table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE categories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		parent_id INTEGER,
+		tax_class TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create categories table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create tags table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE item_tags (
+		item_id INTEGER NOT NULL,
+		tag_id INTEGER NOT NULL,
+		PRIMARY KEY (item_id, tag_id)
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create item_tags table: %v", err)
+	}
+
 	return &MockDB{db: db}
 }
 
@@ -56,7 +185,7 @@ func TestCreateItem(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	item, err := CreateItem(mockDB, "Test Item", "CODE001", "A test item", 9.99, 5.00, 10)
+	item, err := CreateItem(mockDB, "Test Item", "CODE001", "A test item", money.MustParse("9.99"), money.MustParse("5.00"), 10, 1)
 	if err != nil {
 		t.Fatalf("CreateItem failed: %v", err)
 	}
@@ -67,8 +196,8 @@ func TestCreateItem(t *testing.T) {
 	if item.Code != "CODE001" {
 		t.Errorf("Expected code 'CODE001', got '%s'", item.Code)
 	}
-	if item.Price != 9.99 {
-		t.Errorf("Expected price 9.99, got %f", item.Price)
+	if item.Price != money.MustParse("9.99") {
+		t.Errorf("Expected price 9.99, got %s", item.Price)
 	}
 	if item.Quantity != 10 {
 		t.Errorf("Expected quantity 10, got %d", item.Quantity)
@@ -79,7 +208,7 @@ func TestGetItemByID(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	created, err := CreateItem(mockDB, "Find Me", "FIND001", "Item to find", 15.00, 10.00, 5)
+	created, err := CreateItem(mockDB, "Find Me", "FIND001", "Item to find", money.MustParse("15.00"), money.MustParse("10.00"), 5, 1)
 	if err != nil {
 		t.Fatalf("CreateItem failed: %v", err)
 	}
@@ -98,7 +227,7 @@ func TestGetItemByCode(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	_, err := CreateItem(mockDB, "Barcode Item", "BAR123", "Item with barcode", 20.00, 12.00, 8)
+	_, err := CreateItem(mockDB, "Barcode Item", "BAR123", "Item with barcode", money.MustParse("20.00"), money.MustParse("12.00"), 8, 1)
 	if err != nil {
 		t.Fatalf("CreateItem failed: %v", err)
 	}
@@ -117,9 +246,9 @@ func TestSearchItems(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	CreateItem(mockDB, "Apple Juice", "AJ001", "Fresh apple juice", 3.50, 2.00, 20)
-	CreateItem(mockDB, "Orange Juice", "OJ001", "Fresh orange juice", 3.50, 2.00, 15)
-	CreateItem(mockDB, "Milk", "MK001", "Fresh milk", 2.00, 1.00, 30)
+	CreateItem(mockDB, "Apple Juice", "AJ001", "Fresh apple juice", money.MustParse("3.50"), money.MustParse("2.00"), 20, 1)
+	CreateItem(mockDB, "Orange Juice", "OJ001", "Fresh orange juice", money.MustParse("3.50"), money.MustParse("2.00"), 15, 1)
+	CreateItem(mockDB, "Milk", "MK001", "Fresh milk", money.MustParse("2.00"), money.MustParse("1.00"), 30, 1)
 
 	results, err := SearchItems(mockDB, "Juice")
 	if err != nil {
@@ -131,16 +260,67 @@ func TestSearchItems(t *testing.T) {
 	}
 }
 
+func TestFilterItems(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	apple, _ := CreateItem(mockDB, "Apple Juice", "AJ001", "", money.MustParse("3.50"), money.MustParse("2.00"), 20, 1)
+	cola, _ := CreateItem(mockDB, "Cola", "CL001", "", money.MustParse("1.50"), money.MustParse("0.80"), 15, 1)
+	CreateItem(mockDB, "Milk", "MK001", "", money.MustParse("2.00"), money.MustParse("1.00"), 30, 1)
+
+	// Drinks (1) > Soda (2)
+	setup := []string{
+		"INSERT INTO categories (id, name, parent_id) VALUES (1, 'Drinks', NULL), (2, 'Soda', 1)",
+		"INSERT INTO tags (id, name) VALUES (1, 'organic')",
+		"INSERT INTO item_tags (item_id, tag_id) VALUES (1, 1)",
+	}
+	for _, stmt := range setup {
+		if _, err := mockDB.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up categories: %v", err)
+		}
+	}
+	mockDB.db.Exec("UPDATE items SET category_id = 1 WHERE id = ?", apple.ID)
+	mockDB.db.Exec("UPDATE items SET category_id = 2 WHERE id = ?", cola.ID)
+
+	drinks, soda := 1, 2
+	tests := []struct {
+		name   string
+		filter ItemFilter
+		want   int
+	}{
+		{"everything", ItemFilter{}, 3},
+		{"category with subcategories", ItemFilter{CategoryID: &drinks}, 2},
+		{"subcategory", ItemFilter{CategoryID: &soda}, 1},
+		{"tag ignoring case", ItemFilter{Tag: "Organic"}, 1},
+		{"category and query", ItemFilter{CategoryID: &drinks, Query: "cola"}, 1},
+		{"category and tag", ItemFilter{CategoryID: &soda, Tag: "organic"}, 0},
+	}
+	for _, tt := range tests {
+		items, err := FilterItems(mockDB, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: FilterItems failed: %v", tt.name, err)
+		}
+		if len(items) != tt.want {
+			t.Errorf("%s: expected %d items, got %d", tt.name, tt.want, len(items))
+		}
+	}
+
+	item, _ := GetItemByID(mockDB, cola.ID)
+	if item.CategoryID == nil || *item.CategoryID != soda {
+		t.Errorf("Expected item category %d, got %v", soda, item.CategoryID)
+	}
+}
+
 func TestUpdateItemQuantity(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	item, err := CreateItem(mockDB, "Stock Item", "ST001", "Item for stock test", 10.00, 6.00, 100)
+	item, err := CreateItem(mockDB, "Stock Item", "ST001", "Item for stock test", money.MustParse("10.00"), money.MustParse("6.00"), 100, 1)
 	if err != nil {
 		t.Fatalf("CreateItem failed: %v", err)
 	}
 
-	err = UpdateItemQuantity(mockDB, item.ID, 75)
+	err = UpdateItemQuantity(mockDB, item.ID, 75, 1)
 	if err != nil {
 		t.Fatalf("UpdateItemQuantity failed: %v", err)
 	}
@@ -153,18 +333,100 @@ func TestUpdateItemQuantity(t *testing.T) {
 	if updated.Quantity != 75 {
 		t.Errorf("Expected quantity 75, got %d", updated.Quantity)
 	}
+
+	// Batches are kept in step with the item quantity both ways
+	if got := batchTotal(t, mockDB, item.ID); got != 75 {
+		t.Errorf("Expected batches to total 75, got %d", got)
+	}
+	if err := UpdateItemQuantity(mockDB, item.ID, 80, 1); err != nil {
+		t.Fatalf("UpdateItemQuantity failed: %v", err)
+	}
+	if got := batchTotal(t, mockDB, item.ID); got != 80 {
+		t.Errorf("Expected batches to total 80, got %d", got)
+	}
+}
+
+func TestUpdateItem_Permissions(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	const clerkID = 2
+	item, err := CreateItem(mockDB, "Tea", "TEA001", "", money.MustParse("3.00"), money.MustParse("1.20"), 10, clerkID)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+
+	// The clerk can rename and restock, but not reprice
+	twelve := 12
+	if err := UpdateItem(mockDB, item.ID, "Green Tea", "TEA001", "", item.Price, item.Cost, &twelve, clerkID); err != nil {
+		t.Errorf("Expected the clerk to edit details and stock: %v", err)
+	}
+	if err := UpdateItem(mockDB, item.ID, "Green Tea", "TEA001", "", money.MustParse("2.50"), item.Cost, nil, clerkID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted changing the price, got %v", err)
+	}
+	if err := UpdateItem(mockDB, item.ID, "Green Tea", "TEA001", "", money.MustParse("2.50"), item.Cost, nil, 1); err != nil {
+		t.Errorf("Expected the root admin to change the price: %v", err)
+	}
+	if updated, _ := GetItemByID(mockDB, item.ID); updated.Name != "Green Tea" || updated.Price != money.MustParse("2.50") || updated.Quantity != 12 {
+		t.Errorf("Expected Green Tea at 2.50 with 12 in stock, got %+v", updated)
+	}
+
+	// Unknown users can do nothing
+	if _, err := CreateItem(mockDB, "Coffee", "COF001", "", money.MustParse("4.00"), 0, 0, 99); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted for an unknown user, got %v", err)
+	}
+}
+
+func TestUpdateItem_LeavesStockAlone(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, err := CreateItem(mockDB, "Tea", "TEA001", "", money.MustParse("3.00"), money.MustParse("1.20"), 10, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+
+	// Stock moves after the edit form was loaded; saving only the details
+	// keeps the live figure and records no adjustment
+	if err := UpdateItemQuantity(mockDB, item.ID, 7, 1); err != nil {
+		t.Fatalf("UpdateItemQuantity failed: %v", err)
+	}
+	var before int
+	mockDB.db.QueryRow("SELECT COUNT(*) FROM stock_movements WHERE item_id = ?", item.ID).Scan(&before)
+
+	if err := UpdateItem(mockDB, item.ID, "Green Tea", item.Code, "", item.Price, item.Cost, nil, 1); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+	updated, _ := GetItemByID(mockDB, item.ID)
+	if updated.Name != "Green Tea" || updated.Quantity != 7 {
+		t.Errorf("Expected Green Tea with 7 in stock, got %s with %d", updated.Name, updated.Quantity)
+	}
+	var after int
+	mockDB.db.QueryRow("SELECT COUNT(*) FROM stock_movements WHERE item_id = ?", item.ID).Scan(&after)
+	if after != before {
+		t.Errorf("Expected no new stock movements, got %d more", after-before)
+	}
+}
+
+func batchTotal(t *testing.T, mockDB *MockDB, itemID int) int {
+	var total int
+	err := mockDB.db.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM item_stock WHERE item_id = ?", itemID).Scan(&total)
+	if err != nil {
+		t.Fatalf("Failed to total stock batches: %v", err)
+	}
+	return total
 }
 
 func TestDeleteItem(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	item, err := CreateItem(mockDB, "Delete Me", "DEL001", "Item to delete", 5.00, 3.00, 10)
+	item, err := CreateItem(mockDB, "Delete Me", "DEL001", "Item to delete", money.MustParse("5.00"), money.MustParse("3.00"), 0, 1)
 	if err != nil {
 		t.Fatalf("CreateItem failed: %v", err)
 	}
 
-	err = DeleteItem(mockDB, item.ID)
+	err = DeleteItem(mockDB, item.ID, 1)
 	if err != nil {
 		t.Fatalf("DeleteItem failed: %v", err)
 	}
@@ -173,16 +435,116 @@ func TestDeleteItem(t *testing.T) {
 	if err == nil {
 		t.Error("GetItemByID should fail after item is deleted")
 	}
+	if total := batchTotal(t, mockDB, item.ID); total != 0 {
+		t.Errorf("Expected the item's batches to go with it, %d left", total)
+	}
+
+	// Opening stock is in the ledger, which is never rewritten, so an item
+	// created with stock can only be archived
+	opened, err := CreateItem(mockDB, "Typo", "TYPO001", "Created by mistake", money.MustParse("5.00"), money.MustParse("3.00"), 10, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	if err := DeleteItem(mockDB, opened.ID, 1); !errors.Is(err, ErrItemHasHistory) {
+		t.Errorf("Expected ErrItemHasHistory for an item with opening stock, got %v", err)
+	}
+	var movements int
+	mockDB.db.QueryRow("SELECT COUNT(*) FROM stock_movements WHERE item_id = ?", opened.ID).Scan(&movements)
+	if movements != 1 {
+		t.Errorf("Expected the opening movement to be kept, got %d movements", movements)
+	}
+	// Taking the stock back out doesn't make it deletable either
+	if err := UpdateItemQuantity(mockDB, opened.ID, 0, 1); err != nil {
+		t.Fatalf("UpdateItemQuantity failed: %v", err)
+	}
+	if err := DeleteItem(mockDB, opened.ID, 1); !errors.Is(err, ErrItemHasHistory) {
+		t.Errorf("Expected ErrItemHasHistory once opening stock is removed, got %v", err)
+	}
+	if err := ArchiveItem(mockDB, opened.ID, 1); err != nil {
+		t.Fatalf("ArchiveItem failed: %v", err)
+	}
+
+	// Any later stock movement is history too
+	stocked, err := CreateItem(mockDB, "Keep Me", "KEEP001", "Item with stock", money.MustParse("5.00"), money.MustParse("3.00"), 10, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	if err := AdjustStock(mockDB, stocked.ID, nil, -1, models.MovementDamage, 1); err != nil {
+		t.Fatalf("AdjustStock failed: %v", err)
+	}
+	if err := DeleteItem(mockDB, stocked.ID, 1); !errors.Is(err, ErrItemHasHistory) {
+		t.Errorf("Expected ErrItemHasHistory, got %v", err)
+	}
+	if err := DeleteItem(mockDB, 999, 1); err == nil {
+		t.Error("Expected error deleting an unknown item")
+	}
+}
+
+func TestArchiveItem(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, err := CreateItem(mockDB, "Old Soda", "SODA01", "Discontinued", money.MustParse("1.00"), money.MustParse("0.50"), 2, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	if _, err := CreateItem(mockDB, "New Soda", "SODA02", "", money.MustParse("1.20"), money.MustParse("0.60"), 2, 1); err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+
+	if err := ArchiveItem(mockDB, item.ID, 1); err != nil {
+		t.Fatalf("ArchiveItem failed: %v", err)
+	}
+	if err := ArchiveItem(mockDB, item.ID, 1); err == nil {
+		t.Error("Expected error archiving twice")
+	}
+
+	// Hidden from everything used to find items to sell
+	all, _ := GetAllItems(mockDB)
+	found, _ := SearchItems(mockDB, "soda")
+	low, _ := GetLowStockItems(mockDB, 10)
+	if len(all) != 1 || len(found) != 1 || len(low) != 1 {
+		t.Errorf("Expected only the active item listed, got %d, %d and %d", len(all), len(found), len(low))
+	}
+	if _, err := GetItemByCode(mockDB, "SODA01"); err == nil {
+		t.Error("Expected archived item not to be found by code")
+	}
+
+	// Still resolvable by ID and listed as archived
+	archived, err := GetItemByID(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemByID failed: %v", err)
+	}
+	if archived.ArchivedAt == nil {
+		t.Error("Expected ArchivedAt to be set")
+	}
+	list, err := GetArchivedItems(mockDB, "")
+	if err != nil {
+		t.Fatalf("GetArchivedItems failed: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != item.ID {
+		t.Errorf("Expected the archived item listed, got %+v", list)
+	}
+
+	if err := RestoreItem(mockDB, item.ID, 1); err != nil {
+		t.Fatalf("RestoreItem failed: %v", err)
+	}
+	if _, err := GetItemByCode(mockDB, "SODA01"); err != nil {
+		t.Errorf("Expected restored item to be found by code: %v", err)
+	}
+	if err := RestoreItem(mockDB, item.ID, 1); err == nil {
+		t.Error("Expected error restoring an active item")
+	}
 }
 
 func TestGetLowStockItems(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	CreateItem(mockDB, "Low Stock Item", "LOW001", "Only 5 left", 10.00, 5.00, 5)
-	CreateItem(mockDB, "Medium Stock Item", "MED001", "Has 15", 10.00, 5.00, 15)
-	CreateItem(mockDB, "High Stock Item", "HIGH001", "Has 100", 10.00, 5.00, 100)
-	CreateItem(mockDB, "Very Low Stock", "VLOW001", "Only 2 left", 10.00, 5.00, 2)
+	CreateItem(mockDB, "Low Stock Item", "LOW001", "Only 5 left", money.MustParse("10.00"), money.MustParse("5.00"), 5, 1)
+	CreateItem(mockDB, "Medium Stock Item", "MED001", "Has 15", money.MustParse("10.00"), money.MustParse("5.00"), 15, 1)
+	CreateItem(mockDB, "High Stock Item", "HIGH001", "Has 100", money.MustParse("10.00"), money.MustParse("5.00"), 100, 1)
+	CreateItem(mockDB, "Very Low Stock", "VLOW001", "Only 2 left", money.MustParse("10.00"), money.MustParse("5.00"), 2, 1)
 
 	lowStockItems, err := GetLowStockItems(mockDB, 10)
 	if err != nil {
@@ -199,3 +561,385 @@ func TestGetLowStockItems(t *testing.T) {
 		}
 	}
 }
+
+func TestRestockItem(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, err := CreateItem(mockDB, "Restock Item", "RS001", "Item for restock test", money.MustParse("10.00"), money.MustParse("6.00"), 10, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+
+	if err := RestockItem(mockDB, item.ID, 5, "", nil, nil, 1); err != nil {
+		t.Fatalf("RestockItem failed: %v", err)
+	}
+
+	// Restock with a known cost as part of a larger transaction
+	tx, err := mockDB.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	cost := money.MustParse("5.50")
+	lineID := 7
+	_, err = RestockItemTx(tx, models.ItemStock{ItemID: item.ID, Quantity: 3, UnitCost: &cost, PurchaseOrderLineID: &lineID}, StockChange{Reason: models.MovementRestock})
+	if err != nil {
+		t.Fatalf("RestockItemTx failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	quantity, err := GetItemQuantity(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemQuantity failed: %v", err)
+	}
+	if quantity != 18 {
+		t.Errorf("Expected quantity 18, got %d", quantity)
+	}
+
+	batches, err := GetItemStockBatches(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemStockBatches failed: %v", err)
+	}
+	// The initial stock from CreateItem is the first batch
+	if len(batches) != 3 {
+		t.Fatalf("Expected 3 batches, got %d", len(batches))
+	}
+	// Without a unit cost the batch is valued at the item's cost
+	if batches[1].UnitCost == nil || *batches[1].UnitCost != money.MustParse("6.00") {
+		t.Errorf("Expected plain restock at the item cost, got %v", batches[1].UnitCost)
+	}
+	if batches[2].UnitCost == nil || *batches[2].UnitCost != cost {
+		t.Errorf("Expected unit cost %v, got %v", cost, batches[2].UnitCost)
+	}
+	if batches[2].PurchaseOrderLineID == nil || *batches[2].PurchaseOrderLineID != lineID {
+		t.Errorf("Expected purchase order line %d, got %v", lineID, batches[2].PurchaseOrderLineID)
+	}
+
+	// Invalid restocks are rejected
+	if err := RestockItem(mockDB, item.ID, 0, "", nil, nil, 1); err == nil {
+		t.Error("Expected error for zero quantity")
+	}
+	if err := RestockItem(mockDB, 999, 5, "", nil, nil, 1); err == nil {
+		t.Error("Expected error for unknown item")
+	}
+}
+
+func TestSetReorderSettings(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	water, _ := CreateItem(mockDB, "Water Pallet", "WAT001", "Cases of water", money.MustParse("10.00"), money.MustParse("5.00"), 150, 1)
+	camera, _ := CreateItem(mockDB, "Camera", "CAM001", "Expensive single item", money.MustParse("900.00"), money.MustParse("600.00"), 2, 1)
+	CreateItem(mockDB, "Default Item", "DEF001", "Uses the global threshold", money.MustParse("1.00"), money.MustParse("0.50"), 8, 1)
+
+	waterPoint, cameraPoint, supplierID := 200, 1, 3
+	if err := SetReorderSettings(mockDB, water.ID, &waterPoint, 500, &supplierID, 1); err != nil {
+		t.Fatalf("SetReorderSettings failed: %v", err)
+	}
+	if err := SetReorderSettings(mockDB, camera.ID, &cameraPoint, 0, nil, 1); err != nil {
+		t.Fatalf("SetReorderSettings failed: %v", err)
+	}
+
+	updated, err := GetItemByID(mockDB, water.ID)
+	if err != nil {
+		t.Fatalf("GetItemByID failed: %v", err)
+	}
+	if updated.ReorderPoint == nil || *updated.ReorderPoint != 200 {
+		t.Errorf("Expected reorder point 200, got %v", updated.ReorderPoint)
+	}
+	if updated.ReorderQuantity != 500 {
+		t.Errorf("Expected reorder quantity 500, got %d", updated.ReorderQuantity)
+	}
+	if updated.PreferredSupplierID == nil || *updated.PreferredSupplierID != supplierID {
+		t.Errorf("Expected preferred supplier %d, got %v", supplierID, updated.PreferredSupplierID)
+	}
+
+	// Water is below its own point even though it has 150; the camera is
+	// above its point of 1; the default item is below the global 10
+	lowStockItems, err := GetLowStockItems(mockDB, 10)
+	if err != nil {
+		t.Fatalf("GetLowStockItems failed: %v", err)
+	}
+	var codes []string
+	for _, item := range lowStockItems {
+		codes = append(codes, item.Code)
+		if !item.IsLowStock(10) {
+			t.Errorf("Expected %s to report low stock", item.Code)
+		}
+	}
+	if len(codes) != 2 || codes[0] != "DEF001" || codes[1] != "WAT001" {
+		t.Errorf("Expected DEF001 and WAT001 to be low stock, got %v", codes)
+	}
+
+	negative := -1
+	if err := SetReorderSettings(mockDB, water.ID, &negative, 0, nil, 1); err == nil {
+		t.Error("Expected error for negative reorder point")
+	}
+	if err := SetReorderSettings(mockDB, water.ID, nil, -5, nil, 1); err == nil {
+		t.Error("Expected error for negative reorder quantity")
+	}
+	if err := SetReorderSettings(mockDB, 999, nil, 0, nil, 1); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Expected ErrItemNotFound for an unknown item, got %v", err)
+	}
+}
+
+func TestDepleteStockTx(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// The initial batch from CreateItem has no expiry, so it is used last
+	item, err := CreateItem(mockDB, "Yogurt", "YOG001", "Cups of yogurt", money.MustParse("1.00"), money.MustParse("0.50"), 10, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	later := time.Now().AddDate(0, 0, 20)
+	sooner := time.Now().AddDate(0, 0, 5)
+	RestockItem(mockDB, item.ID, 4, "", &later, nil, 1)
+	RestockItem(mockDB, item.ID, 4, "", &sooner, nil, 1)
+
+	batches, err := GetItemStockBatches(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemStockBatches failed: %v", err)
+	}
+	if len(batches) != 3 || batches[0].ExpiryDate == nil || !batches[0].ExpiryDate.Equal(sooner) || batches[2].ExpiryDate != nil {
+		t.Fatalf("Expected batches in expiry order, got %+v", batches)
+	}
+
+	tx, err := mockDB.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	allocations, err := DepleteStockTx(tx, item.ID, nil, 6, StockChange{Reason: models.MovementSale})
+	if err != nil {
+		t.Fatalf("DepleteStockTx failed: %v", err)
+	}
+	cost := money.MustParse("0.50")
+	want := []BatchAllocation{{BatchID: batches[0].ID, Quantity: 4, UnitCost: cost, CostFactor: 1}, {BatchID: batches[1].ID, Quantity: 2, UnitCost: cost, CostFactor: 1}}
+	if len(allocations) != 2 || allocations[0] != want[0] || allocations[1] != want[1] {
+		t.Errorf("Expected allocations %v, got %v", want, allocations)
+	}
+
+	// A specific batch can be asked for, but not beyond what it holds
+	if _, err := DepleteStockTx(tx, item.ID, &batches[2].ID, 11, StockChange{Reason: models.MovementSale}); err == nil {
+		t.Error("Expected error when taking more than the batch holds")
+	}
+	if _, err := DepleteStockTx(tx, item.ID, &batches[2].ID, 3, StockChange{Reason: models.MovementSale}); err != nil {
+		t.Fatalf("DepleteStockTx with batch failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	quantity, err := GetItemQuantity(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemQuantity failed: %v", err)
+	}
+	if quantity != 9 || batchTotal(t, mockDB, item.ID) != 9 {
+		t.Errorf("Expected item and batches to total 9, got %d and %d", quantity, batchTotal(t, mockDB, item.ID))
+	}
+
+	// Emptied batches are no longer listed
+	batches, err = GetItemStockBatches(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemStockBatches failed: %v", err)
+	}
+	if len(batches) != 2 {
+		t.Errorf("Expected 2 batches with stock left, got %d", len(batches))
+	}
+}
+
+func TestReturnStockTx(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, err := CreateItem(mockDB, "Bread", "BRD001", "Loaf", money.MustParse("2.00"), money.MustParse("1.00"), 5, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	batches, err := GetItemStockBatches(mockDB, item.ID)
+	if err != nil || len(batches) != 1 {
+		t.Fatalf("Expected 1 batch, got %d (%v)", len(batches), err)
+	}
+
+	tx, err := mockDB.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	// Back into the original batch
+	if err := ReturnStockTx(tx, item.ID, &batches[0].ID, 2, money.MustParse("1.00"), 1, StockChange{Reason: models.MovementReturn}); err != nil {
+		t.Fatalf("ReturnStockTx failed: %v", err)
+	}
+	// A batch that no longer exists gets a new one instead
+	missing := 999
+	if err := ReturnStockTx(tx, item.ID, &missing, 3, money.MustParse("0.90"), 1, StockChange{Reason: models.MovementReturn}); err != nil {
+		t.Fatalf("ReturnStockTx failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	batches, err = GetItemStockBatches(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemStockBatches failed: %v", err)
+	}
+	if len(batches) != 2 || batches[0].Quantity != 7 || batches[1].Quantity != 3 {
+		t.Errorf("Unexpected batches after return: %+v", batches)
+	} else if batches[1].UnitCost == nil || *batches[1].UnitCost != money.MustParse("0.90") {
+		t.Errorf("Expected the new batch at the sold cost, got %v", batches[1].UnitCost)
+	}
+	quantity, _ := GetItemQuantity(mockDB, item.ID)
+	if quantity != 10 {
+		t.Errorf("Expected quantity 10, got %d", quantity)
+	}
+}
+
+func TestSetCostingMethod(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	for _, method := range []string{CostingAverage, CostingAverage, CostingFIFO} {
+		if err := SetCostingMethod(mockDB, method); err != nil {
+			t.Fatalf("SetCostingMethod failed: %v", err)
+		}
+	}
+	if err := SetCostingMethod(mockDB, "lifo"); err == nil {
+		t.Error("Expected error for an unknown costing method")
+	}
+
+	tx, err := mockDB.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	method, err := CostingMethodTx(tx)
+	tx.Rollback()
+	if err != nil || method != CostingFIFO {
+		t.Errorf("Expected fifo, got %q (%v)", method, err)
+	}
+
+	// Only actual changes are logged
+	var changes string
+	err = mockDB.db.QueryRow("SELECT GROUP_CONCAT(COALESCE(old_value, '') || '>' || new_value, ',') FROM (SELECT * FROM setting_changes ORDER BY id)").Scan(&changes)
+	if err != nil {
+		t.Fatalf("Failed to read setting changes: %v", err)
+	}
+	if changes != "fifo>average,average>fifo" {
+		t.Errorf("Expected two changes logged, got %s", changes)
+	}
+}
+
+func TestAverageCostTx(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, err := CreateItem(mockDB, "Rice", "RCE001", "Bag of rice", money.MustParse("3.00"), money.MustParse("1.00"), 2, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	cost := money.MustParse("2.00")
+	if err := RestockItem(mockDB, item.ID, 1, "", nil, &cost, 1); err != nil {
+		t.Fatalf("RestockItem failed: %v", err)
+	}
+
+	tx, err := mockDB.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	// 2 x 1.00 + 1 x 2.00 for 3, so one costs 1.33
+	average, factor, err := AverageCostTx(tx, item.ID)
+	if err != nil {
+		t.Fatalf("AverageCostTx failed: %v", err)
+	}
+	if average != money.MustParse("4.00") || factor != 3 {
+		t.Errorf("Expected 4.00 for 3, got %s for %d", average, factor)
+	}
+	if one := average.Share(1, factor); one != money.MustParse("1.33") {
+		t.Errorf("Expected one to cost 1.33, got %s", one)
+	}
+
+	// With nothing on hand the item cost is used
+	if _, err := DepleteStockTx(tx, item.ID, nil, 3, StockChange{Reason: models.MovementSale}); err != nil {
+		t.Fatalf("DepleteStockTx failed: %v", err)
+	}
+	average, factor, err = AverageCostTx(tx, item.ID)
+	if err != nil {
+		t.Fatalf("AverageCostTx failed: %v", err)
+	}
+	if average != money.MustParse("1.00") || factor != 1 {
+		t.Errorf("Expected the item cost when out of stock, got %s for %d", average, factor)
+	}
+
+	if _, _, err := AverageCostTx(tx, 999); err == nil {
+		t.Error("Expected error for unknown item")
+	}
+}
+
+func TestStockMovements(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, err := CreateItem(mockDB, "Eggs", "EGG001", "Dozen eggs", money.MustParse("4.00"), money.MustParse("2.50"), 10, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	if err := UpdateItemQuantity(mockDB, item.ID, 7, 1); err != nil {
+		t.Fatalf("UpdateItemQuantity failed: %v", err)
+	}
+	if err := RestockItem(mockDB, item.ID, 5, "", nil, nil, 1); err != nil {
+		t.Fatalf("RestockItem failed: %v", err)
+	}
+	if err := AdjustStock(mockDB, item.ID, nil, -2, models.MovementDamage, 1); err != nil {
+		t.Fatalf("AdjustStock failed: %v", err)
+	}
+
+	// Only manual reasons are accepted, and the quantity must change
+	if err := AdjustStock(mockDB, item.ID, nil, -1, models.MovementSale, 1); err == nil {
+		t.Error("Expected error for a sale recorded as an adjustment")
+	}
+	if err := AdjustStock(mockDB, item.ID, nil, 0, models.MovementTheft, 1); err == nil {
+		t.Error("Expected error for a zero adjustment")
+	}
+	if err := AdjustStock(mockDB, item.ID, nil, -100, models.MovementTheft, 1); err == nil {
+		t.Error("Expected error when adjusting below zero")
+	}
+
+	movements, err := GetItemMovements(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetItemMovements failed: %v", err)
+	}
+
+	// Newest first
+	want := []struct {
+		reason string
+		delta  int
+	}{
+		{models.MovementDamage, -2},
+		{models.MovementRestock, 5},
+		{models.MovementAdjustment, -3},
+		{models.MovementRestock, 10},
+	}
+	if len(movements) != len(want) {
+		t.Fatalf("Expected %d movements, got %d", len(want), len(movements))
+	}
+	total := 0
+	for i, m := range movements {
+		if m.Reason != want[i].reason || m.Delta != want[i].delta {
+			t.Errorf("Movement %d: expected %s %d, got %s %d", i, want[i].reason, want[i].delta, m.Reason, m.Delta)
+		}
+		if m.BatchID == nil {
+			t.Errorf("Movement %d: expected a batch", i)
+		}
+		if m.UserID == nil || *m.UserID != 1 || m.Username != "admin" {
+			t.Errorf("Movement %d: expected user admin, got %v %q", i, m.UserID, m.Username)
+		}
+		total += m.Delta
+	}
+
+	quantity, _ := GetItemQuantity(mockDB, item.ID)
+	if total != quantity {
+		t.Errorf("Expected movements to add up to quantity %d, got %d", quantity, total)
+	}
+}
@@ -0,0 +1,111 @@
+package inventory
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+// StockChange says why stock is changing so the change can be written to the
+// stock movement ledger. UserID 0 means no user is recorded.
+type StockChange struct {
+	Reason      string
+	ReferenceID *int
+	UserID      int
+}
+
+// recordMovementTx appends an entry to the stock movement ledger
+func recordMovementTx(tx *sql.Tx, itemID int, batchID *int, delta int, change StockChange) error {
+	if change.Reason == "" {
+		return fmt.Errorf("stock change for item %d has no reason", itemID)
+	}
+
+	var userID interface{}
+	if change.UserID != 0 {
+		userID = change.UserID
+	}
+	_, err := tx.Exec(
+		"INSERT INTO stock_movements (item_id, batch_id, delta, reason, reference_id, user_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		itemID, batchID, delta, change.Reason, change.ReferenceID, userID, time.Now(),
+	)
+	return err
+}
+
+// AdjustStock records a manual stock correction such as damaged or stolen
+// goods. A negative delta takes stock from batchID, or first-expiry-first-out
+// when batchID is nil; a positive delta adds a new batch. The reason must be
+// adjustment, damage or theft.
+func AdjustStock(db Database, itemID int, batchID *int, delta int, reason string, userID int) error {
+	switch reason {
+	case models.MovementAdjustment, models.MovementDamage, models.MovementTheft:
+	default:
+		return fmt.Errorf("invalid adjustment reason %q", reason)
+	}
+	if delta == 0 {
+		return fmt.Errorf("adjustment must change the quantity")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return err
+	}
+
+	change := StockChange{Reason: reason, UserID: userID}
+	if delta < 0 {
+		_, err = DepleteStockTx(tx, itemID, batchID, -delta, change)
+	} else {
+		_, err = RestockItemTx(tx, models.ItemStock{ItemID: itemID, Quantity: delta}, change)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetItemMovements returns the stock ledger for an item, newest first
+func GetItemMovements(db Database, itemID int) ([]models.StockMovement, error) {
+	rows, err := db.GetDB().Query(
+		`SELECT m.id, m.item_id, m.batch_id, m.delta, m.reason, m.reference_id, m.user_id, COALESCE(u.username, ''), m.created_at
+		 FROM stock_movements m
+		 LEFT JOIN users u ON m.user_id = u.id
+		 WHERE m.item_id = ?
+		 ORDER BY m.created_at DESC, m.id DESC`,
+		itemID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movements []models.StockMovement
+	for rows.Next() {
+		var m models.StockMovement
+		var batchID, referenceID, userID sql.NullInt64
+		err := rows.Scan(&m.ID, &m.ItemID, &batchID, &m.Delta, &m.Reason, &referenceID, &userID, &m.Username, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		m.BatchID = nullableInt(batchID)
+		m.ReferenceID = nullableInt(referenceID)
+		m.UserID = nullableInt(userID)
+		movements = append(movements, m)
+	}
+
+	return movements, rows.Err()
+}
+
+func nullableInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
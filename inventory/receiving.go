@@ -0,0 +1,100 @@
+package inventory
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"ims-go/barcode"
+	"ims-go/models"
+)
+
+// ReceivingLine is stock of one item to receive, totalled from scanned codes
+type ReceivingLine struct {
+	Item     models.Item
+	Quantity int // in base units
+	Expiry   *time.Time
+	Scans    int // how many codes went into the line
+}
+
+// BuildReceivingList totals scanned codes, such as those read from photos of
+// a delivery, into lines of stock to receive. A scan counts as the weight
+// in a GS1 code, its count (30 or 37) of the scanned barcode's quantity, a
+// case barcode's quantity, or else one of the item's purchase unit. Scans of
+// the same item and expiry date share a line, in the order first scanned.
+// Codes that match no item, or a product whose stock is kept by its
+// variants, are returned in unknown, each once.
+func BuildReceivingList(db Database, codes []string) (lines []ReceivingLine, unknown []string, err error) {
+	index := make(map[string]int)
+	missing := make(map[string]bool)
+	for _, code := range codes {
+		item, itemBarcode, scan, err := LookupScan(db, code)
+		if err == nil {
+			var variants int
+			err = db.GetDB().QueryRow("SELECT COUNT(*) FROM items WHERE parent_id = ? AND archived_at IS NULL", item.ID).Scan(&variants)
+			if err != nil {
+				return nil, nil, err
+			}
+			if variants > 0 {
+				err = fmt.Errorf("%s comes in variants", item.Name)
+			}
+		}
+		if err != nil {
+			if !missing[code] {
+				missing[code] = true
+				unknown = append(unknown, code)
+			}
+			continue
+		}
+
+		quantity, err := scanQuantity(db, item, itemBarcode, scan)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var expiry *time.Time
+		key := strconv.Itoa(item.ID)
+		if scan != nil && scan.Expiry != nil {
+			expiry = scan.Expiry
+			key += "/" + expiry.Format("2006-01-02")
+		}
+		if i, ok := index[key]; ok {
+			lines[i].Quantity += quantity
+			lines[i].Scans++
+			continue
+		}
+		index[key] = len(lines)
+		lines = append(lines, ReceivingLine{Item: *item, Quantity: quantity, Expiry: expiry, Scans: 1})
+	}
+	return lines, unknown, nil
+}
+
+// scanQuantity is how many base units one received code stands for
+func scanQuantity(db Database, item *models.Item, itemBarcode *models.ItemBarcode, scan *barcode.GS1) (int, error) {
+	if scan != nil && scan.Weight != nil {
+		return weightBaseUnits(db, item, *scan.Weight)
+	}
+
+	each := 0
+	if itemBarcode != nil && itemBarcode.Quantity != nil {
+		each = *itemBarcode.Quantity
+	}
+	if scan != nil {
+		for _, ai := range []string{"37", "30"} {
+			if v, ok := scan.Fields[ai]; ok {
+				count, err := strconv.Atoi(v)
+				if err != nil || count <= 0 {
+					return 0, fmt.Errorf("invalid count %q in code for %s", v, item.Name)
+				}
+				if each == 0 {
+					each = 1
+				}
+				return count * each, nil
+			}
+		}
+	}
+	if each > 0 {
+		return each, nil
+	}
+	return UnitFactor(db, item.ID, item.PurchaseUnit)
+}
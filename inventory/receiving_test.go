@@ -0,0 +1,85 @@
+package inventory
+
+import (
+	"testing"
+
+	"ims-go/barcode"
+	"ims-go/models"
+	"ims-go/money"
+)
+
+func TestBuildReceivingList(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	cola, _ := CreateItem(mockDB, "Cola", "CL001", "", money.MustParse("1.50"), money.MustParse("0.60"), 0, 1)
+	if err := SetUnits(mockDB, cola.ID, "can", []models.ItemUnit{{Name: "case", Factor: 24}}, "", "case", 1); err != nil {
+		t.Fatalf("SetUnits failed: %v", err)
+	}
+	water, _ := CreateItem(mockDB, "Water", "WT001", "", money.MustParse("0.80"), money.MustParse("0.30"), 0, 1)
+	twelve := 12
+	if _, err := AddBarcode(mockDB, water.ID, "15000112637929", "Case", &twelve, 1); err != nil {
+		t.Fatalf("AddBarcode failed: %v", err)
+	}
+	juice, _ := CreateItem(mockDB, "Apple Juice", "9501101530003", "", money.MustParse("2.49"), money.MustParse("1.10"), 0, 1)
+	// Stock of a product with variants is kept by the variants
+	shirt, _ := CreateItem(mockDB, "T-Shirt", "TS001", "", money.MustParse("15.00"), money.MustParse("6.00"), 0, 1)
+	if _, err := CreateVariant(mockDB, shirt.ID, "Large", "TS001-L", nil, money.MustParse("6.00"), 0, 1); err != nil {
+		t.Fatalf("CreateVariant failed: %v", err)
+	}
+
+	codes := []string{
+		"CL001", // a case, the unit cola is bought in
+		"15000112637929",
+		"]C1020950110153000337" + "10" + barcode.GroupSeparator + "17291231",
+		"ZZZ",
+		"CL001",
+		"(02)09501101530003(37)6(17)291231",
+		"(02)09501101530003(37)4(17)300131",
+		"ZZZ",
+		"TS001",
+	}
+	lines, unknown, err := BuildReceivingList(mockDB, codes)
+	if err != nil {
+		t.Fatalf("BuildReceivingList failed: %v", err)
+	}
+	if len(unknown) != 2 || unknown[0] != "ZZZ" || unknown[1] != "TS001" {
+		t.Errorf("Expected ZZZ and the product with variants to be unresolved once, got %v", unknown)
+	}
+
+	want := []struct {
+		itemID, quantity, scans int
+		expiry                  string
+	}{
+		{cola.ID, 48, 2, ""},
+		{water.ID, 12, 1, ""},
+		{juice.ID, 16, 2, "2029-12-31"},
+		{juice.ID, 4, 1, "2030-01-31"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %+v", len(want), lines)
+	}
+	for i, w := range want {
+		line := lines[i]
+		expiry := ""
+		if line.Expiry != nil {
+			expiry = line.Expiry.Format("2006-01-02")
+		}
+		if line.Item.ID != w.itemID || line.Quantity != w.quantity || line.Scans != w.scans || expiry != w.expiry {
+			t.Errorf("Line %d: expected %+v, got %s x%d from %d scans, expiry %q", i, w, line.Item.Name, line.Quantity, line.Scans, expiry)
+		}
+	}
+
+	// The lines post as ordinary restocks
+	for _, line := range lines {
+		if err := RestockItem(mockDB, line.Item.ID, float64(line.Quantity), "", line.Expiry, nil, 1); err != nil {
+			t.Fatalf("RestockItem failed: %v", err)
+		}
+	}
+	if qty, _ := GetItemQuantity(mockDB, juice.ID); qty != 20 {
+		t.Errorf("Expected 20 juice in stock, got %d", qty)
+	}
+	if batches, _ := GetItemStockBatches(mockDB, juice.ID); len(batches) != 2 {
+		t.Errorf("Expected a batch per expiry date, got %+v", batches)
+	}
+}
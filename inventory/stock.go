@@ -2,33 +2,107 @@ package inventory
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
 )
 
-func RestockItem(db Database, itemID int, quantity int, expiryDate *time.Time) error {
+// RestockItem adds a new stock batch for an item on behalf of userID. The
+// quantity is in unit, one of the item's units or empty for its base unit,
+// e.g. 2 cases of 24 add 48 to stock. unitCost is per unit too, and is kept
+// that way on the batch; nil values the batch at the item's current cost.
+func RestockItem(db Database, itemID int, quantity float64, unit string, expiryDate *time.Time, unitCost *money.Money, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return err
+	}
+
+	factor, err := UnitFactorTx(tx, itemID, unit)
+	if err != nil {
+		return err
+	}
+	baseQuantity, err := ToBaseQuantity(quantity, factor)
+	if err != nil {
+		return err
+	}
+
+	batch := models.ItemStock{ItemID: itemID, Quantity: baseQuantity, ExpiryDate: expiryDate, UnitCost: unitCost, CostFactor: factor}
+	_, err = RestockItemTx(tx, batch, StockChange{Reason: models.MovementRestock, UserID: userID})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestockItemTx adds a new stock batch inside an existing database
+// transaction, so callers such as purchase order receiving can restock
+// atomically with their own changes. ItemID and Quantity are required;
+// ExpiryDate and PurchaseOrderLineID are recorded when set, and UnitCost,
+// for CostFactor base units, defaults to the item's current cost.
+// The new stock is written to the movement ledger as change. It returns the
+// new batch ID. A product with variants can't be restocked.
+func RestockItemTx(tx *sql.Tx, batch models.ItemStock, change StockChange) (int, error) {
+	if batch.Quantity <= 0 {
+		return 0, fmt.Errorf("invalid restock quantity %d", batch.Quantity)
+	}
+	if err := CheckNoVariantsTx(tx, batch.ItemID); err != nil {
+		return 0, err
+	}
+
 	now := time.Now()
 	// Update item quantity
-	_, err := db.GetDB().Exec(
+	result, err := tx.Exec(
 		"UPDATE items SET quantity = quantity + ?, in_stock_date = ?, updated_at = ? WHERE id = ?",
-		quantity, now, now, itemID,
+		batch.Quantity, now, now, batch.ItemID,
 	)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, errors.New("item not found")
 	}
 
-	// Create stock entry
-	_, err = db.GetDB().Exec(
-		"INSERT INTO item_stock (item_id, quantity, in_stock_date, expiry_date) VALUES (?, ?, ?, ?)",
-		itemID, quantity, now, expiryDate,
+	// Create stock entry. The item's cost is per base unit.
+	costFactor := batch.CostFactor
+	if batch.UnitCost == nil || costFactor < 1 {
+		costFactor = 1
+	}
+	result, err = tx.Exec(
+		`INSERT INTO item_stock (item_id, quantity, in_stock_date, expiry_date, unit_cost, cost_factor, purchase_order_line_id)
+		 VALUES (?, ?, ?, ?, COALESCE(?, (SELECT cost FROM items WHERE id = ?)), ?, ?)`,
+		batch.ItemID, batch.Quantity, now, batch.ExpiryDate, batch.UnitCost, batch.ItemID, costFactor, batch.PurchaseOrderLineID,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	batchID := int(id)
+	if err := recordMovementTx(tx, batch.ItemID, &batchID, batch.Quantity, change); err != nil {
+		return 0, err
+	}
+	return batchID, nil
 }
 
+// GetItemStockBatches returns the batches of an item that still hold stock,
+// in the order sales use them up
 func GetItemStockBatches(db Database, itemID int) ([]models.ItemStock, error) {
 	rows, err := db.GetDB().Query(
-		"SELECT id, item_id, quantity, in_stock_date, expiry_date FROM item_stock WHERE item_id = ? ORDER BY in_stock_date ASC",
+		"SELECT id, item_id, quantity, in_stock_date, expiry_date, unit_cost, cost_factor, purchase_order_line_id FROM item_stock WHERE item_id = ? AND quantity > 0 ORDER BY "+depletionOrder,
 		itemID,
 	)
 	if err != nil {
@@ -41,8 +115,9 @@ func GetItemStockBatches(db Database, itemID int) ([]models.ItemStock, error) {
 		var batch models.ItemStock
 		var inStockDate time.Time
 		var expiryDate sql.NullTime
+		var unitCost, lineID sql.NullInt64
 
-		err := rows.Scan(&batch.ID, &batch.ItemID, &batch.Quantity, &inStockDate, &expiryDate)
+		err := rows.Scan(&batch.ID, &batch.ItemID, &batch.Quantity, &inStockDate, &expiryDate, &unitCost, &batch.CostFactor, &lineID)
 		if err != nil {
 			return nil, err
 		}
@@ -51,6 +126,14 @@ func GetItemStockBatches(db Database, itemID int) ([]models.ItemStock, error) {
 		if expiryDate.Valid {
 			batch.ExpiryDate = &expiryDate.Time
 		}
+		if unitCost.Valid {
+			cost := money.FromCents(unitCost.Int64)
+			batch.UnitCost = &cost
+		}
+		if lineID.Valid {
+			id := int(lineID.Int64)
+			batch.PurchaseOrderLineID = &id
+		}
 		batches = append(batches, batch)
 	}
 
@@ -66,3 +149,155 @@ func GetItemQuantity(db Database, itemID int) (int, error) {
 	return quantity, nil
 }
 
+// depletionOrder sorts batches first-expiry-first-out, with batches that
+// never expire last, then first-in-first-out
+const depletionOrder = "expiry_date IS NULL, expiry_date, in_stock_date, id"
+
+// BatchAllocation is a quantity taken from or returned to one stock batch
+type BatchAllocation struct {
+	BatchID  int
+	Quantity int
+	// UnitCost is what CostFactor base units of the batch cost to buy, or
+	// the item's cost when the batch has none recorded
+	UnitCost   money.Money
+	CostFactor int
+}
+
+// Cost is what the units taken cost
+func (a BatchAllocation) Cost() money.Money {
+	return a.UnitCost.Share(a.Quantity, a.CostFactor)
+}
+
+// DepleteStockTx takes quantity units of an item out of stock inside an
+// existing database transaction. When batchID is set only that batch is
+// used; otherwise batches are used up first-expiry-first-out, then
+// first-in-first-out. items.quantity is reduced to match and each batch used
+// is written to the movement ledger as change. It fails without changing
+// anything if there is not enough stock.
+func DepleteStockTx(tx *sql.Tx, itemID int, batchID *int, quantity int, change StockChange) ([]BatchAllocation, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("invalid quantity %d", quantity)
+	}
+
+	const columns = `SELECT id, quantity,
+		COALESCE(unit_cost, (SELECT cost FROM items WHERE id = item_stock.item_id)),
+		CASE WHEN unit_cost IS NULL THEN 1 ELSE cost_factor END
+		FROM item_stock`
+	query := columns + " WHERE item_id = ? AND quantity > 0 ORDER BY " + depletionOrder
+	args := []interface{}{itemID}
+	if batchID != nil {
+		query = columns + " WHERE item_id = ? AND id = ? AND quantity > 0"
+		args = append(args, *batchID)
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var allocations []BatchAllocation
+	remaining := quantity
+	for rows.Next() && remaining > 0 {
+		var id, available, costFactor int
+		var unitCost money.Money
+		if err := rows.Scan(&id, &available, &unitCost, &costFactor); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		take := available
+		if take > remaining {
+			take = remaining
+		}
+		allocations = append(allocations, BatchAllocation{BatchID: id, Quantity: take, UnitCost: unitCost, CostFactor: costFactor})
+		remaining -= take
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("not enough stock for item %d: short by %d", itemID, remaining)
+	}
+
+	now := time.Now()
+	for _, a := range allocations {
+		if _, err := tx.Exec("UPDATE item_stock SET quantity = quantity - ? WHERE id = ?", a.Quantity, a.BatchID); err != nil {
+			return nil, err
+		}
+		id := a.BatchID
+		if err := recordMovementTx(tx, itemID, &id, -a.Quantity, change); err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec("UPDATE items SET quantity = quantity - ?, updated_at = ? WHERE id = ?", quantity, now, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	return allocations, nil
+}
+
+// ReturnStockTx puts quantity units of an item back into stock inside an
+// existing database transaction, such as for a refund or void. They go back
+// into batchID when that batch still exists, otherwise into a new batch valued
+// at unitCost for costFactor base units, and are written to the movement
+// ledger as change. A product with variants can't take stock back.
+func ReturnStockTx(tx *sql.Tx, itemID int, batchID *int, quantity int, unitCost money.Money, costFactor int, change StockChange) error {
+	if quantity <= 0 {
+		return fmt.Errorf("invalid quantity %d", quantity)
+	}
+	if err := CheckNoVariantsTx(tx, itemID); err != nil {
+		return err
+	}
+
+	if batchID != nil {
+		result, err := tx.Exec("UPDATE item_stock SET quantity = quantity + ? WHERE id = ? AND item_id = ?", quantity, *batchID, itemID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n > 0 {
+			_, err := tx.Exec("UPDATE items SET quantity = quantity + ?, updated_at = ? WHERE id = ?", quantity, time.Now(), itemID)
+			if err != nil {
+				return err
+			}
+			return recordMovementTx(tx, itemID, batchID, quantity, change)
+		}
+	}
+
+	_, err := RestockItemTx(tx, models.ItemStock{ItemID: itemID, Quantity: quantity, UnitCost: &unitCost, CostFactor: costFactor}, change)
+	return err
+}
+
+// SetStockLevelTx changes an item's quantity to an absolute value, adding a
+// batch for an increase or depleting batches for a decrease so the batches
+// still add up to the item quantity. The difference is written to the
+// movement ledger as change. A product with variants can only be set to
+// zero, clearing any stock left on it from before it had variants.
+func SetStockLevelTx(tx *sql.Tx, itemID, quantity int, change StockChange) error {
+	if quantity < 0 {
+		return fmt.Errorf("invalid quantity %d", quantity)
+	}
+	if quantity > 0 {
+		if err := CheckNoVariantsTx(tx, itemID); err != nil {
+			return err
+		}
+	}
+
+	var current int
+	err := tx.QueryRow("SELECT quantity FROM items WHERE id = ?", itemID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return errors.New("item not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case quantity > current:
+		_, err = RestockItemTx(tx, models.ItemStock{ItemID: itemID, Quantity: quantity - current}, change)
+	case quantity < current:
+		_, err = DepleteStockTx(tx, itemID, nil, current-quantity, change)
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package inventory
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+// GetUnits returns the units an item can be counted in besides its base
+// unit, smallest first
+func GetUnits(db Database, itemID int) ([]models.ItemUnit, error) {
+	rows, err := db.GetDB().Query("SELECT name, factor FROM item_units WHERE item_id = ? ORDER BY factor, name", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var units []models.ItemUnit
+	for rows.Next() {
+		var unit models.ItemUnit
+		if err := rows.Scan(&unit.Name, &unit.Factor); err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	return units, rows.Err()
+}
+
+// SetUnits replaces an item's units of measure. baseUnit is what stock is
+// counted in; each unit gives how many base units it holds. saleUnit and
+// purchaseUnit name the units the item is usually sold and bought in, empty
+// for the base unit. Changing the sale unit doesn't change the price, so the
+// price should be updated to match.
+//
+// Stock is always a whole number of base units, so goods sold by weight
+// should use a small base unit such as "g" with a "kg" unit of 1000.
+func SetUnits(db Database, itemID int, baseUnit string, units []models.ItemUnit, saleUnit, purchaseUnit string, userID int) error {
+	baseUnit = strings.TrimSpace(baseUnit)
+	if baseUnit == "" {
+		return errors.New("base unit is required")
+	}
+
+	known := map[string]bool{"": true, strings.ToLower(baseUnit): true}
+	for _, unit := range units {
+		name := strings.ToLower(strings.TrimSpace(unit.Name))
+		if name == "" {
+			return errors.New("unit name is required")
+		}
+		if unit.Factor <= 0 {
+			return fmt.Errorf("invalid factor %d for %s", unit.Factor, unit.Name)
+		}
+		if known[name] {
+			return fmt.Errorf("unit %q is defined more than once", unit.Name)
+		}
+		known[name] = true
+	}
+	saleUnit, purchaseUnit = strings.TrimSpace(saleUnit), strings.TrimSpace(purchaseUnit)
+	for _, unit := range []string{saleUnit, purchaseUnit} {
+		if !known[strings.ToLower(unit)] {
+			return fmt.Errorf("unknown unit %q", unit)
+		}
+	}
+	// The base unit is stored as empty so renaming it doesn't orphan them
+	if strings.EqualFold(saleUnit, baseUnit) {
+		saleUnit = ""
+	}
+	if strings.EqualFold(purchaseUnit, baseUnit) {
+		purchaseUnit = ""
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		"UPDATE items SET base_unit = ?, sale_unit = ?, purchase_unit = ?, updated_at = ? WHERE id = ?",
+		baseUnit, saleUnit, purchaseUnit, time.Now(), itemID,
+	)
+	if err := checkChanged(result, err, "item not found"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM item_units WHERE item_id = ?", itemID); err != nil {
+		return err
+	}
+	for _, unit := range units {
+		_, err := tx.Exec("INSERT INTO item_units (item_id, name, factor) VALUES (?, ?, ?)", itemID, strings.TrimSpace(unit.Name), unit.Factor)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// UnitFactor returns the number of base units in one of an item's units.
+// An empty unit or the item's base unit is 1.
+func UnitFactor(db Database, itemID int, unit string) (int, error) {
+	return unitFactor(db.GetDB(), itemID, unit)
+}
+
+// UnitFactorTx is UnitFactor within a transaction
+func UnitFactorTx(tx *sql.Tx, itemID int, unit string) (int, error) {
+	return unitFactor(tx, itemID, unit)
+}
+
+func unitFactor(q rowQuerier, itemID int, unit string) (int, error) {
+	unit = strings.TrimSpace(unit)
+	var baseUnit string
+	err := q.QueryRow("SELECT base_unit FROM items WHERE id = ?", itemID).Scan(&baseUnit)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("item not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if unit == "" || strings.EqualFold(unit, baseUnit) {
+		return 1, nil
+	}
+
+	var factor int
+	err = q.QueryRow("SELECT factor FROM item_units WHERE item_id = ? AND name = ?", itemID, unit).Scan(&factor)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("unknown unit %q", unit)
+	}
+	return factor, err
+}
+
+// ToBaseQuantity converts a quantity of a unit holding factor base units to
+// a whole number of base units. It fails when the result isn't whole, such
+// as half of an item counted in "each".
+func ToBaseQuantity(quantity float64, factor int) (int, error) {
+	base := quantity * float64(factor)
+	rounded := math.Round(base)
+	if math.Abs(base-rounded) > 1e-6 {
+		return 0, fmt.Errorf("quantity %s doesn't come to a whole number of base units", FormatQuantity(quantity))
+	}
+	return int(rounded), nil
+}
+
+// FormatQuantity formats a quantity without trailing zeros, e.g. "2" or "0.35"
+func FormatQuantity(quantity float64) string {
+	return strconv.FormatFloat(quantity, 'f', -1, 64)
+}
+
+// ParseUnits reads units written one per line or comma-separated as
+// "name = factor", e.g. "case = 24, kg = 1000"
+func ParseUnits(text string) ([]models.ItemUnit, error) {
+	var units []models.ItemUnit
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, factorText, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid unit %q: use name = factor", strings.TrimSpace(part))
+		}
+		factor, err := strconv.Atoi(strings.TrimSpace(factorText))
+		if err != nil {
+			return nil, fmt.Errorf("invalid factor in %q", strings.TrimSpace(part))
+		}
+		units = append(units, models.ItemUnit{Name: strings.TrimSpace(name), Factor: factor})
+	}
+	return units, nil
+}
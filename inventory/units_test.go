@@ -0,0 +1,153 @@
+package inventory
+
+import (
+	"testing"
+
+	"ims-go/models"
+	"ims-go/money"
+)
+
+func TestSetUnits(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, _ := CreateItem(mockDB, "Cola Can", "CC01", "", money.MustParse("1.00"), money.MustParse("0.50"), 0, 1)
+
+	units := []models.ItemUnit{{Name: "case", Factor: 24}, {Name: "six-pack", Factor: 6}}
+	if err := SetUnits(mockDB, item.ID, "can", units, "", "Case", 1); err != nil {
+		t.Fatalf("SetUnits failed: %v", err)
+	}
+
+	got, err := GetUnits(mockDB, item.ID)
+	if err != nil {
+		t.Fatalf("GetUnits failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "six-pack" || got[1].Factor != 24 {
+		t.Errorf("Expected units smallest first, got %+v", got)
+	}
+	item, _ = GetItemByID(mockDB, item.ID)
+	if item.BaseUnit != "can" || item.PurchaseUnit != "Case" || item.SaleUnitName() != "can" {
+		t.Errorf("Unexpected units on item: %+v", item)
+	}
+
+	invalid := []struct {
+		name      string
+		baseUnit  string
+		units     []models.ItemUnit
+		saleUnit  string
+		purchUnit string
+	}{
+		{"no base unit", " ", nil, "", ""},
+		{"zero factor", "can", []models.ItemUnit{{Name: "case", Factor: 0}}, "", ""},
+		{"duplicate", "can", []models.ItemUnit{{Name: "case", Factor: 24}, {Name: "CASE", Factor: 12}}, "", ""},
+		{"same as base", "can", []models.ItemUnit{{Name: "Can", Factor: 2}}, "", ""},
+		{"unknown sale unit", "can", units, "pallet", ""},
+	}
+	for _, tt := range invalid {
+		if err := SetUnits(mockDB, item.ID, tt.baseUnit, tt.units, tt.saleUnit, tt.purchUnit, 1); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestRestockItem_InUnit(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	item, _ := CreateItem(mockDB, "Cola Can", "CC01", "", money.MustParse("1.00"), money.MustParse("0.50"), 0, 1)
+	if err := SetUnits(mockDB, item.ID, "can", []models.ItemUnit{{Name: "case", Factor: 24}}, "", "case", 1); err != nil {
+		t.Fatalf("SetUnits failed: %v", err)
+	}
+
+	// Two cases at 10.80 a case is 48 cans at 0.45
+	caseCost := money.MustParse("10.80")
+	if err := RestockItem(mockDB, item.ID, 2, "case", nil, &caseCost, 1); err != nil {
+		t.Fatalf("RestockItem failed: %v", err)
+	}
+	if total := batchTotal(t, mockDB, item.ID); total != 48 {
+		t.Errorf("Expected 48 cans, got %d", total)
+	}
+	var unitCost money.Money
+	var costFactor int
+	if err := mockDB.db.QueryRow("SELECT unit_cost, cost_factor FROM item_stock WHERE item_id = ? ORDER BY id DESC LIMIT 1", item.ID).Scan(&unitCost, &costFactor); err != nil {
+		t.Fatalf("Failed to read batch: %v", err)
+	}
+	if unitCost != caseCost || costFactor != 24 || unitCost.Share(1, costFactor) != money.MustParse("0.45") {
+		t.Errorf("Expected a cost of 10.80 for 24 cans, got %s for %d", unitCost, costFactor)
+	}
+
+	if err := RestockItem(mockDB, item.ID, 0.5, "case", nil, nil, 1); err != nil {
+		t.Errorf("Expected half a case of 12 cans to be accepted: %v", err)
+	}
+	if err := RestockItem(mockDB, item.ID, 1.5, "", nil, nil, 1); err == nil {
+		t.Error("Expected error for a fraction of a can")
+	}
+	if err := RestockItem(mockDB, item.ID, 1, "pallet", nil, nil, 1); err == nil {
+		t.Error("Expected error for an unknown unit")
+	}
+}
+
+func TestRestockItem_CostPerPurchaseUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseUnit string
+		unit     models.ItemUnit
+		cost     string
+		take     int
+		want     string
+	}{
+		// 10.00 / 24 is 0.4166 a can, which rounds to 0.42 on its own
+		{"whole case", "can", models.ItemUnit{Name: "case", Factor: 24}, "10.00", 24, "10.00"},
+		{"one can", "can", models.ItemUnit{Name: "case", Factor: 24}, "10.00", 1, "0.42"},
+		// 5.99 / 1000 is 0.00599 a gram, which rounds to 0.01 on its own
+		{"half a kilo", "g", models.ItemUnit{Name: "kg", Factor: 1000}, "5.99", 500, "3.00"},
+		{"whole kilo", "g", models.ItemUnit{Name: "kg", Factor: 1000}, "5.99", 1000, "5.99"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := setupTestDB(t)
+			defer mockDB.db.Close()
+
+			item, _ := CreateItem(mockDB, "Stock", "STK01", "", money.MustParse("1.00"), 0, 0, 1)
+			if err := SetUnits(mockDB, item.ID, tt.baseUnit, []models.ItemUnit{tt.unit}, "", tt.unit.Name, 1); err != nil {
+				t.Fatalf("SetUnits failed: %v", err)
+			}
+			cost := money.MustParse(tt.cost)
+			if err := RestockItem(mockDB, item.ID, 1, tt.unit.Name, nil, &cost, 1); err != nil {
+				t.Fatalf("RestockItem failed: %v", err)
+			}
+
+			tx, err := mockDB.db.Begin()
+			if err != nil {
+				t.Fatalf("Begin failed: %v", err)
+			}
+			defer tx.Rollback()
+			allocations, err := DepleteStockTx(tx, item.ID, nil, tt.take, StockChange{Reason: models.MovementSale})
+			if err != nil {
+				t.Fatalf("DepleteStockTx failed: %v", err)
+			}
+			if len(allocations) != 1 || allocations[0].Cost() != money.MustParse(tt.want) {
+				t.Errorf("Expected %d %s to cost %s, got %+v", tt.take, tt.baseUnit, tt.want, allocations)
+			}
+		})
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	units, err := ParseUnits("case = 24, six-pack=6\nkg = 1000")
+	if err != nil {
+		t.Fatalf("ParseUnits failed: %v", err)
+	}
+	if len(units) != 3 || units[0] != (models.ItemUnit{Name: "case", Factor: 24}) || units[2].Name != "kg" {
+		t.Errorf("Unexpected units: %+v", units)
+	}
+
+	if units, err := ParseUnits("  "); err != nil || len(units) != 0 {
+		t.Errorf("Expected no units for blank text, got %+v, %v", units, err)
+	}
+	for _, text := range []string{"case", "case = lots"} {
+		if _, err := ParseUnits(text); err == nil {
+			t.Errorf("Expected error for %q", text)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package inventory
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
+)
+
+// ErrItemHasVariants is returned when stock is added to, set on or ordered
+// for a product with variants, since its stock is held by the variants
+var ErrItemHasVariants = errors.New("item has variants; stock is held by each variant")
+
+// ErrProductHasStock is returned when adding the first variant to a product
+// that still holds stock of its own, which no variant would account for
+var ErrProductHasStock = errors.New("item holds stock of its own; set its quantity to zero and give the stock to a variant")
+
+// CheckNoVariantsTx returns ErrItemHasVariants if an item has active
+// variants, so stock goes to the variants and not the product
+func CheckNoVariantsTx(tx *sql.Tx, itemID int) error {
+	var variants int
+	err := tx.QueryRow("SELECT COUNT(*) FROM items WHERE parent_id = ? AND archived_at IS NULL", itemID).Scan(&variants)
+	if err != nil {
+		return err
+	}
+	if variants > 0 {
+		return ErrItemHasVariants
+	}
+	return nil
+}
+
+// CreateVariant adds a sellable variant, such as one size and colour, under
+// a parent product. The variant is an item in its own right with its own
+// code and stock, named after the parent with variantName in brackets. It
+// takes the parent's price unless price is given, and the parent's category
+// and description. A parent holding stock of its own is refused with
+// ErrProductHasStock.
+func CreateVariant(db Database, parentID int, variantName, code string, price *money.Money, cost money.Money, quantity int, userID int) (*models.Item, error) {
+	variantName = strings.TrimSpace(variantName)
+	if variantName == "" {
+		return nil, errors.New("variant name is required")
+	}
+
+	parent, err := GetItemByID(db, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.IsVariant() {
+		return nil, errors.New("variants can't have variants of their own")
+	}
+	if parent.ArchivedAt != nil {
+		return nil, errors.New("can't add a variant to an archived item")
+	}
+
+	variantPrice := parent.Price
+	if price != nil {
+		variantPrice = *price
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemCreate); err != nil {
+		return nil, err
+	}
+
+	var parentQuantity int
+	if err := tx.QueryRow("SELECT quantity FROM items WHERE id = ?", parentID).Scan(&parentQuantity); err != nil {
+		return nil, err
+	}
+	if parentQuantity > 0 {
+		return nil, ErrProductHasStock
+	}
+
+	id, err := createItemTx(tx, variantItemName(parent.Name, variantName), code, parent.Description, variantPrice, cost, quantity, userID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(
+		"UPDATE items SET parent_id = ?, variant_name = ?, price_override = ?, category_id = ? WHERE id = ?",
+		parentID, variantName, variantPrice != parent.Price, parent.CategoryID, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetItemByID(db, id)
+}
+
+// RenameVariant changes a variant's name, keeping its item name in step
+func RenameVariant(db Database, id int, variantName string, userID int) error {
+	variantName = strings.TrimSpace(variantName)
+	if variantName == "" {
+		return errors.New("variant name is required")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermItemEdit); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		`UPDATE items SET variant_name = ?,
+			name = (SELECT p.name FROM items p WHERE p.id = items.parent_id) || ' (' || ? || ')',
+			updated_at = ?
+		 WHERE id = ? AND parent_id IS NOT NULL`,
+		variantName, variantName, time.Now(), id,
+	)
+	if err := checkChanged(result, err, "variant not found"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetVariants returns a parent product's active variants
+func GetVariants(db Database, parentID int) ([]models.Item, error) {
+	return queryItems(db,
+		"SELECT "+itemColumns+" FROM items WHERE parent_id = ? AND archived_at IS NULL ORDER BY variant_name",
+		parentID,
+	)
+}
+
+// GetProductStock returns the total quantity held by the active variants of
+// each parent product, keyed by the parent's item ID
+func GetProductStock(db Database) (map[int]int, error) {
+	rows, err := db.GetDB().Query(
+		"SELECT parent_id, SUM(quantity) FROM items WHERE parent_id IS NOT NULL AND archived_at IS NULL GROUP BY parent_id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := make(map[int]int)
+	for rows.Next() {
+		var parentID, quantity int
+		if err := rows.Scan(&parentID, &quantity); err != nil {
+			return nil, err
+		}
+		stock[parentID] = quantity
+	}
+	return stock, rows.Err()
+}
+
+func variantItemName(parentName, variantName string) string {
+	return parentName + " (" + variantName + ")"
+}
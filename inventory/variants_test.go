@@ -0,0 +1,197 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+
+	"ims-go/money"
+)
+
+func TestCreateVariant(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	shirt, err := CreateItem(mockDB, "T-Shirt", "TS", "Cotton tee", money.MustParse("12.00"), money.MustParse("5.00"), 0, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	mockDB.db.Exec("UPDATE items SET category_id = 7 WHERE id = ?", shirt.ID)
+
+	small, err := CreateVariant(mockDB, shirt.ID, "S / Red", "TS-S-RED", nil, money.MustParse("5.00"), 4, 1)
+	if err != nil {
+		t.Fatalf("CreateVariant failed: %v", err)
+	}
+	xxl := money.MustParse("14.00")
+	large, err := CreateVariant(mockDB, shirt.ID, "XXL / Red", "TS-XXL-RED", &xxl, money.MustParse("6.00"), 2, 1)
+	if err != nil {
+		t.Fatalf("CreateVariant failed: %v", err)
+	}
+
+	if small.Name != "T-Shirt (S / Red)" || small.VariantName != "S / Red" || !small.IsVariant() || *small.ParentID != shirt.ID {
+		t.Errorf("Unexpected variant: %+v", small)
+	}
+	if small.Price != shirt.Price || small.PriceOverride {
+		t.Errorf("Expected the parent's price without an override, got %s (override %v)", small.Price, small.PriceOverride)
+	}
+	if large.Price != xxl || !large.PriceOverride {
+		t.Errorf("Expected price override %s, got %s (override %v)", xxl, large.Price, large.PriceOverride)
+	}
+	if small.CategoryID == nil || *small.CategoryID != 7 || small.Description != "Cotton tee" {
+		t.Errorf("Expected category and description from the parent, got %v and %q", small.CategoryID, small.Description)
+	}
+
+	// Each variant is scanned by its own code
+	found, err := GetItemByCode(mockDB, "TS-XXL-RED")
+	if err != nil || found.ID != large.ID {
+		t.Errorf("Expected the variant found by code, got %+v, %v", found, err)
+	}
+	if batchTotal(t, mockDB, small.ID) != 4 {
+		t.Errorf("Expected the variant to hold its own stock")
+	}
+
+	variants, err := GetVariants(mockDB, shirt.ID)
+	if err != nil {
+		t.Fatalf("GetVariants failed: %v", err)
+	}
+	if len(variants) != 2 || variants[0].ID != small.ID {
+		t.Errorf("Expected both variants in name order, got %+v", variants)
+	}
+
+	stock, err := GetProductStock(mockDB)
+	if err != nil {
+		t.Fatalf("GetProductStock failed: %v", err)
+	}
+	if stock[shirt.ID] != 6 {
+		t.Errorf("Expected 6 in stock across variants, got %d", stock[shirt.ID])
+	}
+
+	if _, err := CreateVariant(mockDB, small.ID, "Tall", "TS-S-RED-T", nil, 0, 0, 1); err == nil {
+		t.Error("Expected error adding a variant to a variant")
+	}
+	if _, err := CreateVariant(mockDB, shirt.ID, " ", "TS-BLANK", nil, 0, 0, 1); err == nil {
+		t.Error("Expected error for a blank variant name")
+	}
+
+	// The parent holds no stock, so it isn't reported as low
+	low, _ := GetLowStockItems(mockDB, 3)
+	if len(low) != 1 || low[0].ID != large.ID {
+		t.Errorf("Expected only the XXL variant to be low, got %+v", low)
+	}
+}
+
+func TestVariants_ParentStock(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Stock the product holds itself would be stranded by its first variant
+	shirt, err := CreateItem(mockDB, "T-Shirt", "TS", "", money.MustParse("12.00"), money.MustParse("5.00"), 5, 1)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	if _, err := CreateVariant(mockDB, shirt.ID, "S", "TS-S", nil, money.MustParse("5.00"), 5, 1); !errors.Is(err, ErrProductHasStock) {
+		t.Errorf("Expected ErrProductHasStock, got %v", err)
+	}
+	if err := UpdateItemQuantity(mockDB, shirt.ID, 0, 1); err != nil {
+		t.Fatalf("UpdateItemQuantity failed: %v", err)
+	}
+	if _, err := CreateVariant(mockDB, shirt.ID, "S", "TS-S", nil, money.MustParse("5.00"), 5, 1); err != nil {
+		t.Fatalf("CreateVariant failed: %v", err)
+	}
+
+	// Once it has variants, stock goes to them and not the product
+	if err := RestockItem(mockDB, shirt.ID, 3, "", nil, nil, 1); !errors.Is(err, ErrItemHasVariants) {
+		t.Errorf("Expected ErrItemHasVariants restocking, got %v", err)
+	}
+	if err := UpdateItemQuantity(mockDB, shirt.ID, 3, 1); !errors.Is(err, ErrItemHasVariants) {
+		t.Errorf("Expected ErrItemHasVariants setting stock, got %v", err)
+	}
+	three := 3
+	if err := UpdateItem(mockDB, shirt.ID, shirt.Name, shirt.Code, "", shirt.Price, shirt.Cost, &three, 1); !errors.Is(err, ErrItemHasVariants) {
+		t.Errorf("Expected ErrItemHasVariants editing the quantity, got %v", err)
+	}
+	if err := UpdateItemQuantity(mockDB, shirt.ID, 0, 1); err != nil {
+		t.Errorf("Expected setting the product to zero to be allowed: %v", err)
+	}
+	if updated, _ := GetItemByID(mockDB, shirt.ID); updated.Quantity != 0 || batchTotal(t, mockDB, shirt.ID) != 0 {
+		t.Errorf("Expected the product to hold no stock, got %d", updated.Quantity)
+	}
+}
+
+func TestUpdateItem_Variants(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	shirt, _ := CreateItem(mockDB, "T-Shirt", "TS", "", money.MustParse("12.00"), money.MustParse("5.00"), 0, 1)
+	small, _ := CreateVariant(mockDB, shirt.ID, "S", "TS-S", nil, money.MustParse("5.00"), 4, 1)
+	xxl := money.MustParse("14.00")
+	large, _ := CreateVariant(mockDB, shirt.ID, "XXL", "TS-XXL", &xxl, money.MustParse("6.00"), 2, 1)
+
+	// Renaming and repricing the parent reaches variants without an override
+	if err := UpdateItem(mockDB, shirt.ID, "Tee", "TS", "", money.MustParse("10.00"), money.MustParse("5.00"), nil, 1); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+	small, _ = GetItemByID(mockDB, small.ID)
+	large, _ = GetItemByID(mockDB, large.ID)
+	if small.Name != "Tee (S)" || small.Price != money.MustParse("10.00") {
+		t.Errorf("Expected Tee (S) at 10.00, got %s at %s", small.Name, small.Price)
+	}
+	if large.Name != "Tee (XXL)" || large.Price != xxl {
+		t.Errorf("Expected Tee (XXL) to keep 14.00, got %s at %s", large.Name, large.Price)
+	}
+
+	// Setting a variant back to the parent's price drops the override
+	if err := UpdateItem(mockDB, large.ID, large.Name, large.Code, "", money.MustParse("10.00"), large.Cost, nil, 1); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+	large, _ = GetItemByID(mockDB, large.ID)
+	if large.PriceOverride {
+		t.Error("Expected the override to be dropped")
+	}
+
+	if err := RenameVariant(mockDB, small.ID, "Small", 1); err != nil {
+		t.Fatalf("RenameVariant failed: %v", err)
+	}
+	small, _ = GetItemByID(mockDB, small.ID)
+	if small.Name != "Tee (Small)" || small.VariantName != "Small" {
+		t.Errorf("Expected Tee (Small), got %s", small.Name)
+	}
+	if err := RenameVariant(mockDB, shirt.ID, "Small", 1); err == nil {
+		t.Error("Expected error renaming an item that isn't a variant")
+	}
+}
+
+func TestArchiveItem_Variants(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	shirt, _ := CreateItem(mockDB, "T-Shirt", "TS", "", money.MustParse("12.00"), money.MustParse("5.00"), 0, 1)
+	small, _ := CreateVariant(mockDB, shirt.ID, "S", "TS-S", nil, money.MustParse("5.00"), 0, 1)
+	large, _ := CreateVariant(mockDB, shirt.ID, "L", "TS-L", nil, money.MustParse("5.00"), 0, 1)
+
+	if err := DeleteItem(mockDB, shirt.ID, 1); err == nil {
+		t.Error("Expected error deleting an item with variants")
+	}
+
+	// A variant archived on its own stays archived when the product returns
+	if err := ArchiveItem(mockDB, large.ID, 1); err != nil {
+		t.Fatalf("ArchiveItem failed: %v", err)
+	}
+	if err := ArchiveItem(mockDB, shirt.ID, 1); err != nil {
+		t.Fatalf("ArchiveItem failed: %v", err)
+	}
+	small, _ = GetItemByID(mockDB, small.ID)
+	if small.ArchivedAt == nil {
+		t.Error("Expected the variant to be archived with its product")
+	}
+	if err := RestoreItem(mockDB, small.ID, 1); err == nil {
+		t.Error("Expected error restoring a variant of an archived product")
+	}
+
+	if err := RestoreItem(mockDB, shirt.ID, 1); err != nil {
+		t.Fatalf("RestoreItem failed: %v", err)
+	}
+	variants, _ := GetVariants(mockDB, shirt.ID)
+	if len(variants) != 1 || variants[0].ID != small.ID {
+		t.Errorf("Expected only the small variant restored, got %+v", variants)
+	}
+}
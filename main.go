@@ -1,21 +1,52 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
 	"ims-go/auth"
+	"ims-go/barcode"
+	"ims-go/config"
 	"ims-go/database"
 	"ims-go/gui"
+	"ims-go/inventory"
+	"ims-go/money"
 )
 
 func main() {
+	// Load settings from the config file, environment and command line
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	money.Symbol = cfg.CurrencySymbol
+	barcode.PricePrefixes = cfg.PriceLabelPrefixes
+	barcode.WeightPrefixes = cfg.WeightLabelPrefixes
+	barcode.Currency = cfg.CurrencyCode
+
 	// Initialize database
-	db, err := database.NewDatabase()
+	db, err := database.NewDatabase(cfg.DBPath)
 	if err != nil {
 		panic(err)
 	}
 	defer db.Close()
+	if err := inventory.SetCostingMethod(db, cfg.CostingMethod); err != nil {
+		panic(err)
+	}
+
+	// Take rotating backups in the background while the program runs
+	if cfg.BackupDir != "" {
+		db.SetBackupDir(cfg.BackupDir)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.ScheduleBackups(ctx, time.Duration(cfg.BackupIntervalMinutes)*time.Minute, cfg.BackupKeep)
 
 	// Initialize app state
-	appState := auth.NewAppState(db)
+	appState := auth.NewAppState(db, cfg)
 
 	// Show login window
 	gui.ShowLoginWindow(appState)
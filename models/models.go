@@ -1,15 +1,87 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"ims-go/money"
+)
 
 type User struct {
-	ID             int
-	Username       string
-	IsRootAdmin    bool
-	CanRead        bool
-	CanTransaction bool
-	CanRevenue     bool
-	CreatedAt      time.Time
+	ID          int
+	Username    string
+	IsRootAdmin bool
+	// Roles decide what the user may do; the root admin may do everything
+	Roles     []Role
+	CreatedAt time.Time
+	// ArchivedAt is set once the user is archived and can no longer log in
+	ArchivedAt *time.Time
+}
+
+// Can reports whether the user holds a permission through any of their roles
+func (u *User) Can(permission Permission) bool {
+	if u == nil {
+		return false
+	}
+	if u.IsRootAdmin {
+		return true
+	}
+	for _, role := range u.Roles {
+		if role.Has(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permission names one thing a user can be allowed to do
+type Permission string
+
+const (
+	PermItemView      Permission = "item.view"
+	PermItemCreate    Permission = "item.create"
+	PermItemEdit      Permission = "item.edit"
+	PermItemEditPrice Permission = "item.edit_price"
+	PermStockAdjust   Permission = "stock.adjust"
+	PermPurchasing    Permission = "purchase.manage"
+	PermTxnCreate     Permission = "txn.create"
+	PermTxnRefund     Permission = "txn.refund"
+	PermTxnVoid       Permission = "txn.void"
+	PermReportView    Permission = "report.view"
+	PermUserManage    Permission = "user.manage"
+	PermDBBackup      Permission = "db.backup"
+)
+
+// Permissions lists every permission, in the order they are shown
+var Permissions = []Permission{
+	PermItemView,
+	PermItemCreate,
+	PermItemEdit,
+	PermItemEditPrice,
+	PermStockAdjust,
+	PermPurchasing,
+	PermTxnCreate,
+	PermTxnRefund,
+	PermTxnVoid,
+	PermReportView,
+	PermUserManage,
+	PermDBBackup,
+}
+
+// Role is a named set of permissions, such as a cashier's or a manager's
+type Role struct {
+	ID          int
+	Name        string
+	Permissions []Permission
+}
+
+// Has reports whether the role includes a permission
+func (r Role) Has(permission Permission) bool {
+	for _, p := range r.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
 }
 
 type Item struct {
@@ -17,13 +89,82 @@ type Item struct {
 	Name        string
 	Code        string
 	Description string
-	Price       float64
-	Cost        float64
+	Price       money.Money
+	Cost        money.Money
 	Quantity    int
 	InStockDate time.Time
 	ExpiryDate  *time.Time
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// ReorderPoint overrides the global low stock threshold when set
+	ReorderPoint        *int
+	ReorderQuantity     int
+	PreferredSupplierID *int
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	// ArchivedAt is set once the item is archived and can no longer be sold
+	ArchivedAt *time.Time
+	CategoryID *int
+	// ParentID is set on a variant, such as one size and colour of a
+	// product, and points at the parent product item
+	ParentID    *int
+	VariantName string
+	// PriceOverride is set when a variant's price differs from its parent's;
+	// otherwise the variant follows the parent's price
+	PriceOverride bool
+	// BaseUnit is the unit stock is counted in, e.g. "each" or "g". Quantity
+	// and Cost are per base unit.
+	BaseUnit string
+	// SaleUnit is the unit the item is sold and priced in, and PurchaseUnit
+	// the unit it is usually bought in. Empty means the base unit.
+	SaleUnit     string
+	PurchaseUnit string
+}
+
+// SaleUnitName is the name of the unit the item is sold in
+func (i Item) SaleUnitName() string {
+	if i.SaleUnit != "" {
+		return i.SaleUnit
+	}
+	return i.BaseUnit
+}
+
+// ItemUnit is a unit an item can be counted in other than its base unit,
+// such as a case of 24 or a kilogram of 1000 g
+type ItemUnit struct {
+	Name string
+	// Factor is the number of base units in one of this unit
+	Factor int
+}
+
+// ItemBarcode is an extra code an item can be scanned by, such as a
+// manufacturer EAN-13 alongside the in-store code, or a case barcode
+type ItemBarcode struct {
+	ID          int
+	ItemID      int
+	Code        string
+	Description string
+	// Quantity is the number of base units one scan stands for, as on a case
+	// of 12. Nil scans as one of the item like its own code.
+	Quantity  *int
+	CreatedAt time.Time
+}
+
+// IsVariant reports whether the item is a variant of a parent product
+func (i Item) IsVariant() bool {
+	return i.ParentID != nil
+}
+
+// ReorderThreshold is the quantity below which the item needs reordering,
+// using defaultThreshold when the item has no reorder point of its own
+func (i Item) ReorderThreshold(defaultThreshold int) int {
+	if i.ReorderPoint != nil {
+		return *i.ReorderPoint
+	}
+	return defaultThreshold
+}
+
+// IsLowStock reports whether the item is below its reorder threshold
+func (i Item) IsLowStock(defaultThreshold int) bool {
+	return i.Quantity < i.ReorderThreshold(defaultThreshold)
 }
 
 type ItemStock struct {
@@ -32,22 +173,236 @@ type ItemStock struct {
 	Quantity    int
 	InStockDate time.Time
 	ExpiryDate  *time.Time
+	// UnitCost is what was actually paid for CostFactor base units, when
+	// known, e.g. per case of 24 or per kilogram. It is kept as paid rather
+	// than divided down to a base unit, which would round away cents.
+	UnitCost   *money.Money
+	CostFactor int
+	// PurchaseOrderLineID links a batch to the purchase order it was received against
+	PurchaseOrderLineID *int
 }
 
-type Transaction struct {
-	ID          int
-	UserID      int
-	TotalAmount float64
+// Stock movement reasons
+const (
+	MovementSale       = "sale"
+	MovementRestock    = "restock"
+	MovementAdjustment = "adjustment"
+	MovementDamage     = "damage"
+	MovementTheft      = "theft"
+	MovementReturn     = "return" // refunded, referring to the refund
+	MovementVoid       = "void"   // voided, referring to the sale
+	MovementCount      = "count"
+)
+
+// StockMovement is one entry in the stock ledger: a change to an item's
+// quantity, the batch it touched and why it happened
+type StockMovement struct {
+	ID      int
+	ItemID  int
+	BatchID *int
+	Delta   int // positive for stock in, negative for stock out
+	Reason  string
+	// ReferenceID is the sale, refund or purchase order behind the change, if any
+	ReferenceID *int
+	UserID      *int
+	Username    string
 	CreatedAt   time.Time
-	Items       []TransactionItem
+}
+
+// Transaction statuses
+const (
+	TransactionCompleted = "completed"
+	TransactionVoided    = "voided"
+)
+
+type Transaction struct {
+	ID             int
+	UserID         int
+	TotalAmount    money.Money
+	RefundedAmount money.Money
+	Status         string
+	VoidedBy       *int
+	VoidReason     string
+	VoidedAt       *time.Time
+	CreatedAt      time.Time
+	Items          []TransactionItem
+}
+
+// IsVoided reports whether the transaction has been voided
+func (t Transaction) IsVoided() bool {
+	return t.Status == TransactionVoided
+}
+
+// NetAmount is the total of the sale less anything refunded against it
+func (t Transaction) NetAmount() money.Money {
+	return t.TotalAmount - t.RefundedAmount
 }
 
 type TransactionItem struct {
 	ID            int
 	TransactionID int
 	ItemID        int
-	ItemName      string
-	Quantity      int
-	Price         float64
+	// ItemName and ItemCode are as they were when the sale was made
+	ItemName string
+	ItemCode string
+	// Quantity is in the item's sale unit and may be fractional for goods
+	// sold by weight. Price is per sale unit.
+	Quantity         float64
+	RefundedQuantity float64
+	Price            money.Money
+	// Unit is the sale unit name, empty for the base unit, and UnitFactor
+	// the number of base units in it, both as they were when sold
+	Unit       string
+	UnitFactor int
+	// Cost is the cost of goods sold for CostFactor base units, fixed when
+	// the sale is made
+	Cost       money.Money
+	CostFactor int
+	// BatchID is the stock batch the units came from. When creating a sale it
+	// asks for a specific batch; nil lets stock be picked automatically.
+	BatchID *int
+	// LineTotal is what the whole line costs when a label says so, such as
+	// the price printed on a weighed pack. It is charged as it is instead of
+	// Price times Quantity.
+	LineTotal *money.Money
+}
+
+// Total is what the line costs the customer
+func (ti TransactionItem) Total() money.Money {
+	if ti.LineTotal != nil {
+		return *ti.LineTotal
+	}
+	return ti.Price.MulFloat(ti.Quantity)
+}
+
+type Refund struct {
+	ID            int
+	TransactionID int
+	UserID        int
+	TotalAmount   money.Money
+	CreatedAt     time.Time
+	Items         []RefundItem
+}
+
+type RefundItem struct {
+	ID                int
+	RefundID          int
+	TransactionItemID int
+	ItemID            int
+	ItemName          string
+	// Quantity is in the sale unit of the transaction item being refunded
+	Quantity float64
+	Price    money.Money
 }
 
+// Category groups items. Categories form a tree through ParentID.
+type Category struct {
+	ID       int
+	Name     string
+	ParentID *int
+	// TaxClass applies to the category's items and to subcategories that
+	// don't set their own
+	TaxClass  string
+	CreatedAt time.Time
+	// Path is the names from the top-level category down, e.g. "Drinks > Juice"
+	Path string
+}
+
+type Supplier struct {
+	ID          int
+	Name        string
+	ContactName string
+	Phone       string
+	Email       string
+	Address     string
+	CreatedAt   time.Time
+}
+
+// Purchase order statuses
+const (
+	PurchaseOrderDraft     = "draft"
+	PurchaseOrderOrdered   = "ordered"
+	PurchaseOrderPartial   = "partially_received"
+	PurchaseOrderReceived  = "received"
+	PurchaseOrderCancelled = "cancelled"
+)
+
+type PurchaseOrder struct {
+	ID           int
+	SupplierID   int
+	SupplierName string
+	Status       string
+	CreatedBy    int
+	Notes        string
+	CreatedAt    time.Time
+	OrderedAt    *time.Time
+	ReceivedAt   *time.Time
+	Lines        []PurchaseOrderLine
+}
+
+// Total is the value of the order at the ordered unit costs
+func (p PurchaseOrder) Total() money.Money {
+	var total money.Money
+	for _, line := range p.Lines {
+		total += line.UnitCost.Mul(line.QuantityOrdered)
+	}
+	return total
+}
+
+type PurchaseOrderLine struct {
+	ID               int
+	PurchaseOrderID  int
+	ItemID           int
+	ItemName         string
+	QuantityOrdered  int
+	QuantityReceived int
+	UnitCost         money.Money
+}
+
+// Outstanding is how many units are still to be received
+func (l PurchaseOrderLine) Outstanding() int {
+	return l.QuantityOrdered - l.QuantityReceived
+}
+
+// Stocktake statuses
+const (
+	StocktakeOpen      = "open"
+	StocktakePosted    = "posted"
+	StocktakeCancelled = "cancelled"
+)
+
+// Stocktake is a physical count of all items or a filtered subset
+type Stocktake struct {
+	ID        int
+	Status    string
+	Filter    string // name or code filter used to choose the items; empty for all items
+	CreatedBy int
+	CreatedAt time.Time
+	PostedBy  *int
+	PostedAt  *time.Time
+	Lines     []StocktakeLine
+}
+
+type StocktakeLine struct {
+	ID          int
+	StocktakeID int
+	ItemID      int
+	ItemName    string
+	ItemCode    string
+	// ExpectedQuantity is the system quantity when the item was counted, or
+	// when the stocktake was opened until it is
+	ExpectedQuantity int
+	// CountedQuantity is nil until the item has been counted
+	CountedQuantity *int
+}
+
+// Counted reports how many lines have been counted so far
+func (s Stocktake) Counted() int {
+	counted := 0
+	for _, line := range s.Lines {
+		if line.CountedQuantity != nil {
+			counted++
+		}
+	}
+	return counted
+}
@@ -0,0 +1,148 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of currency stored in minor units (cents). Keeping money
+// as an integer means totals add up exactly, unlike float64.
+type Money int64
+
+// Symbol is the currency symbol used by Format
+var Symbol = "$"
+
+// FromCents returns the amount for a number of minor units
+func FromCents(cents int64) Money {
+	return Money(cents)
+}
+
+// FromFloat converts a float amount (e.g. 12.345) to Money, rounding half away
+// from zero
+func FromFloat(amount float64) Money {
+	return Money(math.Round(amount * 100))
+}
+
+// Parse reads an amount such as "12", "12.5", "-3.20" or "$1,250.00". At most
+// two decimal places are accepted.
+func Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = strings.TrimSpace(s[1:])
+	}
+	s = strings.TrimPrefix(s, Symbol)
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return 0, errors.New("invalid amount: empty")
+	}
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if hasFrac && (frac == "" || len(frac) > 2) {
+		return 0, fmt.Errorf("invalid amount %q: use at most two decimal places", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	units, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	cents, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if units > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+
+	m := Money(units*100 + cents)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// MustParse is like Parse but panics on error. It is meant for constants and
+// tests.
+func MustParse(s string) Money {
+	m, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Sum adds amounts together
+func Sum(amounts ...Money) Money {
+	var total Money
+	for _, m := range amounts {
+		total += m
+	}
+	return total
+}
+
+// Cents returns the amount in minor units
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Float64 returns the amount in major units. Use it for display or ratios only,
+// never to do further money arithmetic.
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// Mul multiplies a unit price by a whole quantity
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// MulFloat multiplies by a fractional factor (a weight, a percentage) and
+// rounds the result to the nearest cent, half away from zero
+func (m Money) MulFloat(factor float64) Money {
+	return Money(math.Round(float64(m) * factor))
+}
+
+// Share returns what quantity units cost when m is the price of per units,
+// such as part of a case, rounded to the nearest cent, half away from zero.
+// Dividing only here keeps a price per case or kilogram from losing cents.
+func (m Money) Share(quantity, per int) Money {
+	if per <= 1 {
+		return m.Mul(quantity)
+	}
+	n := int64(m) * int64(quantity)
+	d := int64(per)
+	if n < 0 {
+		return Money(-((-n + d/2) / d))
+	}
+	return Money((n + d/2) / d)
+}
+
+// String formats the amount without a currency symbol, e.g. "1250.00" or
+// "-0.50"
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// Format formats the amount with the currency symbol, e.g. "$12.50" or "-$0.50"
+func (m Money) Format() string {
+	if m < 0 {
+		return "-" + Symbol + (-m).String()
+	}
+	return Symbol + m.String()
+}
@@ -0,0 +1,121 @@
+package money
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]Money{
+		"12":        1200,
+		"12.5":      1250,
+		"12.50":     1250,
+		"0.05":      5,
+		".75":       75,
+		"-3.20":     -320,
+		"$1,250.00": 125000,
+		" 9.99 ":    999,
+	}
+
+	for input, expected := range cases {
+		got, err := Parse(input)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", input, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Parse(%q) = %d, expected %d", input, got, expected)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, input := range []string{"", "abc", "1.234", "1.", "1.2.3", "--1"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) should fail", input)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got := MustParse("1250").Format(); got != "$1250.00" {
+		t.Errorf("Expected $1250.00, got %s", got)
+	}
+	if got := FromCents(-50).Format(); got != "-$0.50" {
+		t.Errorf("Expected -$0.50, got %s", got)
+	}
+	if got := FromCents(7).String(); got != "0.07" {
+		t.Errorf("Expected 0.07, got %s", got)
+	}
+}
+
+func TestFromFloat_Rounds(t *testing.T) {
+	if got := FromFloat(0.1 + 0.2); got != 30 {
+		t.Errorf("Expected 30 cents, got %d", got)
+	}
+	if got := FromFloat(19.99); got != 1999 {
+		t.Errorf("Expected 1999 cents, got %d", got)
+	}
+	if got := FromFloat(-0.125); got != -13 {
+		t.Errorf("Expected -13 cents, got %d", got)
+	}
+}
+
+func TestSum_IsExact(t *testing.T) {
+	// Adding ten cents a thousand times drifts with float64 but not with Money
+	var total Money
+	for i := 0; i < 1000; i++ {
+		total += MustParse("0.10")
+	}
+	if total != MustParse("100.00") {
+		t.Errorf("Expected exactly 100.00, got %s", total)
+	}
+
+	if got := Sum(MustParse("0.10"), MustParse("0.20"), MustParse("0.30")); got != MustParse("0.60") {
+		t.Errorf("Expected 0.60, got %s", got)
+	}
+}
+
+func TestMul(t *testing.T) {
+	if got := MustParse("1.50").Mul(3); got != MustParse("4.50") {
+		t.Errorf("Expected 4.50, got %s", got)
+	}
+	if got := MustParse("10.00").MulFloat(0.333); got != MustParse("3.33") {
+		t.Errorf("Expected 3.33, got %s", got)
+	}
+	if got := MustParse("0.99").MulFloat(0.5); got != MustParse("0.50") {
+		t.Errorf("Expected 0.50, got %s", got)
+	}
+}
+
+func TestShare(t *testing.T) {
+	tests := []struct {
+		price         string
+		quantity, per int
+		want          string
+	}{
+		{"10.00", 24, 24, "10.00"},  // a whole case of 24
+		{"10.00", 1, 24, "0.42"},    // one of them
+		{"5.99", 500, 1000, "3.00"}, // 500 g at 5.99/kg
+		{"5.99", 1000, 1000, "5.99"},
+		{"1.50", 3, 1, "4.50"},
+		{"-10.00", 1, 24, "-0.42"},
+	}
+	for _, tt := range tests {
+		if got := MustParse(tt.price).Share(tt.quantity, tt.per); got != MustParse(tt.want) {
+			t.Errorf("%s for %d of %d: expected %s, got %s", tt.price, tt.quantity, tt.per, tt.want, got)
+		}
+	}
+}
+
+func TestSymbol(t *testing.T) {
+	old := Symbol
+	defer func() { Symbol = old }()
+
+	Symbol = "€"
+	if got := MustParse("2.00").Format(); got != "€2.00" {
+		t.Errorf("Expected €2.00, got %s", got)
+	}
+	if got, err := Parse("€2.00"); err != nil || got != 200 {
+		t.Errorf("Expected Parse to accept the configured symbol, got %d, %v", got, err)
+	}
+}
@@ -0,0 +1,411 @@
+package purchasing
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
+)
+
+// Receipt is a delivery against one purchase order line. Quantity and
+// UnitCost are in Unit, one of the item's units or empty for its base unit,
+// e.g. 2 cases at 12.00 a case.
+type Receipt struct {
+	LineID     int
+	Quantity   float64
+	Unit       string
+	UnitCost   money.Money // actual cost per unit on the invoice
+	ExpiryDate *time.Time
+}
+
+// CreatePurchaseOrder starts a draft order for a supplier. Each line needs an
+// ItemID, QuantityOrdered and the expected UnitCost.
+func CreatePurchaseOrder(db Database, supplierID, userID int, notes string, lines []models.PurchaseOrderLine) (*models.PurchaseOrder, error) {
+	if len(lines) == 0 {
+		return nil, errors.New("purchase order has no lines")
+	}
+	for _, line := range lines {
+		if line.QuantityOrdered <= 0 {
+			return nil, fmt.Errorf("invalid quantity %d", line.QuantityOrdered)
+		}
+		if line.UnitCost < 0 {
+			return nil, fmt.Errorf("invalid unit cost %s", line.UnitCost.Format())
+		}
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermPurchasing); err != nil {
+		return nil, err
+	}
+
+	orderID, err := createPurchaseOrder(tx, supplierID, userID, notes, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetPurchaseOrderByID(db, orderID)
+}
+
+func createPurchaseOrder(tx *sql.Tx, supplierID, userID int, notes string, lines []models.PurchaseOrderLine) (int, error) {
+	var exists int
+	err := tx.QueryRow("SELECT COUNT(*) FROM suppliers WHERE id = ?", supplierID).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		return 0, errors.New("supplier not found")
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO purchase_orders (supplier_id, status, created_by, notes, created_at) VALUES (?, ?, ?, ?, ?)",
+		supplierID, models.PurchaseOrderDraft, userID, notes, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	orderID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		// Archived items are no longer stocked, so can't be ordered
+		err := tx.QueryRow("SELECT COUNT(*) FROM items WHERE id = ? AND archived_at IS NULL", line.ItemID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if exists == 0 {
+			return 0, fmt.Errorf("item %d not found or archived", line.ItemID)
+		}
+		// A product with variants is ordered as its variants
+		if err := inventory.CheckNoVariantsTx(tx, line.ItemID); err != nil {
+			return 0, fmt.Errorf("item %d: %w", line.ItemID, err)
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO purchase_order_lines (purchase_order_id, item_id, quantity_ordered, unit_cost) VALUES (?, ?, ?, ?)",
+			orderID, line.ItemID, line.QuantityOrdered, line.UnitCost,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int(orderID), nil
+}
+
+// MarkOrdered records that a draft order has been sent to the supplier
+func MarkOrdered(db Database, orderID int, userID int) error {
+	return setStatus(db, orderID, userID, models.PurchaseOrderOrdered, "ordered_at", models.PurchaseOrderDraft)
+}
+
+// CancelPurchaseOrder cancels an order that has not had anything received
+func CancelPurchaseOrder(db Database, orderID int, userID int) error {
+	return setStatus(db, orderID, userID, models.PurchaseOrderCancelled, "", models.PurchaseOrderDraft, models.PurchaseOrderOrdered)
+}
+
+// setStatus moves an order to status if it is currently in one of from,
+// stamping timeColumn with the current time when given. The user needs the
+// purchasing permission.
+func setStatus(db Database, orderID, userID int, status, timeColumn string, from ...string) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermPurchasing); err != nil {
+		return err
+	}
+
+	current, err := getStatus(tx.QueryRow("SELECT status FROM purchase_orders WHERE id = ?", orderID))
+	if err != nil {
+		return err
+	}
+	if !contains(from, current) {
+		return fmt.Errorf("cannot change a %s purchase order to %s", current, status)
+	}
+
+	query := "UPDATE purchase_orders SET status = ? WHERE id = ? AND status = ?"
+	args := []interface{}{status, orderID, current}
+	if timeColumn != "" {
+		query = "UPDATE purchase_orders SET status = ?, " + timeColumn + " = ? WHERE id = ? AND status = ?"
+		args = []interface{}{status, time.Now(), orderID, current}
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("purchase order #%d changed while it was being updated", orderID)
+	}
+	return tx.Commit()
+}
+
+// ReceivePurchaseOrder books in a full or partial delivery. Each receipt
+// restocks the item as a new stock batch carrying the actual unit cost and
+// expiry date. The order becomes partially received, or received once every
+// line is complete. The stock movements are recorded against userID.
+// Nothing is written if any receipt is invalid.
+func ReceivePurchaseOrder(db Database, orderID, userID int, receipts []Receipt) error {
+	if len(receipts) == 0 {
+		return errors.New("nothing to receive")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return err
+	}
+
+	status, err := getStatus(tx.QueryRow("SELECT status FROM purchase_orders WHERE id = ?", orderID))
+	if err != nil {
+		return err
+	}
+	if status != models.PurchaseOrderOrdered && status != models.PurchaseOrderPartial {
+		return fmt.Errorf("cannot receive against a %s purchase order", status)
+	}
+
+	lines, err := queryLines(tx, orderID)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]*models.PurchaseOrderLine)
+	for i := range lines {
+		byID[lines[i].ID] = &lines[i]
+	}
+
+	// Validate every receipt before writing anything. Lines are kept in the
+	// item's base unit, so receipts are converted to it. Costs stay per
+	// receipt unit, with its factor, so they aren't rounded per base unit.
+	baseQuantities := make([]int, len(receipts))
+	factors := make([]int, len(receipts))
+	for i, receipt := range receipts {
+		line, ok := byID[receipt.LineID]
+		if !ok {
+			return fmt.Errorf("line %d is not part of purchase order #%d", receipt.LineID, orderID)
+		}
+		if receipt.Quantity <= 0 {
+			return fmt.Errorf("invalid quantity %s", inventory.FormatQuantity(receipt.Quantity))
+		}
+		if receipt.UnitCost < 0 {
+			return fmt.Errorf("invalid unit cost %s", receipt.UnitCost.Format())
+		}
+		if err := inventory.CheckNoVariantsTx(tx, line.ItemID); err != nil {
+			return fmt.Errorf("%s: %w", line.ItemName, err)
+		}
+		factors[i], err = inventory.UnitFactorTx(tx, line.ItemID, receipt.Unit)
+		if err != nil {
+			return fmt.Errorf("%s: %w", line.ItemName, err)
+		}
+		if baseQuantities[i], err = inventory.ToBaseQuantity(receipt.Quantity, factors[i]); err != nil {
+			return fmt.Errorf("%s: %w", line.ItemName, err)
+		}
+		line.QuantityReceived += baseQuantities[i]
+		if line.QuantityReceived > line.QuantityOrdered {
+			return fmt.Errorf("cannot receive more %s than ordered (%d ordered, %d received)", line.ItemName, line.QuantityOrdered, line.QuantityReceived)
+		}
+	}
+
+	change := inventory.StockChange{Reason: models.MovementRestock, ReferenceID: &orderID, UserID: userID}
+	for i, receipt := range receipts {
+		line := byID[receipt.LineID]
+		unitCost := receipt.UnitCost
+		lineID := line.ID
+		_, err := inventory.RestockItemTx(tx, models.ItemStock{
+			ItemID:              line.ItemID,
+			Quantity:            baseQuantities[i],
+			ExpiryDate:          receipt.ExpiryDate,
+			UnitCost:            &unitCost,
+			CostFactor:          factors[i],
+			PurchaseOrderLineID: &lineID,
+		}, change)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE purchase_order_lines SET quantity_received = quantity_received + ? WHERE id = ?", baseQuantities[i], line.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	complete := true
+	for _, line := range lines {
+		if line.Outstanding() > 0 {
+			complete = false
+			break
+		}
+	}
+
+	if complete {
+		_, err = tx.Exec("UPDATE purchase_orders SET status = ?, received_at = ? WHERE id = ?", models.PurchaseOrderReceived, time.Now(), orderID)
+	} else {
+		_, err = tx.Exec("UPDATE purchase_orders SET status = ? WHERE id = ?", models.PurchaseOrderPartial, orderID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetPurchaseOrderByID(db Database, id int) (*models.PurchaseOrder, error) {
+	row := db.GetDB().QueryRow(
+		`SELECT po.id, po.supplier_id, s.name, po.status, po.created_by, po.notes, po.created_at, po.ordered_at, po.received_at
+		 FROM purchase_orders po
+		 JOIN suppliers s ON po.supplier_id = s.id
+		 WHERE po.id = ?`,
+		id,
+	)
+	order, err := scanOrder(row)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("purchase order not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	order.Lines, err = queryLines(db.GetDB(), order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// GetPurchaseOrders returns orders newest first, optionally only those with
+// the given status ("" for all)
+func GetPurchaseOrders(db Database, status string) ([]models.PurchaseOrder, error) {
+	query := `SELECT po.id, po.supplier_id, s.name, po.status, po.created_by, po.notes, po.created_at, po.ordered_at, po.received_at
+		 FROM purchase_orders po
+		 JOIN suppliers s ON po.supplier_id = s.id`
+	var args []interface{}
+	if status != "" {
+		query += " WHERE po.status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY po.created_at DESC, po.id DESC"
+
+	rows, err := db.GetDB().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []models.PurchaseOrder
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		orders[i].Lines, err = queryLines(db.GetDB(), orders[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return orders, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func scanOrder(row scanner) (*models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	var orderedAt, receivedAt sql.NullTime
+
+	err := row.Scan(&order.ID, &order.SupplierID, &order.SupplierName, &order.Status, &order.CreatedBy, &order.Notes, &order.CreatedAt, &orderedAt, &receivedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if orderedAt.Valid {
+		order.OrderedAt = &orderedAt.Time
+	}
+	if receivedAt.Valid {
+		order.ReceivedAt = &receivedAt.Time
+	}
+	return &order, nil
+}
+
+// queryLines loads an order's lines using either the database or an open
+// transaction
+func queryLines(q querier, orderID int) ([]models.PurchaseOrderLine, error) {
+	rows, err := q.Query(
+		`SELECT l.id, l.purchase_order_id, l.item_id, i.name, l.quantity_ordered, l.quantity_received, l.unit_cost
+		 FROM purchase_order_lines l
+		 JOIN items i ON l.item_id = i.id
+		 WHERE l.purchase_order_id = ?
+		 ORDER BY l.id`,
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []models.PurchaseOrderLine
+	for rows.Next() {
+		var line models.PurchaseOrderLine
+		err := rows.Scan(&line.ID, &line.PurchaseOrderID, &line.ItemID, &line.ItemName, &line.QuantityOrdered, &line.QuantityReceived, &line.UnitCost)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+func getStatus(row *sql.Row) (string, error) {
+	var status string
+	err := row.Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", errors.New("purchase order not found")
+	}
+	return status, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
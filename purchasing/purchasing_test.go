@@ -0,0 +1,522 @@
+package purchasing
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+
+	_ "modernc.org/sqlite"
+)
+
+type MockDB struct {
+	db *sql.DB
+}
+
+func (m *MockDB) GetDB() *sql.DB {
+	return m.db
+}
+
+func setupTestDB(t *testing.T) *MockDB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			is_root_admin INTEGER DEFAULT 0,
+			archived_at DATETIME
+		)`,
+		`CREATE TABLE roles (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL COLLATE NOCASE, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE role_permissions (role_id INTEGER NOT NULL, permission TEXT NOT NULL, PRIMARY KEY (role_id, permission))`,
+		`CREATE TABLE user_roles (user_id INTEGER NOT NULL, role_id INTEGER NOT NULL, PRIMARY KEY (user_id, role_id))`,
+		`CREATE TABLE items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			code TEXT UNIQUE NOT NULL,
+			description TEXT,
+			price INTEGER NOT NULL,
+			cost INTEGER NOT NULL DEFAULT 0,
+			quantity INTEGER DEFAULT 0,
+			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expiry_date DATETIME,
+			reorder_point INTEGER,
+			reorder_quantity INTEGER NOT NULL DEFAULT 0,
+			preferred_supplier_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			archived_at DATETIME,
+			category_id INTEGER,
+			parent_id INTEGER,
+			variant_name TEXT NOT NULL DEFAULT '',
+			price_override BOOLEAN NOT NULL DEFAULT 0,
+			base_unit TEXT NOT NULL DEFAULT 'each',
+			sale_unit TEXT NOT NULL DEFAULT '',
+			purchase_unit TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE item_stock (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expiry_date DATETIME,
+			unit_cost INTEGER,
+			cost_factor INTEGER NOT NULL DEFAULT 1,
+			purchase_order_line_id INTEGER
+		)`,
+		`CREATE TABLE stock_movements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER NOT NULL,
+			batch_id INTEGER,
+			delta INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			reference_id INTEGER,
+			user_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE suppliers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			contact_name TEXT NOT NULL DEFAULT '',
+			phone TEXT NOT NULL DEFAULT '',
+			email TEXT NOT NULL DEFAULT '',
+			address TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE purchase_orders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			supplier_id INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'draft',
+			created_by INTEGER NOT NULL,
+			notes TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			ordered_at DATETIME,
+			received_at DATETIME
+		)`,
+		`CREATE TABLE purchase_order_lines (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			purchase_order_id INTEGER NOT NULL,
+			item_id INTEGER NOT NULL,
+			quantity_ordered INTEGER NOT NULL,
+			quantity_received INTEGER NOT NULL DEFAULT 0,
+			unit_cost INTEGER NOT NULL
+		)`,
+		`CREATE TABLE item_units (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER NOT NULL,
+			name TEXT NOT NULL COLLATE NOCASE,
+			factor INTEGER NOT NULL,
+			UNIQUE (item_id, name)
+		)`,
+		`CREATE TABLE transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			total_amount INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'completed',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE transaction_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			transaction_id INTEGER NOT NULL,
+			item_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			price INTEGER NOT NULL
+		)`,
+		`CREATE TABLE refund_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			refund_id INTEGER NOT NULL,
+			transaction_item_id INTEGER NOT NULL,
+			item_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			price INTEGER NOT NULL
+		)`,
+		`INSERT INTO users (username, is_root_admin) VALUES ('admin', 1)`,
+		`INSERT INTO items (name, code, description, price, cost, quantity) VALUES ('Apple', 'APL001', '', 150, 100, 10)`,
+		`INSERT INTO items (name, code, description, price, cost, quantity) VALUES ('Banana', 'BAN001', '', 75, 50, 0)`,
+	}
+	for _, query := range schema {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Failed to set up test database: %v", err)
+		}
+	}
+
+	return &MockDB{db: db}
+}
+
+// createTestOrder creates a supplier and an ordered PO for 20 Apples at 0.90
+// and 30 Bananas at 0.40
+func createTestOrder(t *testing.T, mockDB *MockDB) *models.PurchaseOrder {
+	supplier, err := CreateSupplier(mockDB, "Fresh Farms", "Sam", "555-0100", "sam@example.com", "1 Farm Road", 1)
+	if err != nil {
+		t.Fatalf("CreateSupplier failed: %v", err)
+	}
+
+	return createOrderedPO(t, mockDB, supplier.ID,
+		models.PurchaseOrderLine{ItemID: 1, QuantityOrdered: 20, UnitCost: money.MustParse("0.90")},
+		models.PurchaseOrderLine{ItemID: 2, QuantityOrdered: 30, UnitCost: money.MustParse("0.40")},
+	)
+}
+
+// createOrderedPO creates a purchase order and marks it as sent to the supplier
+func createOrderedPO(t *testing.T, mockDB *MockDB, supplierID int, lines ...models.PurchaseOrderLine) *models.PurchaseOrder {
+	order, err := CreatePurchaseOrder(mockDB, supplierID, 1, "", lines)
+	if err != nil {
+		t.Fatalf("CreatePurchaseOrder failed: %v", err)
+	}
+	if err := MarkOrdered(mockDB, order.ID, 1); err != nil {
+		t.Fatalf("MarkOrdered failed: %v", err)
+	}
+	return order
+}
+
+func TestSuppliers(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	if _, err := CreateSupplier(mockDB, "  ", "", "", "", "", 1); err == nil {
+		t.Error("Expected error for blank supplier name")
+	}
+
+	supplier, err := CreateSupplier(mockDB, "Fresh Farms", "Sam", "555-0100", "", "", 1)
+	if err != nil {
+		t.Fatalf("CreateSupplier failed: %v", err)
+	}
+	if _, err := CreateSupplier(mockDB, "Fresh Farms", "", "", "", "", 1); err == nil {
+		t.Error("Expected error for duplicate supplier name")
+	}
+
+	if err := UpdateSupplier(mockDB, supplier.ID, "Fresh Farms Ltd", "Alex", "555-0199", "orders@example.com", "", 1); err != nil {
+		t.Fatalf("UpdateSupplier failed: %v", err)
+	}
+	updated, err := GetSupplierByID(mockDB, supplier.ID)
+	if err != nil {
+		t.Fatalf("GetSupplierByID failed: %v", err)
+	}
+	if updated.Name != "Fresh Farms Ltd" || updated.ContactName != "Alex" || updated.Email != "orders@example.com" {
+		t.Errorf("Supplier not updated: %+v", updated)
+	}
+
+	if err := DeleteSupplier(mockDB, supplier.ID, 1); err != nil {
+		t.Fatalf("DeleteSupplier failed: %v", err)
+	}
+	suppliers, err := GetAllSuppliers(mockDB)
+	if err != nil {
+		t.Fatalf("GetAllSuppliers failed: %v", err)
+	}
+	if len(suppliers) != 0 {
+		t.Errorf("Expected no suppliers, got %d", len(suppliers))
+	}
+}
+
+func TestDeleteSupplier_WithOrders(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	order := createTestOrder(t, mockDB)
+	if err := DeleteSupplier(mockDB, order.SupplierID, 1); err == nil {
+		t.Error("Expected error deleting a supplier with purchase orders")
+	}
+}
+
+func TestCreatePurchaseOrder(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	order := createTestOrder(t, mockDB)
+
+	order, err := GetPurchaseOrderByID(mockDB, order.ID)
+	if err != nil {
+		t.Fatalf("GetPurchaseOrderByID failed: %v", err)
+	}
+	if order.Status != models.PurchaseOrderOrdered {
+		t.Errorf("Expected status ordered, got %s", order.Status)
+	}
+	if order.OrderedAt == nil {
+		t.Error("Expected ordered_at to be set")
+	}
+	if order.SupplierName != "Fresh Farms" {
+		t.Errorf("Expected supplier Fresh Farms, got %s", order.SupplierName)
+	}
+	if len(order.Lines) != 2 || order.Lines[0].ItemName != "Apple" {
+		t.Fatalf("Unexpected lines: %+v", order.Lines)
+	}
+	if want := money.MustParse("30.00"); order.Total() != want {
+		t.Errorf("Expected total %s, got %s", want.Format(), order.Total().Format())
+	}
+
+	// Ordering twice is not allowed
+	if err := MarkOrdered(mockDB, order.ID, 1); err == nil {
+		t.Error("Expected error marking an ordered PO as ordered")
+	}
+}
+
+func TestCreatePurchaseOrder_Invalid(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	supplier, err := CreateSupplier(mockDB, "Fresh Farms", "", "", "", "", 1)
+	if err != nil {
+		t.Fatalf("CreateSupplier failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		supplierID int
+		lines      []models.PurchaseOrderLine
+	}{
+		{"no lines", supplier.ID, nil},
+		{"unknown supplier", 999, []models.PurchaseOrderLine{{ItemID: 1, QuantityOrdered: 1}}},
+		{"unknown item", supplier.ID, []models.PurchaseOrderLine{{ItemID: 999, QuantityOrdered: 1}}},
+		{"zero quantity", supplier.ID, []models.PurchaseOrderLine{{ItemID: 1, QuantityOrdered: 0}}},
+		{"negative cost", supplier.ID, []models.PurchaseOrderLine{{ItemID: 1, QuantityOrdered: 1, UnitCost: -1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CreatePurchaseOrder(mockDB, tt.supplierID, 1, "", tt.lines); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+
+	orders, err := GetPurchaseOrders(mockDB, "")
+	if err != nil {
+		t.Fatalf("GetPurchaseOrders failed: %v", err)
+	}
+	if len(orders) != 0 {
+		t.Errorf("Expected no orders to be created, got %d", len(orders))
+	}
+}
+
+func TestReceivePurchaseOrder_Partial(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	order := createTestOrder(t, mockDB)
+	apple, banana := order.Lines[0], order.Lines[1]
+	expiry := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	// First delivery: all the apples at a higher price than quoted, some bananas
+	err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{
+		{LineID: apple.ID, Quantity: 20, UnitCost: money.MustParse("0.95"), ExpiryDate: &expiry},
+		{LineID: banana.ID, Quantity: 10, UnitCost: banana.UnitCost},
+	})
+	if err != nil {
+		t.Fatalf("ReceivePurchaseOrder failed: %v", err)
+	}
+
+	order, err = GetPurchaseOrderByID(mockDB, order.ID)
+	if err != nil {
+		t.Fatalf("GetPurchaseOrderByID failed: %v", err)
+	}
+	if order.Status != models.PurchaseOrderPartial {
+		t.Errorf("Expected status partially_received, got %s", order.Status)
+	}
+	if order.Lines[1].Outstanding() != 20 {
+		t.Errorf("Expected 20 bananas outstanding, got %d", order.Lines[1].Outstanding())
+	}
+
+	quantity, _ := inventory.GetItemQuantity(mockDB, 1)
+	if quantity != 30 {
+		t.Errorf("Expected 30 apples in stock, got %d", quantity)
+	}
+
+	batches, err := inventory.GetItemStockBatches(mockDB, 1)
+	if err != nil {
+		t.Fatalf("GetItemStockBatches failed: %v", err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("Expected 1 apple batch, got %d", len(batches))
+	}
+	batch := batches[0]
+	if batch.UnitCost == nil || *batch.UnitCost != money.MustParse("0.95") {
+		t.Errorf("Expected batch unit cost 0.95, got %v", batch.UnitCost)
+	}
+	if batch.ExpiryDate == nil || !batch.ExpiryDate.Equal(expiry) {
+		t.Errorf("Expected batch expiry %v, got %v", expiry, batch.ExpiryDate)
+	}
+	if batch.PurchaseOrderLineID == nil || *batch.PurchaseOrderLineID != apple.ID {
+		t.Errorf("Expected batch linked to line %d, got %v", apple.ID, batch.PurchaseOrderLineID)
+	}
+
+	// Second delivery completes the order
+	err = ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: banana.ID, Quantity: 20, UnitCost: banana.UnitCost}})
+	if err != nil {
+		t.Fatalf("ReceivePurchaseOrder failed: %v", err)
+	}
+
+	order, err = GetPurchaseOrderByID(mockDB, order.ID)
+	if err != nil {
+		t.Fatalf("GetPurchaseOrderByID failed: %v", err)
+	}
+	if order.Status != models.PurchaseOrderReceived {
+		t.Errorf("Expected status received, got %s", order.Status)
+	}
+	if order.ReceivedAt == nil {
+		t.Error("Expected received_at to be set")
+	}
+
+	quantity, _ = inventory.GetItemQuantity(mockDB, 2)
+	if quantity != 30 {
+		t.Errorf("Expected 30 bananas in stock, got %d", quantity)
+	}
+
+	// A received order cannot be received again or cancelled
+	if err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: banana.ID, Quantity: 1}}); err == nil {
+		t.Error("Expected error receiving against a received order")
+	}
+	if err := CancelPurchaseOrder(mockDB, order.ID, 1); err == nil {
+		t.Error("Expected error cancelling a received order")
+	}
+}
+
+func TestReceivePurchaseOrder_Invalid(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	order := createTestOrder(t, mockDB)
+	apple, banana := order.Lines[0], order.Lines[1]
+
+	tests := []struct {
+		name     string
+		receipts []Receipt
+	}{
+		{"nothing", nil},
+		{"over receive", []Receipt{{LineID: apple.ID, Quantity: 21}}},
+		{"over receive across receipts", []Receipt{{LineID: apple.ID, Quantity: 15}, {LineID: apple.ID, Quantity: 6}}},
+		{"unknown line", []Receipt{{LineID: 999, Quantity: 1}}},
+		{"zero quantity", []Receipt{{LineID: banana.ID, Quantity: 0}}},
+		{"negative cost", []Receipt{{LineID: banana.ID, Quantity: 1, UnitCost: -5}}},
+		// The valid first receipt must not be applied when the second fails
+		{"partly valid", []Receipt{{LineID: apple.ID, Quantity: 5}, {LineID: banana.ID, Quantity: 31}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ReceivePurchaseOrder(mockDB, order.ID, 1, tt.receipts); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+
+	quantity, _ := inventory.GetItemQuantity(mockDB, 1)
+	if quantity != 10 {
+		t.Errorf("Expected apple stock unchanged at 10, got %d", quantity)
+	}
+
+	// Drafts cannot be received
+	draft, err := CreatePurchaseOrder(mockDB, order.SupplierID, 1, "", []models.PurchaseOrderLine{{ItemID: 1, QuantityOrdered: 5, UnitCost: 90}})
+	if err != nil {
+		t.Fatalf("CreatePurchaseOrder failed: %v", err)
+	}
+	if err := ReceivePurchaseOrder(mockDB, draft.ID, 1, []Receipt{{LineID: draft.Lines[0].ID, Quantity: 5}}); err == nil {
+		t.Error("Expected error receiving against a draft")
+	}
+}
+
+func TestPurchaseOrder_ProductWithVariants(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Bananas are ordered, then split into variants before they arrive
+	order := createTestOrder(t, mockDB)
+	if _, err := mockDB.db.Exec(`INSERT INTO items (name, code, description, price, cost, quantity, parent_id) VALUES ('Banana (Organic)', 'BAN002', '', 90, 60, 0, 2)`); err != nil {
+		t.Fatalf("Failed to insert variant: %v", err)
+	}
+
+	if _, err := CreatePurchaseOrder(mockDB, order.SupplierID, 1, "", []models.PurchaseOrderLine{{ItemID: 2, QuantityOrdered: 5, UnitCost: 40}}); !errors.Is(err, inventory.ErrItemHasVariants) {
+		t.Errorf("Expected ErrItemHasVariants ordering a product with variants, got %v", err)
+	}
+	err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: order.Lines[1].ID, Quantity: 5}})
+	if !errors.Is(err, inventory.ErrItemHasVariants) {
+		t.Errorf("Expected ErrItemHasVariants receiving a product with variants, got %v", err)
+	}
+	if quantity, _ := inventory.GetItemQuantity(mockDB, 2); quantity != 0 {
+		t.Errorf("Expected the product to hold no stock, got %d", quantity)
+	}
+
+	// Once the variant is archived the product holds its own stock again
+	if _, err := mockDB.db.Exec("UPDATE items SET archived_at = CURRENT_TIMESTAMP WHERE parent_id = 2"); err != nil {
+		t.Fatalf("Failed to archive variant: %v", err)
+	}
+	if err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: order.Lines[1].ID, Quantity: 5}}); err != nil {
+		t.Errorf("ReceivePurchaseOrder failed: %v", err)
+	}
+}
+
+func TestReceivePurchaseOrder_InUnit(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Bananas come in boxes of 10
+	if _, err := mockDB.db.Exec(`INSERT INTO item_units (item_id, name, factor) VALUES (2, 'box', 10)`); err != nil {
+		t.Fatalf("Failed to insert unit: %v", err)
+	}
+	order := createTestOrder(t, mockDB)
+	banana := order.Lines[1]
+
+	// Two boxes at 4.20 a box is 20 bananas, costed at 4.20 for 10
+	err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: banana.ID, Quantity: 2, Unit: "box", UnitCost: money.MustParse("4.20")}})
+	if err != nil {
+		t.Fatalf("ReceivePurchaseOrder failed: %v", err)
+	}
+
+	order, _ = GetPurchaseOrderByID(mockDB, order.ID)
+	if order.Lines[1].QuantityReceived != 20 {
+		t.Errorf("Expected 20 bananas received, got %d", order.Lines[1].QuantityReceived)
+	}
+	batches, _ := inventory.GetItemStockBatches(mockDB, 2)
+	if len(batches) != 1 || batches[0].Quantity != 20 || *batches[0].UnitCost != money.MustParse("4.20") || batches[0].CostFactor != 10 {
+		t.Errorf("Expected a batch of 20 at 4.20 a box, got %+v", batches)
+	}
+
+	// Only 10 are outstanding, so a further two boxes is too many
+	if err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: banana.ID, Quantity: 2, Unit: "box"}}); err == nil {
+		t.Error("Expected error receiving more boxes than ordered")
+	}
+	if err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: banana.ID, Quantity: 1, Unit: "crate"}}); err == nil {
+		t.Error("Expected error for an unknown unit")
+	}
+}
+
+func TestCancelPurchaseOrder(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	order := createTestOrder(t, mockDB)
+	if err := CancelPurchaseOrder(mockDB, order.ID, 1); err != nil {
+		t.Fatalf("CancelPurchaseOrder failed: %v", err)
+	}
+
+	cancelled, err := GetPurchaseOrders(mockDB, models.PurchaseOrderCancelled)
+	if err != nil {
+		t.Fatalf("GetPurchaseOrders failed: %v", err)
+	}
+	if len(cancelled) != 1 || cancelled[0].ID != order.ID {
+		t.Errorf("Expected order #%d to be cancelled, got %+v", order.ID, cancelled)
+	}
+
+	open, err := GetPurchaseOrders(mockDB, models.PurchaseOrderOrdered)
+	if err != nil {
+		t.Fatalf("GetPurchaseOrders failed: %v", err)
+	}
+	if len(open) != 0 {
+		t.Errorf("Expected no open orders, got %d", len(open))
+	}
+
+	// Partially received orders can't be cancelled
+	order = createOrderedPO(t, mockDB, order.SupplierID, models.PurchaseOrderLine{ItemID: 1, QuantityOrdered: 5, UnitCost: 90})
+	if err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: order.Lines[0].ID, Quantity: 1}}); err != nil {
+		t.Fatalf("ReceivePurchaseOrder failed: %v", err)
+	}
+	if err := CancelPurchaseOrder(mockDB, order.ID, 1); err == nil {
+		t.Error("Expected error cancelling a partially received order")
+	}
+}
@@ -0,0 +1,272 @@
+package purchasing
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+// ReorderOptions controls how reorder suggestions are worked out
+type ReorderOptions struct {
+	// DefaultReorderPoint is used for items without their own reorder point
+	DefaultReorderPoint int
+	// SalesWindowDays is how many days of recent sales set the sales rate
+	SalesWindowDays int
+	// CoverDays is how many days of sales an order should last beyond the reorder point
+	CoverDays int
+}
+
+// ReorderSuggestion proposes an order for one item that has fallen below its
+// reorder point
+type ReorderSuggestion struct {
+	Item              models.Item
+	ReorderPoint      int
+	OnOrder           int     // outstanding on draft or open purchase orders
+	SoldInWindow      int     // units sold in the sales window, net of refunds
+	DailySales        float64 // average units sold per day over the window
+	SuggestedQuantity int
+	SupplierName      string // empty when the item has no preferred supplier
+}
+
+// GetReorderSuggestions lists every item whose stock plus anything already on
+// order is below its reorder point. The suggested quantity brings stock back
+// up to the reorder point plus CoverDays of sales at the recent daily rate,
+// and is never less than the item's usual reorder quantity. Voided sales are
+// ignored and refunds are netted off. Suggestions are sorted by supplier and
+// then item name.
+func GetReorderSuggestions(db Database, opts ReorderOptions) ([]ReorderSuggestion, error) {
+	if opts.SalesWindowDays <= 0 {
+		return nil, errors.New("sales window must be at least one day")
+	}
+	if opts.CoverDays < 0 {
+		return nil, errors.New("cover days must not be negative")
+	}
+
+	items, err := inventory.GetLowStockItems(db, opts.DefaultReorderPoint)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	onOrder, err := quantitiesOnOrder(db)
+	if err != nil {
+		return nil, err
+	}
+	sold, err := unitsSoldSince(db, time.Now().AddDate(0, 0, -opts.SalesWindowDays))
+	if err != nil {
+		return nil, err
+	}
+	suppliers, err := supplierNames(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var suggestions []ReorderSuggestion
+	for _, item := range items {
+		point := item.ReorderThreshold(opts.DefaultReorderPoint)
+		available := item.Quantity + onOrder[item.ID]
+		if available >= point {
+			continue // enough is already on the way
+		}
+
+		daily := float64(sold[item.ID]) / float64(opts.SalesWindowDays)
+		target := point + int(math.Ceil(daily*float64(opts.CoverDays)))
+		quantity := target - available
+		if quantity < item.ReorderQuantity {
+			quantity = item.ReorderQuantity
+		}
+
+		suggestion := ReorderSuggestion{
+			Item:              item,
+			ReorderPoint:      point,
+			OnOrder:           onOrder[item.ID],
+			SoldInWindow:      sold[item.ID],
+			DailySales:        daily,
+			SuggestedQuantity: quantity,
+		}
+		if item.PreferredSupplierID != nil {
+			suggestion.SupplierName = suppliers[*item.PreferredSupplierID]
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].SupplierName != suggestions[j].SupplierName {
+			return suggestions[i].SupplierName < suggestions[j].SupplierName
+		}
+		return suggestions[i].Item.Name < suggestions[j].Item.Name
+	})
+
+	return suggestions, nil
+}
+
+// quantitiesOnOrder returns the outstanding quantity per item across purchase
+// orders that are still expected to arrive
+func quantitiesOnOrder(db Database) (map[int]int, error) {
+	rows, err := db.GetDB().Query(
+		`SELECT l.item_id, SUM(l.quantity_ordered - l.quantity_received)
+		 FROM purchase_order_lines l
+		 JOIN purchase_orders po ON l.purchase_order_id = po.id
+		 WHERE po.status IN (?, ?, ?)
+		 GROUP BY l.item_id`,
+		models.PurchaseOrderDraft, models.PurchaseOrderOrdered, models.PurchaseOrderPartial,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	onOrder := make(map[int]int)
+	for rows.Next() {
+		var itemID, quantity int
+		if err := rows.Scan(&itemID, &quantity); err != nil {
+			return nil, err
+		}
+		onOrder[itemID] = quantity
+	}
+	return onOrder, rows.Err()
+}
+
+// unitsSoldSince returns the net units sold per item since the given time.
+// Dates are compared in Go because SQLite stores them as text.
+func unitsSoldSince(db Database, since time.Time) (map[int]int, error) {
+	rows, err := db.GetDB().Query(
+		`SELECT ti.item_id, t.created_at,
+			ti.quantity - COALESCE((SELECT SUM(ri.quantity) FROM refund_items ri WHERE ri.transaction_item_id = ti.id), 0)
+		 FROM transaction_items ti
+		 JOIN transactions t ON ti.transaction_id = t.id
+		 WHERE t.status != ?`,
+		models.TransactionVoided,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sold := make(map[int]int)
+	for rows.Next() {
+		var itemID, quantity int
+		var createdAt time.Time
+		if err := rows.Scan(&itemID, &createdAt, &quantity); err != nil {
+			return nil, err
+		}
+		if !createdAt.Before(since) {
+			sold[itemID] += quantity
+		}
+	}
+	return sold, rows.Err()
+}
+
+func supplierNames(db Database) (map[int]string, error) {
+	suppliers, err := GetAllSuppliers(db)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string)
+	for _, s := range suppliers {
+		names[s.ID] = s.Name
+	}
+	return names, nil
+}
+
+// WriteReorderCSV writes suggestions as CSV with a header row
+func WriteReorderCSV(w io.Writer, suggestions []ReorderSuggestion) error {
+	cw := csv.NewWriter(w)
+	header := []string{"Code", "Name", "Supplier", "In Stock", "On Order", "Reorder Point", "Sold In Window", "Daily Sales", "Suggested Quantity", "Unit Cost", "Line Total"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, s := range suggestions {
+		record := []string{
+			s.Item.Code,
+			s.Item.Name,
+			s.SupplierName,
+			strconv.Itoa(s.Item.Quantity),
+			strconv.Itoa(s.OnOrder),
+			strconv.Itoa(s.ReorderPoint),
+			strconv.Itoa(s.SoldInWindow),
+			strconv.FormatFloat(s.DailySales, 'f', 2, 64),
+			strconv.Itoa(s.SuggestedQuantity),
+			s.Item.Cost.String(),
+			s.Item.Cost.Mul(s.SuggestedQuantity).String(),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// CreateDraftOrders turns suggestions into one draft purchase order per
+// preferred supplier, priced at each item's current cost. Suggestions for
+// items without a preferred supplier are left out. All orders are created in
+// a single database transaction.
+func CreateDraftOrders(db Database, userID int, suggestions []ReorderSuggestion) ([]models.PurchaseOrder, error) {
+	bySupplier := make(map[int][]models.PurchaseOrderLine)
+	var supplierIDs []int
+	for _, s := range suggestions {
+		if s.Item.PreferredSupplierID == nil || s.SuggestedQuantity <= 0 {
+			continue
+		}
+		supplierID := *s.Item.PreferredSupplierID
+		if _, ok := bySupplier[supplierID]; !ok {
+			supplierIDs = append(supplierIDs, supplierID)
+		}
+		bySupplier[supplierID] = append(bySupplier[supplierID], models.PurchaseOrderLine{
+			ItemID:          s.Item.ID,
+			QuantityOrdered: s.SuggestedQuantity,
+			UnitCost:        s.Item.Cost,
+		})
+	}
+	if len(supplierIDs) == 0 {
+		return nil, errors.New("no suggested items have a preferred supplier")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermPurchasing); err != nil {
+		return nil, err
+	}
+
+	var orderIDs []int
+	notes := fmt.Sprintf("Reorder suggestions %s", time.Now().Format("2006-01-02"))
+	for _, supplierID := range supplierIDs {
+		orderID, err := createPurchaseOrder(tx, supplierID, userID, notes, bySupplier[supplierID])
+		if err != nil {
+			return nil, err
+		}
+		orderIDs = append(orderIDs, orderID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	var orders []models.PurchaseOrder
+	for _, id := range orderIDs {
+		order, err := GetPurchaseOrderByID(db, id)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+	return orders, nil
+}
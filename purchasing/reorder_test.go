@@ -0,0 +1,191 @@
+package purchasing
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"ims-go/inventory"
+	"ims-go/models"
+)
+
+// recordSale inserts a sale of one item directly, dated daysAgo days back
+func recordSale(t *testing.T, mockDB *MockDB, itemID, quantity, daysAgo int, status string) int {
+	result, err := mockDB.db.Exec(
+		"INSERT INTO transactions (user_id, total_amount, status, created_at) VALUES (1, 0, ?, ?)",
+		status, time.Now().AddDate(0, 0, -daysAgo),
+	)
+	if err != nil {
+		t.Fatalf("Failed to insert transaction: %v", err)
+	}
+	txnID, _ := result.LastInsertId()
+
+	result, err = mockDB.db.Exec(
+		"INSERT INTO transaction_items (transaction_id, item_id, quantity, price) VALUES (?, ?, ?, 0)",
+		txnID, itemID, quantity,
+	)
+	if err != nil {
+		t.Fatalf("Failed to insert transaction item: %v", err)
+	}
+	lineID, _ := result.LastInsertId()
+	return int(lineID)
+}
+
+func TestGetReorderSuggestions(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	supplier, err := CreateSupplier(mockDB, "Fresh Farms", "", "", "", "", 1)
+	if err != nil {
+		t.Fatalf("CreateSupplier failed: %v", err)
+	}
+
+	// Apple: 10 in stock, reorder point 15, usual order 12, from Fresh Farms
+	applePoint := 15
+	if err := inventory.SetReorderSettings(mockDB, 1, &applePoint, 12, &supplier.ID, 1); err != nil {
+		t.Fatalf("SetReorderSettings failed: %v", err)
+	}
+
+	// 30 apples sold in the last 30 days, less 2 refunded, plus sales that
+	// don't count: one voided and one outside the window
+	recordSale(t, mockDB, 1, 20, 5, models.TransactionCompleted)
+	line := recordSale(t, mockDB, 1, 10, 20, models.TransactionCompleted)
+	mockDB.db.Exec("INSERT INTO refund_items (refund_id, transaction_item_id, item_id, quantity, price) VALUES (1, ?, 1, 2, 0)", line)
+	recordSale(t, mockDB, 1, 50, 3, models.TransactionVoided)
+	recordSale(t, mockDB, 1, 50, 60, models.TransactionCompleted)
+
+	suggestions, err := GetReorderSuggestions(mockDB, ReorderOptions{DefaultReorderPoint: 10, SalesWindowDays: 30, CoverDays: 15})
+	if err != nil {
+		t.Fatalf("GetReorderSuggestions failed: %v", err)
+	}
+
+	// Banana has 0 in stock against the default point of 10 and no sales
+	if len(suggestions) != 2 {
+		t.Fatalf("Expected 2 suggestions, got %d", len(suggestions))
+	}
+	banana, apple := suggestions[0], suggestions[1]
+
+	if banana.Item.Name != "Banana" || banana.SupplierName != "" {
+		t.Errorf("Expected Banana without supplier first, got %s (%q)", banana.Item.Name, banana.SupplierName)
+	}
+	if banana.SuggestedQuantity != 10 {
+		t.Errorf("Expected 10 bananas to reach the reorder point, got %d", banana.SuggestedQuantity)
+	}
+
+	if apple.SupplierName != "Fresh Farms" {
+		t.Errorf("Expected apple supplier Fresh Farms, got %q", apple.SupplierName)
+	}
+	if apple.SoldInWindow != 28 {
+		t.Errorf("Expected 28 apples sold in window, got %d", apple.SoldInWindow)
+	}
+	// 28/30 a day for 15 days rounds up to 14; target 15+14 = 29, less 10 in stock
+	if apple.SuggestedQuantity != 19 {
+		t.Errorf("Expected 19 apples suggested, got %d", apple.SuggestedQuantity)
+	}
+
+	// Stock already on order counts towards the target
+	order := createOrderedPO(t, mockDB, supplier.ID, models.PurchaseOrderLine{ItemID: 1, QuantityOrdered: 4, UnitCost: 90})
+	suggestions, err = GetReorderSuggestions(mockDB, ReorderOptions{DefaultReorderPoint: 10, SalesWindowDays: 30, CoverDays: 15})
+	if err != nil {
+		t.Fatalf("GetReorderSuggestions failed: %v", err)
+	}
+	apple = suggestions[1]
+	if apple.OnOrder != 4 || apple.SuggestedQuantity != 15 {
+		t.Errorf("Expected 4 on order and 15 suggested, got %d and %d", apple.OnOrder, apple.SuggestedQuantity)
+	}
+
+	// Never suggest less than the usual reorder quantity, and drop items
+	// that are covered by what's on order
+	if err := ReceivePurchaseOrder(mockDB, order.ID, 1, []Receipt{{LineID: order.Lines[0].ID, Quantity: 4, UnitCost: 90}}); err != nil {
+		t.Fatalf("ReceivePurchaseOrder failed: %v", err)
+	}
+	suggestions, err = GetReorderSuggestions(mockDB, ReorderOptions{DefaultReorderPoint: 10, SalesWindowDays: 30, CoverDays: 0})
+	if err != nil {
+		t.Fatalf("GetReorderSuggestions failed: %v", err)
+	}
+	if len(suggestions) != 2 || suggestions[1].SuggestedQuantity != 12 {
+		t.Errorf("Expected the usual 12 apples, got %+v", suggestions)
+	}
+
+	createOrderedPO(t, mockDB, supplier.ID, models.PurchaseOrderLine{ItemID: 1, QuantityOrdered: 5, UnitCost: 90})
+	suggestions, err = GetReorderSuggestions(mockDB, ReorderOptions{DefaultReorderPoint: 10, SalesWindowDays: 30, CoverDays: 0})
+	if err != nil {
+		t.Fatalf("GetReorderSuggestions failed: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Item.Name != "Banana" {
+		t.Errorf("Expected only Banana once apples are on order, got %+v", suggestions)
+	}
+
+	if _, err := GetReorderSuggestions(mockDB, ReorderOptions{SalesWindowDays: 0}); err == nil {
+		t.Error("Expected error for empty sales window")
+	}
+}
+
+func TestWriteReorderCSV(t *testing.T) {
+	suggestions := []ReorderSuggestion{{
+		Item:              models.Item{Code: "APL001", Name: "Apple, Red", Quantity: 10, Cost: 90},
+		ReorderPoint:      15,
+		OnOrder:           4,
+		SoldInWindow:      28,
+		DailySales:        28.0 / 30,
+		SuggestedQuantity: 15,
+		SupplierName:      "Fresh Farms",
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteReorderCSV(&buf, suggestions); err != nil {
+		t.Fatalf("WriteReorderCSV failed: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Output is not valid CSV: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected header and 1 row, got %d records", len(records))
+	}
+	want := []string{"APL001", "Apple, Red", "Fresh Farms", "10", "4", "15", "28", "0.93", "15", "0.90", "13.50"}
+	for i, field := range want {
+		if records[1][i] != field {
+			t.Errorf("Column %s: expected %q, got %q", records[0][i], field, records[1][i])
+		}
+	}
+}
+
+func TestCreateDraftOrders(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	farms, _ := CreateSupplier(mockDB, "Fresh Farms", "", "", "", "", 1)
+	orchard, _ := CreateSupplier(mockDB, "Orchard Co", "", "", "", "", 1)
+
+	suggestions := []ReorderSuggestion{
+		{Item: models.Item{ID: 1, Name: "Apple", Cost: 100, PreferredSupplierID: &orchard.ID}, SuggestedQuantity: 12},
+		{Item: models.Item{ID: 2, Name: "Banana", Cost: 50, PreferredSupplierID: &farms.ID}, SuggestedQuantity: 30},
+		{Item: models.Item{ID: 1, Name: "Apple"}, SuggestedQuantity: 5}, // no supplier
+	}
+
+	orders, err := CreateDraftOrders(mockDB, 1, suggestions)
+	if err != nil {
+		t.Fatalf("CreateDraftOrders failed: %v", err)
+	}
+	if len(orders) != 2 {
+		t.Fatalf("Expected 2 draft orders, got %d", len(orders))
+	}
+	for _, order := range orders {
+		if order.Status != models.PurchaseOrderDraft {
+			t.Errorf("Expected draft status, got %s", order.Status)
+		}
+		if len(order.Lines) != 1 {
+			t.Errorf("Expected 1 line on PO #%d, got %d", order.ID, len(order.Lines))
+		}
+	}
+	if orders[0].SupplierID != orchard.ID || orders[0].Lines[0].QuantityOrdered != 12 || orders[0].Lines[0].UnitCost != 100 {
+		t.Errorf("Unexpected first order: %+v", orders[0])
+	}
+
+	if _, err := CreateDraftOrders(mockDB, 1, suggestions[2:]); err == nil {
+		t.Error("Expected error when no suggestion has a supplier")
+	}
+}
@@ -0,0 +1,115 @@
+package purchasing
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+type Database interface {
+	GetDB() *sql.DB
+}
+
+func CreateSupplier(db Database, name, contactName, phone, email, address string, userID int) (*models.Supplier, error) {
+	if err := roles.Require(db, userID, models.PermPurchasing); err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("supplier name is required")
+	}
+
+	result, err := db.GetDB().Exec(
+		"INSERT INTO suppliers (name, contact_name, phone, email, address, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		name, contactName, phone, email, address, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetSupplierByID(db, int(id))
+}
+
+func GetSupplierByID(db Database, id int) (*models.Supplier, error) {
+	var supplier models.Supplier
+	err := db.GetDB().QueryRow(
+		"SELECT id, name, contact_name, phone, email, address, created_at FROM suppliers WHERE id = ?",
+		id,
+	).Scan(&supplier.ID, &supplier.Name, &supplier.ContactName, &supplier.Phone, &supplier.Email, &supplier.Address, &supplier.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, errors.New("supplier not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &supplier, nil
+}
+
+func GetAllSuppliers(db Database) ([]models.Supplier, error) {
+	rows, err := db.GetDB().Query("SELECT id, name, contact_name, phone, email, address, created_at FROM suppliers ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suppliers []models.Supplier
+	for rows.Next() {
+		var supplier models.Supplier
+		err := rows.Scan(&supplier.ID, &supplier.Name, &supplier.ContactName, &supplier.Phone, &supplier.Email, &supplier.Address, &supplier.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		suppliers = append(suppliers, supplier)
+	}
+
+	return suppliers, rows.Err()
+}
+
+func UpdateSupplier(db Database, id int, name, contactName, phone, email, address string, userID int) error {
+	if err := roles.Require(db, userID, models.PermPurchasing); err != nil {
+		return err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("supplier name is required")
+	}
+
+	_, err := db.GetDB().Exec(
+		"UPDATE suppliers SET name = ?, contact_name = ?, phone = ?, email = ?, address = ? WHERE id = ?",
+		name, contactName, phone, email, address, id,
+	)
+	return err
+}
+
+// DeleteSupplier removes a supplier that has never had a purchase order.
+// Suppliers with order history are kept so past orders still make sense.
+func DeleteSupplier(db Database, id int, userID int) error {
+	if err := roles.Require(db, userID, models.PermPurchasing); err != nil {
+		return err
+	}
+
+	var orders int
+	err := db.GetDB().QueryRow("SELECT COUNT(*) FROM purchase_orders WHERE supplier_id = ?", id).Scan(&orders)
+	if err != nil {
+		return err
+	}
+	if orders > 0 {
+		return errors.New("cannot delete a supplier with purchase orders")
+	}
+
+	_, err = db.GetDB().Exec("DELETE FROM suppliers WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,491 @@
+package roles
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ims-go/models"
+)
+
+type Database interface {
+	GetDB() *sql.DB
+}
+
+// ErrNotPermitted is returned, wrapped with the permission missing, when a
+// user tries something none of their roles allow
+var ErrNotPermitted = errors.New("not permitted")
+
+// ErrRoleInUse is returned when deleting a role that users still hold
+var ErrRoleInUse = errors.New("role is assigned to users; take it away from them first")
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Require checks that a user may do something. The root admin may do
+// anything; anyone else needs a role with the permission. Archived and
+// unknown users may do nothing.
+func Require(db Database, userID int, permission models.Permission) error {
+	return require(db.GetDB(), userID, permission)
+}
+
+// RequireTx is Require inside a transaction, so the check and the change it
+// guards see the same data
+func RequireTx(tx *sql.Tx, userID int, permission models.Permission) error {
+	return require(tx, userID, permission)
+}
+
+func require(q queryRower, userID int, permission models.Permission) error {
+	var permitted int
+	err := q.QueryRow(
+		`SELECT u.is_root_admin = 1 OR EXISTS (
+			SELECT 1 FROM user_roles ur
+			JOIN role_permissions rp ON rp.role_id = ur.role_id
+			WHERE ur.user_id = u.id AND rp.permission = ?)
+		 FROM users u WHERE u.id = ? AND u.archived_at IS NULL`,
+		string(permission), userID,
+	).Scan(&permitted)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: user not found", ErrNotPermitted)
+	}
+	if err != nil {
+		return err
+	}
+	if permitted != 1 {
+		return fmt.Errorf("%w: %s", ErrNotPermitted, permission)
+	}
+	return nil
+}
+
+// RequireRootTx checks inside a transaction that a user is the root admin,
+// for the few things no role can allow
+func RequireRootTx(tx *sql.Tx, userID int) error {
+	var root int
+	err := tx.QueryRow("SELECT is_root_admin FROM users WHERE id = ? AND archived_at IS NULL", userID).Scan(&root)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: user not found", ErrNotPermitted)
+	}
+	if err != nil {
+		return err
+	}
+	if root != 1 {
+		return fmt.Errorf("%w: only the root admin may do this", ErrNotPermitted)
+	}
+	return nil
+}
+
+// RequireMayChangeTx checks inside a transaction that a user may change
+// another's account. Taking over an account gives its permissions, so the
+// user must already hold everything the target can do, as when granting
+// roles. Nobody but the root admin may change the root admin.
+func RequireMayChangeTx(tx *sql.Tx, userID, targetID int) error {
+	var targetRoot int
+	err := tx.QueryRow("SELECT is_root_admin FROM users WHERE id = ?", targetID).Scan(&targetRoot)
+	if err == sql.ErrNoRows {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+	if targetRoot == 1 {
+		return RequireRootTx(tx, userID)
+	}
+
+	rows, err := tx.Query(
+		`SELECT DISTINCT rp.permission FROM user_roles ur
+		 JOIN role_permissions rp ON rp.role_id = ur.role_id
+		 WHERE ur.user_id = ?`,
+		targetID,
+	)
+	if err != nil {
+		return err
+	}
+	var held []models.Permission
+	for rows.Next() {
+		var permission models.Permission
+		if err := rows.Scan(&permission); err != nil {
+			rows.Close()
+			return err
+		}
+		held = append(held, permission)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, permission := range held {
+		if err := RequireTx(tx, userID, permission); err != nil {
+			return fmt.Errorf("cannot change a user who can do more: %w", err)
+		}
+	}
+	return nil
+}
+
+// RequireGrantTx checks inside a transaction that a user may give targetID
+// the roles in roleIDs. Each role the target doesn't hold yet may only allow
+// what the granting user can do themselves, so nobody can hand out more
+// than they have.
+func RequireGrantTx(tx *sql.Tx, userID, targetID int, roleIDs []int) error {
+	for _, roleID := range roleIDs {
+		rows, err := tx.Query(
+			`SELECT r.name, rp.permission FROM roles r
+			 JOIN role_permissions rp ON rp.role_id = r.id
+			 WHERE r.id = ? AND NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = ? AND ur.role_id = r.id)`,
+			roleID, targetID,
+		)
+		if err != nil {
+			return err
+		}
+		type grant struct {
+			role       string
+			permission models.Permission
+		}
+		var grants []grant
+		for rows.Next() {
+			var g grant
+			if err := rows.Scan(&g.role, &g.permission); err != nil {
+				rows.Close()
+				return err
+			}
+			grants = append(grants, g)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, g := range grants {
+			if err := RequireTx(tx, userID, g.permission); err != nil {
+				return fmt.Errorf("cannot grant %s: %w", g.role, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CreateRole adds a role with the given permissions
+func CreateRole(db Database, name string, permissions []models.Permission, userID int) (*models.Role, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("role name is required")
+	}
+	if err := checkPermissions(permissions); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := RequireTx(tx, userID, models.PermUserManage); err != nil {
+		return nil, err
+	}
+	if err := checkNameFree(tx, name, 0); err != nil {
+		return nil, err
+	}
+	result, err := tx.Exec("INSERT INTO roles (name) VALUES (?)", name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := setPermissions(tx, int(id), permissions); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetRoleByID(db, int(id))
+}
+
+// UpdateRole renames a role and replaces its permissions. Everyone holding
+// the role gets the new permissions at once, so the user can only add
+// permissions they have themselves, and may only change the role at all if
+// they may change every user holding it.
+func UpdateRole(db Database, id int, name string, permissions []models.Permission, userID int) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("role name is required")
+	}
+	if err := checkPermissions(permissions); err != nil {
+		return err
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := RequireTx(tx, userID, models.PermUserManage); err != nil {
+		return err
+	}
+	if err := checkNameFree(tx, name, id); err != nil {
+		return err
+	}
+	if err := requireMayChangeHoldersTx(tx, userID, id); err != nil {
+		return err
+	}
+	for _, p := range permissions {
+		var held int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM role_permissions WHERE role_id = ? AND permission = ?", id, string(p)).Scan(&held); err != nil {
+			return err
+		}
+		if held == 0 {
+			if err := RequireTx(tx, userID, p); err != nil {
+				return fmt.Errorf("cannot add %s to %s: %w", p, name, err)
+			}
+		}
+	}
+	result, err := tx.Exec("UPDATE roles SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("role not found")
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", id); err != nil {
+		return err
+	}
+	if err := setPermissions(tx, id, permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// requireMayChangeHoldersTx checks that a user may change every user holding
+// a role, since changing the role changes what they can do
+func requireMayChangeHoldersTx(tx *sql.Tx, userID, roleID int) error {
+	rows, err := tx.Query("SELECT user_id FROM user_roles WHERE role_id = ?", roleID)
+	if err != nil {
+		return err
+	}
+	var holders []int
+	for rows.Next() {
+		var holder int
+		if err := rows.Scan(&holder); err != nil {
+			rows.Close()
+			return err
+		}
+		holders = append(holders, holder)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, holder := range holders {
+		if err := RequireMayChangeTx(tx, userID, holder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRole removes a role nobody holds
+func DeleteRole(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := RequireTx(tx, userID, models.PermUserManage); err != nil {
+		return err
+	}
+	var holders int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM user_roles WHERE role_id = ?", id).Scan(&holders); err != nil {
+		return err
+	}
+	if holders > 0 {
+		return ErrRoleInUse
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM roles WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("role not found")
+	}
+	return tx.Commit()
+}
+
+// GetRoleByID returns a role with its permissions
+func GetRoleByID(db Database, id int) (*models.Role, error) {
+	roles, err := queryRoles(db, "SELECT id, name FROM roles WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("role not found")
+	}
+	return &roles[0], nil
+}
+
+// GetAllRoles returns every role, by name
+func GetAllRoles(db Database) ([]models.Role, error) {
+	return queryRoles(db, "SELECT id, name FROM roles ORDER BY name")
+}
+
+// GetUserRoles returns the roles a user holds, by name
+func GetUserRoles(db Database, userID int) ([]models.Role, error) {
+	return queryRoles(db,
+		"SELECT r.id, r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = ? ORDER BY r.name",
+		userID,
+	)
+}
+
+// SetUserRoles replaces the roles a user holds. Only the root admin may
+// change the root admin's roles, and nobody may grant a role allowing more
+// than they can do.
+func SetUserRoles(db Database, targetID int, roleIDs []int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := RequireTx(tx, userID, models.PermUserManage); err != nil {
+		return err
+	}
+	if err := RequireMayChangeTx(tx, userID, targetID); err != nil {
+		return err
+	}
+	if err := RequireGrantTx(tx, userID, targetID, roleIDs); err != nil {
+		return err
+	}
+	if err := SetUserRolesTx(tx, targetID, roleIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetUserRolesTx replaces the roles a user holds inside a transaction. It
+// doesn't check permissions; callers do.
+func SetUserRolesTx(tx *sql.Tx, targetID int, roleIDs []int) error {
+	if _, err := tx.Exec("DELETE FROM user_roles WHERE user_id = ?", targetID); err != nil {
+		return err
+	}
+	for _, roleID := range roleIDs {
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM roles WHERE id = ?", roleID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("role %d not found", roleID)
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO user_roles (user_id, role_id) VALUES (?, ?)", targetID, roleID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryRoles runs a query for role IDs and names, then fills in each role's
+// permissions once the rows are closed
+func queryRoles(db Database, query string, args ...interface{}) ([]models.Role, error) {
+	rows, err := db.GetDB().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.ID, &role.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range roles {
+		if roles[i].Permissions, err = rolePermissions(db, roles[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+func rolePermissions(db Database, roleID int) ([]models.Permission, error) {
+	rows, err := db.GetDB().Query("SELECT permission FROM role_permissions WHERE role_id = ?", roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := make(map[models.Permission]bool)
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		held[models.Permission(permission)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Keep the order permissions are shown in
+	var permissions []models.Permission
+	for _, p := range models.Permissions {
+		if held[p] {
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions, nil
+}
+
+func setPermissions(tx *sql.Tx, roleID int, permissions []models.Permission) error {
+	for _, p := range permissions {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO role_permissions (role_id, permission) VALUES (?, ?)", roleID, string(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPermissions rejects permissions this program doesn't know
+func checkPermissions(permissions []models.Permission) error {
+	for _, p := range permissions {
+		known := false
+		for _, k := range models.Permissions {
+			if p == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown permission %q", p)
+		}
+	}
+	return nil
+}
+
+func checkNameFree(tx *sql.Tx, name string, id int) error {
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM roles WHERE name = ? AND id != ?", name, id).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("a role with that name already exists")
+	}
+	return nil
+}
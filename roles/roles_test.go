@@ -0,0 +1,290 @@
+package roles
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"ims-go/models"
+
+	_ "modernc.org/sqlite"
+)
+
+type MockDB struct {
+	db *sql.DB
+}
+
+func (m *MockDB) GetDB() *sql.DB {
+	return m.db
+}
+
+func setupTestDB(t *testing.T) *MockDB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			is_root_admin INTEGER DEFAULT 0,
+			archived_at DATETIME
+		)`,
+		`CREATE TABLE roles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL COLLATE NOCASE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE role_permissions (
+			role_id INTEGER NOT NULL,
+			permission TEXT NOT NULL,
+			PRIMARY KEY (role_id, permission)
+		)`,
+		`CREATE TABLE user_roles (
+			user_id INTEGER NOT NULL,
+			role_id INTEGER NOT NULL,
+			PRIMARY KEY (user_id, role_id)
+		)`,
+		`INSERT INTO users (username, is_root_admin) VALUES ('admin', 1), ('sam', 0), ('alex', 0)`,
+	}
+	for _, query := range schema {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+	}
+	return &MockDB{db: db}
+}
+
+const (
+	adminID = 1
+	samID   = 2
+	alexID  = 3
+)
+
+func TestCreateRole(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	cashier, err := CreateRole(mockDB, " Cashier ", []models.Permission{models.PermTxnRefund, models.PermTxnCreate}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	if cashier.Name != "Cashier" || len(cashier.Permissions) != 2 || cashier.Permissions[0] != models.PermTxnCreate {
+		t.Errorf("Expected Cashier with its permissions in order, got %+v", cashier)
+	}
+
+	tests := []struct {
+		name        string
+		roleName    string
+		permissions []models.Permission
+		userID      int
+	}{
+		{"blank name", " ", nil, adminID},
+		{"duplicate name", "CASHIER", nil, adminID},
+		{"unknown permission", "Baker", []models.Permission{"bread.bake"}, adminID},
+		{"not permitted", "Baker", nil, samID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CreateRole(mockDB, tt.roleName, tt.permissions, tt.userID); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+
+	all, err := GetAllRoles(mockDB)
+	if err != nil {
+		t.Fatalf("GetAllRoles failed: %v", err)
+	}
+	if len(all) != 1 {
+		t.Errorf("Expected only the Cashier role, got %+v", all)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	manager, err := CreateRole(mockDB, "Manager", []models.Permission{models.PermUserManage}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	clerk, err := CreateRole(mockDB, "Stock Clerk", []models.Permission{models.PermStockAdjust}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+
+	// Roles are granted by someone who manages users, then count at once
+	if err := SetUserRoles(mockDB, alexID, []int{clerk.ID}, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted, got %v", err)
+	}
+	if err := SetUserRoles(mockDB, samID, []int{manager.ID, clerk.ID}, adminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+	if err := SetUserRoles(mockDB, alexID, []int{clerk.ID}, samID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+
+	tests := []struct {
+		userID     int
+		permission models.Permission
+		permitted  bool
+	}{
+		{adminID, models.PermDBBackup, true},
+		{samID, models.PermUserManage, true},
+		{samID, models.PermStockAdjust, true},
+		{alexID, models.PermStockAdjust, true},
+		{alexID, models.PermUserManage, false},
+		{99, models.PermItemView, false},
+	}
+	for _, tt := range tests {
+		err := Require(mockDB, tt.userID, tt.permission)
+		if tt.permitted && err != nil {
+			t.Errorf("Expected user %d to have %s: %v", tt.userID, tt.permission, err)
+		}
+		if !tt.permitted && !errors.Is(err, ErrNotPermitted) {
+			t.Errorf("Expected user %d not to have %s, got %v", tt.userID, tt.permission, err)
+		}
+	}
+
+	held, err := GetUserRoles(mockDB, samID)
+	if err != nil {
+		t.Fatalf("GetUserRoles failed: %v", err)
+	}
+	user := models.User{ID: samID, Roles: held}
+	if len(held) != 2 || !user.Can(models.PermStockAdjust) || user.Can(models.PermDBBackup) {
+		t.Errorf("Expected Manager and Stock Clerk, got %+v", held)
+	}
+
+	// Archived users lose everything
+	mockDB.db.Exec("UPDATE users SET archived_at = CURRENT_TIMESTAMP WHERE id = ?", alexID)
+	if err := Require(mockDB, alexID, models.PermStockAdjust); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted for an archived user, got %v", err)
+	}
+}
+
+func TestSetUserRoles_NoEscalation(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	manager, err := CreateRole(mockDB, "Manager", []models.Permission{models.PermUserManage}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	backups, err := CreateRole(mockDB, "Backups", []models.Permission{models.PermDBBackup}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	if err := SetUserRoles(mockDB, samID, []int{manager.ID}, adminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+
+	// Sam can't hand out db.backup, which Sam doesn't have
+	if err := SetUserRoles(mockDB, alexID, []int{backups.ID}, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted granting a role with more than the granter has, got %v", err)
+	}
+	if err := SetUserRoles(mockDB, samID, []int{manager.ID, backups.ID}, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted granting it to themselves, got %v", err)
+	}
+	if err := UpdateRole(mockDB, manager.ID, manager.Name, []models.Permission{models.PermUserManage, models.PermDBBackup}, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted adding a permission to a role, got %v", err)
+	}
+
+	// What Sam has can be given, but a user who can do more is out of reach
+	if err := SetUserRoles(mockDB, alexID, []int{manager.ID}, samID); err != nil {
+		t.Errorf("Expected Sam to give Alex Manager: %v", err)
+	}
+	if err := SetUserRoles(mockDB, alexID, []int{backups.ID}, adminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+	if err := SetUserRoles(mockDB, alexID, nil, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted changing a user with db.backup, got %v", err)
+	}
+
+	// Only the root admin may change the root admin
+	if err := SetUserRoles(mockDB, adminID, nil, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted changing the root admin's roles, got %v", err)
+	}
+	if err := SetUserRoles(mockDB, adminID, []int{manager.ID}, adminID); err != nil {
+		t.Errorf("Expected the root admin to change their own roles: %v", err)
+	}
+}
+
+func TestUpdateRole_HeldByMorePrivileged(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	manager, err := CreateRole(mockDB, "Manager", []models.Permission{models.PermUserManage}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	administrator, err := CreateRole(mockDB, "Administrator", []models.Permission{models.PermUserManage, models.PermDBBackup}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	if err := SetUserRoles(mockDB, samID, []int{manager.ID}, adminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+	if err := SetUserRoles(mockDB, alexID, []int{administrator.ID}, adminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+
+	// Alex can do more than Sam, so Sam can't take from or rename Alex's role
+	if err := UpdateRole(mockDB, administrator.ID, administrator.Name, []models.Permission{models.PermUserManage}, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted removing a permission from a role held by a user who can do more, got %v", err)
+	}
+	if err := UpdateRole(mockDB, administrator.ID, "Admins", administrator.Permissions, samID); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted renaming a role held by a user who can do more, got %v", err)
+	}
+	if updated, _ := GetRoleByID(mockDB, administrator.ID); updated.Name != "Administrator" || !updated.Has(models.PermDBBackup) {
+		t.Errorf("Expected Administrator to be unchanged, got %+v", updated)
+	}
+
+	// Sam's own role is within reach
+	if err := UpdateRole(mockDB, manager.ID, "Managers", manager.Permissions, samID); err != nil {
+		t.Errorf("Expected Sam to rename a role only Sam holds: %v", err)
+	}
+}
+
+func TestUpdateAndDeleteRole(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	role, err := CreateRole(mockDB, "Cashier", []models.Permission{models.PermTxnCreate}, adminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	if err := SetUserRoles(mockDB, samID, []int{role.ID}, adminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+
+	if err := UpdateRole(mockDB, role.ID, "Senior Cashier", []models.Permission{models.PermTxnCreate, models.PermTxnVoid}, adminID); err != nil {
+		t.Fatalf("UpdateRole failed: %v", err)
+	}
+	updated, err := GetRoleByID(mockDB, role.ID)
+	if err != nil {
+		t.Fatalf("GetRoleByID failed: %v", err)
+	}
+	if updated.Name != "Senior Cashier" || !updated.Has(models.PermTxnVoid) {
+		t.Errorf("Expected the renamed role to void, got %+v", updated)
+	}
+	if err := UpdateRole(mockDB, 99, "Nobody", nil, adminID); err == nil {
+		t.Error("Expected error updating a missing role")
+	}
+
+	if err := DeleteRole(mockDB, role.ID, adminID); !errors.Is(err, ErrRoleInUse) {
+		t.Errorf("Expected ErrRoleInUse, got %v", err)
+	}
+	if err := SetUserRoles(mockDB, samID, nil, adminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
+	if err := DeleteRole(mockDB, role.ID, adminID); err != nil {
+		t.Fatalf("DeleteRole failed: %v", err)
+	}
+	if _, err := GetRoleByID(mockDB, role.ID); err == nil {
+		t.Error("Expected the role to be gone")
+	}
+}
@@ -0,0 +1,468 @@
+package stocktake
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
+)
+
+type Database interface {
+	GetDB() *sql.DB
+}
+
+// OpenStocktake starts a count of every active item whose name or code
+// contains filter, or of all active items when filter is empty. Each item's
+// current quantity is recorded as the expected quantity. Products with
+// variants are left out, as their stock is counted as each variant.
+func OpenStocktake(db Database, userID int, filter string) (*models.Stocktake, error) {
+	filter = strings.TrimSpace(filter)
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO stocktakes (status, filter, created_by, created_at) VALUES (?, ?, ?, ?)",
+		models.StocktakeOpen, filter, userID, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err = tx.Exec(
+		`INSERT INTO stocktake_lines (stocktake_id, item_id, expected_quantity)
+		 SELECT ?, id, quantity FROM items
+		 WHERE archived_at IS NULL AND (name LIKE ? OR code LIKE ?)
+			AND NOT EXISTS (SELECT 1 FROM items v WHERE v.parent_id = items.id AND v.archived_at IS NULL)`,
+		id, "%"+filter+"%", "%"+filter+"%",
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, errors.New("no items match the filter")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetStocktakeByID(db, int(id))
+}
+
+func GetStocktakeByID(db Database, id int) (*models.Stocktake, error) {
+	stocktake, err := scanStocktake(db.GetDB().QueryRow(
+		"SELECT id, status, filter, created_by, created_at, posted_by, posted_at FROM stocktakes WHERE id = ?",
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("stocktake not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stocktake.Lines, err = queryLines(db.GetDB(), id)
+	if err != nil {
+		return nil, err
+	}
+	return stocktake, nil
+}
+
+// GetStocktakes returns every stocktake with its lines, newest first
+func GetStocktakes(db Database) ([]models.Stocktake, error) {
+	rows, err := db.GetDB().Query(
+		"SELECT id, status, filter, created_by, created_at, posted_by, posted_at FROM stocktakes ORDER BY created_at DESC, id DESC",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var stocktakes []models.Stocktake
+	for rows.Next() {
+		stocktake, err := scanStocktake(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stocktakes = append(stocktakes, *stocktake)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range stocktakes {
+		stocktakes[i].Lines, err = queryLines(db.GetDB(), stocktakes[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stocktakes, nil
+}
+
+// SetCount records the counted quantity of an item, replacing any earlier
+// count. The item's system quantity now becomes the expected quantity, since
+// that is what the count was made against.
+func SetCount(db Database, stocktakeID, itemID, quantity int, userID int) error {
+	if quantity < 0 {
+		return fmt.Errorf("invalid count %d", quantity)
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return err
+	}
+	err = updateCountTx(tx, stocktakeID,
+		`UPDATE stocktake_lines SET counted_quantity = ?,
+			expected_quantity = (SELECT quantity FROM items WHERE id = stocktake_lines.item_id)
+		 WHERE stocktake_id = ? AND item_id = ?`,
+		quantity, stocktakeID, itemID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddCountByCode adds quantity to the count of the item with the given code,
+// as when items are scanned one at a time. A barcode standing for several
+// units, such as a case, counts each scan as that many. The first scan of an
+// item takes its system quantity as the expected quantity. It returns the
+// updated line.
+func AddCountByCode(db Database, stocktakeID int, code string, quantity int, userID int) (*models.StocktakeLine, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("invalid count %d", quantity)
+	}
+
+	item, barcode, err := inventory.LookupCode(db, code)
+	if err != nil {
+		return nil, err
+	}
+	if barcode != nil && barcode.Quantity != nil {
+		quantity *= *barcode.Quantity
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return nil, err
+	}
+	err = updateCountTx(tx, stocktakeID,
+		`UPDATE stocktake_lines SET counted_quantity = COALESCE(counted_quantity, 0) + ?,
+			expected_quantity = CASE WHEN counted_quantity IS NULL
+				THEN (SELECT quantity FROM items WHERE id = stocktake_lines.item_id)
+				ELSE expected_quantity END
+		 WHERE stocktake_id = ? AND item_id = ?`,
+		quantity, stocktakeID, item.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := queryLines(tx, stocktakeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for i := range lines {
+		if lines[i].ItemID == item.ID {
+			return &lines[i], nil
+		}
+	}
+	return nil, errors.New("item is not part of this stocktake")
+}
+
+func updateCountTx(tx *sql.Tx, stocktakeID int, query string, args ...interface{}) error {
+	status, err := getStatus(tx.QueryRow("SELECT status FROM stocktakes WHERE id = ?", stocktakeID))
+	if err != nil {
+		return err
+	}
+	if status != models.StocktakeOpen {
+		return fmt.Errorf("cannot count a %s stocktake", status)
+	}
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("item is not part of this stocktake")
+	}
+	return nil
+}
+
+// Variance compares one counted line against the system
+type Variance struct {
+	Line models.StocktakeLine
+	// SystemQuantity is the item quantity now, which may have moved since the item was counted
+	SystemQuantity int
+	// BatchQuantity is the total of the item's stock batches
+	BatchQuantity int
+	// Difference is counted less expected quantity; negative is shrinkage
+	Difference int
+	UnitCost   money.Money
+	// Value is Difference at the item's cost
+	Value money.Money
+}
+
+// VarianceReport is the outcome of a stocktake. Only counted lines are
+// included; uncounted items are left unchanged when the count is posted.
+type VarianceReport struct {
+	Variances []Variance
+	Uncounted int
+	// Shrinkage is the cost of missing stock, as a positive amount
+	Shrinkage money.Money
+	// Surplus is the cost of stock found beyond what the system expected
+	Surplus money.Money
+}
+
+// Net is the overall value of the variances; negative is a loss
+func (r VarianceReport) Net() money.Money {
+	return r.Surplus - r.Shrinkage
+}
+
+// GetVarianceReport compares the counted quantities against current item
+// and batch quantities
+func GetVarianceReport(db Database, stocktakeID int) (*VarianceReport, error) {
+	if _, err := getStatus(db.GetDB().QueryRow("SELECT status FROM stocktakes WHERE id = ?", stocktakeID)); err != nil {
+		return nil, err
+	}
+	return buildReport(db.GetDB(), stocktakeID)
+}
+
+// PostStocktake corrects every counted item by its difference, recording
+// each change in the stock ledger as a count against userID, and closes the
+// stocktake. Stock sold or received since an item was counted is kept, as
+// only the difference found by the count is applied, though stock never goes
+// below zero. It returns the variance report that was posted.
+func PostStocktake(db Database, stocktakeID, userID int) (*VarianceReport, error) {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return nil, err
+	}
+
+	status, err := getStatus(tx.QueryRow("SELECT status FROM stocktakes WHERE id = ?", stocktakeID))
+	if err != nil {
+		return nil, err
+	}
+	if status != models.StocktakeOpen {
+		return nil, fmt.Errorf("cannot post a %s stocktake", status)
+	}
+
+	report, err := buildReport(tx, stocktakeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Variances) == 0 {
+		return nil, errors.New("nothing has been counted")
+	}
+
+	change := inventory.StockChange{Reason: models.MovementCount, ReferenceID: &stocktakeID, UserID: userID}
+	for _, v := range report.Variances {
+		if v.Difference == 0 {
+			continue
+		}
+		quantity := v.SystemQuantity + v.Difference
+		if quantity < 0 {
+			quantity = 0
+		}
+		if err := inventory.SetStockLevelTx(tx, v.Line.ItemID, quantity, change); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE stocktakes SET status = ?, posted_by = ?, posted_at = ? WHERE id = ?",
+		models.StocktakePosted, userID, time.Now(), stocktakeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CancelStocktake abandons an open stocktake without changing any stock
+func CancelStocktake(db Database, stocktakeID int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermStockAdjust); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		"UPDATE stocktakes SET status = ? WHERE id = ? AND status = ?",
+		models.StocktakeCancelled, stocktakeID, models.StocktakeOpen,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("only open stocktakes can be cancelled")
+	}
+	return tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func scanStocktake(row scanner) (*models.Stocktake, error) {
+	var stocktake models.Stocktake
+	var postedBy sql.NullInt64
+	var postedAt sql.NullTime
+
+	err := row.Scan(&stocktake.ID, &stocktake.Status, &stocktake.Filter, &stocktake.CreatedBy, &stocktake.CreatedAt, &postedBy, &postedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if postedBy.Valid {
+		id := int(postedBy.Int64)
+		stocktake.PostedBy = &id
+	}
+	if postedAt.Valid {
+		stocktake.PostedAt = &postedAt.Time
+	}
+	return &stocktake, nil
+}
+
+// queryLines loads a stocktake's lines using either the database or an open
+// transaction
+func queryLines(q querier, stocktakeID int) ([]models.StocktakeLine, error) {
+	rows, err := q.Query(
+		`SELECT l.id, l.stocktake_id, l.item_id, i.name, i.code, l.expected_quantity, l.counted_quantity
+		 FROM stocktake_lines l
+		 JOIN items i ON l.item_id = i.id
+		 WHERE l.stocktake_id = ?
+		 ORDER BY i.name`,
+		stocktakeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []models.StocktakeLine
+	for rows.Next() {
+		var line models.StocktakeLine
+		var counted sql.NullInt64
+		err := rows.Scan(&line.ID, &line.StocktakeID, &line.ItemID, &line.ItemName, &line.ItemCode, &line.ExpectedQuantity, &counted)
+		if err != nil {
+			return nil, err
+		}
+		if counted.Valid {
+			quantity := int(counted.Int64)
+			line.CountedQuantity = &quantity
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+func buildReport(q querier, stocktakeID int) (*VarianceReport, error) {
+	rows, err := q.Query(
+		`SELECT l.id, l.stocktake_id, l.item_id, i.name, i.code, l.expected_quantity, l.counted_quantity,
+			i.quantity, COALESCE((SELECT SUM(s.quantity) FROM item_stock s WHERE s.item_id = i.id), 0), i.cost
+		 FROM stocktake_lines l
+		 JOIN items i ON l.item_id = i.id
+		 WHERE l.stocktake_id = ?
+		 ORDER BY i.name`,
+		stocktakeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &VarianceReport{}
+	for rows.Next() {
+		var v Variance
+		var counted sql.NullInt64
+		err := rows.Scan(&v.Line.ID, &v.Line.StocktakeID, &v.Line.ItemID, &v.Line.ItemName, &v.Line.ItemCode, &v.Line.ExpectedQuantity, &counted,
+			&v.SystemQuantity, &v.BatchQuantity, &v.UnitCost)
+		if err != nil {
+			return nil, err
+		}
+		if !counted.Valid {
+			report.Uncounted++
+			continue
+		}
+
+		quantity := int(counted.Int64)
+		v.Line.CountedQuantity = &quantity
+		v.Difference = quantity - v.Line.ExpectedQuantity
+		v.Value = v.UnitCost.Mul(v.Difference)
+		if v.Value < 0 {
+			report.Shrinkage -= v.Value
+		} else {
+			report.Surplus += v.Value
+		}
+		report.Variances = append(report.Variances, v)
+	}
+
+	return report, rows.Err()
+}
+
+func getStatus(row *sql.Row) (string, error) {
+	var status string
+	err := row.Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", errors.New("stocktake not found")
+	}
+	return status, err
+}
@@ -0,0 +1,400 @@
+package stocktake
+
+import (
+	"database/sql"
+	"testing"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+
+	_ "modernc.org/sqlite"
+)
+
+type MockDB struct {
+	db *sql.DB
+}
+
+func (m *MockDB) GetDB() *sql.DB {
+	return m.db
+}
+
+func setupTestDB(t *testing.T) *MockDB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			is_root_admin INTEGER DEFAULT 0,
+			archived_at DATETIME
+		)`,
+		`CREATE TABLE roles (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL COLLATE NOCASE, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE role_permissions (role_id INTEGER NOT NULL, permission TEXT NOT NULL, PRIMARY KEY (role_id, permission))`,
+		`CREATE TABLE user_roles (user_id INTEGER NOT NULL, role_id INTEGER NOT NULL, PRIMARY KEY (user_id, role_id))`,
+		`INSERT INTO users (username, is_root_admin) VALUES ('admin', 1)`,
+		`CREATE TABLE items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			code TEXT UNIQUE NOT NULL,
+			description TEXT,
+			price INTEGER NOT NULL,
+			cost INTEGER NOT NULL DEFAULT 0,
+			quantity INTEGER DEFAULT 0,
+			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expiry_date DATETIME,
+			reorder_point INTEGER,
+			reorder_quantity INTEGER NOT NULL DEFAULT 0,
+			preferred_supplier_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			archived_at DATETIME,
+			category_id INTEGER,
+			parent_id INTEGER,
+			variant_name TEXT NOT NULL DEFAULT '',
+			price_override BOOLEAN NOT NULL DEFAULT 0,
+			base_unit TEXT NOT NULL DEFAULT 'each',
+			sale_unit TEXT NOT NULL DEFAULT '',
+			purchase_unit TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE item_barcodes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER NOT NULL,
+			code TEXT UNIQUE NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			quantity INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE item_stock (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expiry_date DATETIME,
+			unit_cost INTEGER,
+			cost_factor INTEGER NOT NULL DEFAULT 1,
+			purchase_order_line_id INTEGER
+		)`,
+		`CREATE TABLE stock_movements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			item_id INTEGER NOT NULL,
+			batch_id INTEGER,
+			delta INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			reference_id INTEGER,
+			user_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE stocktakes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			status TEXT NOT NULL DEFAULT 'open',
+			filter TEXT NOT NULL DEFAULT '',
+			created_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			posted_by INTEGER,
+			posted_at DATETIME
+		)`,
+		`CREATE TABLE stocktake_lines (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			stocktake_id INTEGER NOT NULL,
+			item_id INTEGER NOT NULL,
+			expected_quantity INTEGER NOT NULL,
+			counted_quantity INTEGER,
+			UNIQUE (stocktake_id, item_id)
+		)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up schema: %v", err)
+		}
+	}
+
+	mockDB := &MockDB{db: db}
+	items := []struct {
+		name, code string
+		cost       string
+		quantity   int
+	}{
+		{"Apple Juice", "AJ001", "2.00", 20},
+		{"Orange Juice", "OJ001", "2.50", 15},
+		{"Milk", "MK001", "1.00", 30},
+	}
+	for _, item := range items {
+		_, err := inventory.CreateItem(mockDB, item.name, item.code, "", money.MustParse("5.00"), money.MustParse(item.cost), item.quantity, 1)
+		if err != nil {
+			t.Fatalf("CreateItem failed: %v", err)
+		}
+	}
+
+	return mockDB
+}
+
+func TestOpenStocktake(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	all, err := OpenStocktake(mockDB, 1, "")
+	if err != nil {
+		t.Fatalf("OpenStocktake failed: %v", err)
+	}
+	if all.Status != models.StocktakeOpen || len(all.Lines) != 3 {
+		t.Errorf("Expected an open stocktake of 3 items, got %s with %d", all.Status, len(all.Lines))
+	}
+
+	juice, err := OpenStocktake(mockDB, 1, "juice")
+	if err != nil {
+		t.Fatalf("OpenStocktake with filter failed: %v", err)
+	}
+	if len(juice.Lines) != 2 {
+		t.Fatalf("Expected 2 juice lines, got %d", len(juice.Lines))
+	}
+	if juice.Lines[0].ItemName != "Apple Juice" || juice.Lines[0].ExpectedQuantity != 20 || juice.Lines[0].CountedQuantity != nil {
+		t.Errorf("Unexpected first line: %+v", juice.Lines[0])
+	}
+
+	if _, err := OpenStocktake(mockDB, 1, "bread"); err == nil {
+		t.Error("Expected error when no items match")
+	}
+
+	stocktakes, err := GetStocktakes(mockDB)
+	if err != nil {
+		t.Fatalf("GetStocktakes failed: %v", err)
+	}
+	if len(stocktakes) != 2 || stocktakes[0].ID != juice.ID {
+		t.Errorf("Expected 2 stocktakes newest first, got %d", len(stocktakes))
+	}
+}
+
+func TestOpenStocktake_ProductWithVariants(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Milk is counted as its variants, not as a product
+	if _, err := mockDB.db.Exec("UPDATE items SET quantity = 0 WHERE code = 'MK001'"); err != nil {
+		t.Fatalf("Failed to empty product: %v", err)
+	}
+	_, err := mockDB.db.Exec(`INSERT INTO items (name, code, description, price, cost, quantity, parent_id)
+		SELECT 'Milk (Skimmed)', 'MK002', '', 500, 100, 12, id FROM items WHERE code = 'MK001'`)
+	if err != nil {
+		t.Fatalf("Failed to insert variant: %v", err)
+	}
+
+	st, err := OpenStocktake(mockDB, 1, "milk")
+	if err != nil {
+		t.Fatalf("OpenStocktake failed: %v", err)
+	}
+	if len(st.Lines) != 1 || st.Lines[0].ItemName != "Milk (Skimmed)" {
+		t.Errorf("Expected only the variant to be counted, got %+v", st.Lines)
+	}
+}
+
+func TestCounting(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	st, err := OpenStocktake(mockDB, 1, "juice")
+	if err != nil {
+		t.Fatalf("OpenStocktake failed: %v", err)
+	}
+
+	// Scanning adds to the count
+	for i := 0; i < 3; i++ {
+		if _, err := AddCountByCode(mockDB, st.ID, "AJ001", 1, 1); err != nil {
+			t.Fatalf("AddCountByCode failed: %v", err)
+		}
+	}
+	line, err := AddCountByCode(mockDB, st.ID, "AJ001", 14, 1)
+	if err != nil {
+		t.Fatalf("AddCountByCode failed: %v", err)
+	}
+	if line.CountedQuantity == nil || *line.CountedQuantity != 17 {
+		t.Errorf("Expected count 17, got %v", line.CountedQuantity)
+	}
+
+	// A case barcode counts the whole case
+	caseOf := 6
+	if _, err := inventory.AddBarcode(mockDB, line.ItemID, "AJ001-CASE", "Case of 6", &caseOf, 1); err != nil {
+		t.Fatalf("AddBarcode failed: %v", err)
+	}
+	line, err = AddCountByCode(mockDB, st.ID, "AJ001-CASE", 2, 1)
+	if err != nil {
+		t.Fatalf("AddCountByCode failed: %v", err)
+	}
+	if *line.CountedQuantity != 29 {
+		t.Errorf("Expected count 29 after two cases, got %d", *line.CountedQuantity)
+	}
+
+	// Typing a count replaces it
+	if err := SetCount(mockDB, st.ID, 2, 16, 1); err != nil {
+		t.Fatalf("SetCount failed: %v", err)
+	}
+
+	// Items outside the stocktake can't be counted
+	if _, err := AddCountByCode(mockDB, st.ID, "MK001", 1, 1); err == nil {
+		t.Error("Expected error counting an item outside the filter")
+	}
+	if _, err := AddCountByCode(mockDB, st.ID, "NOPE", 1, 1); err == nil {
+		t.Error("Expected error for an unknown code")
+	}
+	if err := SetCount(mockDB, st.ID, 2, -1, 1); err == nil {
+		t.Error("Expected error for a negative count")
+	}
+
+	got, err := GetStocktakeByID(mockDB, st.ID)
+	if err != nil {
+		t.Fatalf("GetStocktakeByID failed: %v", err)
+	}
+	if got.Counted() != 2 {
+		t.Errorf("Expected 2 counted lines, got %d", got.Counted())
+	}
+}
+
+func TestPostStocktake(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	st, err := OpenStocktake(mockDB, 1, "")
+	if err != nil {
+		t.Fatalf("OpenStocktake failed: %v", err)
+	}
+	SetCount(mockDB, st.ID, 1, 17, 1) // Apple Juice: 3 missing at 2.00
+	SetCount(mockDB, st.ID, 2, 16, 1) // Orange Juice: 1 extra at 2.50
+	// Milk is not counted
+
+	report, err := GetVarianceReport(mockDB, st.ID)
+	if err != nil {
+		t.Fatalf("GetVarianceReport failed: %v", err)
+	}
+	if len(report.Variances) != 2 || report.Uncounted != 1 {
+		t.Fatalf("Expected 2 variances and 1 uncounted, got %d and %d", len(report.Variances), report.Uncounted)
+	}
+	apple := report.Variances[0]
+	if apple.Difference != -3 || apple.SystemQuantity != 20 || apple.BatchQuantity != 20 || apple.Value != money.MustParse("-6.00") {
+		t.Errorf("Unexpected apple variance: %+v", apple)
+	}
+	if report.Shrinkage != money.MustParse("6.00") || report.Surplus != money.MustParse("2.50") || report.Net() != money.MustParse("-3.50") {
+		t.Errorf("Unexpected totals: shrinkage %s, surplus %s, net %s", report.Shrinkage, report.Surplus, report.Net())
+	}
+
+	if _, err := PostStocktake(mockDB, st.ID, 1); err != nil {
+		t.Fatalf("PostStocktake failed: %v", err)
+	}
+
+	expected := map[int]int{1: 17, 2: 16, 3: 30}
+	for itemID, want := range expected {
+		quantity, err := inventory.GetItemQuantity(mockDB, itemID)
+		if err != nil {
+			t.Fatalf("GetItemQuantity failed: %v", err)
+		}
+		if quantity != want {
+			t.Errorf("Item %d: expected quantity %d, got %d", itemID, want, quantity)
+		}
+	}
+
+	movements, err := inventory.GetItemMovements(mockDB, 1)
+	if err != nil {
+		t.Fatalf("GetItemMovements failed: %v", err)
+	}
+	if len(movements) != 2 || movements[0].Reason != models.MovementCount || movements[0].Delta != -3 ||
+		movements[0].ReferenceID == nil || *movements[0].ReferenceID != st.ID {
+		t.Errorf("Expected a count movement for the stocktake, got %+v", movements)
+	}
+
+	posted, err := GetStocktakeByID(mockDB, st.ID)
+	if err != nil {
+		t.Fatalf("GetStocktakeByID failed: %v", err)
+	}
+	if posted.Status != models.StocktakePosted || posted.PostedAt == nil || posted.PostedBy == nil {
+		t.Errorf("Expected stocktake to be posted, got %+v", posted)
+	}
+
+	// A posted stocktake is closed
+	if _, err := PostStocktake(mockDB, st.ID, 1); err == nil {
+		t.Error("Expected error posting twice")
+	}
+	if err := SetCount(mockDB, st.ID, 3, 1, 1); err == nil {
+		t.Error("Expected error counting a posted stocktake")
+	}
+	if err := CancelStocktake(mockDB, st.ID, 1); err == nil {
+		t.Error("Expected error cancelling a posted stocktake")
+	}
+}
+
+func TestPostStocktake_KeepsLaterSales(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	st, err := OpenStocktake(mockDB, 1, "juice")
+	if err != nil {
+		t.Fatalf("OpenStocktake failed: %v", err)
+	}
+
+	// 4 Orange Juice go after opening but before it is counted, so the count
+	// is made against 11
+	if err := inventory.AdjustStock(mockDB, 2, nil, -4, models.MovementDamage, 1); err != nil {
+		t.Fatalf("AdjustStock failed: %v", err)
+	}
+	if err := SetCount(mockDB, st.ID, 2, 11, 1); err != nil {
+		t.Fatalf("SetCount failed: %v", err)
+	}
+	// Apple Juice is counted 3 short, then 2 more go before posting
+	if _, err := AddCountByCode(mockDB, st.ID, "AJ001", 17, 1); err != nil {
+		t.Fatalf("AddCountByCode failed: %v", err)
+	}
+	if err := inventory.AdjustStock(mockDB, 1, nil, -2, models.MovementDamage, 1); err != nil {
+		t.Fatalf("AdjustStock failed: %v", err)
+	}
+
+	report, err := PostStocktake(mockDB, st.ID, 1)
+	if err != nil {
+		t.Fatalf("PostStocktake failed: %v", err)
+	}
+	if apple := report.Variances[0]; apple.Line.ExpectedQuantity != 20 || apple.SystemQuantity != 18 || apple.Difference != -3 {
+		t.Errorf("Unexpected apple variance: %+v", apple)
+	}
+	if orange := report.Variances[1]; orange.Line.ExpectedQuantity != 11 || orange.Difference != 0 {
+		t.Errorf("Unexpected orange variance: %+v", orange)
+	}
+
+	expected := map[int]int{1: 15, 2: 11}
+	for itemID, want := range expected {
+		quantity, err := inventory.GetItemQuantity(mockDB, itemID)
+		if err != nil {
+			t.Fatalf("GetItemQuantity failed: %v", err)
+		}
+		if quantity != want {
+			t.Errorf("Item %d: expected quantity %d, got %d", itemID, want, quantity)
+		}
+	}
+}
+
+func TestCancelStocktake(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	st, err := OpenStocktake(mockDB, 1, "")
+	if err != nil {
+		t.Fatalf("OpenStocktake failed: %v", err)
+	}
+	if _, err := PostStocktake(mockDB, st.ID, 1); err == nil {
+		t.Error("Expected error posting with nothing counted")
+	}
+
+	SetCount(mockDB, st.ID, 1, 0, 1)
+	if err := CancelStocktake(mockDB, st.ID, 1); err != nil {
+		t.Fatalf("CancelStocktake failed: %v", err)
+	}
+
+	quantity, _ := inventory.GetItemQuantity(mockDB, 1)
+	if quantity != 20 {
+		t.Errorf("Expected quantity untouched by a cancelled count, got %d", quantity)
+	}
+	if _, err := PostStocktake(mockDB, st.ID, 1); err == nil {
+		t.Error("Expected error posting a cancelled stocktake")
+	}
+}
@@ -0,0 +1,252 @@
+package transactions
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
+)
+
+// CreateRefund records a return against an existing transaction. Each line
+// refers to one of the original transaction items by TransactionItemID and
+// gives the quantity being returned. Returned stock goes back into the batch
+// it was sold from, or a new stock batch if that batch no longer exists. The
+// refund is written in a single database transaction and is rejected if any
+// line would refund more than was sold.
+// The user needs the txn.refund permission.
+func CreateRefund(db Database, originalTxnID, userID int, lines []models.RefundItem) (*models.Refund, error) {
+	if len(lines) == 0 {
+		return nil, errors.New("refund has no items")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermTxnRefund); err != nil {
+		return nil, err
+	}
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM transactions WHERE id = ?", originalTxnID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("transaction not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == models.TransactionVoided {
+		return nil, errors.New("cannot refund a voided transaction")
+	}
+
+	// Load what was sold on each line and how much has already been
+	// refunded, in base units
+	rows, err := tx.Query(
+		`SELECT ti.id, ti.item_id, ti.quantity, ti.price, ti.line_total, COALESCE(ti.cost, 0), ti.cost_factor, ti.batch_id, ti.unit_factor,
+			COALESCE((SELECT SUM(ri.quantity) FROM refund_items ri WHERE ri.transaction_item_id = ti.id), 0)
+		 FROM transaction_items ti
+		 WHERE ti.transaction_id = ?`,
+		originalTxnID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	type soldLine struct {
+		item               models.TransactionItem
+		quantity, refunded int
+	}
+	sold := make(map[int]soldLine)
+	for rows.Next() {
+		var line soldLine
+		var batchID, lineTotal sql.NullInt64
+		item := &line.item
+		if err := rows.Scan(&item.ID, &item.ItemID, &line.quantity, &item.Price, &lineTotal, &item.Cost, &item.CostFactor, &batchID, &item.UnitFactor, &line.refunded); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if batchID.Valid {
+			id := int(batchID.Int64)
+			item.BatchID = &id
+		}
+		if lineTotal.Valid {
+			total := money.FromCents(lineTotal.Int64)
+			item.LineTotal = &total
+		}
+		sold[item.ID] = line
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Validate every line before writing anything. Lines are in the unit the
+	// item was sold in and converted to base units for stock.
+	var totalAmount money.Money
+	requested := make(map[int]int)
+	quantities := make([]int, len(lines))
+	for i, line := range lines {
+		original, ok := sold[line.TransactionItemID]
+		if !ok {
+			return nil, fmt.Errorf("item line %d is not part of transaction #%d", line.TransactionItemID, originalTxnID)
+		}
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("invalid refund quantity %s", inventory.FormatQuantity(line.Quantity))
+		}
+		factor := original.item.UnitFactor
+		if quantities[i], err = inventory.ToBaseQuantity(line.Quantity, factor); err != nil {
+			return nil, err
+		}
+		requested[line.TransactionItemID] += quantities[i]
+		if refundable := original.quantity - original.refunded; requested[line.TransactionItemID] > refundable {
+			return nil, fmt.Errorf("cannot refund %s of item line %d: only %s refundable", inventory.FormatQuantity(float64(requested[line.TransactionItemID])/float64(factor)),
+				line.TransactionItemID, inventory.FormatQuantity(float64(refundable)/float64(factor)))
+		}
+		if total := original.item.LineTotal; total != nil {
+			// Refunding a labelled line pays back its share of the label
+			// total, worked out on everything refunded so far so that
+			// refunding it all returns the total exactly
+			after := original.refunded + requested[line.TransactionItemID]
+			totalAmount += total.Share(after, original.quantity) - total.Share(after-quantities[i], original.quantity)
+		} else {
+			totalAmount += original.item.Price.MulFloat(line.Quantity)
+		}
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(
+		"INSERT INTO refunds (transaction_id, user_id, total_amount, created_at) VALUES (?, ?, ?, ?)",
+		originalTxnID, userID, totalAmount, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	refundID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	// Record refund lines and put the stock back
+	refundRef := int(refundID)
+	change := inventory.StockChange{Reason: models.MovementReturn, ReferenceID: &refundRef, UserID: userID}
+	for i, line := range lines {
+		original := sold[line.TransactionItemID].item
+		_, err := tx.Exec(
+			"INSERT INTO refund_items (refund_id, transaction_item_id, item_id, quantity, price) VALUES (?, ?, ?, ?, ?)",
+			refundID, original.ID, original.ItemID, quantities[i], original.Price,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := inventory.ReturnStockTx(tx, original.ItemID, original.BatchID, quantities[i], original.Cost, original.CostFactor, change); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetRefundByID(db, int(refundID))
+}
+
+func GetRefundByID(db Database, id int) (*models.Refund, error) {
+	var refund models.Refund
+	var createdAt time.Time
+
+	err := db.GetDB().QueryRow(
+		"SELECT id, transaction_id, user_id, total_amount, created_at FROM refunds WHERE id = ?",
+		id,
+	).Scan(&refund.ID, &refund.TransactionID, &refund.UserID, &refund.TotalAmount, &createdAt)
+
+	if err == sql.ErrNoRows {
+		return nil, errors.New("refund not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refund.CreatedAt = createdAt
+
+	items, err := getRefundItems(db, refund.ID)
+	if err != nil {
+		return nil, err
+	}
+	refund.Items = items
+
+	return &refund, nil
+}
+
+// GetRefundsForTransaction returns every refund recorded against a
+// transaction, oldest first
+func GetRefundsForTransaction(db Database, transactionID int) ([]models.Refund, error) {
+	rows, err := db.GetDB().Query(
+		"SELECT id, transaction_id, user_id, total_amount, created_at FROM refunds WHERE transaction_id = ? ORDER BY created_at ASC",
+		transactionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var refunds []models.Refund
+	for rows.Next() {
+		var refund models.Refund
+		var createdAt time.Time
+
+		err := rows.Scan(&refund.ID, &refund.TransactionID, &refund.UserID, &refund.TotalAmount, &createdAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		refund.CreatedAt = createdAt
+		refunds = append(refunds, refund)
+	}
+	rows.Close()
+
+	for i := range refunds {
+		items, err := getRefundItems(db, refunds[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		refunds[i].Items = items
+	}
+
+	return refunds, nil
+}
+
+func getRefundItems(db Database, refundID int) ([]models.RefundItem, error) {
+	rows, err := db.GetDB().Query(
+		`SELECT ri.id, ri.refund_id, ri.transaction_item_id, ri.item_id, ri.quantity * 1.0 / COALESCE(ti.unit_factor, 1), ri.price,
+			COALESCE(ti.item_name, i.name, 'Item #' || ri.item_id)
+		 FROM refund_items ri
+		 LEFT JOIN transaction_items ti ON ri.transaction_item_id = ti.id
+		 LEFT JOIN items i ON ri.item_id = i.id
+		 WHERE ri.refund_id = ?`,
+		refundID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.RefundItem
+	for rows.Next() {
+		var item models.RefundItem
+		err := rows.Scan(&item.ID, &item.RefundID, &item.TransactionItemID, &item.ItemID, &item.Quantity, &item.Price, &item.ItemName)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
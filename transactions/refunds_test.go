@@ -0,0 +1,207 @@
+package transactions
+
+import (
+	"testing"
+
+	"ims-go/models"
+	"ims-go/money"
+)
+
+func createTestSale(t *testing.T, mockDB *MockDB) *models.Transaction {
+	items := []models.TransactionItem{
+		{ItemID: 1, ItemName: "Apple", Quantity: 4, Price: money.MustParse("1.50")},
+		{ItemID: 2, ItemName: "Banana", Quantity: 2, Price: money.MustParse("0.75")},
+	}
+
+	transaction, err := CreateTransaction(mockDB, 1, items)
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+	return transaction
+}
+
+func TestCreateRefund_Partial(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	refund, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 3},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+
+	if refund.TotalAmount != money.MustParse("4.50") {
+		t.Errorf("Expected refund total 4.50, got %s", refund.TotalAmount)
+	}
+	if len(refund.Items) != 1 || refund.Items[0].ItemName != "Apple" {
+		t.Errorf("Unexpected refund items: %+v", refund.Items)
+	}
+
+	var quantity int
+	if err := mockDB.db.QueryRow("SELECT quantity FROM items WHERE id = 1").Scan(&quantity); err != nil {
+		t.Fatalf("Failed to get item quantity: %v", err)
+	}
+	if quantity != 99 {
+		t.Errorf("Expected quantity 99 after restock, got %d", quantity)
+	}
+
+	// Returned stock goes back into the batch it was sold from
+	if got := batchQuantity(t, mockDB, *sale.Items[0].BatchID); got != 99 {
+		t.Errorf("Expected 99 in the original batch after refund, got %d", got)
+	}
+	var batches int
+	if err := mockDB.db.QueryRow("SELECT COUNT(*) FROM item_stock WHERE item_id = 1").Scan(&batches); err != nil {
+		t.Fatalf("Failed to count stock batches: %v", err)
+	}
+	if batches != 1 {
+		t.Errorf("Expected no new stock batch, got %d batches", batches)
+	}
+}
+
+func TestCreateRefund_NewBatchWhenOriginalGone(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+	if _, err := mockDB.db.Exec("DELETE FROM item_stock WHERE id = ?", *sale.Items[0].BatchID); err != nil {
+		t.Fatalf("Failed to delete batch: %v", err)
+	}
+
+	_, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 2},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+
+	var batches int
+	if err := mockDB.db.QueryRow("SELECT COUNT(*) FROM item_stock WHERE item_id = 1 AND quantity = 2").Scan(&batches); err != nil {
+		t.Fatalf("Failed to count stock batches: %v", err)
+	}
+	if batches != 1 {
+		t.Errorf("Expected a new stock batch for the returned items, got %d", batches)
+	}
+}
+
+func TestCreateRefund_NetsOutOfTransaction(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	_, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[1].ID, Quantity: 2},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+
+	found, err := GetTransactionByID(mockDB, sale.ID)
+	if err != nil {
+		t.Fatalf("GetTransactionByID failed: %v", err)
+	}
+
+	if found.RefundedAmount != money.MustParse("1.50") {
+		t.Errorf("Expected refunded amount 1.50, got %s", found.RefundedAmount)
+	}
+	if found.NetAmount() != money.MustParse("6.00") {
+		t.Errorf("Expected net amount 6.00, got %s", found.NetAmount())
+	}
+	if found.Items[1].RefundedQuantity != 2 {
+		t.Errorf("Expected refunded quantity 2, got %v", found.Items[1].RefundedQuantity)
+	}
+}
+
+func TestCreateRefund_RejectsMoreThanSold(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	_, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 3},
+	})
+	if err != nil {
+		t.Fatalf("First CreateRefund failed: %v", err)
+	}
+
+	_, err = CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 2},
+	})
+	if err == nil {
+		t.Error("CreateRefund should fail when refunding more than was sold")
+	}
+
+	refunds, err := GetRefundsForTransaction(mockDB, sale.ID)
+	if err != nil {
+		t.Fatalf("GetRefundsForTransaction failed: %v", err)
+	}
+	if len(refunds) != 1 {
+		t.Errorf("Expected 1 refund, got %d", len(refunds))
+	}
+}
+
+func TestCreateRefund_RejectsLineFromOtherTransaction(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	first := createTestSale(t, mockDB)
+	second := createTestSale(t, mockDB)
+
+	_, err := CreateRefund(mockDB, second.ID, 1, []models.RefundItem{
+		{TransactionItemID: first.Items[0].ID, Quantity: 1},
+	})
+	if err == nil {
+		t.Error("CreateRefund should fail for a line that belongs to another transaction")
+	}
+}
+
+func TestSaleAndRefund_RecordStockMovements(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+	refund, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 1},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+
+	rows, err := mockDB.db.Query("SELECT item_id, batch_id, delta, reason, reference_id, user_id FROM stock_movements ORDER BY id")
+	if err != nil {
+		t.Fatalf("Failed to query movements: %v", err)
+	}
+	defer rows.Close()
+
+	type movement struct {
+		itemID, batchID, delta int
+		reason                 string
+		referenceID, userID    int
+	}
+	var got []movement
+	for rows.Next() {
+		var m movement
+		if err := rows.Scan(&m.itemID, &m.batchID, &m.delta, &m.reason, &m.referenceID, &m.userID); err != nil {
+			t.Fatalf("Failed to scan movement: %v", err)
+		}
+		got = append(got, m)
+	}
+
+	want := []movement{
+		{1, *sale.Items[0].BatchID, -4, models.MovementSale, sale.ID, 1},
+		{2, *sale.Items[1].BatchID, -2, models.MovementSale, sale.ID, 1},
+		{1, *sale.Items[0].BatchID, 1, models.MovementReturn, refund.ID, 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d movements, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Movement %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
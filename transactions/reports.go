@@ -0,0 +1,167 @@
+package transactions
+
+import (
+	"ims-go/categories"
+	"ims-go/models"
+	"ims-go/money"
+)
+
+// ItemSales totals what has been sold of one item, net of refunds. Amounts
+// use the price and cost fixed on each sale, so later price or cost changes
+// don't rewrite past margins.
+type ItemSales struct {
+	ItemID       int
+	ItemName     string
+	QuantitySold float64 // in the unit the item was sold in
+	Revenue      money.Money
+	// Cost is the cost of goods sold
+	Cost money.Money
+}
+
+// Profit is revenue less the cost of goods sold
+func (s ItemSales) Profit() money.Money {
+	return s.Revenue - s.Cost
+}
+
+// GetSalesByItem returns sales totals for every item sold, highest revenue
+// first, including items that have since been deleted. Voided sales are left
+// out and refunded quantities are taken off.
+func GetSalesByItem(db Database) ([]ItemSales, error) {
+	return querySales(db, "ti.item_id")
+}
+
+// GetSalesByProduct is GetSalesByItem with the sales of each variant rolled
+// up into its parent product
+func GetSalesByProduct(db Database) ([]ItemSales, error) {
+	return querySales(db, "COALESCE(i.parent_id, ti.item_id)")
+}
+
+// querySales totals sales grouped by key, an expression over the sold
+// transaction item ti and its current item i giving the item to report under
+func querySales(db Database, key string) ([]ItemSales, error) {
+	rows, err := db.GetDB().Query(
+		`SELECT `+key+`, COALESCE(p.name, MAX(ti.item_name), 'Item #' || `+key+`),
+			SUM((ti.quantity - COALESCE(r.quantity, 0)) * 1.0 / ti.unit_factor) AS quantity_sold,
+			CAST(ROUND(SUM(CASE WHEN ti.line_total IS NULL
+				THEN ti.price * (ti.quantity - COALESCE(r.quantity, 0)) * 1.0 / ti.unit_factor
+				ELSE ti.line_total * (ti.quantity - COALESCE(r.quantity, 0)) * 1.0 / ti.quantity END)) AS INTEGER) AS revenue,
+			CAST(ROUND(SUM(COALESCE(ti.cost, 0) * (ti.quantity - COALESCE(r.quantity, 0)) * 1.0 / ti.cost_factor)) AS INTEGER) AS cost
+		 FROM transaction_items ti
+		 LEFT JOIN items i ON ti.item_id = i.id
+		 LEFT JOIN items p ON p.id = `+key+`
+		 JOIN transactions t ON ti.transaction_id = t.id
+		 LEFT JOIN (
+			SELECT transaction_item_id, SUM(quantity) AS quantity
+			FROM refund_items
+			GROUP BY transaction_item_id
+		 ) r ON r.transaction_item_id = ti.id
+		 WHERE t.status != ?
+		 GROUP BY `+key+`
+		 ORDER BY revenue DESC, `+key,
+		models.TransactionVoided,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sales []ItemSales
+	for rows.Next() {
+		var s ItemSales
+		if err := rows.Scan(&s.ItemID, &s.ItemName, &s.QuantitySold, &s.Revenue, &s.Cost); err != nil {
+			return nil, err
+		}
+		sales = append(sales, s)
+	}
+
+	return sales, rows.Err()
+}
+
+// CategorySales totals sales for a category, including everything sold from
+// its subcategories. CategoryID is nil for uncategorised items.
+type CategorySales struct {
+	CategoryID   *int
+	Path         string
+	QuantitySold float64
+	Revenue      money.Money
+	Cost         money.Money
+}
+
+// Profit is revenue less the cost of goods sold
+func (s CategorySales) Profit() money.Money {
+	return s.Revenue - s.Cost
+}
+
+// GetSalesByCategory returns sales totals for each category with sales, in
+// tree order, followed by uncategorised items. Items are counted under the
+// category they are in now, and totals roll up into parent categories.
+func GetSalesByCategory(db Database) ([]CategorySales, error) {
+	sales, err := GetSalesByItem(db)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := categories.GetCategories(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.GetDB().Query("SELECT id, category_id FROM items WHERE category_id IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	itemCategory := make(map[int]int)
+	for rows.Next() {
+		var itemID, categoryID int
+		if err := rows.Scan(&itemID, &categoryID); err != nil {
+			return nil, err
+		}
+		itemCategory[itemID] = categoryID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	parents := make(map[int]*int, len(tree))
+	totals := make(map[int]*CategorySales, len(tree))
+	for _, c := range tree {
+		id := c.ID
+		parents[id] = c.ParentID
+		totals[id] = &CategorySales{CategoryID: &id, Path: c.Path}
+	}
+	uncategorised := CategorySales{Path: "Uncategorised"}
+
+	for _, s := range sales {
+		categoryID, ok := itemCategory[s.ItemID]
+		if _, known := totals[categoryID]; !ok || !known {
+			uncategorised.add(s)
+			continue
+		}
+		// Bounded by the number of categories in case the tree is ever corrupted
+		for id, steps := &categoryID, 0; id != nil && steps < len(tree); steps++ {
+			total, ok := totals[*id]
+			if !ok {
+				break
+			}
+			total.add(s)
+			id = parents[*id]
+		}
+	}
+
+	var result []CategorySales
+	for _, c := range tree {
+		if total := totals[c.ID]; total.QuantitySold != 0 || total.Revenue != 0 {
+			result = append(result, *total)
+		}
+	}
+	if uncategorised.QuantitySold != 0 || uncategorised.Revenue != 0 {
+		result = append(result, uncategorised)
+	}
+	return result, nil
+}
+
+func (s *CategorySales) add(item ItemSales) {
+	s.QuantitySold += item.QuantitySold
+	s.Revenue += item.Revenue
+	s.Cost += item.Cost
+}
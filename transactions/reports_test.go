@@ -0,0 +1,174 @@
+package transactions
+
+import (
+	"testing"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/money"
+)
+
+func TestGetSalesByItem(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// 4 apples at 1.50 costing 1.00, 2 bananas at 0.75 costing 0.50
+	sale := createTestSale(t, mockDB)
+	if _, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{{TransactionItemID: sale.Items[0].ID, Quantity: 1}}); err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+
+	voided := createTestSale(t, mockDB)
+	if err := VoidTransaction(mockDB, voided.ID, 2, "Rang up twice"); err != nil {
+		t.Fatalf("VoidTransaction failed: %v", err)
+	}
+
+	// Changing prices and costs later leaves past margins alone
+	if _, err := mockDB.db.Exec("UPDATE items SET price = price * 2, cost = cost * 3"); err != nil {
+		t.Fatalf("Failed to update items: %v", err)
+	}
+
+	sales, err := GetSalesByItem(mockDB)
+	if err != nil {
+		t.Fatalf("GetSalesByItem failed: %v", err)
+	}
+	if len(sales) != 2 {
+		t.Fatalf("Expected 2 items sold, got %d", len(sales))
+	}
+
+	apple := sales[0]
+	if apple.ItemName != "Apple" || apple.QuantitySold != 3 || apple.Revenue != money.MustParse("4.50") || apple.Profit() != money.MustParse("1.50") {
+		t.Errorf("Unexpected apple sales: %+v, profit %s", apple, apple.Profit())
+	}
+	banana := sales[1]
+	if banana.QuantitySold != 2 || banana.Revenue != money.MustParse("1.50") || banana.Cost != money.MustParse("1.00") {
+		t.Errorf("Unexpected banana sales: %+v", banana)
+	}
+}
+
+func TestGetSalesByItem_CostPerCase(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Cans bought at 10.00 a case of 24 cost 0.41666 each
+	item, err := inventory.CreateItem(mockDB, "Cola", "COLA", "", money.MustParse("1.00"), 0, 0, 2)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	batch := addBatch(t, mockDB, item.ID, 24, "")
+	if _, err := mockDB.db.Exec("UPDATE item_stock SET unit_cost = 1000, cost_factor = 24 WHERE id = ?", batch); err != nil {
+		t.Fatalf("Failed to set batch cost: %v", err)
+	}
+
+	// Three sales of one can cost 1.25 between them, not 3 x 0.42
+	for i := 0; i < 3; i++ {
+		if _, err := CreateTransaction(mockDB, 1, []models.TransactionItem{{ItemID: item.ID, Quantity: 1, Price: money.MustParse("1.00")}}); err != nil {
+			t.Fatalf("CreateTransaction failed: %v", err)
+		}
+	}
+
+	sales, err := GetSalesByItem(mockDB)
+	if err != nil {
+		t.Fatalf("GetSalesByItem failed: %v", err)
+	}
+	if len(sales) != 1 || sales[0].Cost != money.MustParse("1.25") {
+		t.Errorf("Expected 3 cans to cost 1.25, got %+v", sales)
+	}
+}
+
+func TestGetSalesByCategory(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Apples under Produce > Fruit, bananas left uncategorised
+	setup := []string{
+		"INSERT INTO categories (id, name, parent_id) VALUES (1, 'Produce', NULL), (2, 'Fruit', 1), (3, 'Bakery', NULL)",
+		"UPDATE items SET category_id = 2 WHERE id = 1",
+	}
+	for _, stmt := range setup {
+		if _, err := mockDB.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up categories: %v", err)
+		}
+	}
+	createTestSale(t, mockDB)
+
+	sales, err := GetSalesByCategory(mockDB)
+	if err != nil {
+		t.Fatalf("GetSalesByCategory failed: %v", err)
+	}
+	if len(sales) != 3 {
+		t.Fatalf("Expected Produce, Fruit and Uncategorised, got %+v", sales)
+	}
+
+	expected := []struct {
+		path     string
+		quantity float64
+		revenue  string
+		profit   string
+	}{
+		{"Produce", 4, "6.00", "2.00"},
+		{"Produce > Fruit", 4, "6.00", "2.00"},
+		{"Uncategorised", 2, "1.50", "0.50"},
+	}
+	for i, want := range expected {
+		got := sales[i]
+		if got.Path != want.path || got.QuantitySold != want.quantity || got.Revenue != money.MustParse(want.revenue) || got.Profit() != money.MustParse(want.profit) {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+	if sales[2].CategoryID != nil {
+		t.Errorf("Expected no category ID for uncategorised sales, got %d", *sales[2].CategoryID)
+	}
+}
+
+func TestGetSalesByProduct(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	shirt, err := inventory.CreateItem(mockDB, "Shirt", "SH", "", money.MustParse("10.00"), money.MustParse("4.00"), 0, 2)
+	if err != nil {
+		t.Fatalf("CreateItem failed: %v", err)
+	}
+	small, err := inventory.CreateVariant(mockDB, shirt.ID, "S", "SH-S", nil, money.MustParse("4.00"), 5, 2)
+	if err != nil {
+		t.Fatalf("CreateVariant failed: %v", err)
+	}
+	large, err := inventory.CreateVariant(mockDB, shirt.ID, "L", "SH-L", nil, money.MustParse("5.00"), 5, 2)
+	if err != nil {
+		t.Fatalf("CreateVariant failed: %v", err)
+	}
+
+	// A product with variants is sold through them
+	if _, err := CreateTransaction(mockDB, 1, []models.TransactionItem{{ItemID: shirt.ID, Quantity: 1, Price: shirt.Price}}); err == nil {
+		t.Error("Expected error selling a product that comes in variants")
+	}
+
+	_, err = CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: small.ID, Quantity: 2, Price: small.Price},
+		{ItemID: large.ID, Quantity: 1, Price: large.Price},
+		{ItemID: 1, Quantity: 1, Price: money.MustParse("1.50")},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+
+	byItem, err := GetSalesByItem(mockDB)
+	if err != nil {
+		t.Fatalf("GetSalesByItem failed: %v", err)
+	}
+	if len(byItem) != 3 {
+		t.Errorf("Expected each variant listed by item, got %+v", byItem)
+	}
+
+	sales, err := GetSalesByProduct(mockDB)
+	if err != nil {
+		t.Fatalf("GetSalesByProduct failed: %v", err)
+	}
+	if len(sales) != 2 {
+		t.Fatalf("Expected the shirt and the apple, got %+v", sales)
+	}
+	got := sales[0]
+	if got.ItemID != shirt.ID || got.ItemName != "Shirt" || got.QuantitySold != 3 || got.Revenue != money.MustParse("30.00") || got.Cost != money.MustParse("13.00") {
+		t.Errorf("Unexpected shirt sales: %+v", got)
+	}
+}
@@ -2,26 +2,195 @@ package transactions
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"ims-go/inventory"
 	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
 )
 
 type Database interface {
 	GetDB() *sql.DB
 }
 
+// StockShortage describes a transaction line that asks for more of an item
+// than is currently on hand. Requested and Available are in base units; Unit
+// names the item's sale unit and UnitFactor is the base units in it.
+type StockShortage struct {
+	ItemID     int
+	ItemName   string
+	BatchID    *int // set when a specific batch was asked for
+	Requested  int
+	Available  int
+	Unit       string
+	UnitFactor int
+}
+
+// SaleQuantity converts a quantity in base units to the item's sale unit
+func (s StockShortage) SaleQuantity(base int) float64 {
+	if s.UnitFactor <= 1 {
+		return float64(base)
+	}
+	return float64(base) / float64(s.UnitFactor)
+}
+
+// ErrInsufficientStock is returned by CreateTransaction when one or more items
+// cannot be fulfilled from stock. Nothing from the sale is recorded.
+type ErrInsufficientStock struct {
+	Items []StockShortage
+}
+
+func (e *ErrInsufficientStock) Error() string {
+	parts := make([]string, 0, len(e.Items))
+	for _, s := range e.Items {
+		parts = append(parts, fmt.Sprintf("%s (requested %s %s, available %s %s)", s.ItemName,
+			inventory.FormatQuantity(s.SaleQuantity(s.Requested)), s.Unit,
+			inventory.FormatQuantity(s.SaleQuantity(s.Available)), s.Unit))
+	}
+	return "insufficient stock: " + strings.Join(parts, ", ")
+}
+
+// CreateTransaction records a sale and deducts the sold quantities from
+// inventory. The whole sale is written in a single database transaction, so
+// either every line is recorded or none of them are.
+//
+// Lines with a BatchID are taken from that stock batch. Other lines are taken
+// from the item's batches first-expiry-first-out, then first-in-first-out.
+// A line that draws on several batches is stored as one transaction item per
+// batch, each recording the batch it came from. The user needs the
+// txn.create permission.
 func CreateTransaction(db Database, userID int, items []models.TransactionItem) (*models.Transaction, error) {
-	// Calculate total
-	var totalAmount float64
-	for _, item := range items {
-		totalAmount += item.Price * float64(item.Quantity)
+	if len(items) == 0 {
+		return nil, errors.New("transaction has no items")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermTxnCreate); err != nil {
+		return nil, err
+	}
+
+	// Calculate total and the quantity requested per item and per chosen
+	// batch. Lines are in each item's sale unit and stock in its base unit.
+	var totalAmount money.Money
+	requested := make(map[int]int)
+	requestedBatch := make(map[int]int)
+	var itemOrder, batchOrder []int
+	batchItem := make(map[int]int)
+	units := make(map[int]saleUnit)
+	lines := make([]saleLine, len(items))
+	for i, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("invalid quantity %s for %s", inventory.FormatQuantity(item.Quantity), item.ItemName)
+		}
+		unit, ok := units[item.ItemID]
+		if !ok {
+			if unit, err = saleUnitTx(tx, item.ItemID); err != nil {
+				return nil, err
+			}
+			units[item.ItemID] = unit
+		}
+		quantity, err := inventory.ToBaseQuantity(item.Quantity, unit.factor)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.ItemName, err)
+		}
+		lines[i] = saleLine{item: item, unit: unit, quantity: quantity}
+
+		totalAmount += item.Total()
+		if _, ok := requested[item.ItemID]; !ok {
+			itemOrder = append(itemOrder, item.ItemID)
+		}
+		requested[item.ItemID] += quantity
+		if item.BatchID != nil {
+			if _, ok := requestedBatch[*item.BatchID]; !ok {
+				batchOrder = append(batchOrder, *item.BatchID)
+			}
+			requestedBatch[*item.BatchID] += quantity
+			batchItem[*item.BatchID] = item.ItemID
+		}
 	}
 
+	// Check stock for every item and chosen batch before writing anything
+	var shortages []StockShortage
+	names := make(map[int]string)
+	unitNames := make(map[int]string)
+	for _, itemID := range itemOrder {
+		var name, unitName string
+		var available, variants int
+		var archivedAt sql.NullTime
+		err := tx.QueryRow(
+			`SELECT name, COALESCE((SELECT SUM(quantity) FROM item_stock WHERE item_id = items.id AND quantity > 0), 0), archived_at,
+				(SELECT COUNT(*) FROM items v WHERE v.parent_id = items.id AND v.archived_at IS NULL),
+				COALESCE(NULLIF(sale_unit, ''), base_unit)
+			 FROM items WHERE id = ?`,
+			itemID,
+		).Scan(&name, &available, &archivedAt, &variants, &unitName)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("item %d not found", itemID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if archivedAt.Valid {
+			return nil, fmt.Errorf("%s is archived and can't be sold", name)
+		}
+		if variants > 0 {
+			return nil, fmt.Errorf("%s comes in variants; sell one of them instead", name)
+		}
+		names[itemID] = name
+		unitNames[itemID] = unitName
+		if requested[itemID] > available {
+			shortages = append(shortages, StockShortage{
+				ItemID:     itemID,
+				ItemName:   name,
+				Requested:  requested[itemID],
+				Available:  available,
+				Unit:       unitName,
+				UnitFactor: units[itemID].factor,
+			})
+		}
+	}
+	for _, batchID := range batchOrder {
+		itemID := batchItem[batchID]
+		var available int
+		err := tx.QueryRow("SELECT quantity FROM item_stock WHERE id = ? AND item_id = ?", batchID, itemID).Scan(&available)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("stock batch %d not found for %s", batchID, names[itemID])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if requestedBatch[batchID] > available {
+			id := batchID
+			shortages = append(shortages, StockShortage{
+				ItemID:     itemID,
+				ItemName:   names[itemID],
+				BatchID:    &id,
+				Requested:  requestedBatch[batchID],
+				Available:  available,
+				Unit:       unitNames[itemID],
+				UnitFactor: units[itemID].factor,
+			})
+		}
+	}
+	if len(shortages) > 0 {
+		return nil, &ErrInsufficientStock{Items: shortages}
+	}
+
+	now := time.Now()
+
 	// Create transaction
-	result, err := db.GetDB().Exec(
+	result, err := tx.Exec(
 		"INSERT INTO transactions (user_id, total_amount, created_at) VALUES (?, ?, ?)",
-		userID, totalAmount, time.Now(),
+		userID, totalAmount, now,
 	)
 	if err != nil {
 		return nil, err
@@ -32,45 +201,156 @@ func CreateTransaction(db Database, userID int, items []models.TransactionItem)
 		return nil, err
 	}
 
-	// Create transaction items and update inventory
-	for _, item := range items {
-		_, err := db.GetDB().Exec(
-			"INSERT INTO transaction_items (transaction_id, item_id, quantity, price) VALUES (?, ?, ?, ?)",
-			transactionID, item.ItemID, item.Quantity, item.Price,
-		)
-		if err != nil {
-			return nil, err
+	// Chosen batches are taken first so automatic picking can't use them up
+	ordered := make([]saleLine, 0, len(lines))
+	for _, line := range lines {
+		if line.item.BatchID != nil {
+			ordered = append(ordered, line)
 		}
-
-		// Update item quantity
-		var currentQuantity int
-		err = db.GetDB().QueryRow("SELECT quantity FROM items WHERE id = ?", item.ItemID).Scan(&currentQuantity)
-		if err != nil {
-			return nil, err
+	}
+	for _, line := range lines {
+		if line.item.BatchID == nil {
+			ordered = append(ordered, line)
 		}
+	}
 
-		newQuantity := currentQuantity - item.Quantity
-		if newQuantity < 0 {
-			newQuantity = 0
+	// Average costs are taken before any stock leaves, so every line of an
+	// item costs the same
+	type averageCost struct {
+		cost   money.Money
+		factor int
+	}
+	averageCosts := make(map[int]averageCost)
+	costing, err := inventory.CostingMethodTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	if costing == inventory.CostingAverage {
+		for _, line := range ordered {
+			if _, ok := averageCosts[line.item.ItemID]; ok {
+				continue
+			}
+			cost, factor, err := inventory.AverageCostTx(tx, line.item.ItemID)
+			if err != nil {
+				return nil, err
+			}
+			averageCosts[line.item.ItemID] = averageCost{cost, factor}
 		}
+	}
 
-		_, err = db.GetDB().Exec("UPDATE items SET quantity = ?, updated_at = ? WHERE id = ?", newQuantity, time.Now(), item.ItemID)
+	// Take the stock and record a transaction item per batch used, costed
+	// at the batch cost or the average cost
+	saleID := int(transactionID)
+	change := inventory.StockChange{Reason: models.MovementSale, ReferenceID: &saleID, UserID: userID}
+	for _, line := range ordered {
+		item := line.item
+		allocations, err := inventory.DepleteStockTx(tx, item.ItemID, item.BatchID, line.quantity, change)
 		if err != nil {
 			return nil, err
 		}
+
+		taken := 0
+		for _, a := range allocations {
+			cost, ok := averageCosts[item.ItemID]
+			if !ok {
+				cost = averageCost{a.UnitCost, a.CostFactor}
+			}
+			// A label total is split across the batches used so the parts
+			// add back up to it exactly
+			var lineTotal *money.Money
+			if item.LineTotal != nil {
+				part := item.LineTotal.Share(taken+a.Quantity, line.quantity) - item.LineTotal.Share(taken, line.quantity)
+				lineTotal = &part
+			}
+			taken += a.Quantity
+			// The item's name and code are copied so renaming or deleting
+			// the item later leaves the sale as it was
+			_, err := tx.Exec(
+				`INSERT INTO transaction_items (transaction_id, item_id, item_name, item_code, quantity, price, line_total, cost, cost_factor, batch_id, unit, unit_factor)
+				 SELECT ?, id, name, code, ?, ?, ?, ?, ?, ?, ?, ? FROM items WHERE id = ?`,
+				transactionID, a.Quantity, item.Price, lineTotal, cost.cost, cost.factor, a.BatchID, line.unit.name, line.unit.factor, item.ItemID,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
 	return GetTransactionByID(db, int(transactionID))
 }
 
+// saleUnit is the unit an item is sold in and the base units it holds
+type saleUnit struct {
+	name   string
+	factor int
+}
+
+// saleLine is a requested line with its quantity in base units
+type saleLine struct {
+	item     models.TransactionItem
+	unit     saleUnit
+	quantity int
+}
+
+// saleUnitTx looks up the unit an item is sold in. Unknown items are left
+// for the stock check to report.
+func saleUnitTx(tx *sql.Tx, itemID int) (saleUnit, error) {
+	unit := saleUnit{factor: 1}
+	err := tx.QueryRow(
+		"SELECT sale_unit, COALESCE((SELECT factor FROM item_units u WHERE u.item_id = items.id AND u.name = items.sale_unit), 1) FROM items WHERE id = ?",
+		itemID,
+	).Scan(&unit.name, &unit.factor)
+	if err == sql.ErrNoRows {
+		return unit, nil
+	}
+	return unit, err
+}
+
+// transactionItemsQuery selects a transaction's items in the order
+// scanTransactionItem expects. Quantities are stored in base units and read
+// back in the unit the item was sold in.
+const transactionItemsQuery = `SELECT ti.id, ti.transaction_id, ti.item_id, ti.quantity * 1.0 / ti.unit_factor, ti.price, COALESCE(ti.cost, 0), ti.cost_factor, ti.batch_id,
+	COALESCE(ti.item_name, i.name, 'Item #' || ti.item_id), COALESCE(ti.item_code, i.code, ''),
+	COALESCE((SELECT SUM(ri.quantity) FROM refund_items ri WHERE ri.transaction_item_id = ti.id), 0) * 1.0 / ti.unit_factor,
+	ti.unit, ti.unit_factor, ti.line_total
+ FROM transaction_items ti
+ LEFT JOIN items i ON ti.item_id = i.id
+ WHERE ti.transaction_id = ?
+ ORDER BY ti.id`
+
+func scanTransactionItem(rows *sql.Rows) (models.TransactionItem, error) {
+	var item models.TransactionItem
+	var batchID, lineTotal sql.NullInt64
+	err := rows.Scan(&item.ID, &item.TransactionID, &item.ItemID, &item.Quantity, &item.Price, &item.Cost, &item.CostFactor, &batchID, &item.ItemName, &item.ItemCode, &item.RefundedQuantity,
+		&item.Unit, &item.UnitFactor, &lineTotal)
+	if batchID.Valid {
+		id := int(batchID.Int64)
+		item.BatchID = &id
+	}
+	if lineTotal.Valid {
+		total := money.FromCents(lineTotal.Int64)
+		item.LineTotal = &total
+	}
+	return item, err
+}
+
 func GetTransactionByID(db Database, id int) (*models.Transaction, error) {
 	var transaction models.Transaction
 	var createdAt time.Time
+	var voidedBy sql.NullInt64
+	var voidReason sql.NullString
+	var voidedAt sql.NullTime
 
 	err := db.GetDB().QueryRow(
-		"SELECT id, user_id, total_amount, created_at FROM transactions WHERE id = ?",
+		`SELECT t.id, t.user_id, t.total_amount, t.status, t.voided_by, t.void_reason, t.voided_at, t.created_at,
+			COALESCE((SELECT SUM(r.total_amount) FROM refunds r WHERE r.transaction_id = t.id), 0)
+		 FROM transactions t WHERE t.id = ?`,
 		id,
-	).Scan(&transaction.ID, &transaction.UserID, &transaction.TotalAmount, &createdAt)
+	).Scan(&transaction.ID, &transaction.UserID, &transaction.TotalAmount, &transaction.Status, &voidedBy, &voidReason, &voidedAt, &createdAt, &transaction.RefundedAmount)
 
 	if err == sql.ErrNoRows {
 		return nil, err
@@ -80,23 +360,17 @@ func GetTransactionByID(db Database, id int) (*models.Transaction, error) {
 	}
 
 	transaction.CreatedAt = createdAt
+	setVoidFields(&transaction, voidedBy, voidReason, voidedAt)
 
 	// Get transaction items
-	rows, err := db.GetDB().Query(
-		`SELECT ti.id, ti.transaction_id, ti.item_id, ti.quantity, ti.price, i.name 
-		 FROM transaction_items ti 
-		 JOIN items i ON ti.item_id = i.id 
-		 WHERE ti.transaction_id = ?`,
-		id,
-	)
+	rows, err := db.GetDB().Query(transactionItemsQuery, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var item models.TransactionItem
-		err := rows.Scan(&item.ID, &item.TransactionID, &item.ItemID, &item.Quantity, &item.Price, &item.ItemName)
+		item, err := scanTransactionItem(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -108,7 +382,9 @@ func GetTransactionByID(db Database, id int) (*models.Transaction, error) {
 
 func GetRecentTransactions(db Database, limit int) ([]models.Transaction, error) {
 	rows, err := db.GetDB().Query(
-		"SELECT id, user_id, total_amount, created_at FROM transactions ORDER BY created_at DESC LIMIT ?",
+		`SELECT t.id, t.user_id, t.total_amount, t.status, t.voided_by, t.void_reason, t.voided_at, t.created_at,
+			COALESCE((SELECT SUM(r.total_amount) FROM refunds r WHERE r.transaction_id = t.id), 0)
+		 FROM transactions t ORDER BY t.created_at DESC LIMIT ?`,
 		limit,
 	)
 	if err != nil {
@@ -120,29 +396,26 @@ func GetRecentTransactions(db Database, limit int) ([]models.Transaction, error)
 	for rows.Next() {
 		var transaction models.Transaction
 		var createdAt time.Time
+		var voidedBy sql.NullInt64
+		var voidReason sql.NullString
+		var voidedAt sql.NullTime
 
-		err := rows.Scan(&transaction.ID, &transaction.UserID, &transaction.TotalAmount, &createdAt)
+		err := rows.Scan(&transaction.ID, &transaction.UserID, &transaction.TotalAmount, &transaction.Status, &voidedBy, &voidReason, &voidedAt, &createdAt, &transaction.RefundedAmount)
 		if err != nil {
 			return nil, err
 		}
 
 		transaction.CreatedAt = createdAt
+		setVoidFields(&transaction, voidedBy, voidReason, voidedAt)
 
 		// Get transaction items
-		itemRows, err := db.GetDB().Query(
-			`SELECT ti.id, ti.transaction_id, ti.item_id, ti.quantity, ti.price, i.name 
-			 FROM transaction_items ti 
-			 JOIN items i ON ti.item_id = i.id 
-			 WHERE ti.transaction_id = ?`,
-			transaction.ID,
-		)
+		itemRows, err := db.GetDB().Query(transactionItemsQuery, transaction.ID)
 		if err != nil {
 			return nil, err
 		}
 
 		for itemRows.Next() {
-			var item models.TransactionItem
-			err := itemRows.Scan(&item.ID, &item.TransactionID, &item.ItemID, &item.Quantity, &item.Price, &item.ItemName)
+			item, err := scanTransactionItem(itemRows)
 			if err != nil {
 				itemRows.Close()
 				return nil, err
@@ -157,3 +430,13 @@ func GetRecentTransactions(db Database, limit int) ([]models.Transaction, error)
 	return transactions, nil
 }
 
+func setVoidFields(transaction *models.Transaction, voidedBy sql.NullInt64, voidReason sql.NullString, voidedAt sql.NullTime) {
+	if voidedBy.Valid {
+		id := int(voidedBy.Int64)
+		transaction.VoidedBy = &id
+	}
+	transaction.VoidReason = voidReason.String
+	if voidedAt.Valid {
+		transaction.VoidedAt = &voidedAt.Time
+	}
+}
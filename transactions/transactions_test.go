@@ -2,9 +2,14 @@ package transactions
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
 	"testing"
 
+	"ims-go/inventory"
 	"ims-go/models"
+	"ims-go/money"
+	"ims-go/roles"
 
 	_ "modernc.org/sqlite"
 )
@@ -28,27 +33,53 @@ func setupTestDB(t *testing.T) *MockDB {
 		username TEXT UNIQUE NOT NULL,
 		password_hash TEXT NOT NULL,
 		is_root_admin INTEGER DEFAULT 0,
-		can_read INTEGER DEFAULT 0,
-		can_transaction INTEGER DEFAULT 0,
-		can_revenue INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		archived_at DATETIME
 	)`)
 	if err != nil {
 		t.Fatalf("Failed to create users table: %v", err)
 	}
 
+	// A cashier role that sells and refunds, and a manager role that can
+	// also void and manage stock
+	roleSetup := []string{
+		`CREATE TABLE roles (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL COLLATE NOCASE, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE role_permissions (role_id INTEGER NOT NULL, permission TEXT NOT NULL, PRIMARY KEY (role_id, permission))`,
+		`CREATE TABLE user_roles (user_id INTEGER NOT NULL, role_id INTEGER NOT NULL, PRIMARY KEY (user_id, role_id))`,
+		`INSERT INTO roles (name) VALUES ('Cashier'), ('Manager')`,
+		`INSERT INTO role_permissions (role_id, permission) VALUES
+			(1, 'txn.create'), (1, 'txn.refund'),
+			(2, 'txn.create'), (2, 'txn.refund'), (2, 'txn.void'), (2, 'item.create'), (2, 'item.edit'), (2, 'stock.adjust')`,
+	}
+	for _, stmt := range roleSetup {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up roles: %v", err)
+		}
+	}
+
 	_, err = db.Exec(`CREATE TABLE items (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL,
 		code TEXT UNIQUE NOT NULL,
 		description TEXT,
-		price REAL NOT NULL,
-		cost REAL NOT NULL DEFAULT 0,
+		price INTEGER NOT NULL,
+		cost INTEGER NOT NULL DEFAULT 0,
 		quantity INTEGER DEFAULT 0,
 		in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
 		expiry_date DATETIME,
+		reorder_point INTEGER,
+		reorder_quantity INTEGER NOT NULL DEFAULT 0,
+		preferred_supplier_id INTEGER,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		archived_at DATETIME,
+		category_id INTEGER,
+		parent_id INTEGER,
+		variant_name TEXT NOT NULL DEFAULT '',
+		price_override BOOLEAN NOT NULL DEFAULT 0,
+		base_unit TEXT NOT NULL DEFAULT 'each',
+		sale_unit TEXT NOT NULL DEFAULT '',
+		purchase_unit TEXT NOT NULL DEFAULT ''
 	)`)
 	if err != nil {
 		t.Fatalf("Failed to create items table: %v", err)
@@ -57,7 +88,11 @@ func setupTestDB(t *testing.T) *MockDB {
 	_, err = db.Exec(`CREATE TABLE transactions (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id INTEGER NOT NULL,
-		total_amount REAL NOT NULL,
+		total_amount INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'completed',
+		voided_by INTEGER,
+		void_reason TEXT,
+		voided_at DATETIME,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	)`)
 	if err != nil {
@@ -68,28 +103,146 @@ func setupTestDB(t *testing.T) *MockDB {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		transaction_id INTEGER NOT NULL,
 		item_id INTEGER NOT NULL,
+		item_name TEXT,
+		item_code TEXT,
 		quantity INTEGER NOT NULL,
-		price REAL NOT NULL
+		price INTEGER NOT NULL,
+		cost INTEGER,
+		cost_factor INTEGER NOT NULL DEFAULT 1,
+		batch_id INTEGER,
+		unit TEXT NOT NULL DEFAULT '',
+		unit_factor INTEGER NOT NULL DEFAULT 1,
+		line_total INTEGER
 	)`)
 	if err != nil {
 		t.Fatalf("Failed to create transaction_items table: %v", err)
 	}
 
-	_, err = db.Exec(`INSERT INTO users (username, password_hash, can_transaction) VALUES ('testuser', 'hash', 1)`)
+	_, err = db.Exec(`CREATE TABLE item_stock (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		quantity INTEGER NOT NULL,
+		in_stock_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expiry_date DATETIME,
+		unit_cost INTEGER,
+		cost_factor INTEGER NOT NULL DEFAULT 1,
+		purchase_order_line_id INTEGER
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create item_stock table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE stock_movements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		batch_id INTEGER,
+		delta INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		reference_id INTEGER,
+		user_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create stock_movements table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE refunds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		transaction_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		total_amount INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create refunds table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE refund_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		refund_id INTEGER NOT NULL,
+		transaction_item_id INTEGER NOT NULL,
+		item_id INTEGER NOT NULL,
+		quantity INTEGER NOT NULL,
+		price INTEGER NOT NULL
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create refund_items table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE item_barcodes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		code TEXT UNIQUE NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		quantity INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create item_barcodes table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE item_units (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		name TEXT NOT NULL COLLATE NOCASE,
+		factor INTEGER NOT NULL,
+		UNIQUE (item_id, name)
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create item_units table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE categories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		parent_id INTEGER,
+		tax_class TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create categories table: %v", err)
+	}
+
+	for _, query := range []string{
+		`CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT NOT NULL, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE setting_changes (id INTEGER PRIMARY KEY AUTOINCREMENT, key TEXT NOT NULL, old_value TEXT, new_value TEXT NOT NULL, changed_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO settings (key, value) VALUES ('costing_method', 'fifo')`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Failed to create settings: %v", err)
+		}
+	}
+
+	_, err = db.Exec(`INSERT INTO users (username, password_hash) VALUES ('testuser', 'hash')`)
 	if err != nil {
 		t.Fatalf("Failed to insert test user: %v", err)
 	}
 
-	_, err = db.Exec(`INSERT INTO items (name, code, price, cost, quantity) VALUES ('Apple', 'APL001', 1.50, 1.00, 100)`)
+	_, err = db.Exec(`INSERT INTO users (username, password_hash) VALUES ('manager', 'hash')`)
+	if err != nil {
+		t.Fatalf("Failed to insert test manager: %v", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO user_roles (user_id, role_id) VALUES (1, 1), (2, 2)`)
+	if err != nil {
+		t.Fatalf("Failed to assign roles: %v", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO items (name, code, price, cost, quantity) VALUES ('Apple', 'APL001', 150, 100, 100)`)
 	if err != nil {
 		t.Fatalf("Failed to insert test item: %v", err)
 	}
 
-	_, err = db.Exec(`INSERT INTO items (name, code, price, cost, quantity) VALUES ('Banana', 'BAN001', 0.75, 0.50, 50)`)
+	_, err = db.Exec(`INSERT INTO items (name, code, price, cost, quantity) VALUES ('Banana', 'BAN001', 75, 50, 50)`)
 	if err != nil {
 		t.Fatalf("Failed to insert test item: %v", err)
 	}
 
+	_, err = db.Exec(`INSERT INTO item_stock (item_id, quantity) VALUES (1, 100), (2, 50)`)
+	if err != nil {
+		t.Fatalf("Failed to insert test stock: %v", err)
+	}
+
 	return &MockDB{db: db}
 }
 
@@ -98,8 +251,8 @@ func TestCreateTransaction(t *testing.T) {
 	defer mockDB.db.Close()
 
 	items := []models.TransactionItem{
-		{ItemID: 1, ItemName: "Apple", Quantity: 2, Price: 1.50},
-		{ItemID: 2, ItemName: "Banana", Quantity: 3, Price: 0.75},
+		{ItemID: 1, ItemName: "Apple", Quantity: 2, Price: money.MustParse("1.50")},
+		{ItemID: 2, ItemName: "Banana", Quantity: 3, Price: money.MustParse("0.75")},
 	}
 
 	transaction, err := CreateTransaction(mockDB, 1, items)
@@ -107,9 +260,9 @@ func TestCreateTransaction(t *testing.T) {
 		t.Fatalf("CreateTransaction failed: %v", err)
 	}
 
-	expectedTotal := 5.25
+	expectedTotal := money.MustParse("5.25")
 	if transaction.TotalAmount != expectedTotal {
-		t.Errorf("Expected total %.2f, got %.2f", expectedTotal, transaction.TotalAmount)
+		t.Errorf("Expected total %s, got %s", expectedTotal, transaction.TotalAmount)
 	}
 
 	if transaction.UserID != 1 {
@@ -117,12 +270,30 @@ func TestCreateTransaction(t *testing.T) {
 	}
 }
 
+func TestCreateTransaction_RequiresPermission(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// A user without a role that sells
+	if _, err := mockDB.db.Exec(`INSERT INTO users (username, password_hash) VALUES ('viewer', 'hash')`); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	items := []models.TransactionItem{{ItemID: 1, ItemName: "Apple", Quantity: 1, Price: money.MustParse("1.50")}}
+	if _, err := CreateTransaction(mockDB, 3, items); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Fatalf("Expected ErrNotPermitted, got %v", err)
+	}
+	if qty, _ := inventory.GetItemQuantity(mockDB, 1); qty != 100 {
+		t.Errorf("Expected stock untouched at 100, got %d", qty)
+	}
+}
+
 func TestCreateTransaction_ReducesStock(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
 	items := []models.TransactionItem{
-		{ItemID: 1, ItemName: "Apple", Quantity: 10, Price: 1.50},
+		{ItemID: 1, ItemName: "Apple", Quantity: 10, Price: money.MustParse("1.50")},
 	}
 
 	_, err := CreateTransaction(mockDB, 1, items)
@@ -146,7 +317,7 @@ func TestGetTransactionByID(t *testing.T) {
 	defer mockDB.db.Close()
 
 	items := []models.TransactionItem{
-		{ItemID: 1, ItemName: "Apple", Quantity: 5, Price: 1.50},
+		{ItemID: 1, ItemName: "Apple", Quantity: 5, Price: money.MustParse("1.50")},
 	}
 
 	created, err := CreateTransaction(mockDB, 1, items)
@@ -167,3 +338,493 @@ func TestGetTransactionByID(t *testing.T) {
 		t.Errorf("Expected 1 item, got %d", len(found.Items))
 	}
 }
+
+func TestGetTransactionByID_KeepsSaleTimeItemDetails(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 1, ItemName: "Apple", Quantity: 2, Price: money.MustParse("1.50")},
+		{ItemID: 2, ItemName: "Banana", Quantity: 1, Price: money.MustParse("0.75")},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+	refund, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{{TransactionItemID: sale.Items[1].ID, Quantity: 1}})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+
+	// Renaming one item and deleting the other doesn't rewrite the sale
+	if _, err := mockDB.db.Exec("UPDATE items SET name = 'Green Apple', code = 'GAP001', cost = 120 WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to rename item: %v", err)
+	}
+	if _, err := mockDB.db.Exec("DELETE FROM items WHERE id = 2"); err != nil {
+		t.Fatalf("Failed to delete item: %v", err)
+	}
+
+	found, err := GetTransactionByID(mockDB, sale.ID)
+	if err != nil {
+		t.Fatalf("GetTransactionByID failed: %v", err)
+	}
+	if len(found.Items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(found.Items))
+	}
+	apple := found.Items[0]
+	if apple.ItemName != "Apple" || apple.ItemCode != "APL001" || apple.Cost != money.MustParse("1.00") {
+		t.Errorf("Expected the sale-time apple, got %+v", apple)
+	}
+	if found.Items[1].ItemName != "Banana" || found.Items[1].ItemCode != "BAN001" {
+		t.Errorf("Expected the deleted banana to keep its details, got %+v", found.Items[1])
+	}
+
+	refunds, err := GetRefundsForTransaction(mockDB, sale.ID)
+	if err != nil {
+		t.Fatalf("GetRefundsForTransaction failed: %v", err)
+	}
+	if len(refunds) != 1 || refunds[0].ID != refund.ID || len(refunds[0].Items) != 1 || refunds[0].Items[0].ItemName != "Banana" {
+		t.Errorf("Expected the refund of the deleted banana, got %+v", refunds)
+	}
+}
+
+func TestCreateTransaction_InsufficientStock(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	items := []models.TransactionItem{
+		{ItemID: 1, ItemName: "Apple", Quantity: 5, Price: money.MustParse("1.50")},
+		{ItemID: 2, ItemName: "Banana", Quantity: 60, Price: money.MustParse("0.75")},
+	}
+
+	_, err := CreateTransaction(mockDB, 1, items)
+	if err == nil {
+		t.Fatal("CreateTransaction should fail when selling more than is in stock")
+	}
+
+	var stockErr *ErrInsufficientStock
+	if !errors.As(err, &stockErr) {
+		t.Fatalf("Expected ErrInsufficientStock, got %v", err)
+	}
+	if len(stockErr.Items) != 1 {
+		t.Fatalf("Expected 1 shortage, got %d", len(stockErr.Items))
+	}
+	if stockErr.Items[0].ItemID != 2 || stockErr.Items[0].Available != 50 || stockErr.Items[0].Requested != 60 {
+		t.Errorf("Unexpected shortage: %+v", stockErr.Items[0])
+	}
+}
+
+func TestCreateTransaction_ArchivedItem(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	if _, err := mockDB.db.Exec("UPDATE items SET archived_at = CURRENT_TIMESTAMP WHERE id = 2"); err != nil {
+		t.Fatalf("Failed to archive item: %v", err)
+	}
+
+	_, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 2, ItemName: "Banana", Quantity: 1, Price: money.MustParse("0.75")},
+	})
+	if err == nil {
+		t.Error("Expected error selling an archived item")
+	}
+}
+
+func TestCreateTransaction_InsufficientStockRecordsNothing(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	items := []models.TransactionItem{
+		{ItemID: 1, ItemName: "Apple", Quantity: 10, Price: money.MustParse("1.50")},
+		{ItemID: 2, ItemName: "Banana", Quantity: 60, Price: money.MustParse("0.75")},
+	}
+
+	if _, err := CreateTransaction(mockDB, 1, items); err == nil {
+		t.Fatal("CreateTransaction should fail when selling more than is in stock")
+	}
+
+	var count int
+	if err := mockDB.db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&count); err != nil {
+		t.Fatalf("Failed to count transactions: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no transactions, got %d", count)
+	}
+
+	if err := mockDB.db.QueryRow("SELECT COUNT(*) FROM transaction_items").Scan(&count); err != nil {
+		t.Fatalf("Failed to count transaction items: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no transaction items, got %d", count)
+	}
+
+	var quantity int
+	if err := mockDB.db.QueryRow("SELECT quantity FROM items WHERE id = 1").Scan(&quantity); err != nil {
+		t.Fatalf("Failed to get item quantity: %v", err)
+	}
+	if quantity != 100 {
+		t.Errorf("Expected quantity 100 to be untouched, got %d", quantity)
+	}
+}
+
+func TestCreateTransaction_DuplicateLinesCountTogether(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	items := []models.TransactionItem{
+		{ItemID: 2, ItemName: "Banana", Quantity: 30, Price: money.MustParse("0.75")},
+		{ItemID: 2, ItemName: "Banana", Quantity: 30, Price: money.MustParse("0.75")},
+	}
+
+	_, err := CreateTransaction(mockDB, 1, items)
+	var stockErr *ErrInsufficientStock
+	if !errors.As(err, &stockErr) {
+		t.Fatalf("Expected ErrInsufficientStock, got %v", err)
+	}
+	if stockErr.Items[0].Requested != 60 {
+		t.Errorf("Expected requested 60, got %d", stockErr.Items[0].Requested)
+	}
+}
+
+func TestCreateTransaction_ExactTotal(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	_, err := mockDB.db.Exec(`INSERT INTO items (name, code, price, cost, quantity) VALUES ('Gum', 'GUM001', 10, 5, 1000)`)
+	if err != nil {
+		t.Fatalf("Failed to insert test item: %v", err)
+	}
+	addBatch(t, mockDB, 3, 1000, "")
+
+	// 0.10 added up 3 times and 0.20 once would drift as float64; as cents it is exact
+	items := []models.TransactionItem{
+		{ItemID: 3, ItemName: "Gum", Quantity: 3, Price: money.MustParse("0.10")},
+		{ItemID: 3, ItemName: "Gum", Quantity: 1, Price: money.MustParse("0.20")},
+		{ItemID: 2, ItemName: "Banana", Quantity: 7, Price: money.MustParse("0.07")},
+	}
+
+	transaction, err := CreateTransaction(mockDB, 1, items)
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+
+	if transaction.TotalAmount != money.MustParse("0.99") {
+		t.Errorf("Expected total exactly 0.99, got %s", transaction.TotalAmount)
+	}
+
+	var stored int64
+	if err := mockDB.db.QueryRow("SELECT total_amount FROM transactions WHERE id = ?", transaction.ID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read stored total: %v", err)
+	}
+	if stored != 99 {
+		t.Errorf("Expected 99 cents stored, got %d", stored)
+	}
+}
+
+// addBatch adds a stock batch for an item and returns its ID. An empty expiry
+// leaves the batch without an expiry date.
+func addBatch(t *testing.T, mockDB *MockDB, itemID, quantity int, expiry string) int {
+	var expiryDate interface{}
+	if expiry != "" {
+		expiryDate = expiry
+	}
+	result, err := mockDB.db.Exec("INSERT INTO item_stock (item_id, quantity, expiry_date) VALUES (?, ?, ?)", itemID, quantity, expiryDate)
+	if err != nil {
+		t.Fatalf("Failed to insert stock batch: %v", err)
+	}
+	_, err = mockDB.db.Exec("UPDATE items SET quantity = quantity + ? WHERE id = ?", quantity, itemID)
+	if err != nil {
+		t.Fatalf("Failed to update item quantity: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// setBatchCost sets what a stock batch cost to buy
+func setBatchCost(t *testing.T, mockDB *MockDB, batchID int, cost string) {
+	if _, err := mockDB.db.Exec("UPDATE item_stock SET unit_cost = ? WHERE id = ?", money.MustParse(cost), batchID); err != nil {
+		t.Fatalf("Failed to set batch cost: %v", err)
+	}
+}
+
+func batchQuantity(t *testing.T, mockDB *MockDB, batchID int) int {
+	var quantity int
+	if err := mockDB.db.QueryRow("SELECT quantity FROM item_stock WHERE id = ?", batchID).Scan(&quantity); err != nil {
+		t.Fatalf("Failed to get batch quantity: %v", err)
+	}
+	return quantity
+}
+
+func TestCreateTransaction_DepletesEarliestExpiryFirst(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Batch 1 (Apple, 100) has no expiry so it goes last
+	later := addBatch(t, mockDB, 1, 5, "2030-06-01")
+	sooner := addBatch(t, mockDB, 1, 5, "2030-01-01")
+
+	sale, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 1, ItemName: "Apple", Quantity: 8, Price: money.MustParse("1.50")},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+
+	if got := batchQuantity(t, mockDB, sooner); got != 0 {
+		t.Errorf("Expected earliest batch emptied, got %d", got)
+	}
+	if got := batchQuantity(t, mockDB, later); got != 2 {
+		t.Errorf("Expected 2 left in later batch, got %d", got)
+	}
+	if got := batchQuantity(t, mockDB, 1); got != 100 {
+		t.Errorf("Expected undated batch untouched, got %d", got)
+	}
+
+	// The line is split per batch and each part records its batch
+	if len(sale.Items) != 2 {
+		t.Fatalf("Expected 2 transaction items, got %d", len(sale.Items))
+	}
+	if sale.Items[0].BatchID == nil || *sale.Items[0].BatchID != sooner || sale.Items[0].Quantity != 5 {
+		t.Errorf("Unexpected first line: %+v", sale.Items[0])
+	}
+	if sale.Items[1].BatchID == nil || *sale.Items[1].BatchID != later || sale.Items[1].Quantity != 3 {
+		t.Errorf("Unexpected second line: %+v", sale.Items[1])
+	}
+	if sale.TotalAmount != money.MustParse("12.00") {
+		t.Errorf("Expected total 12.00, got %s", sale.TotalAmount)
+	}
+
+	var quantity int
+	if err := mockDB.db.QueryRow("SELECT quantity FROM items WHERE id = 1").Scan(&quantity); err != nil {
+		t.Fatalf("Failed to get item quantity: %v", err)
+	}
+	if quantity != 102 {
+		t.Errorf("Expected item quantity 102, got %d", quantity)
+	}
+}
+
+func TestCreateTransaction_UsesSelectedBatch(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	dated := addBatch(t, mockDB, 1, 5, "2030-01-01")
+	selected := 1
+
+	sale, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 1, ItemName: "Apple", Quantity: 4, Price: money.MustParse("1.50"), BatchID: &selected},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+
+	if got := batchQuantity(t, mockDB, selected); got != 96 {
+		t.Errorf("Expected 96 left in selected batch, got %d", got)
+	}
+	if got := batchQuantity(t, mockDB, dated); got != 5 {
+		t.Errorf("Expected other batch untouched, got %d", got)
+	}
+	if len(sale.Items) != 1 || sale.Items[0].BatchID == nil || *sale.Items[0].BatchID != selected {
+		t.Errorf("Expected line to record the selected batch: %+v", sale.Items)
+	}
+}
+
+func TestCreateTransaction_SelectedBatchShortage(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	small := addBatch(t, mockDB, 2, 3, "2030-01-01")
+
+	_, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 2, ItemName: "Banana", Quantity: 5, Price: money.MustParse("0.75"), BatchID: &small},
+	})
+	var stockErr *ErrInsufficientStock
+	if !errors.As(err, &stockErr) {
+		t.Fatalf("Expected ErrInsufficientStock, got %v", err)
+	}
+	if len(stockErr.Items) != 1 || stockErr.Items[0].BatchID == nil || *stockErr.Items[0].BatchID != small || stockErr.Items[0].Available != 3 {
+		t.Errorf("Unexpected shortages: %+v", stockErr.Items)
+	}
+}
+
+func TestCreateTransaction_FIFOCost(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Batch 1 (Apple, 100) has no recorded cost so uses the item cost of 1.00
+	sooner := addBatch(t, mockDB, 1, 2, "2030-01-01")
+	setBatchCost(t, mockDB, sooner, "0.80")
+
+	sale, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 1, ItemName: "Apple", Quantity: 5, Price: money.MustParse("1.50")},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+
+	if len(sale.Items) != 2 {
+		t.Fatalf("Expected 2 transaction items, got %d", len(sale.Items))
+	}
+	if sale.Items[0].Cost != money.MustParse("0.80") || sale.Items[1].Cost != money.MustParse("1.00") {
+		t.Errorf("Expected each line costed at its batch, got %s and %s", sale.Items[0].Cost, sale.Items[1].Cost)
+	}
+}
+
+func TestCreateTransaction_AverageCost(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	if err := inventory.SetCostingMethod(mockDB, inventory.CostingAverage); err != nil {
+		t.Fatalf("SetCostingMethod failed: %v", err)
+	}
+
+	// 50 bananas at 0.50 and 50 at 0.70 average 0.60
+	dated := addBatch(t, mockDB, 2, 50, "2030-01-01")
+	setBatchCost(t, mockDB, dated, "0.70")
+
+	sale, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 2, ItemName: "Banana", Quantity: 60, Price: money.MustParse("0.75")},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+
+	if len(sale.Items) != 2 {
+		t.Fatalf("Expected 2 transaction items, got %d", len(sale.Items))
+	}
+	for _, item := range sale.Items {
+		if cost := item.Cost.Share(1, item.CostFactor); cost != money.MustParse("0.60") {
+			t.Errorf("Expected average cost 0.60, got %s", cost)
+		}
+	}
+}
+
+func TestCreateTransaction_SoldByWeight(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Cheese is stocked in grams and sold by the kilogram at 12.00
+	_, err := mockDB.db.Exec(`INSERT INTO items (name, code, price, cost, quantity, base_unit, sale_unit) VALUES ('Cheese', 'CHS001', 1200, 1, 0, 'g', 'kg')`)
+	if err != nil {
+		t.Fatalf("Failed to insert test item: %v", err)
+	}
+	if _, err := mockDB.db.Exec(`INSERT INTO item_units (item_id, name, factor) VALUES (3, 'kg', 1000)`); err != nil {
+		t.Fatalf("Failed to insert unit: %v", err)
+	}
+	batch := addBatch(t, mockDB, 3, 2000, "")
+
+	sale, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 3, ItemName: "Cheese", Quantity: 0.35, Price: money.MustParse("12.00")},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+	if sale.TotalAmount != money.MustParse("4.20") {
+		t.Errorf("Expected total 4.20, got %s", sale.TotalAmount)
+	}
+	if len(sale.Items) != 1 || sale.Items[0].Quantity != 0.35 || sale.Items[0].Unit != "kg" || sale.Items[0].UnitFactor != 1000 {
+		t.Fatalf("Expected 0.35 kg sold, got %+v", sale.Items)
+	}
+	if got := batchQuantity(t, mockDB, batch); got != 1650 {
+		t.Errorf("Expected 1650 g left, got %d", got)
+	}
+
+	// Stock is whole grams, so a fraction of one can't be sold
+	_, err = CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 3, ItemName: "Cheese", Quantity: 0.3505, Price: money.MustParse("12.00")},
+	})
+	if err == nil {
+		t.Error("Expected error for a fraction of a gram")
+	}
+
+	// A shortage is reported in the sale unit
+	_, err = CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 3, ItemName: "Cheese", Quantity: 2, Price: money.MustParse("12.00")},
+	})
+	var stockErr *ErrInsufficientStock
+	if !errors.As(err, &stockErr) {
+		t.Fatalf("Expected ErrInsufficientStock, got %v", err)
+	}
+	if shortage := stockErr.Items[0]; shortage.Unit != "kg" || shortage.SaleQuantity(shortage.Available) != 1.65 {
+		t.Errorf("Expected 1.65 kg available, got %+v", shortage)
+	}
+	if !strings.Contains(err.Error(), "available 1.65 kg") {
+		t.Errorf("Expected the shortage in kg, got %q", err.Error())
+	}
+
+	refund, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 0.1},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+	if refund.TotalAmount != money.MustParse("1.20") {
+		t.Errorf("Expected refund total 1.20, got %s", refund.TotalAmount)
+	}
+	if got := batchQuantity(t, mockDB, batch); got != 1750 {
+		t.Errorf("Expected 1750 g after the refund, got %d", got)
+	}
+
+	sale, _ = GetTransactionByID(mockDB, sale.ID)
+	if sale.Items[0].RefundedQuantity != 0.1 {
+		t.Errorf("Expected 0.1 kg refunded, got %v", sale.Items[0].RefundedQuantity)
+	}
+}
+
+func TestCreateTransaction_LabelTotal(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	// Cheese is stocked in grams across two batches and sold by the kilogram
+	_, err := mockDB.db.Exec(`INSERT INTO items (name, code, price, cost, quantity, base_unit, sale_unit) VALUES ('Cheese', 'CHS001', 400, 1, 0, 'g', 'kg')`)
+	if err != nil {
+		t.Fatalf("Failed to insert test item: %v", err)
+	}
+	if _, err := mockDB.db.Exec(`INSERT INTO item_units (item_id, name, factor) VALUES (3, 'kg', 1000)`); err != nil {
+		t.Fatalf("Failed to insert unit: %v", err)
+	}
+	addBatch(t, mockDB, 3, 1000, "2030-01-01")
+	addBatch(t, mockDB, 3, 2000, "2030-02-01")
+
+	// A 2.5 kg pack labelled 10.01 is charged 10.01, not 2.5 x 4.00
+	label := money.MustParse("10.01")
+	sale, err := CreateTransaction(mockDB, 1, []models.TransactionItem{
+		{ItemID: 3, ItemName: "Cheese", Quantity: 2.5, Price: money.MustParse("4.00"), LineTotal: &label},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransaction failed: %v", err)
+	}
+	if sale.TotalAmount != label {
+		t.Errorf("Expected total 10.01, got %s", sale.TotalAmount)
+	}
+	if len(sale.Items) != 2 {
+		t.Fatalf("Expected the pack split over 2 batches, got %+v", sale.Items)
+	}
+	if got := sale.Items[0].Total() + sale.Items[1].Total(); got != label {
+		t.Errorf("Expected the batch lines to add up to 10.01, got %s", got)
+	}
+
+	sales, err := GetSalesByItem(mockDB)
+	if err != nil {
+		t.Fatalf("GetSalesByItem failed: %v", err)
+	}
+	if len(sales) != 1 || sales[0].Revenue != label {
+		t.Errorf("Expected revenue 10.01, got %+v", sales)
+	}
+
+	// Refunding the whole pack in two goes gives back exactly the label price
+	first, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[1].ID, Quantity: 0.7},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+	second, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 1},
+		{TransactionItemID: sale.Items[1].ID, Quantity: 0.8},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+	if got := first.TotalAmount + second.TotalAmount; got != label {
+		t.Errorf("Expected refunds to total 10.01, got %s and %s", first.TotalAmount, second.TotalAmount)
+	}
+}
@@ -0,0 +1,112 @@
+package transactions
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"ims-go/inventory"
+	"ims-go/models"
+	"ims-go/roles"
+)
+
+var (
+	ErrVoidNotPermitted = errors.New("user is not permitted to approve voids")
+	ErrAlreadyVoided    = errors.New("transaction is already voided")
+)
+
+// CanApproveVoid reports whether a user is allowed to approve voiding a sale
+func CanApproveVoid(user *models.User) bool {
+	return user.Can(models.PermTxnVoid)
+}
+
+// VoidTransaction cancels a completed sale. The approver must hold the
+// txn.void permission, and a reason is required. Any stock that has not
+// already been refunded is put back into the batch it was sold from, and the
+// sale is kept in the log marked as voided rather than deleted.
+func VoidTransaction(db Database, transactionID, approverID int, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return errors.New("a reason is required to void a transaction")
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Check the approver's permission inside the same transaction
+	if err := roles.RequireTx(tx, approverID, models.PermTxnVoid); errors.Is(err, roles.ErrNotPermitted) {
+		return ErrVoidNotPermitted
+	} else if err != nil {
+		return err
+	}
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM transactions WHERE id = ?", transactionID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return errors.New("transaction not found")
+	}
+	if err != nil {
+		return err
+	}
+	if status == models.TransactionVoided {
+		return ErrAlreadyVoided
+	}
+
+	// Work out how much of each line is still out of stock (sold less refunded)
+	rows, err := tx.Query(
+		`SELECT ti.item_id, ti.batch_id, COALESCE(ti.cost, 0), ti.cost_factor,
+			ti.quantity - COALESCE((SELECT SUM(ri.quantity) FROM refund_items ri WHERE ri.transaction_item_id = ti.id), 0)
+		 FROM transaction_items ti
+		 WHERE ti.transaction_id = ?`,
+		transactionID,
+	)
+	if err != nil {
+		return err
+	}
+	type outstanding struct {
+		item     models.TransactionItem
+		quantity int // in base units
+	}
+	var restock []outstanding
+	for rows.Next() {
+		var line outstanding
+		var batchID sql.NullInt64
+		if err := rows.Scan(&line.item.ItemID, &batchID, &line.item.Cost, &line.item.CostFactor, &line.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		if batchID.Valid {
+			id := int(batchID.Int64)
+			line.item.BatchID = &id
+		}
+		if line.quantity > 0 {
+			restock = append(restock, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	change := inventory.StockChange{Reason: models.MovementVoid, ReferenceID: &transactionID, UserID: approverID}
+	for _, line := range restock {
+		if err := inventory.ReturnStockTx(tx, line.item.ItemID, line.item.BatchID, line.quantity, line.item.Cost, line.item.CostFactor, change); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(
+		"UPDATE transactions SET status = ?, voided_by = ?, void_reason = ?, voided_at = ? WHERE id = ?",
+		models.TransactionVoided, approverID, reason, now, transactionID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
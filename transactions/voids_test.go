@@ -0,0 +1,148 @@
+package transactions
+
+import (
+	"errors"
+	"testing"
+
+	"ims-go/models"
+)
+
+func TestVoidTransaction_RestoresStock(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	err := VoidTransaction(mockDB, sale.ID, 2, "Rang up the wrong basket")
+	if err != nil {
+		t.Fatalf("VoidTransaction failed: %v", err)
+	}
+
+	var quantity int
+	if err := mockDB.db.QueryRow("SELECT quantity FROM items WHERE id = 1").Scan(&quantity); err != nil {
+		t.Fatalf("Failed to get item quantity: %v", err)
+	}
+	if quantity != 100 {
+		t.Errorf("Expected quantity 100 after void, got %d", quantity)
+	}
+	if got := batchQuantity(t, mockDB, *sale.Items[0].BatchID); got != 100 {
+		t.Errorf("Expected stock back in the original batch, got %d", got)
+	}
+
+	var returned int
+	err = mockDB.db.QueryRow(
+		"SELECT COALESCE(SUM(delta), 0) FROM stock_movements WHERE reason = ? AND reference_id = ? AND user_id = 2",
+		models.MovementVoid, sale.ID,
+	).Scan(&returned)
+	if err != nil {
+		t.Fatalf("Failed to total movements: %v", err)
+	}
+	if returned != 6 {
+		t.Errorf("Expected 6 units returned in the ledger, got %d", returned)
+	}
+
+	found, err := GetTransactionByID(mockDB, sale.ID)
+	if err != nil {
+		t.Fatalf("GetTransactionByID failed: %v", err)
+	}
+	if !found.IsVoided() {
+		t.Errorf("Expected status voided, got %s", found.Status)
+	}
+	if found.VoidedBy == nil || *found.VoidedBy != 2 {
+		t.Error("Expected VoidedBy to be the approving manager")
+	}
+	if found.VoidReason != "Rang up the wrong basket" {
+		t.Errorf("Unexpected void reason: %s", found.VoidReason)
+	}
+	if found.VoidedAt == nil {
+		t.Error("Expected VoidedAt to be set")
+	}
+}
+
+func TestVoidTransaction_RequiresPermission(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	err := VoidTransaction(mockDB, sale.ID, 1, "Cashier trying to void")
+	if !errors.Is(err, ErrVoidNotPermitted) {
+		t.Fatalf("Expected ErrVoidNotPermitted, got %v", err)
+	}
+
+	found, err := GetTransactionByID(mockDB, sale.ID)
+	if err != nil {
+		t.Fatalf("GetTransactionByID failed: %v", err)
+	}
+	if found.Status != models.TransactionCompleted {
+		t.Errorf("Expected status completed, got %s", found.Status)
+	}
+}
+
+func TestVoidTransaction_RequiresReason(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	if err := VoidTransaction(mockDB, sale.ID, 2, "  "); err == nil {
+		t.Error("VoidTransaction should fail without a reason")
+	}
+}
+
+func TestVoidTransaction_OnlyOnce(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	if err := VoidTransaction(mockDB, sale.ID, 2, "Wrong basket"); err != nil {
+		t.Fatalf("VoidTransaction failed: %v", err)
+	}
+
+	err := VoidTransaction(mockDB, sale.ID, 2, "Wrong basket")
+	if !errors.Is(err, ErrAlreadyVoided) {
+		t.Errorf("Expected ErrAlreadyVoided, got %v", err)
+	}
+
+	var quantity int
+	if err := mockDB.db.QueryRow("SELECT quantity FROM items WHERE id = 1").Scan(&quantity); err != nil {
+		t.Fatalf("Failed to get item quantity: %v", err)
+	}
+	if quantity != 100 {
+		t.Errorf("Expected stock to be restored only once, got %d", quantity)
+	}
+}
+
+func TestVoidTransaction_SkipsRefundedQuantity(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	sale := createTestSale(t, mockDB)
+
+	_, err := CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 1},
+	})
+	if err != nil {
+		t.Fatalf("CreateRefund failed: %v", err)
+	}
+
+	if err := VoidTransaction(mockDB, sale.ID, 2, "Wrong basket"); err != nil {
+		t.Fatalf("VoidTransaction failed: %v", err)
+	}
+
+	var quantity int
+	if err := mockDB.db.QueryRow("SELECT quantity FROM items WHERE id = 1").Scan(&quantity); err != nil {
+		t.Fatalf("Failed to get item quantity: %v", err)
+	}
+	if quantity != 100 {
+		t.Errorf("Expected quantity 100 after refund and void, got %d", quantity)
+	}
+
+	_, err = CreateRefund(mockDB, sale.ID, 1, []models.RefundItem{
+		{TransactionItemID: sale.Items[0].ID, Quantity: 1},
+	})
+	if err == nil {
+		t.Error("CreateRefund should fail on a voided transaction")
+	}
+}
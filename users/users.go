@@ -7,16 +7,29 @@ import (
 
 	"ims-go/auth"
 	"ims-go/models"
+	"ims-go/roles"
 )
 
 type Database interface {
 	GetDB() *sql.DB
 }
 
-func CreateUser(db Database, username, password string, canRead, canTransaction, canRevenue bool) (*models.User, error) {
+// CreateUser adds a user holding the given roles. The creating user needs
+// the user.manage permission and everything the roles allow.
+func CreateUser(db Database, username, password string, roleIDs []int, userID int) (*models.User, error) {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := roles.RequireTx(tx, userID, models.PermUserManage); err != nil {
+		return nil, err
+	}
+
 	// Check if username already exists
 	var count int
-	err := db.GetDB().QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
+	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
 	if err != nil {
 		return nil, err
 	}
@@ -29,20 +42,9 @@ func CreateUser(db Database, username, password string, canRead, canTransaction,
 		return nil, err
 	}
 
-	var canReadInt, canTransactionInt, canRevenueInt int
-	if canRead {
-		canReadInt = 1
-	}
-	if canTransaction {
-		canTransactionInt = 1
-	}
-	if canRevenue {
-		canRevenueInt = 1
-	}
-
-	result, err := db.GetDB().Exec(
-		"INSERT INTO users (username, password_hash, can_read, can_transaction, can_revenue) VALUES (?, ?, ?, ?, ?)",
-		username, hashedPassword, canReadInt, canTransactionInt, canRevenueInt,
+	result, err := tx.Exec(
+		"INSERT INTO users (username, password_hash) VALUES (?, ?)",
+		username, hashedPassword,
 	)
 	if err != nil {
 		return nil, err
@@ -52,89 +54,117 @@ func CreateUser(db Database, username, password string, canRead, canTransaction,
 	if err != nil {
 		return nil, err
 	}
+	if err := roles.RequireGrantTx(tx, userID, int(id), roleIDs); err != nil {
+		return nil, err
+	}
+	if err := roles.SetUserRolesTx(tx, int(id), roleIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 
 	return GetUserByID(db, int(id))
 }
 
-func GetUserByID(db Database, id int) (*models.User, error) {
-	var user models.User
-	var createdAt time.Time
-	var isRootAdmin, canRead, canTransaction, canRevenue int
+// userColumns is the column list every user query selects, in the order
+// scanUser expects
+const userColumns = "id, username, is_root_admin, created_at, archived_at"
 
-	err := db.GetDB().QueryRow(
-		"SELECT id, username, is_root_admin, can_read, can_transaction, can_revenue, created_at FROM users WHERE id = ?",
-		id,
-	).Scan(&user.ID, &user.Username, &isRootAdmin, &canRead, &canTransaction, &canRevenue, &createdAt)
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	if err == sql.ErrNoRows {
-		return nil, errors.New("user not found")
-	}
+func scanUser(row scanner) (*models.User, error) {
+	var user models.User
+	var isRootAdmin int
+	var archivedAt sql.NullTime
+
+	err := row.Scan(&user.ID, &user.Username, &isRootAdmin, &user.CreatedAt, &archivedAt)
 	if err != nil {
 		return nil, err
 	}
 
 	user.IsRootAdmin = isRootAdmin == 1
-	user.CanRead = canRead == 1
-	user.CanTransaction = canTransaction == 1
-	user.CanRevenue = canRevenue == 1
-	user.CreatedAt = createdAt
+	if archivedAt.Valid {
+		user.ArchivedAt = &archivedAt.Time
+	}
 	return &user, nil
 }
 
-func GetAllUsers(db Database) ([]models.User, error) {
-	rows, err := db.GetDB().Query(
-		"SELECT id, username, is_root_admin, can_read, can_transaction, can_revenue, created_at FROM users ORDER BY username",
-	)
+func queryUsers(db Database, query string) ([]models.User, error) {
+	rows, err := db.GetDB().Query(query)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		var createdAt time.Time
-		var isRootAdmin, canRead, canTransaction, canRevenue int
-
-		err := rows.Scan(&user.ID, &user.Username, &isRootAdmin, &canRead, &canTransaction, &canRevenue, &createdAt)
+		user, err := scanUser(rows)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
-
-		user.IsRootAdmin = isRootAdmin == 1
-		user.CanRead = canRead == 1
-		user.CanTransaction = canTransaction == 1
-		user.CanRevenue = canRevenue == 1
-		user.CreatedAt = createdAt
-		users = append(users, user)
+		users = append(users, *user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
+	for i := range users {
+		if users[i].Roles, err = roles.GetUserRoles(db, users[i].ID); err != nil {
+			return nil, err
+		}
+	}
 	return users, nil
 }
 
-func UpdateUserPermissions(db Database, id int, canRead, canTransaction, canRevenue bool) error {
-	var canReadInt, canTransactionInt, canRevenueInt int
-	if canRead {
-		canReadInt = 1
+// GetUserByID returns a user, archived or not, so the people behind past
+// transactions can always be shown
+func GetUserByID(db Database, id int) (*models.User, error) {
+	user, err := scanUser(db.GetDB().QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("user not found")
 	}
-	if canTransaction {
-		canTransactionInt = 1
+	if err != nil {
+		return nil, err
 	}
-	if canRevenue {
-		canRevenueInt = 1
+	if user.Roles, err = roles.GetUserRoles(db, id); err != nil {
+		return nil, err
 	}
+	return user, nil
+}
 
-	_, err := db.GetDB().Exec(
-		"UPDATE users SET can_read = ?, can_transaction = ?, can_revenue = ? WHERE id = ?",
-		canReadInt, canTransactionInt, canRevenueInt, id,
-	)
-	return err
+// GetAllUsers returns every active user
+func GetAllUsers(db Database) ([]models.User, error) {
+	return queryUsers(db, "SELECT "+userColumns+" FROM users WHERE archived_at IS NULL ORDER BY username")
 }
 
-func DeleteUser(db Database, id int) error {
+// GetArchivedUsers returns every archived user
+func GetArchivedUsers(db Database) ([]models.User, error) {
+	return queryUsers(db, "SELECT "+userColumns+" FROM users WHERE archived_at IS NOT NULL ORDER BY username")
+}
+
+// ErrUserHasHistory is returned when deleting a user who has made sales or
+// changed stock. Such users are archived instead.
+var ErrUserHasHistory = errors.New("user has transaction or stock history; archive them instead")
+
+// DeleteUser removes a user with no history. The root admin can't be deleted.
+func DeleteUser(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireManageTx(tx, userID, id); err != nil {
+		return err
+	}
+
 	// Prevent deleting root admin
 	var isRootAdmin int
-	err := db.GetDB().QueryRow("SELECT is_root_admin FROM users WHERE id = ?", id).Scan(&isRootAdmin)
+	err = tx.QueryRow("SELECT is_root_admin FROM users WHERE id = ?", id).Scan(&isRootAdmin)
 	if err != nil {
 		return err
 	}
@@ -142,17 +172,117 @@ func DeleteUser(db Database, id int) error {
 		return errors.New("cannot delete root admin")
 	}
 
-	_, err = db.GetDB().Exec("DELETE FROM users WHERE id = ?", id)
-	return err
+	var history int
+	err = tx.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM transactions WHERE user_id = ? OR voided_by = ?)
+			+ (SELECT COUNT(*) FROM refunds WHERE user_id = ?)
+			+ (SELECT COUNT(*) FROM purchase_orders WHERE created_by = ?)
+			+ (SELECT COUNT(*) FROM stocktakes WHERE created_by = ? OR posted_by = ?)
+			+ (SELECT COUNT(*) FROM stock_movements WHERE user_id = ?)`,
+		id, id, id, id, id, id, id,
+	).Scan(&history)
+	if err != nil {
+		return err
+	}
+	if history > 0 {
+		return ErrUserHasHistory
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_roles WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func UpdateUserPassword(db Database, id int, newPassword string) error {
-	hashedPassword, err := auth.HashPassword(newPassword)
+// ArchiveUser stops a user logging in while keeping them for reports and
+// past transactions. The root admin can't be archived.
+func ArchiveUser(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = db.GetDB().Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashedPassword, id)
-	return err
+	if err := requireManageTx(tx, userID, id); err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		"UPDATE users SET archived_at = ? WHERE id = ? AND archived_at IS NULL AND is_root_admin = 0",
+		time.Now(), id,
+	)
+	if err := checkChanged(result, err, "user not found, already archived or root admin"); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// RestoreUser lets an archived user log in again
+func RestoreUser(db Database, id int, userID int) error {
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireManageTx(tx, userID, id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("UPDATE users SET archived_at = NULL WHERE id = ? AND archived_at IS NOT NULL", id)
+	if err := checkChanged(result, err, "user not found or not archived"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// requireManageTx checks that a user manages users and may change this one
+func requireManageTx(tx *sql.Tx, userID, targetID int) error {
+	if err := roles.RequireTx(tx, userID, models.PermUserManage); err != nil {
+		return err
+	}
+	return roles.RequireMayChangeTx(tx, userID, targetID)
+}
+
+// checkChanged turns an update that matched no rows into an error
+func checkChanged(result sql.Result, err error, message string) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New(message)
+	}
+	return nil
+}
+
+// UpdateUserPassword sets a user's password. Users may change their own;
+// anyone else's needs the user.manage permission, and only the root admin
+// may change the root admin's.
+func UpdateUserPassword(db Database, id int, newPassword string, userID int) error {
+	hashedPassword, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if id != userID {
+		if err := roles.RequireTx(tx, userID, models.PermUserManage); err != nil {
+			return err
+		}
+	}
+	if err := roles.RequireMayChangeTx(tx, userID, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashedPassword, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
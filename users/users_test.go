@@ -2,8 +2,12 @@ package users
 
 import (
 	"database/sql"
+	"errors"
 	"testing"
 
+	"ims-go/models"
+	"ims-go/roles"
+
 	_ "modernc.org/sqlite"
 )
 
@@ -26,23 +30,57 @@ func setupTestDB(t *testing.T) *MockDB {
 		username TEXT UNIQUE NOT NULL,
 		password_hash TEXT NOT NULL,
 		is_root_admin INTEGER DEFAULT 0,
-		can_read INTEGER DEFAULT 0,
-		can_transaction INTEGER DEFAULT 0,
-		can_revenue INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		archived_at DATETIME
 	)`)
 	if err != nil {
 		t.Fatalf("Failed to create users table: %v", err)
 	}
 
+	// Roles, with the root admin as user 1 to make changes as
+	setup := []string{
+		`CREATE TABLE roles (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL COLLATE NOCASE, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE role_permissions (role_id INTEGER NOT NULL, permission TEXT NOT NULL, PRIMARY KEY (role_id, permission))`,
+		`CREATE TABLE user_roles (user_id INTEGER NOT NULL, role_id INTEGER NOT NULL, PRIMARY KEY (user_id, role_id))`,
+		`INSERT INTO roles (name) VALUES ('Cashier'), ('Reports')`,
+		`INSERT INTO role_permissions (role_id, permission) VALUES (1, 'txn.create'), (1, 'txn.refund'), (2, 'report.view')`,
+		`INSERT INTO users (username, password_hash, is_root_admin) VALUES ('admin', 'hash', 1)`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up roles: %v", err)
+		}
+	}
+
+	// Tables that refer to users, checked before a user is deleted
+	history := []string{
+		`CREATE TABLE transactions (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, voided_by INTEGER)`,
+		`CREATE TABLE refunds (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL)`,
+		`CREATE TABLE purchase_orders (id INTEGER PRIMARY KEY AUTOINCREMENT, created_by INTEGER NOT NULL)`,
+		`CREATE TABLE stocktakes (id INTEGER PRIMARY KEY AUTOINCREMENT, created_by INTEGER NOT NULL, posted_by INTEGER)`,
+		`CREATE TABLE stock_movements (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER)`,
+	}
+	for _, stmt := range history {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create history table: %v", err)
+		}
+	}
+
 	return &MockDB{db: db}
 }
 
+// The roles setupTestDB creates
+const (
+	cashierRole = 1
+	reportsRole = 2
+	rootAdminID = 1
+)
+
 func TestCreateUser(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	user, err := CreateUser(mockDB, "testuser", "password123", true, false, true)
+	user, err := CreateUser(mockDB, "testuser", "password123", []int{reportsRole}, rootAdminID)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -50,14 +88,22 @@ func TestCreateUser(t *testing.T) {
 	if user.Username != "testuser" {
 		t.Errorf("Expected username 'testuser', got '%s'", user.Username)
 	}
-	if !user.CanRead {
-		t.Error("Expected CanRead to be true")
+	if len(user.Roles) != 1 || user.Roles[0].Name != "Reports" {
+		t.Errorf("Expected the Reports role, got %+v", user.Roles)
+	}
+	if !user.Can(models.PermReportView) {
+		t.Error("Expected to be able to view reports")
 	}
-	if user.CanTransaction {
-		t.Error("Expected CanTransaction to be false")
+	if user.Can(models.PermTxnCreate) {
+		t.Error("Expected not to be able to sell")
+	}
+
+	// Only users who manage users can add them
+	if _, err := CreateUser(mockDB, "another", "password", nil, user.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted, got %v", err)
 	}
-	if !user.CanRevenue {
-		t.Error("Expected CanRevenue to be true")
+	if _, err := CreateUser(mockDB, "another", "password", []int{99}, rootAdminID); err == nil {
+		t.Error("Expected error for an unknown role")
 	}
 }
 
@@ -65,12 +111,12 @@ func TestCreateUser_DuplicateUsername(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	_, err := CreateUser(mockDB, "sameuser", "password1", true, true, true)
+	_, err := CreateUser(mockDB, "sameuser", "password1", []int{cashierRole, reportsRole}, rootAdminID)
 	if err != nil {
 		t.Fatalf("First CreateUser failed: %v", err)
 	}
 
-	_, err = CreateUser(mockDB, "sameuser", "password2", false, false, false)
+	_, err = CreateUser(mockDB, "sameuser", "password2", nil, rootAdminID)
 	if err == nil {
 		t.Error("Expected error for duplicate username, got nil")
 	}
@@ -80,7 +126,7 @@ func TestGetUserByID(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	created, err := CreateUser(mockDB, "findme", "password", true, true, true)
+	created, err := CreateUser(mockDB, "findme", "password", []int{cashierRole, reportsRole}, rootAdminID)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -93,35 +139,47 @@ func TestGetUserByID(t *testing.T) {
 	if found.Username != "findme" {
 		t.Errorf("Expected username 'findme', got '%s'", found.Username)
 	}
+	if len(found.Roles) != 2 || !found.Can(models.PermTxnRefund) {
+		t.Errorf("Expected both roles, got %+v", found.Roles)
+	}
 }
 
-func TestUpdateUserPermissions(t *testing.T) {
+func TestSetUserRoles(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	user, err := CreateUser(mockDB, "updateme", "password", false, false, false)
+	user, err := CreateUser(mockDB, "updateme", "password", nil, rootAdminID)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
-
-	err = UpdateUserPermissions(mockDB, user.ID, true, true, true)
-	if err != nil {
-		t.Fatalf("UpdateUserPermissions failed: %v", err)
+	if user.Can(models.PermItemView) {
+		t.Error("Expected a user without roles to be able to do nothing")
 	}
 
+	if err := roles.SetUserRoles(mockDB, user.ID, []int{cashierRole}, rootAdminID); err != nil {
+		t.Fatalf("SetUserRoles failed: %v", err)
+	}
 	updated, err := GetUserByID(mockDB, user.ID)
 	if err != nil {
 		t.Fatalf("GetUserByID failed: %v", err)
 	}
+	if !updated.Can(models.PermTxnCreate) || updated.Can(models.PermTxnVoid) {
+		t.Errorf("Expected to sell but not void, got %+v", updated.Roles)
+	}
 
-	if !updated.CanRead {
-		t.Error("Expected CanRead to be true after update")
+	// Changing a role changes what its holders can do
+	cashier, err := roles.GetRoleByID(mockDB, cashierRole)
+	if err != nil {
+		t.Fatalf("GetRoleByID failed: %v", err)
+	}
+	if err := roles.UpdateRole(mockDB, cashierRole, cashier.Name, append(cashier.Permissions, models.PermTxnVoid), rootAdminID); err != nil {
+		t.Fatalf("UpdateRole failed: %v", err)
 	}
-	if !updated.CanTransaction {
-		t.Error("Expected CanTransaction to be true after update")
+	if err := roles.Require(mockDB, user.ID, models.PermTxnVoid); err != nil {
+		t.Errorf("Expected void permission through the updated role: %v", err)
 	}
-	if !updated.CanRevenue {
-		t.Error("Expected CanRevenue to be true after update")
+	if err := roles.DeleteRole(mockDB, cashierRole, rootAdminID); !errors.Is(err, roles.ErrRoleInUse) {
+		t.Errorf("Expected ErrRoleInUse, got %v", err)
 	}
 }
 
@@ -129,12 +187,12 @@ func TestDeleteUser(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	user, err := CreateUser(mockDB, "deleteme", "password", true, true, true)
+	user, err := CreateUser(mockDB, "deleteme", "password", []int{cashierRole}, rootAdminID)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	err = DeleteUser(mockDB, user.ID)
+	err = DeleteUser(mockDB, user.ID, rootAdminID)
 	if err != nil {
 		t.Fatalf("DeleteUser failed: %v", err)
 	}
@@ -143,22 +201,197 @@ func TestDeleteUser(t *testing.T) {
 	if err == nil {
 		t.Error("GetUserByID should fail after user is deleted")
 	}
+	var held int
+	mockDB.db.QueryRow("SELECT COUNT(*) FROM user_roles WHERE user_id = ?", user.ID).Scan(&held)
+	if held != 0 {
+		t.Errorf("Expected the user's roles to go with them, %d left", held)
+	}
 }
 
 func TestDeleteUser_CannotDeleteRootAdmin(t *testing.T) {
 	mockDB := setupTestDB(t)
 	defer mockDB.db.Close()
 
-	_, err := mockDB.db.Exec(
-		`INSERT INTO users (username, password_hash, is_root_admin, can_read, can_transaction, can_revenue)
-		 VALUES ('admin', 'hash', 1, 1, 1, 1)`,
-	)
+	err := DeleteUser(mockDB, rootAdminID, rootAdminID)
+	if err == nil {
+		t.Error("Should not be able to delete root admin")
+	}
+}
+
+func TestDeleteUser_WithHistory(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	user, err := CreateUser(mockDB, "cashier", "password", []int{cashierRole}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := mockDB.db.Exec("INSERT INTO transactions (user_id) VALUES (?)", user.ID); err != nil {
+		t.Fatalf("Failed to insert sale: %v", err)
+	}
+
+	if err := DeleteUser(mockDB, user.ID, rootAdminID); !errors.Is(err, ErrUserHasHistory) {
+		t.Errorf("Expected ErrUserHasHistory, got %v", err)
+	}
+	if _, err := GetUserByID(mockDB, user.ID); err != nil {
+		t.Errorf("Expected user to be kept: %v", err)
+	}
+}
+
+func TestArchiveUser(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	user, err := CreateUser(mockDB, "leaver", "password", []int{cashierRole}, rootAdminID)
 	if err != nil {
-		t.Fatalf("Failed to insert root admin: %v", err)
+		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	err = DeleteUser(mockDB, 1)
-	if err == nil {
-		t.Error("Should not be able to delete root admin")
+	if err := ArchiveUser(mockDB, rootAdminID, rootAdminID); err == nil {
+		t.Error("Should not be able to archive root admin")
+	}
+	if err := ArchiveUser(mockDB, rootAdminID, user.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted archiving as a cashier, got %v", err)
+	}
+	if err := ArchiveUser(mockDB, user.ID, rootAdminID); err != nil {
+		t.Fatalf("ArchiveUser failed: %v", err)
+	}
+	if err := ArchiveUser(mockDB, user.ID, rootAdminID); err == nil {
+		t.Error("Expected error archiving twice")
+	}
+
+	// Archived users can't do anything, whatever their roles
+	if err := roles.Require(mockDB, user.ID, models.PermTxnCreate); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted for an archived user, got %v", err)
+	}
+
+	active, err := GetAllUsers(mockDB)
+	if err != nil {
+		t.Fatalf("GetAllUsers failed: %v", err)
+	}
+	archived, err := GetArchivedUsers(mockDB)
+	if err != nil {
+		t.Fatalf("GetArchivedUsers failed: %v", err)
+	}
+	if len(active) != 1 || len(archived) != 1 || archived[0].ID != user.ID || archived[0].ArchivedAt == nil {
+		t.Errorf("Expected 1 active and 1 archived user, got %+v and %+v", active, archived)
+	}
+
+	// Archived users still resolve for history
+	found, err := GetUserByID(mockDB, user.ID)
+	if err != nil || found.ArchivedAt == nil {
+		t.Errorf("Expected archived user by ID, got %+v (%v)", found, err)
+	}
+
+	if err := RestoreUser(mockDB, user.ID, rootAdminID); err != nil {
+		t.Fatalf("RestoreUser failed: %v", err)
+	}
+	active, _ = GetAllUsers(mockDB)
+	if len(active) != 2 {
+		t.Errorf("Expected 2 active users after restore, got %d", len(active))
+	}
+}
+
+func TestUpdateUserPassword(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	user, err := CreateUser(mockDB, "cashier", "password", []int{cashierRole}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if err := UpdateUserPassword(mockDB, user.ID, "new password", user.ID); err != nil {
+		t.Errorf("Expected users to be able to change their own password: %v", err)
+	}
+	if err := UpdateUserPassword(mockDB, rootAdminID, "new password", user.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted changing someone else's password, got %v", err)
+	}
+	if err := UpdateUserPassword(mockDB, user.ID, "reset", rootAdminID); err != nil {
+		t.Errorf("Expected the root admin to be able to reset a password: %v", err)
+	}
+
+	// Managing users doesn't extend to the root admin, or to anyone who can
+	// do more than the manager
+	manager, err := roles.CreateRole(mockDB, "Manager", []models.Permission{models.PermUserManage, models.PermReportView}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	boss, err := CreateUser(mockDB, "boss", "password", []int{manager.ID}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := UpdateUserPassword(mockDB, rootAdminID, "taken over", boss.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted changing the root admin's password, got %v", err)
+	}
+	if err := UpdateUserPassword(mockDB, user.ID, "taken over", boss.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted changing the password of a user who can sell, got %v", err)
+	}
+	reporter, err := CreateUser(mockDB, "reporter", "password", []int{reportsRole}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := UpdateUserPassword(mockDB, reporter.ID, "reset again", boss.ID); err != nil {
+		t.Errorf("Expected a user manager to reset the password of a user who can do less: %v", err)
+	}
+	if err := UpdateUserPassword(mockDB, rootAdminID, "new password", rootAdminID); err != nil {
+		t.Errorf("Expected the root admin to change their own password: %v", err)
+	}
+}
+
+func TestArchiveAndDeleteUser_NoEscalation(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	manager, err := roles.CreateRole(mockDB, "Manager", []models.Permission{models.PermUserManage}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	boss, err := CreateUser(mockDB, "boss", "password", []int{manager.ID}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	cashier, err := CreateUser(mockDB, "cashier", "password", []int{cashierRole}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// The boss can't sell, so can't touch a cashier's account
+	if err := ArchiveUser(mockDB, cashier.ID, boss.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted archiving, got %v", err)
+	}
+	if err := DeleteUser(mockDB, cashier.ID, boss.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted deleting, got %v", err)
+	}
+	if err := ArchiveUser(mockDB, cashier.ID, rootAdminID); err != nil {
+		t.Fatalf("ArchiveUser failed: %v", err)
+	}
+	if err := RestoreUser(mockDB, cashier.ID, boss.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted restoring, got %v", err)
+	}
+	if err := DeleteUser(mockDB, cashier.ID, rootAdminID); err != nil {
+		t.Errorf("DeleteUser failed: %v", err)
+	}
+}
+
+func TestCreateUser_NoEscalation(t *testing.T) {
+	mockDB := setupTestDB(t)
+	defer mockDB.db.Close()
+
+	manager, err := roles.CreateRole(mockDB, "Manager", []models.Permission{models.PermUserManage, models.PermReportView}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	boss, err := CreateUser(mockDB, "boss", "password", []int{manager.ID}, rootAdminID)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// The boss can't sell, so can't make a cashier
+	if _, err := CreateUser(mockDB, "cashier", "password", []int{cashierRole}, boss.ID); !errors.Is(err, roles.ErrNotPermitted) {
+		t.Errorf("Expected ErrNotPermitted granting a role with more than the granter has, got %v", err)
+	}
+	if _, err := CreateUser(mockDB, "reporter", "password", []int{reportsRole}, boss.ID); err != nil {
+		t.Errorf("Expected the boss to grant Reports: %v", err)
 	}
 }